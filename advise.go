@@ -0,0 +1,210 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressionAdvice is the current compression of an input and the stored size it would have recompressed
+// at each gzip level. The sizes at the levels are estimated from a sample of its decompressed bytes, the
+// first ones, so inputs whose start is not typical are estimated less well.
+type CompressionAdvice struct {
+	File        string
+	Compression string // "" for plain files
+	Stored      int64
+	Bytes       int64   // decompressed
+	Levels      []int64 // estimated stored sizes, by level
+	Err         error
+}
+
+// countWriter counts the bytes written to it
+type countWriter int64
+
+func (cw *countWriter) Write(p []byte) (int, error) { *cw += countWriter(len(p)); return len(p), nil }
+
+// AdviseCompression reads an input through the source and decoder, and compresses its first sample
+// decompressed bytes, all of them for 0, at each gzip level
+func AdviseCompression(source Source, decoder Decoder, file string, levels []int, sample int64) CompressionAdvice {
+	ca := CompressionAdvice{File: file, Compression: compression(file), Levels: make([]int64, len(levels))}
+	fp, stored, err := source.Open(file)
+	if err != nil {
+		ca.Err = err
+		return ca
+	}
+	defer fp.Close()
+	zfp, err := decoder.Decode(file, fp)
+	if err != nil {
+		ca.Err = err
+		return ca
+	}
+	defer zfp.Close()
+	ca.Stored = stored
+
+	counts := make([]countWriter, len(levels))
+	writers := make([]io.Writer, len(levels))
+	zws := make([]*gzip.Writer, len(levels))
+	for i, level := range levels {
+		zws[i], _ = gzip.NewWriterLevel(&counts[i], level)
+		writers[i] = zws[i]
+	}
+	var sampled int64
+	if sample > 0 {
+		sampled, err = io.Copy(io.MultiWriter(writers...), io.LimitReader(zfp, sample))
+	} else {
+		sampled, err = io.Copy(io.MultiWriter(writers...), zfp)
+	}
+	if err != nil {
+		ca.Err = err
+		return ca
+	}
+	rest, err := io.Copy(ioutil.Discard, zfp)
+	if err != nil {
+		ca.Err = err
+		return ca
+	}
+	ca.Bytes = sampled + rest
+	for i, zw := range zws {
+		zw.Close()
+		if sampled > 0 {
+			ca.Levels[i] = int64(float64(counts[i]) * float64(ca.Bytes) / float64(sampled))
+		}
+	}
+	return ca
+}
+
+// ParseLevels parses comma separated gzip levels, 1 to 9
+func ParseLevels(s string) ([]int, error) {
+	var levels []int
+	for _, f := range strings.Split(s, ",") {
+		level, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || level < gzip.BestSpeed || level > gzip.BestCompression {
+			return nil, fmt.Errorf("gzip level %q: want 1 to 9", f)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// WriteAdvice writes a CSV line per input, sorted by file, with a header and a line of totals. ratio is
+// the decompressed size divided by the stored one, and gzip-N the stored size at level N.
+func WriteAdvice(w io.Writer, advice []CompressionAdvice, levels []int) error {
+	sort.Slice(advice, func(i, j int) bool { return advice[i].File < advice[j].File })
+	cw := csv.NewWriter(w)
+	header := []string{"file", "compression", "stored", "bytes", "ratio"}
+	for _, level := range levels {
+		header = append(header, "gzip-"+strconv.Itoa(level))
+	}
+	cw.Write(header)
+
+	total := CompressionAdvice{File: "total", Levels: make([]int64, len(levels))}
+	line := func(ca CompressionAdvice) {
+		c := ca.Compression
+		if c == "" {
+			c = "none"
+		}
+		ratio := ""
+		if ca.Stored > 0 {
+			ratio = strconv.FormatFloat(float64(ca.Bytes)/float64(ca.Stored), 'f', 2, 64)
+		}
+		rec := []string{ca.File, c, strconv.FormatInt(ca.Stored, 10), strconv.FormatInt(ca.Bytes, 10), ratio}
+		for _, n := range ca.Levels {
+			rec = append(rec, strconv.FormatInt(n, 10))
+		}
+		cw.Write(rec)
+	}
+	for _, ca := range advice {
+		if ca.Err != nil {
+			continue
+		}
+		line(ca)
+		total.Stored += ca.Stored
+		total.Bytes += ca.Bytes
+		for i, n := range ca.Levels {
+			total.Levels[i] += n
+		}
+	}
+	total.Compression = "-"
+	line(total)
+	cw.Flush()
+	return cw.Error()
+}
+
+// adviseFlags registers the flags compression has on top of the run flags
+func adviseFlags(fs *flag.FlagSet) (levels *string, sample *int64) {
+	levels = fs.String("levels", "1,6,9", "comma separated gzip levels to estimate the stored size at")
+	sample = new(int64)
+	*sample = 16 << 20
+	fs.Var(SizeFlag{sample}, "sample", "decompressed bytes at the start of every input compressed to estimate its size, 0 for all of them")
+	return levels, sample
+}
+
+func compressionCommand(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("compression", flag.ExitOnError)
+	cfg.RegisterFlags(fs)
+	levelsFlag, sample := adviseFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	levels, err := ParseLevels(*levelsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "compression:", err)
+		return 2
+	}
+	files, err := cfg.ListFiles()
+	if err != nil {
+		slog.Error("failed to list inputs", "error", err)
+		return 2
+	}
+
+	source := NewFileSource(Retry{cfg.Retries, cfg.RetryBackoff}).Throttle(cfg.MaxReadMBps, cfg.MaxOpenFiles)
+	advice := make([]CompressionAdvice, len(files))
+	tasks := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(cfg.Procs, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				advice[i] = AdviseCompression(source, SuffixDecoder{}, files[i], levels, *sample)
+			}
+		}()
+	}
+	for i := range files {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+
+	failed := 0
+	for _, ca := range advice {
+		if ca.Err != nil {
+			slog.Error("failed to read", "file", ca.File, "error", ca.Err)
+			failed++
+		}
+	}
+	if err := WriteAdvice(os.Stdout, advice, levels); err != nil {
+		slog.Error("failed to write", "error", err)
+		return 1
+	}
+	if failed > 0 {
+		return ExitPartial
+	}
+	return 0
+}
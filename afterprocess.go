@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// markerSuffix names the marker -after-process touch-marker leaves next to a processed input
+const markerSuffix = ".done"
+
+// AfterProcess is done with every input processed without error, once the run succeeded and its results
+// are written, so that a drop folder only holds the inputs still to process: move:DIR moves them into DIR,
+// delete removes them and touch-marker creates an empty marker file next to them, named like them with
+// .done appended. Nothing is done after a failed or canceled run, and failed inputs stay where they are.
+type AfterProcess struct {
+	Action string // move, delete or touch-marker
+	Dir    string // the directory of move
+}
+
+func ParseAfterProcess(s string) (*AfterProcess, error) {
+	action, dir, _ := strings.Cut(s, ":")
+	switch {
+	case action == "move" && dir != "":
+		return &AfterProcess{action, dir}, nil
+	case (action == "delete" || action == "touch-marker") && dir == "":
+		return &AfterProcess{Action: action}, nil
+	}
+	return nil, fmt.Errorf("unknown -after-process %s, want move:DIR, delete or touch-marker", s)
+}
+
+// Apply does the action to one input
+func (ap *AfterProcess) Apply(file string) error {
+	switch ap.Action {
+	case "move":
+		if err := os.MkdirAll(ap.Dir, 0755); err != nil {
+			return err
+		}
+		// a file in the way, e.g. an input of the same name from another directory, is not replaced
+		to := filepath.Join(ap.Dir, filepath.Base(file))
+		if _, err := os.Lstat(to); err == nil {
+			return fmt.Errorf("%s exists already", to)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Rename(file, to); err == nil {
+			return nil
+		}
+		// across file systems
+		if err := copyNew(file, to); err != nil {
+			return err
+		}
+		return os.Remove(file)
+	case "delete":
+		return os.Remove(file)
+	default:
+		fp, err := os.Create(file + markerSuffix)
+		if err != nil {
+			return err
+		}
+		return fp.Close()
+	}
+}
+
+// copyNew copies a file to a new one, failing if it exists, and removes the copy if it fails
+func copyNew(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(to)
+	}
+	return err
+}
+
+// Hooks applies the action at the end of the run to the inputs that were processed without error
+func (ap *AfterProcess) Hooks() Hooks {
+	return Hooks{OnRunEnd: func(stats *WorkerStats, err error) {
+		if err != nil {
+			slog.Info("run failed, -after-process not applied", "action", ap.Action)
+			return
+		}
+		done, failed := 0, 0
+		for _, s := range stats.PerFile() {
+			if s.Status() != "ok" {
+				continue
+			}
+			if err := ap.Apply(s.File); err != nil {
+				slog.Warn("-after-process failed", "file", s.File, "action", ap.Action, "error", err)
+				failed += 1
+			} else {
+				done += 1
+			}
+		}
+		slog.Info("after process", "action", ap.Action, "files", done, "failed", failed)
+	}}
+}
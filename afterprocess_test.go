@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAfterProcessMove(t *testing.T) {
+	in, done := t.TempDir(), t.TempDir()
+	a, b := filepath.Join(in, "a", "x.log"), filepath.Join(in, "b", "x.log")
+	for _, file := range []string{a, b} {
+		os.MkdirAll(filepath.Dir(file), 0o755)
+		os.WriteFile(file, []byte(file), 0o644)
+	}
+	ap, err := ParseAfterProcess("move:" + done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.Apply(a); err != nil {
+		t.Fatal(err)
+	}
+	// the second input of the same name is left in place
+	if err := ap.Apply(b); err == nil {
+		t.Error("moved over a file")
+	}
+	if data, _ := os.ReadFile(filepath.Join(done, "x.log")); string(data) != a {
+		t.Errorf("moved file has %q", data)
+	}
+	if _, err := os.Stat(b); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCopyNew(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	os.WriteFile(from, []byte("new"), 0o644)
+	os.WriteFile(to, []byte("old"), 0o644)
+	if err := copyNew(from, to); err == nil {
+		t.Error("copied over a file")
+	}
+	if data, _ := os.ReadFile(to); string(data) != "old" {
+		t.Errorf("existing file has %q", data)
+	}
+	if err := copyNew(from, filepath.Join(dir, "missing", "to")); err == nil {
+		t.Error("copied into a missing directory")
+	}
+	os.Remove(to)
+	if err := copyNew(from, to); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(to); string(data) != "new" {
+		t.Errorf("copy has %q", data)
+	}
+}
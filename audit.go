@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/csv"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// hashingReader feeds everything read from the input to a checksum
+type hashingReader struct {
+	io.ReadCloser
+	h hash.Hash
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.ReadCloser.Read(p)
+	hr.h.Write(p[:n])
+	return n, err
+}
+
+// AuditResult writes result-audit.csv with the SHA-256 of every input as read and the records it produced,
+// so a report can be traced back to its exact inputs. Failed files have no checksum.
+type AuditResult struct {
+	Files   []FileStats
+	Started time.Time
+}
+
+func (ar AuditResult) Name() string      { return "audit" }
+func (ar AuditResult) Extension() string { return ".csv" }
+
+func (ar AuditResult) Output(path string) {
+	files := make([]FileStats, len(ar.Files))
+	copy(files, ar.Files)
+	sort.Slice(files, func(i, j int) bool { return files[i].File < files[j].File })
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	started := ar.Started.UTC().Format(time.RFC3339)
+	w := csv.NewWriter(fp)
+	w.Write([]string{"file", "sha256", "bytes_compressed", "records", "parse_errors", "status", "run_started"})
+	for _, s := range files {
+		w.Write([]string{s.File, s.SHA256, strconv.FormatInt(s.BytesCompressed, 10), strconv.FormatInt(s.Records, 10),
+			strconv.FormatInt(s.ParseErrors, 10), s.Status(), started})
+	}
+	w.Flush()
+}
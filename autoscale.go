@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Autoscaler limits how many workers process files at the same time. It measures the input throughput
+// and keeps lowering the limit while that does not reduce throughput, i.e. while the storage and not
+// the CPU is the bottleneck, settles on the lowest limit that keeps it, and raises it again when the
+// throughput falls.
+type Autoscaler struct {
+	sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int
+	max    int
+
+	bytes    int64 // read from the source, updated atomically
+	interval time.Duration
+	stop     chan struct{}
+}
+
+func NewAutoscaler(max int, interval time.Duration) *Autoscaler {
+	as := &Autoscaler{limit: max, max: max, interval: interval, stop: make(chan struct{})}
+	as.cond = sync.NewCond(as)
+	return as
+}
+
+func (as *Autoscaler) Acquire() {
+	as.Lock()
+	for as.active >= as.limit {
+		as.cond.Wait()
+	}
+	as.active += 1
+	as.Unlock()
+}
+
+func (as *Autoscaler) Release() {
+	as.Lock()
+	as.active -= 1
+	as.Unlock()
+	as.cond.Signal()
+}
+
+func (as *Autoscaler) setLimit(limit int) {
+	as.Lock()
+	as.limit = limit
+	as.Unlock()
+	as.cond.Broadcast()
+}
+
+// autoscaleTolerance is the share of the best throughput a limit may lose and still count as as good
+const autoscaleTolerance = 0.05
+
+const (
+	autoscaleMeasuring = iota
+	autoscaleLowering
+	autoscaleHolding
+	autoscaleRaising
+)
+
+// autoscaleState picks the limit of the next interval from the throughput of the last one. The first
+// interval measures the throughput with all workers. The limit is then lowered while the throughput stays
+// within the tolerance of the best one seen, and set back to the lowest limit that kept it once it does
+// not. It is held there while the throughput holds up. When it falls, e.g. as the storage got slower, the
+// limit is raised while that raises the throughput by more than the tolerance.
+type autoscaleState struct {
+	phase            int
+	best             float64 // throughput of bestLimit
+	limit, bestLimit int
+	max              int
+}
+
+func (st *autoscaleState) next(rate float64) int {
+	holds := rate >= st.best*(1-autoscaleTolerance)
+	switch st.phase {
+	case autoscaleMeasuring:
+		st.best, st.bestLimit, st.phase = rate, st.limit, autoscaleLowering
+	case autoscaleLowering:
+		if holds {
+			st.best, st.bestLimit = max(st.best, rate), st.limit
+		} else {
+			st.limit, st.phase = st.bestLimit, autoscaleHolding
+		}
+	case autoscaleHolding:
+		if holds {
+			st.best = max(st.best, rate)
+		} else {
+			st.best, st.bestLimit, st.phase = rate, st.limit, autoscaleRaising
+		}
+	case autoscaleRaising:
+		if rate > st.best*(1+autoscaleTolerance) {
+			st.best, st.bestLimit = rate, st.limit
+		} else {
+			st.limit, st.phase = st.bestLimit, autoscaleHolding
+		}
+	}
+	switch {
+	case st.phase == autoscaleLowering && st.limit == st.bestLimit && st.limit > 1:
+		st.limit -= 1
+	case st.phase == autoscaleLowering:
+		st.phase = autoscaleHolding
+	case st.phase == autoscaleRaising && st.limit == st.bestLimit && st.limit < st.max:
+		st.limit += 1
+	case st.phase == autoscaleRaising:
+		st.phase = autoscaleHolding
+	}
+	return st.limit
+}
+
+// Run adjusts the limit every interval until Stop is called
+func (as *Autoscaler) Run() {
+	ticker := time.NewTicker(as.interval)
+	defer ticker.Stop()
+
+	as.Lock()
+	st := autoscaleState{limit: as.limit, bestLimit: as.limit, max: as.max}
+	as.Unlock()
+	var lastBytes int64
+	for {
+		select {
+		case <-as.stop:
+			return
+		case <-ticker.C:
+		}
+
+		bytes := atomic.LoadInt64(&as.bytes)
+		rate := float64(bytes-lastBytes) / as.interval.Seconds()
+		lastBytes = bytes
+		limit := st.next(rate)
+		as.setLimit(limit)
+		slog.Debug("autoscale", "mb_per_sec", rate/1024/1024, "workers_allowed", limit, "best_workers", st.bestLimit)
+	}
+}
+
+func (as *Autoscaler) Stop() { close(as.stop) }
+
+// Count wraps a source reader to measure the throughput
+func (as *Autoscaler) Count(r io.ReadCloser) io.ReadCloser { return &countingReader{r, &as.bytes} }
+
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	atomic.AddInt64(cr.n, int64(n))
+	return n, err
+}
+
+// ProcsFlag is an -procs value that is either a number or "auto", which is stored as 0
+type ProcsFlag struct {
+	n *int
+}
+
+func (pf ProcsFlag) String() string {
+	if pf.n == nil || *pf.n == 0 {
+		return "auto"
+	}
+	return strconv.Itoa(*pf.n)
+}
+
+func (pf ProcsFlag) Set(s string) error {
+	if s == "auto" {
+		*pf.n = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*pf.n = n
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Batch is a set of jobs run in one invocation, e.g.
+//
+//	{"procs": 8, "defaults": {"keys": "0"}, "jobs": [{"name": "web", "in": "logs/web", "out": "out/web"}, ...]}
+//
+// Every job is an object of run flags on top of the defaults. The jobs run at the same time and share
+// a pool of procs workers, 0 for the number of CPUs.
+type Batch struct {
+	Procs    int                      `json:"procs"`
+	Defaults map[string]interface{}   `json:"defaults"`
+	Jobs     []map[string]interface{} `json:"jobs"`
+}
+
+// BatchJob is one job of a batch and its outcome
+type BatchJob struct {
+	Name     string
+	Config   Config
+	Failures *Failures
+	Err      error
+	Control  *Control
+	Duration time.Duration
+}
+
+// LoadBatch reads a batch file and builds the configs of its jobs
+func LoadBatch(path string) (*Batch, []*BatchJob, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var batch Batch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(batch.Jobs) == 0 {
+		return nil, nil, fmt.Errorf("%s: no jobs", path)
+	}
+
+	jobs := make([]*BatchJob, len(batch.Jobs))
+	for i, settings := range batch.Jobs {
+		job := &BatchJob{Name: fmt.Sprintf("job%d", i+1), Control: NewControl()}
+		values := make(map[string]interface{}, len(batch.Defaults)+len(settings))
+		for k, v := range batch.Defaults {
+			values[k] = v
+		}
+		for k, v := range settings {
+			values[k] = v
+		}
+		if name, ok := values["name"]; ok {
+			job.Name = fmt.Sprint(name)
+			delete(values, "name")
+		}
+
+		data, _ := json.Marshal(values)
+		if err := job.Config.LoadJSON(data); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", job.Name, err)
+		}
+		jobs[i] = job
+	}
+	return &batch, jobs, nil
+}
+
+// RunBatch validates all jobs, then runs them together on one shared pool of workers
+func RunBatch(batch *Batch, jobs []*BatchJob) error {
+	for _, job := range jobs {
+		if err := job.Config.Validate(); err != nil {
+			return ConfigError{fmt.Errorf("%s: %v", job.Name, err)}
+		}
+	}
+
+	procs := batch.Procs
+	if procs < 1 {
+		procs = runtime.NumCPU()
+	}
+	pool := NewAutoscaler(procs, 0)
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job *BatchJob) {
+			defer wg.Done()
+			start := time.Now()
+			job.Config.pool = pool
+			job.Failures, job.Err = job.Config.RunWith(job.Control)
+			job.Duration = time.Since(start)
+		}(job)
+	}
+	wg.Wait()
+	return nil
+}
+
+// printBatchSummary prints one line per job
+func printBatchSummary(w io.Writer, jobs []*BatchJob) {
+	fmt.Fprintf(w, "%-16s %-8s %8s %12s %8s %10s\n", "job", "state", "files", "records", "failed", "seconds")
+	for _, job := range jobs {
+		state, failed := "done", 0
+		if job.Failures != nil {
+			failed = job.Failures.Count()
+		}
+		if job.Err != nil {
+			state = "error"
+		}
+		progress := job.Control.Progress()
+		if job.Err == nil && (failed > 0 || progress.Partial) {
+			state = "partial"
+		}
+		fmt.Fprintf(w, "%-16s %-8s %8d %12d %8d %10.3f\n", job.Name, state, progress.Files, progress.Records, failed, job.Duration.Seconds())
+		if job.Err != nil {
+			fmt.Fprintf(w, "  %s: %v\n", job.Name, job.Err)
+		}
+	}
+}
+
+func batchFlags(fs *flag.FlagSet) (config *string) {
+	return fs.String("config", "", "JSON file with the jobs to run")
+}
+
+func batchCommand(args []string) int {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	config := batchFlags(fs)
+	var lf LogFlags
+	lf.RegisterFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := lf.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *config == "" {
+		fmt.Fprintln(os.Stderr, "batch: no -config file")
+		return 2
+	}
+
+	batch, jobs, err := LoadBatch(*config)
+	if err != nil {
+		slog.Error("failed to load batch", "error", err)
+		return 2
+	}
+	ctls := make([]*Control, len(jobs))
+	for i, job := range jobs {
+		ctls[i] = job.Control
+	}
+	stopInterrupt := cancelOnInterrupt(ctls...)
+	err = RunBatch(batch, jobs)
+	stopInterrupt()
+	if err != nil {
+		slog.Error("batch failed", "error", err)
+		return 2
+	}
+	printBatchSummary(os.Stdout, jobs)
+
+	// the worst outcome of the jobs, interrupted above all
+	code := ExitOK
+	for _, job := range jobs {
+		if c := exitCode(job.Control, job.Failures, job.Err); c == ExitInterrupted || code != ExitInterrupted && c > code {
+			code = c
+		}
+		if job.Failures != nil {
+			job.Failures.Quarantine(job.Config.Out + "/quarantine.txt")
+		}
+	}
+	return code
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// NopReport discards all records, to measure everything but the reports
+type NopReport[T any] struct{}
+
+func (NopReport[T]) New() Report[T]      { return NopReport[T]{} }
+func (NopReport[T]) Merge(rpt Report[T]) {}
+func (NopReport[T]) Clear()              {}
+func (NopReport[T]) Name() string        { return "nop" }
+func (NopReport[T]) Add(rec T)           {}
+func (NopReport[T]) Output(path string)  {}
+func (NopReport[T]) Discards()           {}
+
+// NopSink discards the results
+type NopSink struct{}
+
+func (NopSink) Write(rpt Result) error { return nil }
+
+// bench runs the inputs several times on one worker, adding a stage each time, and prints the cost of
+// every stage as the difference to the previous pass
+func bench[T any](cfg *Config, pr *Registry[Parser[T]], rr *Registry[Report[T]], defaultParser string, files []string, w io.Writer) error {
+	p, err := BuildPipeline(cfg, pr, rr, defaultParser)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var compressed int64
+	for _, file := range files {
+		fp, _, err := p.source.Open(file)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(ioutil.Discard, fp)
+		fp.Close()
+		if err != nil {
+			return err
+		}
+		compressed += n
+	}
+	read := time.Since(start)
+
+	start = time.Now()
+	var decompressed int64
+	for _, file := range files {
+		fp, _, err := p.source.Open(file)
+		if err != nil {
+			return err
+		}
+		zfp, err := p.decoder.Decode(file, fp)
+		if err != nil {
+			fp.Close()
+			return err
+		}
+		n, err := io.Copy(ioutil.Discard, zfp)
+		zfp.Close()
+		fp.Close()
+		if err != nil {
+			return err
+		}
+		decompressed += n
+	}
+	decode := time.Since(start)
+
+	start = time.Now()
+	nop := NewPipeline[T]().From(p.source).Decode(p.decoder).Parse(p.parser.Clone()).Report(NopReport[T]{}).To(NopSink{})
+	if err := nop.Run(files); err != nil {
+		return err
+	}
+	parse := time.Since(start)
+	records := nop.Stats().records
+
+	start = time.Now()
+	p.Procs(1).To(NopSink{})
+	if err := p.Run(files); err != nil {
+		return err
+	}
+	full := time.Since(start)
+
+	mb := func(n int64, d time.Duration) float64 { return float64(n) / 1024 / 1024 / d.Seconds() }
+	rate := func(n int64, d time.Duration) float64 { return float64(n) / d.Seconds() }
+	fmt.Fprintf(w, "%-12s %10s %10s %12s\n", "stage", "seconds", "MB/s", "records/s")
+	fmt.Fprintf(w, "%-12s %10.3f %10.1f %12s\n", "read", read.Seconds(), mb(compressed, read), "-")
+	fmt.Fprintf(w, "%-12s %10.3f %10.1f %12s\n", "decompress", (decode - read).Seconds(), mb(decompressed, decode-read), "-")
+	fmt.Fprintf(w, "%-12s %10.3f %10.1f %12.0f\n", "parse", (parse - decode).Seconds(), mb(decompressed, parse-decode), rate(records, parse-decode))
+	fmt.Fprintf(w, "%-12s %10.3f %10s %12.0f\n", "report", (full - parse).Seconds(), "-", rate(records, full-parse))
+	fmt.Fprintf(w, "%-12s %10.3f %10.1f %12.0f\n", "total", full.Seconds(), mb(decompressed, full), rate(records, full))
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Bucketer rewrites numbers in columns of records as the lower bound of their bucket, so reports keyed
+// by them, e.g. response sizes or latencies, count a few buckets instead of every distinct value. Rules
+// are separated by ; and select columns like -units, e.g. 4=pow2;5=width:10. The buckets are:
+//
+//	pow2      powers of two: 512 for 512 to 1023, 0 below 1
+//	pow10     powers of ten: 100 for 100 to 999, 0 below 1
+//	width:N   buckets of N: 10 for 10 to 19.99 with width:10, -10 for -10 to -0.01
+//
+// Values that are not numbers are left as they are. It is safe for concurrent use.
+type Bucketer struct {
+	rules []bucketRule
+}
+
+type bucketRule struct {
+	columns *KeySpec
+	bucket  func(v float64) float64
+	prec    int // decimals of the bounds, -1 for as many as needed
+}
+
+// NewBucketer parses the rules
+func NewBucketer(rules string) (*Bucketer, error) {
+	b := &Bucketer{}
+	for _, rule := range strings.Split(rules, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		columns, bucketing, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("buckets %s: want columns=pow2, columns=pow10 or columns=width:N", rule)
+		}
+		spec, err := ParseKeySpec(columns)
+		if err != nil {
+			return nil, fmt.Errorf("buckets %s: %v", rule, err)
+		}
+		if spec.HasNames() {
+			return nil, fmt.Errorf("buckets %s: columns must be numbers", rule)
+		}
+		r := bucketRule{columns: spec, prec: -1}
+		name, arg, _ := strings.Cut(bucketing, ":")
+		switch {
+		case name == "pow2" && arg == "":
+			r.bucket = func(v float64) float64 { return powBucket(v, 2) }
+		case name == "pow10" && arg == "":
+			r.bucket = func(v float64) float64 { return powBucket(v, 10) }
+		case name == "width":
+			width, err := strconv.ParseFloat(arg, 64)
+			if err != nil || width <= 0 || math.IsInf(width, 0) {
+				return nil, fmt.Errorf("buckets %s: width %q must be a positive number", rule, arg)
+			}
+			r.bucket = func(v float64) float64 { return math.Floor(v/width) * width }
+			// 0.1 buckets are 0.3 rather than 0.30000000000000004
+			if _, frac, ok := strings.Cut(arg, "."); ok {
+				r.prec = len(frac)
+			} else {
+				r.prec = 0
+			}
+		default:
+			return nil, fmt.Errorf("buckets %s: unknown bucketing %s", rule, bucketing)
+		}
+		b.rules = append(b.rules, r)
+	}
+	if len(b.rules) == 0 {
+		return nil, fmt.Errorf("no bucket rules")
+	}
+	return b, nil
+}
+
+// powBucket returns the largest power of base not above v, 0 for v below 1
+func powBucket(v, base float64) float64 {
+	if v < 1 {
+		return 0
+	}
+	p := math.Pow(base, math.Floor(math.Log(v)/math.Log(base)))
+	// the logarithm may round across a power
+	if p*base <= v {
+		p *= base
+	} else if p > v {
+		p /= base
+	}
+	return p
+}
+
+func (r bucketRule) convert(s string) (string, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return s, false
+	}
+	return strconv.FormatFloat(r.bucket(v), 'f', r.prec, 64), true
+}
+
+// Bucket rewrites the configured columns of a LogRecord or ByteRecord in place. Columns a record does not
+// have are left alone.
+func (b *Bucketer) Bucket(rec interface{}) {
+	switch r := rec.(type) {
+	case LogRecord:
+		for _, rule := range b.rules {
+			for _, c := range specColumns(rule.columns, len(r)) {
+				if v, ok := rule.convert(r[c]); ok {
+					r[c] = v
+				}
+			}
+		}
+	case ByteRecord:
+		for _, rule := range b.rules {
+			for _, c := range specColumns(rule.columns, len(r)) {
+				if v, ok := rule.convert(string(r[c])); ok {
+					r[c] = []byte(v)
+				}
+			}
+		}
+	}
+}
+
+// Buckets is the Transformer of a Bucketer for records of type T
+type Buckets[T any] struct{ *Bucketer }
+
+func (b Buckets[T]) Transform(rec T) T {
+	b.Bucket(rec)
+	return rec
+}
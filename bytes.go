@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+)
+
+// ByteRecord holds the fields of a record as views into the parser's read buffer. The views are only valid
+// until the next NextRecord call; use string(field) or CopyByteRecord to keep them.
+type ByteRecord = [][]byte
+
+func CopyByteRecord(r ByteRecord) ByteRecord {
+	c := make(ByteRecord, len(r))
+	for i, f := range r {
+		c[i] = append([]byte(nil), f...)
+	}
+	return c
+}
+
+// FieldsParser splits lines by a separator without allocating per field. It does not handle quoting.
+type FieldsParser struct {
+	comma  byte
+	reader *bufio.Reader
+	mapped []byte
+	line   []byte
+	fields ByteRecord
+}
+
+func NewFieldsParser(comma byte) *FieldsParser { return &FieldsParser{comma: comma} }
+
+func (fp *FieldsParser) Clone() Parser[ByteRecord] { return NewFieldsParser(fp.comma) }
+
+func (fp *FieldsParser) Reset(r io.Reader) {
+	fp.mapped = nil
+	if m, ok := r.(*MappedReader); ok {
+		fp.reader = nil
+		fp.mapped = m.Remaining()
+	} else if br, ok := r.(*bufio.Reader); ok {
+		fp.reader = br
+	} else {
+		fp.reader = bufio.NewReader(r)
+	}
+}
+
+// nextLine returns the next line of a mapped file, which needs no copying regardless of its length
+func (fp *FieldsParser) nextLine() ([]byte, error) {
+	if len(fp.mapped) == 0 {
+		return nil, io.EOF
+	}
+	i := bytes.IndexByte(fp.mapped, '\n')
+	if i < 0 {
+		i = len(fp.mapped) - 1
+	}
+	line := fp.mapped[:i+1]
+	fp.mapped = fp.mapped[i+1:]
+	return line, nil
+}
+
+func (fp *FieldsParser) NextRecord() (int, ByteRecord, error) {
+	if fp.reader == nil {
+		line, err := fp.nextLine()
+		if err != nil {
+			return 0, nil, err
+		}
+		return len(line), fp.split(line), nil
+	}
+
+	line, err := fp.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// longer than the read buffer, fall back to a copy
+		fp.line = append(fp.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = fp.reader.ReadSlice('\n')
+			fp.line = append(fp.line, line...)
+		}
+		line = fp.line
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return 0, nil, err
+	}
+
+	return len(line), fp.split(line), nil
+}
+
+func (fp *FieldsParser) split(line []byte) ByteRecord {
+	line = bytes.TrimRight(line, "\r\n")
+	fp.fields = fp.fields[:0]
+	for {
+		i := bytes.IndexByte(line, fp.comma)
+		if i < 0 {
+			fp.fields = append(fp.fields, line)
+			break
+		}
+		fp.fields = append(fp.fields, line[:i])
+		line = line[i+1:]
+	}
+	return fp.fields
+}
+
+// BytesQuickReport is QuickReport for ByteRecord. Keys are built in a reused buffer and only allocated the
+// first time they are seen.
+type BytesQuickReport struct {
+	result map[string]*int64
+	spec   *KeySpec
+	keys   keyCache
+	norm   *Normalizer
+	empty  *EmptyKeys
+	buf    []byte
+}
+
+func NewBytesQuickReport(spec *KeySpec) *BytesQuickReport {
+	return &BytesQuickReport{result: make(map[string]*int64), spec: spec, keys: newKeyCache(spec)}
+}
+
+// Normalize rewrites the key columns with n before counting, which allocates a string per column
+func (br *BytesQuickReport) Normalize(n *Normalizer) *BytesQuickReport { br.norm = n; return br }
+
+// EmptyKeys handles empty key columns with ek
+func (br *BytesQuickReport) EmptyKeys(ek *EmptyKeys) *BytesQuickReport { br.empty = ek; return br }
+
+func (br *BytesQuickReport) New() Report[ByteRecord] {
+	return NewBytesQuickReport(br.spec).Normalize(br.norm).EmptyKeys(br.empty)
+}
+
+func (br *BytesQuickReport) Name() string         { return "quick" }
+func (br *BytesQuickReport) Clear()               { br.result = make(map[string]*int64) }
+func (br *BytesQuickReport) Len() int             { return len(br.result) }
+func (br *BytesQuickReport) LogValue() slog.Value { return keysLogValue(br.Len(), br.empty) }
+
+func (br *BytesQuickReport) Top(n int) []KeyCount {
+	return topCounts(n, func(fn func(string, int64)) {
+		for k, c := range br.result {
+			fn(k, *c)
+		}
+	})
+}
+
+func (br *BytesQuickReport) Check(r ByteRecord) error {
+	_, err := br.keys.columns(len(r))
+	return err
+}
+
+func (br *BytesQuickReport) Add(r ByteRecord) {
+	keys, err := br.keys.columns(len(r))
+	if err != nil {
+		return
+	}
+	br.buf = br.buf[:0]
+	hasEmpty := false
+	for i, k := range keys {
+		if i > 0 {
+			br.buf = append(br.buf, ',')
+		}
+		n := len(br.buf)
+		if br.norm != nil {
+			br.buf = append(br.buf, br.norm.Normalize(string(r[k]))...)
+		} else {
+			br.buf = append(br.buf, r[k]...)
+		}
+		if len(br.buf) == n && br.empty != nil {
+			hasEmpty = true
+			br.buf = append(br.buf, br.empty.value...)
+		}
+	}
+	if hasEmpty && !br.empty.record() {
+		return
+	}
+
+	// the map lookup with string(buf) does not allocate
+	if c, ok := br.result[string(br.buf)]; ok {
+		*c += 1
+		return
+	}
+	c := int64(1)
+	br.result[string(br.buf)] = &c
+}
+
+func (br *BytesQuickReport) Merge(rpt Report[ByteRecord]) {
+	for k, v := range rpt.(*BytesQuickReport).result {
+		if c, ok := br.result[k]; ok {
+			*c += *v
+		} else {
+			br.result[k] = v
+		}
+	}
+}
+
+func (br *BytesQuickReport) Load(path string) error {
+	counts := make(map[string]int64)
+	if err := readCounts(path, counts); err != nil {
+		return err
+	}
+	for k, v := range counts {
+		if c, ok := br.result[k]; ok {
+			*c += v
+		} else {
+			c := v
+			br.result[k] = &c
+		}
+	}
+	return nil
+}
+
+func (br *BytesQuickReport) Output(path string) {
+	WriteCounts(path, func(fn func(string, int64)) {
+		for k, v := range br.result {
+			fn(k, *v)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFieldsParser(t *testing.T) {
+	fp := NewFieldsParser(',')
+	fp.Reset(strings.NewReader("a,b,c\r\n,x,\nlast"))
+
+	want := []string{"a|b|c", "|x|", "last"}
+	for _, w := range want {
+		_, rec, err := fp.NextRecord()
+		if err != nil {
+			t.Fatalf("NextRecord: %v", err)
+		}
+		got := make([]string, len(rec))
+		for i, f := range rec {
+			got[i] = string(f)
+		}
+		if g := strings.Join(got, "|"); g != w {
+			t.Errorf("record %q, want %q", g, w)
+		}
+	}
+	if _, _, err := fp.NextRecord(); err != io.EOF {
+		t.Errorf("after the last record: %v, want EOF", err)
+	}
+}
+
+func TestFieldsParserLongLine(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	fp := NewFieldsParser('\t')
+	fp.Reset(bufio.NewReaderSize(strings.NewReader(long+"\ty\n"), 16))
+	n, rec, err := fp.NextRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(long)+3 || len(rec) != 2 || string(rec[0]) != long || string(rec[1]) != "y" {
+		t.Errorf("got %d bytes, %d fields", n, len(rec))
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+const (
+	cacheMagic     = "LOPROC1\n"
+	cacheBlockSize = 8192 // records per block
+)
+
+// ColumnCache keeps the parsed records of every input in a compact columnar file, so later runs over the
+// same inputs, e.g. with other reports, skip decompressing and parsing them. A cache file is named after the
+// input path, size and modification time and the settings the records depend on, so a changed input or
+// parser just misses. Only inputs read without bad records are cached.
+//
+// A cache file holds blocks of up to cacheBlockSize records: the width and parsed bytes of every record,
+// then every column as a dictionary of its distinct values and, with more than one, the index of the value
+// of every record. Columns left out by the column selection have an empty dictionary and read as "".
+type ColumnCache struct {
+	dir     string
+	columns *KeySpec // nil for all
+	salt    string
+
+	hits, misses, written int64 // updated atomically
+}
+
+// NewColumnCache returns a cache in dir of the columns, nil for all, of records parsed with the settings
+// described by salt
+func NewColumnCache(dir string, columns *KeySpec, salt string) *ColumnCache {
+	return &ColumnCache{dir: dir, columns: columns, salt: salt}
+}
+
+// path returns the cache file of an input and the size of the input
+func (cc *ColumnCache) path(file string) (string, int64, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return "", 0, err
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", 0, err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00%s", abs, fi.Size(), fi.ModTime().UnixNano(), cc.salt)
+	return filepath.Join(cc.dir, hex.EncodeToString(h.Sum(nil)[:12])+".lpc"), fi.Size(), nil
+}
+
+// Open returns a reader of the cached records of an input, or nil on a miss
+func (cc *ColumnCache) Open(file string) (*CacheReader, int64, error) {
+	path, size, err := cc.path(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	fp, err := os.Open(path)
+	if os.IsNotExist(err) {
+		atomic.AddInt64(&cc.misses, 1)
+		return nil, size, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	cr, err := newCacheReader(fp)
+	if err != nil {
+		fp.Close()
+		atomic.AddInt64(&cc.misses, 1)
+		slog.Warn("ignoring invalid cache file", "file", file, "cache", path, "error", err)
+		return nil, size, nil
+	}
+	atomic.AddInt64(&cc.hits, 1)
+	return cr, size, nil
+}
+
+// Create returns a writer of the cache file of an input, which only appears once committed
+func (cc *ColumnCache) Create(file string) (*CacheWriter, error) {
+	path, _, err := cc.path(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cc.dir, 0755); err != nil {
+		return nil, err
+	}
+	fp, err := os.CreateTemp(cc.dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &CacheWriter{cc: cc, fp: fp, path: path, w: bufio.NewWriterSize(fp, 1024*1024)}, nil
+}
+
+func (cc *ColumnCache) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("hits", atomic.LoadInt64(&cc.hits)), slog.Int64("misses", atomic.LoadInt64(&cc.misses)),
+		slog.Int64("written", atomic.LoadInt64(&cc.written)))
+}
+
+// CacheWriter writes the records of an input to its cache file
+type CacheWriter struct {
+	cc     *ColumnCache
+	fp     *os.File
+	path   string
+	w      *bufio.Writer
+	header []string
+	spec   *KeySpec // the column selection with the names of the header resolved
+
+	widths []int
+	sizes  []int
+	values [][]string // by column, of the records of the block that have it
+	dict   map[string]uint64
+	buf    []byte
+	err    error
+	done   bool
+}
+
+// SetHeader stores the header of the input with its records
+func (cw *CacheWriter) SetHeader(header []string) { cw.header = CopyRecord(header) }
+
+// Add appends a parsed record of bytes bytes
+func (cw *CacheWriter) Add(rec interface{}, bytes int) {
+	if cw.err != nil {
+		return
+	}
+	if cw.sizes == nil {
+		cw.start()
+	}
+	width := 0
+	switch r := rec.(type) {
+	case LogRecord:
+		width = len(r)
+		cw.grow(width)
+		for i, v := range r {
+			cw.values[i] = append(cw.values[i], v)
+		}
+	case ByteRecord:
+		width = len(r)
+		cw.grow(width)
+		for i, v := range r {
+			cw.values[i] = append(cw.values[i], string(v))
+		}
+	}
+	cw.widths = append(cw.widths, width)
+	cw.sizes = append(cw.sizes, bytes)
+	if len(cw.widths) == cacheBlockSize {
+		cw.flush()
+	}
+}
+
+// start writes the file header once the header of the input, if any, is known
+func (cw *CacheWriter) start() {
+	cw.sizes = make([]int, 0, cacheBlockSize)
+	cw.spec = cw.cc.columns
+	if cw.spec != nil {
+		if cw.spec, cw.err = cw.spec.WithHeader(cw.header); cw.err != nil {
+			return
+		}
+	}
+	cw.w.WriteString(cacheMagic)
+	if cw.header == nil {
+		cw.uvarint(0)
+	} else {
+		cw.uvarint(uint64(len(cw.header)) + 1)
+		for _, name := range cw.header {
+			cw.str(name)
+		}
+	}
+}
+
+func (cw *CacheWriter) grow(width int) {
+	for len(cw.values) < width {
+		cw.values = append(cw.values, make([]string, 0, cacheBlockSize))
+	}
+}
+
+func (cw *CacheWriter) uvarint(n uint64) {
+	cw.buf = binary.AppendUvarint(cw.buf[:0], n)
+	cw.w.Write(cw.buf)
+}
+
+func (cw *CacheWriter) str(s string) {
+	cw.uvarint(uint64(len(s)))
+	cw.w.WriteString(s)
+}
+
+// flush writes the block of records so far
+func (cw *CacheWriter) flush() {
+	cw.uvarint(uint64(len(cw.widths)))
+	for i, width := range cw.widths {
+		cw.uvarint(uint64(width))
+		cw.uvarint(uint64(cw.sizes[i]))
+	}
+	cw.uvarint(uint64(len(cw.values)))
+	selected := selectedColumns(cw.spec, len(cw.values))
+	for c, values := range cw.values {
+		if !selected[c] {
+			cw.uvarint(0)
+			continue
+		}
+		if cw.dict == nil {
+			cw.dict = make(map[string]uint64)
+		}
+		clear(cw.dict)
+		var distinct []string
+		for _, v := range values {
+			if _, ok := cw.dict[v]; !ok {
+				cw.dict[v] = uint64(len(distinct))
+				distinct = append(distinct, v)
+			}
+		}
+		cw.uvarint(uint64(len(distinct)))
+		for _, v := range distinct {
+			cw.str(v)
+		}
+		if len(distinct) > 1 {
+			for _, v := range values {
+				cw.uvarint(cw.dict[v])
+			}
+		}
+	}
+	cw.widths, cw.sizes = cw.widths[:0], cw.sizes[:0]
+	for c := range cw.values {
+		cw.values[c] = cw.values[c][:0]
+	}
+}
+
+// selectedColumns tells which of width columns the spec, nil for all, keeps
+func selectedColumns(spec *KeySpec, width int) []bool {
+	selected := make([]bool, width)
+	if spec == nil {
+		for c := range selected {
+			selected[c] = true
+		}
+		return selected
+	}
+	columns := spec.static
+	if columns == nil {
+		columns, _ = spec.resolve(width)
+	}
+	for _, c := range columns {
+		if c < width {
+			selected[c] = true
+		}
+	}
+	return selected
+}
+
+// Commit writes the last block and the decompressed size of the input and moves the cache file in place
+func (cw *CacheWriter) Commit(bytes int64) error {
+	if cw.sizes == nil {
+		cw.start()
+	}
+	if len(cw.widths) > 0 {
+		cw.flush()
+	}
+	cw.uvarint(0)
+	cw.uvarint(uint64(bytes))
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	if err := cw.fp.Close(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	if cw.err == nil {
+		cw.err = os.Rename(cw.fp.Name(), cw.path)
+	}
+	cw.done = true
+	if cw.err != nil {
+		os.Remove(cw.fp.Name())
+		return cw.err
+	}
+	atomic.AddInt64(&cw.cc.written, 1)
+	return nil
+}
+
+// Abort drops the cache file. It does nothing after Commit.
+func (cw *CacheWriter) Abort() {
+	if !cw.done {
+		cw.fp.Close()
+		os.Remove(cw.fp.Name())
+		cw.done = true
+	}
+}
+
+// CacheReader reads the records of a cache file. It is a Parser of LogRecord or ByteRecord, whose fields
+// point into the dictionaries of the current block.
+type CacheReader struct {
+	fp     *os.File
+	r      *bufio.Reader
+	header []string
+	bytes  int64 // decompressed size of the input, known at the end
+
+	widths []int
+	sizes  []int
+	next   int
+	strs   []string // the fields of the records of the block, one after the other
+	raw    [][]byte
+	offset int
+}
+
+func newCacheReader(fp *os.File) (*CacheReader, error) {
+	cr := &CacheReader{fp: fp, r: bufio.NewReaderSize(fp, 1024*1024)}
+	magic := make([]byte, len(cacheMagic))
+	if _, err := io.ReadFull(cr.r, magic); err != nil || string(magic) != cacheMagic {
+		return nil, fmt.Errorf("not a cache file")
+	}
+	n, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		cr.header = make([]string, n-1)
+		for i := range cr.header {
+			if cr.header[i], err = cr.str(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cr, nil
+}
+
+func (cr *CacheReader) Close() error { return cr.fp.Close() }
+
+func (cr *CacheReader) ReadHeader() ([]string, error) { return cr.header, nil }
+
+func (cr *CacheReader) str() (string, error) {
+	b, err := cr.bytesField()
+	return string(b), err
+}
+
+func (cr *CacheReader) bytesField() ([]byte, error) {
+	n, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(cr.r, b)
+	return b, err
+}
+
+// block reads the next block, with strings for LogRecords or byte slices for ByteRecords. It returns
+// io.EOF after the last one.
+func (cr *CacheReader) block(strs bool) error {
+	n, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return unexpected(err)
+	}
+	if n == 0 {
+		total, err := binary.ReadUvarint(cr.r)
+		if err != nil {
+			return unexpected(err)
+		}
+		cr.bytes = int64(total)
+		return io.EOF
+	}
+	cr.widths, cr.sizes = cr.widths[:0], cr.sizes[:0]
+	fields := 0
+	for i := uint64(0); i < n; i++ {
+		width, err := binary.ReadUvarint(cr.r)
+		if err != nil {
+			return unexpected(err)
+		}
+		size, err := binary.ReadUvarint(cr.r)
+		if err != nil {
+			return unexpected(err)
+		}
+		cr.widths = append(cr.widths, int(width))
+		cr.sizes = append(cr.sizes, int(size))
+		fields += int(width)
+	}
+	// the fields of record i start at the sum of the widths before it
+	starts := make([]int, len(cr.widths))
+	for i := 1; i < len(starts); i++ {
+		starts[i] = starts[i-1] + cr.widths[i-1]
+	}
+	if strs {
+		cr.strs = resize(cr.strs, fields)
+	} else {
+		cr.raw = resize(cr.raw, fields)
+	}
+
+	ncols, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return unexpected(err)
+	}
+	for c := 0; c < int(ncols); c++ {
+		ndict, err := binary.ReadUvarint(cr.r)
+		if err != nil {
+			return unexpected(err)
+		}
+		dict := make([][]byte, ndict)
+		for i := range dict {
+			if dict[i], err = cr.bytesField(); err != nil {
+				return unexpected(err)
+			}
+		}
+		var dictStrs []string
+		if strs {
+			dictStrs = make([]string, ndict)
+			for i, b := range dict {
+				dictStrs[i] = string(b)
+			}
+		}
+		for i, width := range cr.widths {
+			if c >= width || ndict == 0 {
+				continue
+			}
+			var idx uint64
+			if ndict > 1 {
+				if idx, err = binary.ReadUvarint(cr.r); err != nil {
+					return unexpected(err)
+				} else if idx >= ndict {
+					return fmt.Errorf("corrupt cache file: value %d of %d", idx, ndict)
+				}
+			}
+			if strs {
+				cr.strs[starts[i]+c] = dictStrs[idx]
+			} else {
+				cr.raw[starts[i]+c] = dict[idx]
+			}
+		}
+	}
+	cr.next, cr.offset = 0, 0
+	return nil
+}
+
+// resize returns s with n zero elements, reusing its array when large enough
+func resize[E any](s []E, n int) []E {
+	if cap(s) < n {
+		return make([]E, n)
+	}
+	s = s[:n]
+	clear(s)
+	return s
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// nextRecord returns the bytes and the position of the fields of the next record
+func (cr *CacheReader) nextRecord(strs bool) (int, int, int, error) {
+	if cr.next == len(cr.widths) {
+		if err := cr.block(strs); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	i := cr.next
+	cr.next += 1
+	from := cr.offset
+	cr.offset += cr.widths[i]
+	return cr.sizes[i], from, cr.offset, nil
+}
+
+// cacheParser adapts a CacheReader to the Parser of the record type
+type cacheParser[T any] struct {
+	*CacheReader
+}
+
+func (cp cacheParser[T]) Clone() Parser[T]  { return cp }
+func (cp cacheParser[T]) Reset(r io.Reader) {}
+
+func (cp cacheParser[T]) NextRecord() (int, T, error) {
+	var rec T
+	switch p := interface{}(&rec).(type) {
+	case *LogRecord:
+		n, from, to, err := cp.nextRecord(true)
+		if err != nil {
+			return 0, rec, err
+		}
+		*p = cp.strs[from:to:to]
+		return n, rec, nil
+	case *ByteRecord:
+		n, from, to, err := cp.nextRecord(false)
+		if err != nil {
+			return 0, rec, err
+		}
+		*p = cp.raw[from:to:to]
+		return n, rec, nil
+	}
+	return 0, rec, fmt.Errorf("records of type %T cannot be cached", rec)
+}
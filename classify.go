@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Classifier maps the raw values of columns to categories, e.g. URLs to endpoint names or status codes to
+// classes, with rule files maintained as data rather than code. -classify takes columns=FILE pairs
+// separated by ;, with columns selected like -units. A rule file is the YAML subset of schemas, or JSON:
+//
+//	default: other       # the category of the values no rule matches, kept as they are without it
+//	rules:
+//	  - exact: /
+//	    class: home
+//	  - prefix: /api/v1/
+//	    class: api-v1
+//	  - regex: "^/users/[0-9]+$"
+//	    class: user
+//
+// Exact rules win, then the prefix and regex rules are tried in order. Regexes match anywhere unless
+// anchored. It is safe for concurrent use.
+type Classifier struct {
+	rules []classifyRule
+}
+
+type classifyRule struct {
+	columns *KeySpec
+	table   *ClassTable
+}
+
+// ClassTable is the compiled rule file of a Classifier
+type ClassTable struct {
+	Default *string     `json:"default"` // nil to keep the values no rule matches
+	Rules   []ClassRule `json:"rules"`
+
+	exact   map[string]string
+	ordered []ClassRule // prefix and regex rules
+}
+
+type ClassRule struct {
+	Exact  string `json:"exact"`
+	Prefix string `json:"prefix"`
+	Regex  string `json:"regex"`
+	Class  string `json:"class"`
+
+	re *regexp.Regexp
+}
+
+// NewClassifier parses the columns=FILE pairs and loads the files
+func NewClassifier(rules string) (*Classifier, error) {
+	cl := &Classifier{}
+	for _, rule := range strings.Split(rules, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		columns, path, ok := strings.Cut(rule, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("classify %s: want columns=FILE", rule)
+		}
+		spec, err := ParseKeySpec(columns)
+		if err != nil {
+			return nil, fmt.Errorf("classify %s: %v", rule, err)
+		}
+		if spec.HasNames() {
+			return nil, fmt.Errorf("classify %s: columns must be numbers", rule)
+		}
+		table, err := LoadClassTable(path)
+		if err != nil {
+			return nil, err
+		}
+		cl.rules = append(cl.rules, classifyRule{spec, table})
+	}
+	if len(cl.rules) == 0 {
+		return nil, fmt.Errorf("no classify rules")
+	}
+	return cl, nil
+}
+
+// classifyFiles returns the rule files of -classify, which the result cache fingerprints
+func classifyFiles(rules string) []string {
+	var files []string
+	for _, rule := range strings.Split(rules, ";") {
+		if _, path, ok := strings.Cut(rule, "="); ok && path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// LoadClassTable reads a rule file
+func LoadClassTable(path string) (*ClassTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ct := &ClassTable{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		err = json.Unmarshal(data, ct)
+	} else {
+		err = ct.parseYAML(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("classify %s: %v", path, err)
+	}
+	if err := ct.compile(); err != nil {
+		return nil, fmt.Errorf("classify %s: %v", path, err)
+	}
+	return ct, nil
+}
+
+func (ct *ClassTable) parseYAML(data string) error {
+	top, rules, err := readYAMLList(data)
+	if err != nil {
+		return err
+	}
+	for key, value := range top {
+		switch key {
+		case "default":
+			ct.Default = &value
+		case "rules":
+		default:
+			return fmt.Errorf("unknown key %s", key)
+		}
+	}
+	for _, r := range rules {
+		var cr ClassRule
+		for key, value := range r {
+			switch key {
+			case "exact":
+				cr.Exact = value
+			case "prefix":
+				cr.Prefix = value
+			case "regex":
+				cr.Regex = value
+			case "class":
+				cr.Class = value
+			default:
+				return fmt.Errorf("unknown rule key %s", key)
+			}
+		}
+		ct.Rules = append(ct.Rules, cr)
+	}
+	return nil
+}
+
+func (ct *ClassTable) compile() error {
+	ct.exact = make(map[string]string)
+	for i, r := range ct.Rules {
+		set := 0
+		for _, s := range []string{r.Exact, r.Prefix, r.Regex} {
+			if s != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("rule %d: want one of exact, prefix or regex", i+1)
+		}
+		switch {
+		case r.Exact != "":
+			if _, ok := ct.exact[r.Exact]; !ok {
+				ct.exact[r.Exact] = r.Class
+			}
+		case r.Regex != "":
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return fmt.Errorf("rule %d: %v", i+1, err)
+			}
+			r.re = re
+			ct.ordered = append(ct.ordered, r)
+		default:
+			ct.ordered = append(ct.ordered, r)
+		}
+	}
+	return nil
+}
+
+// Class returns the category of v, and false if no rule matches and there is no default
+func (ct *ClassTable) Class(v string) (string, bool) {
+	if class, ok := ct.exact[v]; ok {
+		return class, true
+	}
+	for _, r := range ct.ordered {
+		if r.re != nil && r.re.MatchString(v) || r.re == nil && strings.HasPrefix(v, r.Prefix) {
+			return r.Class, true
+		}
+	}
+	if ct.Default == nil {
+		return v, false
+	}
+	return *ct.Default, true
+}
+
+// Classify rewrites the configured columns of a LogRecord or ByteRecord in place. Columns a record does
+// not have are left alone.
+func (cl *Classifier) Classify(rec interface{}) {
+	switch r := rec.(type) {
+	case LogRecord:
+		for _, rule := range cl.rules {
+			for _, c := range specColumns(rule.columns, len(r)) {
+				if v, ok := rule.table.Class(r[c]); ok {
+					r[c] = v
+				}
+			}
+		}
+	case ByteRecord:
+		for _, rule := range cl.rules {
+			for _, c := range specColumns(rule.columns, len(r)) {
+				if v, ok := rule.table.Class(string(r[c])); ok {
+					r[c] = []byte(v)
+				}
+			}
+		}
+	}
+}
+
+// Classification is the Transformer of a Classifier for records of type T
+type Classification[T any] struct{ *Classifier }
+
+func (c Classification[T]) Transform(rec T) T {
+	c.Classify(rec)
+	return rec
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// FieldState tells apart a column with a value, an empty one (such as "" in a CSV input) and one a record
+// does not have at all, e.g. a trailing field of a short line read with -ragged
+type FieldState int
+
+const (
+	FieldAbsent FieldState = iota
+	FieldEmpty
+	FieldValue
+)
+
+func (fs FieldState) String() string {
+	return [...]string{"absent", "empty", "value"}[fs]
+}
+
+// Field returns column i of a LogRecord or ByteRecord and its state
+func Field(rec interface{}, i int) (string, FieldState) {
+	var v string
+	switch r := rec.(type) {
+	case LogRecord:
+		if i >= len(r) {
+			return "", FieldAbsent
+		}
+		v = r[i]
+	case ByteRecord:
+		if i >= len(r) {
+			return "", FieldAbsent
+		}
+		v = string(r[i])
+	default:
+		return "", FieldAbsent
+	}
+	if v == "" {
+		return "", FieldEmpty
+	}
+	return v, FieldValue
+}
+
+// ColumnsReport profiles the columns of the records: how many records have a value, an empty value or no
+// value at all in every column, the last ones being the records shorter than the widest one
+type ColumnsReport[T any] struct {
+	records int64
+	values  []int64
+	empty   []int64
+	header  []string
+}
+
+func NewColumnsReport[T any]() *ColumnsReport[T] { return &ColumnsReport[T]{} }
+
+func (cr *ColumnsReport[T]) New() Report[T]    { return NewColumnsReport[T]() }
+func (cr *ColumnsReport[T]) Name() string      { return "columns" }
+func (cr *ColumnsReport[T]) Extension() string { return ".csv" }
+func (cr *ColumnsReport[T]) Clear()            { cr.records, cr.values, cr.empty = 0, nil, nil }
+
+func (cr *ColumnsReport[T]) SetHeader(columns []string) error {
+	if cr.header == nil {
+		cr.header = CopyRecord(columns)
+	}
+	return nil
+}
+
+func (cr *ColumnsReport[T]) grow(width int) {
+	for len(cr.values) < width {
+		cr.values = append(cr.values, 0)
+		cr.empty = append(cr.empty, 0)
+	}
+}
+
+func (cr *ColumnsReport[T]) Add(rec T) {
+	cr.records += 1
+	switch r := interface{}(rec).(type) {
+	case LogRecord:
+		cr.grow(len(r))
+		for i, v := range r {
+			cr.count(i, v == "")
+		}
+	case ByteRecord:
+		cr.grow(len(r))
+		for i, v := range r {
+			cr.count(i, len(v) == 0)
+		}
+	}
+}
+
+func (cr *ColumnsReport[T]) count(i int, empty bool) {
+	if empty {
+		cr.empty[i] += 1
+	} else {
+		cr.values[i] += 1
+	}
+}
+
+func (cr *ColumnsReport[T]) Merge(rpt Report[T]) {
+	ncr := rpt.(*ColumnsReport[T])
+	cr.grow(len(ncr.values))
+	cr.records += ncr.records
+	for i := range ncr.values {
+		cr.values[i] += ncr.values[i]
+		cr.empty[i] += ncr.empty[i]
+	}
+	if cr.header == nil {
+		cr.header = ncr.header
+	}
+}
+
+func (cr *ColumnsReport[T]) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("columns", len(cr.values)), slog.Int64("records", cr.records))
+}
+
+// Output writes a column,name,values,empty,absent line per column
+func (cr *ColumnsReport[T]) Output(path string) {
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := csv.NewWriter(fp)
+	w.Write([]string{"column", "name", "values", "empty", "absent"})
+	for i := range cr.values {
+		var name string
+		if i < len(cr.header) {
+			name = cr.header[i]
+		}
+		w.Write([]string{strconv.Itoa(i), name, strconv.FormatInt(cr.values[i], 10), strconv.FormatInt(cr.empty[i], 10),
+			strconv.FormatInt(cr.records-cr.values[i]-cr.empty[i], 10)})
+	}
+	w.Flush()
+}
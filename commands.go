@@ -0,0 +1,692 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Command is a subcommand of the CLI. It gets the arguments after its name and returns the exit code.
+// Flags registers its flags on a flag set, for help and completion, and is nil for commands without flags.
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) int
+	Flags func(fs *flag.FlagSet)
+}
+
+var commands []*Command
+
+// Exit codes of run and batch
+const (
+	ExitOK          = 0
+	ExitPartial     = 1 // some files failed, or the run failed after it started
+	ExitConfig      = 2 // bad flags or config, nothing was processed
+	ExitFailed      = 3 // every file failed
+	ExitInterrupted = 130
+)
+
+// exitCode maps the outcome of a run to its exit code
+func exitCode(ctl *Control, failures *Failures, err error) int {
+	switch {
+	case err == ErrCanceled:
+		return ExitInterrupted
+	case err != nil:
+		if _, ok := err.(ConfigError); ok {
+			return ExitConfig
+		}
+		return ExitPartial
+	case failures.Count() > 0 && int64(failures.Count()) >= ctl.Attempted():
+		return ExitFailed
+	case failures.Count() > 0:
+		return ExitPartial
+	}
+	return ExitOK
+}
+
+// cancelOnInterrupt cancels the controls on the first SIGINT or SIGTERM, so the run stops at the next
+// checkpoint. A second signal kills the process.
+func cancelOnInterrupt(ctls ...*Control) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ch:
+			signal.Stop(ch)
+			slog.Warn("interrupted, stopping")
+			for _, ctl := range ctls {
+				ctl.Cancel()
+			}
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+func init() {
+	runFlags := func(fs *flag.FlagSet) { new(Config).RegisterFlags(fs) }
+	jsonFlag := func(fs *flag.FlagSet) { listFlags(fs) }
+	commands = []*Command{
+		{"run", "process the input files (the default when no command is given)", runCommand, runFlags},
+		{"batch", "run the jobs of a batch file together on one worker pool", batchCommand, func(fs *flag.FlagSet) {
+			batchFlags(fs)
+			new(LogFlags).RegisterFlags(fs)
+		}},
+		{"validate", "check the run flags, parser and reports against a sample of the input", validateCommand, func(fs *flag.FlagSet) {
+			runFlags(fs)
+			validateFlags(fs)
+		}},
+		{"infer", "infer a schema for -schema from a sample of the input", inferCommand, func(fs *flag.FlagSet) {
+			runFlags(fs)
+			inferFlags(fs)
+		}},
+		{"compression", "estimate the stored size of the inputs recompressed at gzip levels", compressionCommand, func(fs *flag.FlagSet) {
+			runFlags(fs)
+			adviseFlags(fs)
+		}},
+		{"parsers", "list the registered parsers and their options", listCommand("parsers", parserCapabilities), jsonFlag},
+		{"reports", "list the registered reports and their options", listCommand("reports", reportCapabilities), jsonFlag},
+		{"transforms", "list the normalize, rewrite, units, classify, buckets and redact transforms", listCommand("transforms", func() []Capability { return transforms }), jsonFlag},
+		{"sinks", "list the sinks results are written to", listCommand("sinks", func() []Capability { return sinks }), jsonFlag},
+		{"merge", "combine the results of several runs with the reports' Merge", mergeCommand, runFlags},
+		{"replay", "re-emit the parsed records paced by their timestamps", replayCommand, func(fs *flag.FlagSet) {
+			runFlags(fs)
+			replayFlags(fs)
+		}},
+		{"repl", "load the inputs in memory and query them interactively", replCommand, runFlags},
+		{"diff", "compare the results of two runs", diffCommand, nil},
+		{"serve", "run jobs submitted over a REST API", serveCommand, func(fs *flag.FlagSet) {
+			serveFlags(fs)
+			new(LogFlags).RegisterFlags(fs)
+		}},
+		{"help", "describe the commands, parsers, reports, transforms and sinks, or the flags of a command", helpCommand, nil},
+		{"completion", "print a bash, zsh or fish completion script", completionCommand, nil},
+	}
+}
+
+// Main dispatches to a subcommand. Without one the arguments are the flags of run, as before there were
+// subcommands.
+func Main(args []string) int {
+	if len(args) > 0 {
+		if cmd := findCommand(args[0]); cmd != nil {
+			return cmd.Run(args[1:])
+		}
+		if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+			return helpCommand(nil)
+		}
+		if args[0] == "-version" || args[0] == "--version" {
+			fmt.Println(GetBuildInfo())
+			return 0
+		}
+		if !strings.HasPrefix(args[0], "-") && !strings.HasPrefix(args[0], "@") {
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+			usage(os.Stderr)
+			return 2
+		}
+	}
+	return runCommand(args)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.Name, cmd.Usage)
+	}
+	fmt.Fprintf(w, "\nUse \"%s help <command>\" for the flags of a command, \"%s -version\" for the version.\n",
+		filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
+	fmt.Fprintf(w, "Flags can also be set by %s<FLAG> environment variables, e.g. %sLOG_LEVEL=debug, and read from @file arguments.\n", EnvPrefix, EnvPrefix)
+}
+
+func runCommand(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	cfg.RegisterFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.SetPriority(); err != nil {
+		slog.Error("failed to set the priority", "error", err)
+		return 2
+	}
+	cfg.SetMaxProcs()
+
+	stopProfiling := cfg.StartProfiling()
+	ctl, started := NewControl(), time.Now()
+	stopInterrupt := cancelOnInterrupt(ctl)
+	failures, err := cfg.RunWith(ctl)
+	stopInterrupt()
+	stopProfiling()
+	if cfg.Notify != "" {
+		if err := Notify(cfg.Notify, NewRunSummary(&cfg, started, ctl, failures, err)); err != nil {
+			slog.Error("failed to notify", "error", err)
+		}
+	}
+	// the failed files are listed also when they aborted the run
+	if failures != nil {
+		failures.Quarantine(cfg.Out + "/quarantine.txt")
+	}
+	if err != nil {
+		slog.Error("run failed", "error", err)
+	}
+	return exitCode(ctl, failures, err)
+}
+
+func validateFlags(fs *flag.FlagSet) (config *string, sample *int) {
+	return fs.String("config", "", "JSON or YAML job file of run flags, which the flags on the command line override"),
+		fs.Int("sample", 1000, "records of the first input checked against the parser, schema and reports, 0 to not read any input")
+}
+
+func validateCommand(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	cfg.RegisterFlags(fs)
+	config, sample := validateFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *config != "" {
+		overrides := map[string]string{}
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name != "config" && f.Name != "sample" {
+				overrides[f.Name] = f.Value.String()
+			}
+		})
+		if err := cfg.LoadFile(*config, overrides); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if err := cfg.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.CheckOutput(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *sample > 0 {
+		if err := cfg.CheckSample(*sample, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	fmt.Println("ok")
+	return 0
+}
+
+// Validate checks the settings the way Run would, without processing any file
+func (cfg *Config) Validate() error {
+	if _, err := ParseErrorPolicy(cfg.OnError); err != nil {
+		return err
+	}
+	if _, err := cfg.ListFiles(); err != nil {
+		return err
+	}
+	if _, err := cfg.TimeParser(); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(cfg.Out); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", cfg.Out)
+	}
+
+	var err error
+	switch cfg.Records {
+	case "string":
+		_, err = BuildPipeline(cfg, parsers, reports, "csv")
+	case "bytes":
+		_, err = BuildPipeline(cfg, byteParsers, byteReports, "fields")
+	default:
+		err = fmt.Errorf("unknown record type: %s", cfg.Records)
+	}
+	return err
+}
+
+// CheckOutput checks that the results can be written and the -notify and -task-queue servers are reachable
+func (cfg *Config) CheckOutput() error {
+	fp, err := ioutil.TempFile(cfg.Out, ".validate-")
+	if err != nil {
+		return fmt.Errorf("-out is not writable: %v", err)
+	}
+	fp.Close()
+	os.Remove(fp.Name())
+
+	ports := map[string]string{"http": "80", "https": "443", "redis": "6379", "sqs": "443"}
+	for name, target := range map[string]string{"notify": cfg.Notify, "task-queue": cfg.TaskQueue} {
+		if target == "" {
+			continue
+		}
+		u, err := url.Parse(target)
+		if err != nil || ports[u.Scheme] == "" || u.Host == "" {
+			return fmt.Errorf("-%s: not a supported URL: %s", name, target)
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), ports[u.Scheme])
+		}
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("-%s: %v", name, err)
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// CheckSample checks the first n records of the first input against the parser, the schema and the
+// reports, e.g. that the key columns exist, and prints how many were rejected. It fails if all were.
+func (cfg *Config) CheckSample(n int, w io.Writer) error {
+	files, err := cfg.ListFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(w, "no input to sample")
+		return nil
+	}
+	switch cfg.Records {
+	case "string":
+		return checkSample(cfg, parsers, reports, "csv", files[0], n, w)
+	case "bytes":
+		return checkSample(cfg, byteParsers, byteReports, "fields", files[0], n, w)
+	}
+	return fmt.Errorf("unknown record type: %s", cfg.Records)
+}
+
+func checkSample[T any](cfg *Config, pr *Registry[Parser[T]], rr *Registry[Report[T]], defaultParser, file string, n int, w io.Writer) error {
+	p, err := BuildPipeline(cfg, pr, rr, defaultParser)
+	if err != nil {
+		return err
+	}
+	records, rejected, first, err := p.Sample(file, n)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	fmt.Fprintf(w, "sampled %d records of %s, %d rejected\n", records, file, rejected)
+	if rejected > 0 && rejected == records {
+		return fmt.Errorf("%s: every sampled record was rejected, the first at %v", file, first)
+	} else if rejected > 0 {
+		fmt.Fprintf(w, "first rejected at %v\n", first)
+	}
+	return nil
+}
+
+func mergeCommand(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	cfg.RegisterFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// -in is a list of result directories here; the reports are built from the flags of the runs
+	dirs := strings.Split(cfg.In, ",")
+	var err error
+	switch cfg.Records {
+	case "string":
+		err = mergeRuns(&cfg, reports, dirs)
+	case "bytes":
+		err = mergeRuns(&cfg, byteReports, dirs)
+	default:
+		err = ConfigError{fmt.Errorf("unknown record type: %s", cfg.Records)}
+	}
+	if err != nil {
+		slog.Error("merge failed", "error", err)
+		if _, ok := err.(ConfigError); ok {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+func mergeRuns[T any](cfg *Config, rr *Registry[Report[T]], dirs []string) error {
+	rpts, err := BuildReports(cfg, rr)
+	if err != nil {
+		return err
+	}
+	sink, _, err := cfg.Sink()
+	if err != nil {
+		return err
+	}
+	return MergeResults(rpts, dirs, sink)
+}
+
+// MergeResults loads the result-<name><extension> files of each report from the directories of separate runs,
+// merges them with the report's Merge and writes the merged reports to the sink
+func MergeResults[T any](rpts []Report[T], dirs []string, sink Sink) error {
+	for _, rpt := range rpts {
+		if !canLoad(rpt) {
+			return ConfigError{fmt.Errorf("report %s cannot be merged, as its results are not all of it; quick, sum, counters and seen can", rpt.Name())}
+		}
+	}
+
+	for _, rpt := range rpts {
+		for _, dir := range dirs {
+			part := rpt.New()
+			if err := part.(Loader).Load(dir + "/result-" + rpt.Name() + extensionOf(rpt)); err != nil {
+				return err
+			}
+			rpt.Merge(part)
+		}
+		slog.Info("merged", "report", rpt.Name(), "runs", len(dirs))
+		if err := sink.Write(rpt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func diffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "Usage: diff <result dir A> <result dir B>") }
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	differ, err := DiffResults(fs.Arg(0), fs.Arg(1), os.Stdout)
+	if err != nil {
+		slog.Error("diff failed", "error", err)
+		return 2
+	}
+	if differ {
+		return 1
+	}
+	return 0
+}
+
+// DiffResults compares the result files of two runs and writes the added (+), removed (-) and changed (~)
+// keys with the change of their values. It returns whether the results differ.
+func DiffResults(a, b string, w io.Writer) (bool, error) {
+	as, err := resultFiles(a)
+	if err != nil {
+		return false, err
+	}
+	bs, err := resultFiles(b)
+	if err != nil {
+		return false, err
+	}
+
+	differ := false
+	for _, name := range sortedNames(as) {
+		if _, ok := bs[name]; !ok {
+			fmt.Fprintf(w, "only in %s: %s\n", a, name)
+			differ = true
+		}
+	}
+	for _, name := range sortedNames(bs) {
+		if _, ok := as[name]; !ok {
+			fmt.Fprintf(w, "only in %s: %s\n", b, name)
+			differ = true
+			continue
+		}
+		d, err := diffResult(name, as[name], bs[name], w)
+		if err != nil {
+			return differ, err
+		}
+		differ = differ || d
+	}
+	return differ, nil
+}
+
+// diffResult compares the key,value text or CSV result files a and b. Other results, e.g. of extract,
+// are only compared as a whole.
+func diffResult(name, a, b string, w io.Writer) (bool, error) {
+	ext := filepath.Ext(name)
+	if ext != ".txt" && ext != ".csv" {
+		da, err := ioutil.ReadFile(a)
+		if err != nil {
+			return false, err
+		}
+		db, err := ioutil.ReadFile(b)
+		if err != nil {
+			return false, err
+		}
+		if string(da) == string(db) {
+			return false, nil
+		}
+		fmt.Fprintf(w, "--- %s\n~ contents differ\n", name)
+		return true, nil
+	}
+
+	ta, err := readResult(a)
+	if err != nil {
+		return false, err
+	}
+	tb, err := readResult(b)
+	if err != nil {
+		return false, err
+	}
+	if strings.Join(ta.columns, ",") != strings.Join(tb.columns, ",") {
+		fmt.Fprintf(w, "--- %s\n~ columns %s -> %s\n", name, strings.Join(ta.columns, ","), strings.Join(tb.columns, ","))
+		return true, nil
+	}
+
+	keys := make(map[string]string, len(ta.rows))
+	for k := range ta.rows {
+		keys[k] = ""
+	}
+	for k := range tb.rows {
+		keys[k] = ""
+	}
+	header := false
+	line := func(format string, args ...interface{}) {
+		if !header {
+			fmt.Fprintf(w, "--- %s\n", name)
+			header = true
+		}
+		fmt.Fprintf(w, format, args...)
+	}
+	for _, k := range sortedNames(keys) {
+		va, ina := ta.rows[k]
+		vb, inb := tb.rows[k]
+		switch {
+		case !ina:
+			line("+ %s\t%s\n", k, strings.Join(vb, ","))
+		case !inb:
+			line("- %s\t%s\n", k, strings.Join(va, ","))
+		default:
+			for i := range va {
+				if va[i] == vb[i] {
+					continue
+				}
+				column := ""
+				if ta.columns != nil {
+					column = "\t" + ta.columns[i]
+				}
+				line("~ %s%s\t%s\n", k, column, valueChange(va[i], vb[i]))
+			}
+		}
+	}
+	return header, nil
+}
+
+// resultTable is a result file read for diffing: the values of each key, and the names of the value
+// columns, nil for key,value text results
+type resultTable struct {
+	columns []string
+	rows    map[string][]string
+}
+
+// readResult reads a key,value text result, whose key may contain commas, or a CSV result with a header
+// and the key in its first column. Rows with more fields than the header have a key of several columns,
+// joined by commas as in text results.
+func readResult(path string) (*resultTable, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	rt := &resultTable{rows: make(map[string][]string)}
+	if filepath.Ext(path) == ".txt" {
+		scanner := bufio.NewScanner(fp)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			line := scanner.Text()
+			i := strings.LastIndexByte(line, ',')
+			if i < 0 {
+				return nil, fmt.Errorf("%s:%d: no value", path, n)
+			}
+			rt.rows[line[:i]] = []string{line[i+1:]}
+		}
+		return rt, scanner.Err()
+	}
+
+	r := csv.NewReader(fp)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return rt, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	rt.columns = header[1:]
+	for n := 2; ; n++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rt, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		keys := len(row) - len(rt.columns)
+		if keys < 1 {
+			return nil, fmt.Errorf("%s:%d: %d fields, the header has %d", path, n, len(row), len(header))
+		}
+		rt.rows[strings.Join(row[:keys], ",")] = row[keys:]
+	}
+}
+
+// valueChange formats the change of a value. Numbers are compared exactly whether the report wrote
+// integers, e.g. counts and int64 sums, or decimals, e.g. float64 and decimal sums.
+func valueChange(a, b string) string {
+	ra, oka := new(big.Rat).SetString(a)
+	rb, okb := new(big.Rat).SetString(b)
+	if !oka || !okb {
+		return a + " -> " + b
+	}
+	d := new(big.Rat).Sub(rb, ra)
+	sign := ""
+	if d.Sign() >= 0 {
+		sign = "+"
+	}
+	change := fmt.Sprintf("%s -> %s\t%s%s", a, b, sign, d.FloatString(max(decimals(a), decimals(b))))
+	if ra.Sign() != 0 {
+		pct, _ := new(big.Rat).Quo(d, ra).Float64()
+		change += fmt.Sprintf("\t%+.1f%%", pct*100)
+	}
+	return change
+}
+
+// decimals returns the digits after the decimal point of a number
+func decimals(s string) int {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// resultFiles maps the names of the result files in a directory to their paths
+func resultFiles(dir string) (map[string]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.IsDir() && strings.HasPrefix(name, "result-") {
+			files[name] = dir + "/" + name
+		}
+	}
+	return files, nil
+}
+
+// readCounts adds the key,count lines of a result file to counts. The key may contain commas.
+func readCounts(path string, counts map[string]int64) error {
+	return readResultLines(path, func(line string) error {
+		key, values, err := splitValues(line, 1)
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		counts[key] += v
+		return nil
+	})
+}
+
+// readResultLines calls fn with every line of a result file, failing with the line number
+func readResultLines(path string, fn func(line string) error) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if err := fn(scanner.Text()); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitValues splits the last n comma separated values off a result line, leaving the key, which may
+// have commas itself
+func splitValues(line string, n int) (string, []string, error) {
+	values := make([]string, n)
+	for i := n - 1; i >= 0; i-- {
+		j := strings.LastIndexByte(line, ',')
+		if j < 0 {
+			return "", nil, fmt.Errorf("want a key and %d values", n)
+		}
+		line, values[i] = line[:j], line[j+1:]
+	}
+	return line, values, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeResult(t *testing.T, dir, name, data string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeResults(t *testing.T) {
+	a, b, out := t.TempDir(), t.TempDir(), t.TempDir()
+	writeResult(t, a, "result-quick.txt", "a,1\nb,c,2\n")
+	writeResult(t, b, "result-quick.txt", "a,3\nd,4\n")
+
+	rpt := quickReport(t, "0")
+	if err := MergeResults([]Report[LogRecord]{rpt}, []string{a, b}, NewDirSink(out)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(out, "result-quick.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "a,4\nb,c,2\nd,4\n"; got != want {
+		t.Errorf("merged\n%s\nwant\n%s", got, want)
+	}
+
+	if err := MergeResults([]Report[LogRecord]{rpt}, []string{a, t.TempDir()}, NewDirSink(out)); err == nil {
+		t.Error("merged a run without results")
+	}
+}
+
+// buildReports builds the reports of the run flags
+func buildReports(t *testing.T, args ...string) []Report[LogRecord] {
+	t.Helper()
+	var cfg Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	rpts, err := BuildReports(&cfg, reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rpts
+}
+
+func TestMergeLoaders(t *testing.T) {
+	a, b, out := t.TempDir(), t.TempDir(), t.TempDir()
+	writeResult(t, a, "result-sum.txt", "a,1.5\nb,c,2\n")
+	writeResult(t, b, "result-sum.txt", "a,0.25\n")
+	writeResult(t, a, "result-counters.csv", "key,records,errors\nx,y,10,1\nz,3,0\n")
+	writeResult(t, b, "result-counters.csv", "key,records,errors\nx,y,5,2\n")
+	writeResult(t, a, "result-seen.txt", "u,2024-01-02T00:00:00Z,2024-01-03T00:00:00Z,4\n")
+	writeResult(t, b, "result-seen.txt", "u,2024-01-01T00:00:00Z,2024-01-02T12:00:00Z,1\nv,2024-01-05T00:00:00Z,2024-01-05T00:00:00Z,1\n")
+
+	rpts := buildReports(t, "-reports", "sum,counters,seen", "-keys", "0", "-sum-column", "1", "-accumulate", "decimal",
+		"-counters", "errors:1 = E", "-time-column", "1")
+	if err := MergeResults(rpts, []string{a, b}, NewDirSink(out)); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"result-sum.txt":      "a,1.75\nb,c,2\n",
+		"result-counters.csv": "key,records,errors\nx,y,15,3\nz,3,0\n",
+		"result-seen.txt":     "u,2024-01-01T00:00:00Z,2024-01-03T00:00:00Z,5\nv,2024-01-05T00:00:00Z,2024-01-05T00:00:00Z,1\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("merged %s\n%s\nwant\n%s", name, data, want)
+		}
+	}
+
+	// reports whose results are not all of them are refused before reading any, filtered ones too
+	for _, args := range [][]string{{"-reports", "topn"}, {"-reports", "topn", "-report-filter", "topn:0 = a"}} {
+		rpts := buildReports(t, append(args, "-keys", "0", "-group-by", "1")...)
+		err := MergeResults(rpts, []string{t.TempDir()}, NewDirSink(out))
+		if _, ok := err.(ConfigError); !ok {
+			t.Errorf("%v: merge error %v", args, err)
+		}
+	}
+}
+
+func TestDiffResults(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeResult(t, a, "result-quick.txt", "a,10\nb,5\nc,0\n")
+	writeResult(t, b, "result-quick.txt", "a,15\nc,2\nd,1\n")
+	writeResult(t, a, "result-topn.txt", "x,1\n")
+
+	var w strings.Builder
+	differ, err := DiffResults(a, b, &w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "only in " + a + ": result-topn.txt\n" +
+		"--- result-quick.txt\n" +
+		"~ a\t10 -> 15\t+5\t+50.0%\n" +
+		"- b\t5\n" +
+		"~ c\t0 -> 2\t+2\n" +
+		"+ d\t1\n"
+	if !differ || w.String() != want {
+		t.Errorf("diff %v\n%s\nwant\n%s", differ, w.String(), want)
+	}
+}
+
+func TestDiffResultsTypes(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeResult(t, a, "result-sum.txt", "a,1.25\nb,18446744073709551615\n")
+	writeResult(t, b, "result-sum.txt", "a,1.5\nb,18446744073709551614\n")
+	writeResult(t, a, "result-counters.csv", "key,records,errors\nx,y,10,1\nz,3,0\n")
+	writeResult(t, b, "result-counters.csv", "key,records,errors\nx,y,10,2\n")
+	writeResult(t, a, "result-extract.jsonl", "{}\n")
+	writeResult(t, b, "result-extract.jsonl", "{}\n")
+
+	var w strings.Builder
+	differ, err := DiffResults(a, b, &w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- result-counters.csv\n" +
+		"~ x,y\terrors\t1 -> 2\t+1\t+100.0%\n" +
+		"- z\t3,0\n" +
+		"--- result-sum.txt\n" +
+		"~ a\t1.25 -> 1.5\t+0.25\t+20.0%\n" +
+		"~ b\t18446744073709551615 -> 18446744073709551614\t-1\t-0.0%\n"
+	if !differ || w.String() != want {
+		t.Errorf("diff %v\n%s\nwant\n%s", differ, w.String(), want)
+	}
+
+	w.Reset()
+	if differ, err := DiffResults(a, a, &w); err != nil || differ || w.Len() > 0 {
+		t.Errorf("diff of a run with itself: %v, %v\n%s", differ, err, w.String())
+	}
+}
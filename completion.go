@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// completionValues lists the values completed for the flags that take one of a fixed set, from the
+// registries for parsers and reports. Flags in commaLists take several of them.
+func completionValues() map[string][]string {
+	var steps []string
+	for name := range normalizeSteps {
+		steps = append(steps, name)
+	}
+	sort.Strings(steps)
+	return map[string][]string{
+		"records":       {"string", "bytes"},
+		"parser":        unionNames(parsers.Names(), byteParsers.Names()),
+		"reports":       unionNames(reports.Names(), byteReports.Names()),
+		"on-error":      {"skip", "abort", "quarantine"},
+		"aggregate":     {"clone", "sharded", "shared"},
+		"accumulate":    {"int64", "uint64", "float64", "decimal"},
+		"number-locale": {"c", "en", "de", "fr", "ch"},
+		"empty-keys":    {"keep", "drop", "bucket", "default="},
+		"normalize":     steps,
+		"log-level":     {"trace", "debug", "info", "warn", "error"},
+		"log-format":    {"text", "json"},
+		"procs":         {"auto"},
+	}
+}
+
+var commaLists = map[string]bool{"reports": true, "normalize": true}
+
+func unionNames(a, b []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(append([]string(nil), a...), b...) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandFlags returns the flags of a command in name order
+func commandFlags(cmd *Command) []*flag.Flag {
+	if cmd.Flags == nil {
+		return nil
+	}
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	cmd.Flags(fs)
+	var flags []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	return flags
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func completionCommand(args []string) int {
+	prog := filepath.Base(os.Args[0])
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s completion bash|zsh|fish\n\nbash: source <(%s completion bash)\nzsh:  %s completion zsh > \"${fpath[1]}/_%s\"\nfish: %s completion fish > ~/.config/fish/completions/%s.fish\n",
+			prog, prog, prog, prog, prog, prog)
+		return 2
+	}
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout, prog)
+	case "zsh":
+		// zsh runs the bash completion through its bash compatibility layer
+		fmt.Fprintf(os.Stdout, "#compdef %s\nautoload -U +X bashcompinit && bashcompinit\n", prog)
+		writeBashCompletion(os.Stdout, prog)
+	case "fish":
+		writeFishCompletion(os.Stdout, prog)
+	default:
+		fmt.Fprintf(os.Stderr, "completion: unknown shell %s, want bash, zsh or fish\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func writeBashCompletion(w io.Writer, prog string) {
+	fn := "_" + nonIdent.ReplaceAllString(prog, "_")
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.Name
+	}
+
+	fmt.Fprintf(w, "# bash completion for %s, generated by \"%s completion bash\"\n", prog, prog)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd=run flags\n")
+	fmt.Fprintf(w, "\tcase ${COMP_WORDS[1]} in\n\t%s) cmd=${COMP_WORDS[1]} ;;\n\tesac\n", strings.Join(names, "|"))
+	fmt.Fprintf(w, "\tif [[ $COMP_CWORD -eq 1 && $cur != -* && $cur != @* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n\t\treturn\n\tfi\n", strings.Join(names, " "))
+
+	values := completionValues()
+	fmt.Fprintf(w, "\tcase ${prev#-} in\n")
+	var flagNames []string
+	for name := range values {
+		flagNames = append(flagNames, name)
+	}
+	sort.Strings(flagNames)
+	for _, name := range flagNames {
+		if commaLists[name] {
+			fmt.Fprintf(w, "\t-%s|%s)\n\t\tlocal prefix=\n\t\t[[ $cur == *,* ]] && prefix=${cur%%,*},\n", name, name)
+			fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -P \"$prefix\" -W \"%s\" -- \"${cur##*,}\"))\n\t\tcompopt -o nospace\n\t\treturn ;;\n",
+				strings.Join(values[name], " "))
+		} else {
+			fmt.Fprintf(w, "\t-%s|%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", name, name, strings.Join(values[name], " "))
+		}
+	}
+	fmt.Fprintf(w, "\tesac\n")
+
+	fmt.Fprintf(w, "\tcase $cmd in\n")
+	for _, cmd := range commands {
+		var flags []string
+		for _, f := range commandFlags(cmd) {
+			flags = append(flags, "-"+f.Name)
+		}
+		if len(flags) > 0 {
+			fmt.Fprintf(w, "\t%s) flags=\"%s\" ;;\n", cmd.Name, strings.Join(flags, " "))
+		}
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\tif [[ $cur == -* ]]; then\n\t\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n\telse\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n\tfi\n}\n")
+	fmt.Fprintf(w, "complete -o filenames -F %s %s\n", fn, prog)
+}
+
+func writeFishCompletion(w io.Writer, prog string) {
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.Name
+	}
+	noCommand := fmt.Sprintf("not __fish_seen_subcommand_from %s", strings.Join(names, " "))
+
+	fmt.Fprintf(w, "# fish completion for %s, generated by \"%s completion fish\"\n", prog, prog)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c %s -n %s -f -a %s -d %s\n", prog, fishQuote(noCommand), cmd.Name, fishQuote(cmd.Usage))
+	}
+	values := completionValues()
+	for _, cmd := range commands {
+		cond := "__fish_seen_subcommand_from " + cmd.Name
+		if cmd.Name == "run" {
+			// the flags of run also apply without a command
+			cond = fmt.Sprintf("%s; or %s", cond, noCommand)
+		}
+		for _, f := range commandFlags(cmd) {
+			fmt.Fprintf(w, "complete -c %s -n %s -o %s", prog, fishQuote(cond), f.Name)
+			if v, ok := values[f.Name]; ok && !commaLists[f.Name] {
+				fmt.Fprintf(w, " -x -a %s", fishQuote(strings.Join(v, " ")))
+			} else if ok {
+				fmt.Fprintf(w, " -x -a %s", fishQuote(fmt.Sprintf("(__fish_complete_list , 'printf \"%%s\\n\" %s')", strings.Join(v, " "))))
+			} else if !isBoolFlag(f) {
+				fmt.Fprintf(w, " -r")
+			}
+			fmt.Fprintf(w, " -d %s\n", fishQuote(f.Usage))
+		}
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
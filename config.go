@@ -0,0 +1,839 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of one run
+type Config struct {
+	LogFlags
+	In             string
+	Out            string
+	Output         string
+	Procs          int
+	Comma          string
+	Keys           string
+	Header         bool
+	Ragged         bool
+	SkipLines      int
+	SkipFooter     int
+	Comment        string
+	Schema         string
+	Strict         bool
+	Normalize      string
+	Rewrite        string
+	EmptyKeys      string
+	SumColumn      int
+	Accumulate     string
+	Redact         string
+	RedactKey      string
+	TimeColumn     int
+	TimeLayout     string
+	TZ             string
+	From           string
+	To             string
+	Records        string
+	Parser         string
+	Reports        string
+	OnError        string
+	MaxFailedFiles int
+	MaxFiles       int
+	MaxInputBytes  int64
+	MaxReadMBps    float64
+	MaxOpenFiles   int
+	Retries        int
+	RetryBackoff   time.Duration
+	Aggregate      string
+	Shards         int
+	ReduceEvery    time.Duration
+	Mmap           bool
+	AsyncDecode    bool
+	StageTimes     bool
+	ReadBuffer     int64
+	Prefetch       int64
+	FilesPerTask   int
+	MaxProcs       int
+	Nice           int
+	IONice         string
+	QueueSize      int
+	Deterministic  bool
+	DryRun         bool
+	Bench          bool
+	Pprof          string
+	Metrics        string
+	OTLP           string
+	CPUProfile     string
+	MemProfile     string
+	Trace          string
+	TaskQueue      string
+	Push           bool
+	ProgressEvery  time.Duration
+	Slowest        int
+	Notify         string
+	TUI            bool
+	Audit          bool
+	Dedup          bool
+	Expect         string
+	ExpectWarn     bool
+	SQL            string
+	DuckDB         string
+	Cache          string
+	ResultCache    string
+	Follow         string
+	FollowEvery    time.Duration
+	CacheColumns   string
+	TmpDir         string
+	TmpLimit       int64
+	SkipDotfiles   bool
+	FollowSymlinks bool
+	SkipEmpty      bool
+	MinSize        int64
+	MaxSize        int64
+	AfterProcess   string
+	NumberLocale   string
+	Units          string
+	Rollup         string
+	RollupDepth    int
+	GroupBy        string
+	PairWith       string
+	SessionBy      string
+	StateColumns   string
+	SpillSize      int64
+	Window         time.Duration
+	Threshold      int
+	Shares         bool
+	Buckets        string
+	Classify       string
+	Counters       string
+	Distinct       string
+	ExtractFormat  string
+	ExtractColumns string
+	SplitBy        string
+	SplitTime      string
+	TimeBucket     string
+	HLLPrecision   int
+	Top            int
+	ReportFilter   string
+	Dispatch       bool
+
+	pool       *Autoscaler // shared with the other jobs of a batch
+	tracer     *Tracer
+	duplicates []Duplicate // inputs skipped by -dedup
+}
+
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	cfg.LogFlags.RegisterFlags(fs)
+	fs.StringVar(&cfg.In, "in", ".", "input directory")
+	fs.BoolVar(&cfg.SkipDotfiles, "skip-dotfiles", false, "skip the files and linked directories of the input directory whose name starts with a dot")
+	fs.BoolVar(&cfg.FollowSymlinks, "follow-symlinks", true, "read the files that links in the input directory point to, and list linked directories like the input directory, each once; false skips links")
+	fs.BoolVar(&cfg.SkipEmpty, "skip-empty", false, "skip zero-byte files instead of processing them as inputs without records")
+	fs.Var(SizeFlag{&cfg.MinSize}, "min-size", "skip the files of the input directory smaller than this, e.g. 1K")
+	fs.Var(SizeFlag{&cfg.MaxSize}, "max-size", "skip the files of the input directory larger than this, e.g. 2G; 0 for no limit")
+	fs.StringVar(&cfg.Pprof, "pprof", "", "serve net/http/pprof on this address, e.g. :6060")
+	fs.StringVar(&cfg.Metrics, "metrics", "", "serve Prometheus metrics of the run on this address at /metrics, e.g. :9100")
+	fs.StringVar(&cfg.OTLP, "otlp", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "export OpenTelemetry spans of the run and every file to this OTLP/HTTP endpoint, e.g. http://localhost:4318")
+	fs.StringVar(&cfg.CPUProfile, "cpuprofile", "", "write a cpu profile of the run to this file")
+	fs.StringVar(&cfg.MemProfile, "memprofile", "", "write a heap profile at the end of the run to this file")
+	fs.StringVar(&cfg.Trace, "trace", "", "write a runtime trace of the run to this file")
+	fs.BoolVar(&cfg.Bench, "bench", false, "measure read, decompress, parse and report throughput on one worker instead of writing results")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "list the files, parser and reports of the run without reading any data")
+	fs.StringVar(&cfg.Out, "out", ".", "output directory")
+	fs.BoolVar(&cfg.Shares, "shares", false, "also write the keys of every counting report by count with their percentage of the total and cumulative percentage, as result-NAME-shares.csv")
+	fs.StringVar(&cfg.Output, "output", "", "comma separated report=destination overrides of -out: - for stdout, s3://bucket/key or a file, e.g. quick=-,sum=s3://bucket/sums.csv")
+	cfg.Procs = 1
+	fs.Var(ProcsFlag{&cfg.Procs}, "procs", "number of processes, or auto to size by the CPUs and throttle when input is the bottleneck")
+	fs.IntVar(&cfg.MaxProcs, "max-procs", 0, "CPUs the workers run on at the same time (GOMAXPROCS), 0 for one per worker; fewer than -procs keeps inputs open in parallel on fewer CPUs")
+	fs.IntVar(&cfg.Nice, "nice", 0, "nice value of the run, e.g. 10 to give way to other processes on the host; 0 leaves it unchanged")
+	fs.StringVar(&cfg.IONice, "ionice", "", "I/O priority of the run on Linux: idle, or best-effort[:LEVEL] with levels from 0, the highest, to 7")
+	fs.StringVar(&cfg.Comma, "comma", ",", "separator")
+	fs.StringVar(&cfg.Keys, "keys", "0", "key columns: indices, ranges such as 0-3 or 4-, * for all, !n to exclude, or names with -header")
+	fs.StringVar(&cfg.Normalize, "normalize", "", "comma separated steps applied to the key columns before counting: lower, upper, trim, strip-query, nfc, fold")
+	fs.StringVar(&cfg.Rewrite, "rewrite", "", "regexp=>replacement rewrite of the key columns after -normalize, e.g. '/users/[0-9]+=>/users/:id'")
+	fs.StringVar(&cfg.EmptyKeys, "empty-keys", "keep", "what to do with records with an empty key column: keep, drop, bucket (count them under (empty)) or default=VALUE")
+	fs.StringVar(&cfg.Rollup, "rollup", "", "separator of a hierarchy in the last key column, whose records are also counted under every ancestor, e.g. / for /a/b and /a of /a/b/c")
+	fs.IntVar(&cfg.RollupDepth, "rollup-depth", 0, "count -rollup ancestors down to this many levels, e.g. 1 for /a only, 0 for all")
+	fs.StringVar(&cfg.GroupBy, "group-by", "", "group columns of the topn report, in the -keys syntax")
+	fs.IntVar(&cfg.Top, "top", 5, "number of keys the topn report writes per group, and of pairs the pairs report writes")
+	fs.StringVar(&cfg.PairWith, "pair-with", "", "columns the pairs report pairs with the -keys, in the -keys syntax")
+	fs.StringVar(&cfg.StateColumns, "state-columns", "", "state columns of the transitions report, whose changes along the -time-column it counts per key, in the -keys syntax")
+	cfg.SpillSize = 64 << 20
+	fs.Var(SizeFlag{&cfg.SpillSize}, "spill-size", "bytes of events a transitions or rate report buffers per worker before spilling them to the scratch workspace, 0 to never spill")
+	fs.DurationVar(&cfg.Window, "window", time.Minute, "rolling window of the rate report")
+	fs.IntVar(&cfg.Threshold, "threshold", 0, "number of records in a -window over which the rate report reports a key, e.g. 1000")
+	fs.StringVar(&cfg.Counters, "counters", "", "named conditions the counters report counts per key, separated by ;, e.g. 'errors:status >= 500;hits:cache = HIT'")
+	fs.StringVar(&cfg.Distinct, "distinct-columns", "", "columns whose distinct values the distinct report estimates, e.g. user, in the -keys syntax")
+	fs.StringVar(&cfg.ExtractFormat, "extract-format", "csv", "format of the records the extract report writes: csv, tsv or json (JSON lines named by the -header)")
+	fs.StringVar(&cfg.ExtractColumns, "extract-columns", "", "columns the extract report writes, in the -keys syntax, e.g. time,path,status; all columns when empty")
+	fs.StringVar(&cfg.SplitBy, "split-by", "", "columns whose values split the records of the extract report into a result per value, e.g. service, in the -keys syntax")
+	fs.StringVar(&cfg.SplitTime, "split-time", "", "split the records of the extract report into a result per time bucket of the -time-column: minute, hour, day, week, month or a duration")
+	fs.StringVar(&cfg.TimeBucket, "time-bucket", "day", "time bucket of the distinct report: minute, hour, day, week, month or a duration such as 15m")
+	fs.IntVar(&cfg.HLLPrecision, "hll-precision", 12, "HyperLogLog sketches of the distinct report have 2^N registers, for a standard error of 1.04/sqrt(2^N): 1.6% for 12")
+	fs.StringVar(&cfg.SessionBy, "session-by", "", "session columns of the pairs report, which counts the pairs of a session once instead of by record")
+	fs.IntVar(&cfg.SumColumn, "sum-column", -1, "column summed by key by the sum report")
+	fs.StringVar(&cfg.NumberLocale, "number-locale", "c", "separators of the numbers summed: c (1234.5), en (1,234.5), de (1.234,5), fr (1 234,5), ch (1'234.5) or the decimal and grouping separators, e.g. ',.'")
+	fs.StringVar(&cfg.Accumulate, "accumulate", "int64", "number type of the sum report: int64, uint64, float64 or decimal (exact, never overflows)")
+	fs.StringVar(&cfg.Buckets, "buckets", "", "replace numbers in columns by the lower bound of their bucket, after -units, e.g. '4=pow2;5=width:10'")
+	fs.StringVar(&cfg.Classify, "classify", "", "map the values of columns to categories with the exact, prefix and regex rules of YAML or JSON files, e.g. '2=endpoints.yaml;3=status.yaml'")
+	fs.StringVar(&cfg.Units, "units", "", "convert humanized durations and sizes in columns to plain numbers, e.g. '3=duration;4=duration:ms;5=size'")
+	fs.StringVar(&cfg.Redact, "redact", "", "redact columns before the reports see them, e.g. '0=ip;3,4=hash;5=mask;6=truncate:8'")
+	fs.StringVar(&cfg.RedactKey, "redact-key", os.Getenv(EnvPrefix+"REDACT_KEY"), "HMAC key of -redact hash")
+	fs.BoolVar(&cfg.Header, "header", false, "the first line of every input is a header naming the columns")
+	fs.IntVar(&cfg.SkipLines, "skip-lines", 0, "skip this many lines at the start of every input, before the header")
+	fs.IntVar(&cfg.SkipFooter, "skip-footer", 0, "skip this many lines at the end of every input")
+	fs.StringVar(&cfg.Comment, "comment", "", "skip the lines starting with this prefix, e.g. #")
+	fs.BoolVar(&cfg.Ragged, "ragged", false, "CSV records may have fewer or more fields than the first one, absent fields are not an error")
+	fs.StringVar(&cfg.Schema, "schema", "", "YAML or JSON file declaring the column count, names and types of the records; nonconforming records are dropped")
+	fs.BoolVar(&cfg.Strict, "strict", false, "with -schema, nonconforming records are bad records handled by -on-error instead of being dropped")
+	fs.IntVar(&cfg.TimeColumn, "time-column", -1, "column holding the record timestamp, -1 for none")
+	fs.StringVar(&cfg.TimeLayout, "time-layout", "rfc3339", "|-separated candidate layouts of the time column: Go or strftime layouts, rfc3339, clf, epoch, epoch_ms, ...")
+	fs.StringVar(&cfg.From, "from", "", "drop records with a -time-column before this time, in the -time-layout, RFC 3339 or 2006-01-02[ 15:04:05]")
+	fs.StringVar(&cfg.To, "to", "", "drop records with a -time-column at or after this time")
+	fs.StringVar(&cfg.TZ, "tz", "Local", "time zone of timestamps without one, and of time-based reports: an IANA name, UTC or Local")
+	fs.BoolVar(&cfg.Deterministic, "deterministic", false, "assign files to workers round-robin in name order so runs are reproducible")
+	fs.IntVar(&cfg.QueueSize, "queue", 0, "capacity of the task queue, 0 for the number of workers")
+	fs.StringVar(&cfg.Records, "records", "string", "record type: string ([]string fields) or bytes (zero-allocation [][]byte fields)")
+	fs.StringVar(&cfg.Parser, "parser", "", "parser name, defaults to csv for string records and fields for bytes")
+	fs.StringVar(&cfg.Reports, "reports", "quick", "comma separated report names, ALIAS=NAME to run a report under another name, e.g. quick,errors=quick")
+	fs.StringVar(&cfg.ReportFilter, "report-filter", "", "; separated REPORT:CONDITION filters of the records single reports see, e.g. 'errors:status >= 500 and path ~ ^/api/'")
+	fs.StringVar(&cfg.OnError, "on-error", "skip", "what to do on a failed file: skip, abort or quarantine")
+	fs.IntVar(&cfg.MaxFailedFiles, "max-failed-files", 0, "abort when more files than this fail, 0 for no limit")
+	fs.IntVar(&cfg.MaxFiles, "max-files", 0, "stop after starting this many inputs and mark the results partial, 0 for no limit")
+	fs.Float64Var(&cfg.MaxReadMBps, "max-read-mbps", 0, "read the inputs at most this many MB (2^20 bytes) per second in total, to spare shared storage; 0 for no limit")
+	fs.IntVar(&cfg.MaxOpenFiles, "max-open-files", 0, "open at most this many inputs at the same time, fewer than -procs to spare shared storage; 0 for no limit")
+	fs.Var(SizeFlag{&cfg.MaxInputBytes}, "max-input-bytes", "stop before an input that would take the stored size of the inputs read past this, e.g. 10G, and mark the results partial; 0 for no limit")
+	fs.IntVar(&cfg.Retries, "retries", 1, "attempts for opening and reading a file")
+	fs.DurationVar(&cfg.RetryBackoff, "retry-backoff", time.Second, "initial delay between retries, doubled on each attempt")
+	fs.StringVar(&cfg.Aggregate, "aggregate", "clone", "aggregation backend: clone (per-worker reports merged at the end), sharded (one shared sharded map for quick) or shared (every report shared by all workers under a lock)")
+	fs.StringVar(&cfg.Notify, "notify", "", "POST the run summary as JSON to this URL when the run ends, or a message to a Slack incoming webhook URL")
+	fs.IntVar(&cfg.Slowest, "slowest", 5, "log this many slowest files and the load skew of the workers at the end of the run")
+	fs.IntVar(&cfg.Shards, "shards", 64, "number of shards for -aggregate sharded")
+	fs.BoolVar(&cfg.Dispatch, "dispatch-reports", false, "add the records to every report in a goroutine of its own, so a slow report does not hold up the others")
+	fs.BoolVar(&cfg.StageTimes, "stage-times", false, "time the reading, decompression, parsing and reporting of every worker and log them at the end of the run, at the cost of timing every record")
+	fs.Var(SizeFlag{&cfg.ReadBuffer}, "read-buffer", "read buffer of every worker, e.g. 1M; 0 sizes it by input, from 64K for small files to 8M, with at most 256M for all workers")
+	fs.IntVar(&cfg.FilesPerTask, "files-per-task", 1, "inputs a worker takes from the queue at a time and processes as one task, e.g. 100 for millions of small files")
+	fs.Var(SizeFlag{&cfg.Prefetch}, "prefetch", "open the next input of every worker while it parses the current one and read its first bytes, e.g. 1M, to hide the latency of remote storage; 0 for off")
+	fs.BoolVar(&cfg.AsyncDecode, "async-decode", false, "decompress in a separate goroutine per worker, overlapping with parsing")
+	fs.BoolVar(&cfg.Mmap, "mmap", false, "memory-map uncompressed input files instead of reading them")
+	fs.DurationVar(&cfg.ReduceEvery, "reduce-every", 0, "fold worker reports into the result at this interval during the run, 0 to reduce only at the end")
+	fs.StringVar(&cfg.TaskQueue, "task-queue", "", "take the inputs from a queue shared with other instances: redis://[:password@]host:port/key or sqs://sqs.<region>.amazonaws.com/<account>/<queue>")
+	fs.DurationVar(&cfg.ProgressEvery, "progress-every", 0, "interval of the progress line, 0 for every second on a terminal and every 10 seconds otherwise")
+	fs.StringVar(&cfg.Expect, "expect", "", "CSV manifest of file,records[,bytes]: inputs whose record count or decompressed size differ fail")
+	fs.BoolVar(&cfg.ExpectWarn, "expect-warn", false, "only log the inputs that do not match -expect instead of failing them")
+	fs.BoolVar(&cfg.Dedup, "dedup", false, "process inputs with identical content once, listing the skipped ones in result-duplicates.csv")
+	fs.StringVar(&cfg.SQL, "sql", "", "query of the sql report over a table named records, e.g. 'SELECT c0, count(*) FROM records GROUP BY ALL'")
+	fs.StringVar(&cfg.DuckDB, "duckdb", "duckdb", "path of the DuckDB command line binary run by the sql report")
+	fs.StringVar(&cfg.Cache, "cache", "", "directory of a columnar cache of the parsed records of every input, written on the first run and read instead of the input by later ones")
+	fs.StringVar(&cfg.CacheColumns, "cache-columns", "*", "columns kept in the -cache, in the -keys syntax; the others read as empty")
+	fs.StringVar(&cfg.ResultCache, "result-cache", "", "directory caching the results by the inputs' paths, sizes and modification times and the settings; an unchanged run copies them instead of running")
+	fs.StringVar(&cfg.Follow, "follow", "", "live file tailed after the inputs are processed, e.g. the one they are rotated from: its appended lines are read into the same reports, whose results are written again, until the run is interrupted")
+	fs.DurationVar(&cfg.FollowEvery, "follow-every", 10*time.Second, "interval at which -follow polls the live file")
+	fs.StringVar(&cfg.TmpDir, "tmpdir", "", "directory in which the run creates its scratch workspace for spills, dead letters and checkpoints, removed when the run succeeds; the system temporary directory when empty")
+	fs.Var(SizeFlag{&cfg.TmpLimit}, "tmpdir-limit", "maximum size of the scratch workspace, e.g. 512M or 2G, 0 for no limit")
+	fs.BoolVar(&cfg.Audit, "audit", false, "write the SHA-256 and record count of every input to result-audit.csv")
+	fs.BoolVar(&cfg.TUI, "tui", false, "show a live dashboard of the workers, throughput, top keys and errors instead of the progress line, when stdout is a terminal")
+	fs.BoolVar(&cfg.Push, "push", false, "push the input files to -task-queue instead of processing them")
+	fs.StringVar(&cfg.AfterProcess, "after-process", "", "what to do with every input processed without error once the run succeeded: move:DIR, delete or touch-marker (create FILE.done, and skip the inputs that have one)")
+}
+
+// Options are handed to the parser and report factories
+func (cfg *Config) Options() Options {
+	return Options{
+		"comma":            cfg.Comma,
+		"keys":             cfg.Keys,
+		"header":           strconv.FormatBool(cfg.Header),
+		"ragged":           strconv.FormatBool(cfg.Ragged),
+		"aggregate":        cfg.Aggregate,
+		"shards":           strconv.Itoa(cfg.Shards),
+		"normalize":        cfg.Normalize,
+		"rewrite":          cfg.Rewrite,
+		"empty-keys":       cfg.EmptyKeys,
+		"rollup":           cfg.Rollup,
+		"rollup-depth":     strconv.Itoa(cfg.RollupDepth),
+		"sum-column":       strconv.Itoa(cfg.SumColumn),
+		"group-by":         cfg.GroupBy,
+		"pair-with":        cfg.PairWith,
+		"session-by":       cfg.SessionBy,
+		"counters":         cfg.Counters,
+		"distinct-columns": cfg.Distinct,
+		"time-bucket":      cfg.TimeBucket,
+		"hll-precision":    strconv.Itoa(cfg.HLLPrecision),
+		"state-columns":    cfg.StateColumns,
+		"spill-size":       strconv.FormatInt(cfg.SpillSize, 10),
+		"window":           cfg.Window.String(),
+		"threshold":        strconv.Itoa(cfg.Threshold),
+		"top":              strconv.Itoa(cfg.Top),
+		"accumulate":       cfg.Accumulate,
+		"number-locale":    cfg.NumberLocale,
+		"sql":              cfg.SQL,
+		"extract-format":   cfg.ExtractFormat,
+		"extract-columns":  cfg.ExtractColumns,
+		"split-by":         cfg.SplitBy,
+		"split-time":       cfg.SplitTime,
+		"duckdb":           cfg.DuckDB,
+		"time-column":      strconv.Itoa(cfg.TimeColumn),
+		"time-layout":      cfg.TimeLayout,
+		"tz":               cfg.TZ,
+	}
+}
+
+// TimeParser returns the parser of the -time-column, or nil without one
+func (cfg *Config) TimeParser() (*TimeParser, error) { return cfg.Options().Time() }
+
+// ListFiles returns the input file, or the files in the input directory as filtered by -skip-dotfiles,
+// -follow-symlinks, -skip-empty, -min-size and -max-size
+func (cfg *Config) ListFiles() ([]string, error) {
+	if cfg.MinSize < 0 || cfg.MaxSize < 0 || cfg.MaxSize > 0 && cfg.MinSize > cfg.MaxSize {
+		return nil, fmt.Errorf("-min-size %d and -max-size %d select no file", cfg.MinSize, cfg.MaxSize)
+	}
+	fi, err := os.Stat(cfg.In)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, 4096)
+	if fi.IsDir() {
+		ls := &listing{cfg: cfg, seen: make(map[string]bool), files: files}
+		if err := ls.dir(cfg.In); err != nil {
+			return nil, err
+		}
+		ls.logSkipped()
+		files = ls.files
+	} else {
+		files = append(files, cfg.In)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ConfigError is returned for invalid settings, as opposed to failures while processing
+type ConfigError struct {
+	err error
+}
+
+func (e ConfigError) Error() string { return e.err.Error() }
+
+// LoadJSON sets the flags named by the keys of a JSON object, e.g. {"in": "logs", "keys": "0,2", "procs": 4}.
+// Values are parsed like on the command line and the other settings keep their defaults.
+func (cfg *Config) LoadJSON(data []byte) error {
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	return cfg.setValues(values)
+}
+
+// LoadFile reads a job file, a JSON object like LoadJSON takes or YAML lines of flag: value, with the
+// overrides on top
+func (cfg *Config) LoadFile(path string, overrides map[string]string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		err = json.Unmarshal(data, &values)
+	} else {
+		err = parseFlatYAML(string(data), values)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for k, v := range overrides {
+		values[k] = v
+	}
+	if err := cfg.setValues(values); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// parseFlatYAML reads top level key: value lines, which is all a job file needs
+func parseFlatYAML(data string, values map[string]interface{}) error {
+	for i, line := range strings.Split(data, "\n") {
+		if c := strings.Index(line, " #"); c >= 0 {
+			line = line[:c]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("line %d: want key: value", i+1)
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return nil
+}
+
+func (cfg *Config) setValues(values map[string]interface{}) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	for name, v := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting: %s", name)
+		}
+		if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("setting %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Run executes the job with the record type picked by cfg.Records
+func (cfg *Config) Run() (*Failures, error) { return cfg.RunWith(NewControl()) }
+
+// RunWith is Run with a Control to follow or cancel the run from another goroutine
+func (cfg *Config) RunWith(ctl *Control) (*Failures, error) {
+	slog.Info("starting run", "build", GetBuildInfo())
+	policy, err := ParseErrorPolicy(cfg.OnError)
+	if err != nil {
+		return nil, ConfigError{err}
+	}
+	failures := NewFailures(policy, cfg.MaxFailedFiles)
+
+	var queue TaskQueue
+	if cfg.TaskQueue != "" {
+		if queue, err = OpenTaskQueue(cfg.TaskQueue); err != nil {
+			return nil, ConfigError{err}
+		}
+	} else if cfg.Push {
+		return nil, ConfigError{fmt.Errorf("-push needs -task-queue")}
+	}
+
+	var files []string
+	if queue == nil || cfg.Push {
+		if files, err = cfg.ListFiles(); err != nil {
+			return nil, ConfigError{err}
+		}
+		if cfg.Dedup && !cfg.DryRun {
+			files, cfg.duplicates = DedupFiles(files)
+		}
+	}
+	if cfg.Push {
+		slog.Info("pushing files", "files", len(files), "queue", cfg.TaskQueue)
+		return failures, queue.Push(files)
+	}
+	if queue == nil {
+		slog.Info("listed inputs", "files", len(files))
+	}
+
+	if cfg.OTLP != "" {
+		cfg.tracer = NewTracer(cfg.OTLP)
+		defer cfg.tracer.Shutdown()
+	}
+
+	switch cfg.Records {
+	case "string":
+		err = runPipeline(cfg, parsers, reports, "csv", failures, ctl, files, queue, os.Stdout)
+	case "bytes":
+		err = runPipeline(cfg, byteParsers, byteReports, "fields", failures, ctl, files, queue, os.Stdout)
+	default:
+		err = ConfigError{fmt.Errorf("unknown record type: %s", cfg.Records)}
+	}
+	return failures, err
+}
+
+// ColumnCache returns the -cache of records parsed with the parser and settings of the run
+func (cfg *Config) ColumnCache(defaultParser string) (*ColumnCache, error) {
+	var columns *KeySpec
+	if cfg.CacheColumns != "*" {
+		var err error
+		if columns, err = ParseKeySpec(cfg.CacheColumns); err != nil {
+			return nil, err
+		}
+	}
+	// the cached records depend on everything that goes into parsing them
+	salt := fmt.Sprintf("%s\x00%s\x00%q\x00%t\x00%t\x00%d\x00%d\x00%q\x00%s", cfg.Records, cfg.parserName(defaultParser),
+		cfg.Comma, cfg.Header, cfg.Ragged, cfg.SkipLines, cfg.SkipFooter, cfg.Comment, cfg.CacheColumns)
+	return NewColumnCache(cfg.Cache, columns, salt), nil
+}
+
+// BuildPipeline creates a pipeline for record type T with the parser and reports from the registries
+func BuildPipeline[T any](cfg *Config, pr *Registry[Parser[T]], rr *Registry[Report[T]], defaultParser string) (*Pipeline[T], error) {
+	parser, err := pr.New(cfg.parserName(defaultParser), cfg.Options())
+	if err != nil {
+		return nil, ConfigError{err}
+	}
+
+	sink, routes, err := cfg.Sink()
+	if err != nil {
+		return nil, err
+	}
+
+	p := NewPipeline[T]().
+		From(NewFileSource(Retry{cfg.Retries, cfg.RetryBackoff}).Mmap(cfg.Mmap).Throttle(cfg.MaxReadMBps, cfg.MaxOpenFiles)).
+		Parse(parser).
+		To(sink).
+		Procs(cfg.Procs).
+		ReduceEvery(cfg.ReduceEvery).
+		AsyncDecode(cfg.AsyncDecode).
+		StageTimes(cfg.StageTimes).
+		ReadBuffer(int(cfg.ReadBuffer)).
+		Prefetch(int(cfg.Prefetch)).
+		FilesPerTask(cfg.FilesPerTask).
+		QueueSize(cfg.QueueSize).
+		Deterministic(cfg.Deterministic).
+		ProgressEvery(cfg.ProgressEvery).
+		Trace(cfg.tracer).
+		Slowest(cfg.Slowest).
+		TUI(cfg.TUI && isTerminal(os.Stdout)).
+		Audit(cfg.Audit).
+		Dispatch(cfg.Dispatch).
+		Lines(&LineFilter{cfg.SkipLines, cfg.SkipFooter, cfg.Comment}).
+		Workspace(NewWorkspace(cfg.TmpDir, cfg.TmpLimit)).
+		Limit(Limits{cfg.MaxFiles, cfg.MaxInputBytes})
+	if cfg.pool != nil {
+		p.Pool(cfg.pool)
+	}
+	if cfg.SkipLines < 0 || cfg.SkipFooter < 0 {
+		return nil, ConfigError{fmt.Errorf("-skip-lines and -skip-footer must not be negative")}
+	}
+	if cfg.ReadBuffer < 0 || cfg.ReadBuffer > 1<<30 {
+		return nil, ConfigError{fmt.Errorf("-read-buffer must be from 0 to 1G")}
+	}
+	if cfg.MaxProcs < 0 {
+		return nil, ConfigError{fmt.Errorf("-max-procs must not be negative")}
+	}
+	if cfg.FilesPerTask < 0 {
+		return nil, ConfigError{fmt.Errorf("-files-per-task must not be negative")}
+	}
+	if cfg.Prefetch > 1<<30 {
+		return nil, ConfigError{fmt.Errorf("-prefetch must be at most 1G")}
+	}
+	if cfg.Cache != "" {
+		cc, err := cfg.ColumnCache(defaultParser)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Cache(cc)
+	}
+	if cfg.Expect != "" {
+		m, err := LoadManifest(cfg.Expect, cfg.ExpectWarn)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Expect(m)
+	}
+	if cfg.Schema != "" {
+		times, err := NewTimeParser(-1, cfg.TimeLayout, cfg.TZ)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		sc, err := LoadSchema(cfg.Schema, times)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		if cfg.Strict {
+			p.Validate(Conformance[T]{sc})
+		} else {
+			p.Filter(Conformance[T]{sc})
+		}
+	} else if cfg.Strict {
+		return nil, ConfigError{fmt.Errorf("-strict needs -schema")}
+	}
+	if cfg.From != "" || cfg.To != "" {
+		times, from, to, err := cfg.TimeRange()
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Filter(NewTimeRange[T](times, from, to))
+	}
+	if cfg.Units != "" {
+		uc, err := NewUnitConverter(cfg.Units)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Transform(Units[T]{uc})
+	}
+	if cfg.Classify != "" {
+		cl, err := NewClassifier(cfg.Classify)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Transform(Classification[T]{cl})
+	}
+	if cfg.Buckets != "" {
+		bk, err := NewBucketer(cfg.Buckets)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Transform(Buckets[T]{bk})
+	}
+	if cfg.Redact != "" {
+		rd, err := NewRedactor(cfg.Redact, cfg.RedactKey)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Transform(Redaction[T]{rd})
+	}
+	if cfg.AfterProcess != "" {
+		ap, err := ParseAfterProcess(cfg.AfterProcess)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Hook(ap.Hooks())
+	}
+	rpts, err := BuildReports(cfg, rr)
+	if err != nil {
+		return nil, err
+	}
+	for _, rpt := range rpts {
+		p.Report(rpt)
+	}
+	for name := range routes {
+		// the shares of a report are only written if it counts, which is not known before the run
+		if !hasResult(rpts, name) && !(cfg.Shares && hasResult(rpts, strings.TrimSuffix(name, "-shares"))) &&
+			!((cfg.SplitBy != "" || cfg.SplitTime != "") && hasPart(rpts, name)) {
+			return nil, ConfigError{fmt.Errorf("-output: no result named %s", name)}
+		}
+	}
+	return p, nil
+}
+
+// Sink returns the sink of the results: -out, with the -output routes of single reports if any, the
+// shares of the counting reports with -shares, and the parts of split reports with -split-by or -split-time
+func (cfg *Config) Sink() (Sink, map[string]string, error) {
+	var sink Sink = NewDirSink(cfg.Out)
+	var routes map[string]string
+	if cfg.Output != "" {
+		var err error
+		if routes, err = ParseRoutes(cfg.Output); err != nil {
+			return nil, nil, ConfigError{err}
+		}
+		sink = NewRouteSink(sink, routes, cfg.Out)
+	}
+	if cfg.Shares {
+		sink = NewShareSink(sink)
+	}
+	if cfg.SplitBy != "" || cfg.SplitTime != "" {
+		sink = NewSplitSink(sink)
+	}
+	return sink, routes, nil
+}
+
+// hasResult tells whether a run with the reports writes a result of that name
+func hasResult[T any](rpts []Report[T], name string) bool {
+	switch name {
+	case "files", "audit", "duplicates", "partial":
+		return true
+	}
+	for _, rpt := range rpts {
+		if rpt.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPart tells whether name is a part, NAME-PART, of a split report of the run
+func hasPart[T any](rpts []Report[T], name string) bool {
+	for _, rpt := range rpts {
+		if _, ok := rpt.(SplitReport); ok && strings.HasPrefix(name, rpt.Name()+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeRange parses -from and -to, zero when not set
+func (cfg *Config) TimeRange() (times *TimeParser, from, to time.Time, err error) {
+	if times, err = cfg.TimeParser(); err != nil {
+		return
+	}
+	if times == nil {
+		err = fmt.Errorf("-from and -to need -time-column")
+		return
+	}
+	bounds, err := NewTimeParser(-1, cfg.TimeLayout+"|rfc3339|datetime|date", cfg.TZ)
+	if err != nil {
+		return
+	}
+	if cfg.From != "" {
+		if from, err = bounds.Parse(cfg.From); err != nil {
+			err = fmt.Errorf("-from: %v", err)
+			return
+		}
+	}
+	if cfg.To != "" {
+		if to, err = bounds.Parse(cfg.To); err != nil {
+			err = fmt.Errorf("-to: %v", err)
+			return
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		err = fmt.Errorf("-to %s is not after -from %s", cfg.To, cfg.From)
+	}
+	return
+}
+
+// BuildReports creates the reports named by cfg.Reports
+func BuildReports[T any](cfg *Config, rr *Registry[Report[T]]) ([]Report[T], error) {
+	switch cfg.Aggregate {
+	case "clone", "sharded", "shared":
+	default:
+		return nil, ConfigError{fmt.Errorf("unknown aggregation backend: %s", cfg.Aggregate)}
+	}
+	filters, err := ParseReportFilters(cfg.ReportFilter, cfg.Header)
+	if err != nil {
+		return nil, ConfigError{err}
+	}
+	rpts := make([]Report[T], 0, 1)
+	names := make(map[string]bool)
+	for _, entry := range strings.Split(cfg.Reports, ",") {
+		// ALIAS=NAME runs report NAME under the name ALIAS
+		alias, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			name = alias
+		}
+		if names[alias] {
+			return nil, ConfigError{fmt.Errorf("report %s named twice, name one of them with ALIAS=%s", alias, name)}
+		}
+		names[alias] = true
+		rpt, err := rr.New(name, cfg.Options())
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		if _, ok := rpt.(SharedReport); !ok && cfg.Aggregate == "shared" {
+			if _, ok := rpt.(HeaderReport); ok && cfg.Header {
+				// the workers read inputs with different headers at the same time
+				return nil, ConfigError{fmt.Errorf("%s: -header is not supported with -aggregate shared", name)}
+			}
+			rpt = NewLockedReport(rpt)
+		}
+		if filter := filters[alias]; filter != nil || alias != name {
+			rpt = NewFilteredReport(rpt, alias, filter)
+		}
+		rpts = append(rpts, rpt)
+	}
+	for name := range filters {
+		if !names[name] {
+			return nil, ConfigError{fmt.Errorf("-report-filter: no report named %s", name)}
+		}
+	}
+	return rpts, nil
+}
+
+func (cfg *Config) parserName(defaultParser string) string {
+	if cfg.Parser == "" {
+		return defaultParser
+	}
+	return cfg.Parser
+}
+
+func runPipeline[T any](cfg *Config, pr *Registry[Parser[T]], rr *Registry[Report[T]], defaultParser string, failures *Failures, ctl *Control, files []string, queue TaskQueue, stdout io.Writer) error {
+	if queue != nil && (cfg.Bench || cfg.DryRun) {
+		return ConfigError{fmt.Errorf("-bench and -dry-run need a file listing, not -task-queue")}
+	}
+	if cfg.Follow != "" && (queue != nil || cfg.ResultCache != "" || cfg.Bench || cfg.DryRun) {
+		return ConfigError{fmt.Errorf("-follow does not go with -task-queue, -result-cache, -bench or -dry-run")}
+	}
+	if cfg.Bench {
+		return bench(cfg, pr, rr, defaultParser, files, stdout)
+	}
+	p, err := BuildPipeline(cfg, pr, rr, defaultParser)
+	if err != nil {
+		return err
+	}
+	if cfg.Follow != "" {
+		if err := checkFollow(cfg, p, files); err != nil {
+			return ConfigError{err}
+		}
+	}
+	if cfg.DryRun {
+		return dryRun(cfg, p, defaultParser, files, stdout)
+	}
+	p.OnError(failures).Control(ctl)
+	if len(cfg.duplicates) > 0 {
+		p.Result(DuplicatesResult(cfg.duplicates))
+	}
+	if queue != nil {
+		// the inputs are acked once the results are written, or put back for another instance
+		qi := newQueueInputs(queue)
+		err := p.Hook(Hooks{OnFileEnd: qi.ended}).RunInputs(qi)
+		qi.settle(err == nil || err == qi.err)
+		return err
+	}
+	if cfg.ResultCache != "" {
+		return cachedRun(cfg, p, files, failures)
+	}
+	if err := p.Run(files); err != nil || cfg.Follow == "" {
+		return err
+	}
+	return p.Follow(cfg.Follow, cfg.FollowEvery, cfg.Header)
+}
+
+// checkFollow rejects the settings a run of -follow cannot continue with
+func checkFollow[T any](cfg *Config, p *Pipeline[T], files []string) error {
+	switch {
+	case cfg.SkipLines > 0 || cfg.SkipFooter > 0:
+		return fmt.Errorf("-follow does not go with -skip-lines or -skip-footer")
+	case cfg.AfterProcess != "" || cfg.Audit || cfg.Notify != "" || cfg.Expect != "":
+		// they act on every run, and -follow runs on every poll
+		return fmt.Errorf("-follow does not go with -after-process, -audit, -notify or -expect")
+	case cfg.FollowEvery <= 0:
+		return fmt.Errorf("-follow-every must be positive")
+	}
+	for _, rpt := range p.Reports() {
+		if _, ok := rpt.(WorkspaceReport); ok {
+			return fmt.Errorf("-follow: report %s keeps its records in the run's workspace", rpt.Name())
+		}
+	}
+	live, _ := filepath.Abs(cfg.Follow)
+	for _, file := range files {
+		if abs, _ := filepath.Abs(file); abs == live {
+			return fmt.Errorf("-follow: %s is an input too, list the rotated files only", file)
+		}
+	}
+	return nil
+}
+
+// dryRun prints the plan of a run from the file listing alone
+func dryRun[T any](cfg *Config, p *Pipeline[T], defaultParser string, files []string, w io.Writer) error {
+	parser := cfg.parserName(defaultParser)
+	var total int64
+	sizes := make(map[int64]int)
+	for _, file := range files {
+		var size int64
+		if fi, err := os.Stat(file); err == nil {
+			size = fi.Size()
+			sizes[size] += 1
+		}
+		total += size
+
+		if c := compression(file); c != "" {
+			fmt.Fprintf(w, "%s\t%d\t%s+%s\n", file, size, c, parser)
+		} else {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", file, size, parser)
+		}
+	}
+
+	names := make([]string, 0, len(p.Reports()))
+	for _, rpt := range p.Reports() {
+		names = append(names, rpt.Name())
+	}
+	fmt.Fprintf(w, "reports: %s\n", strings.Join(names, ","))
+	fmt.Fprintf(w, "files: %d, compressed bytes: %d\n", len(files), total)
+	if cfg.Dedup {
+		// -dedup hashes the inputs of the same size, which a dry run does not read
+		hashed := 0
+		for _, n := range sizes {
+			if n > 1 {
+				hashed += n
+			}
+		}
+		fmt.Fprintf(w, "dedup: %d files of a shared size to hash\n", hashed)
+	}
+	return nil
+}
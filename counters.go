@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CountersReport counts the records of every key and, in the same pass, the ones matching each of its
+// named conditions, e.g. errors:status >= 500;cache_hits:8 = HIT. The conditions are those of
+// -report-filter.
+type CountersReport struct {
+	counts  map[string][]int64 // the records, then every counter
+	names   []string
+	filters []*ReportFilter
+	spec    *KeySpec
+	keys    keyCache
+	norm    *Normalizer
+	empty   *EmptyKeys
+}
+
+// ParseCounters parses ;-separated NAME:CONDITION pairs, in order
+func ParseCounters(s string, header bool) ([]string, []*ReportFilter, error) {
+	var names []string
+	var filters []*ReportFilter
+	for _, part := range strings.Split(s, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, expr, ok := strings.Cut(part, ":")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, nil, fmt.Errorf("counter %s: want name:condition", part)
+		}
+		for _, n := range names {
+			if n == name {
+				return nil, nil, fmt.Errorf("counter %s: %s defined twice", part, name)
+			}
+		}
+		rf, err := ParseReportFilter(expr, header)
+		if err != nil {
+			return nil, nil, fmt.Errorf("counter %s: %v", name, err)
+		}
+		names, filters = append(names, name), append(filters, rf)
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no counters")
+	}
+	return names, filters, nil
+}
+
+func NewCountersReport(spec *KeySpec, names []string, filters []*ReportFilter) *CountersReport {
+	return &CountersReport{make(map[string][]int64), names, filters, spec, newKeyCache(spec), nil, nil}
+}
+
+// Normalize rewrites the key columns with n before counting, the conditions see the records as they are
+func (cr *CountersReport) Normalize(n *Normalizer) *CountersReport { cr.norm = n; return cr }
+
+// EmptyKeys handles empty key columns with ek
+func (cr *CountersReport) EmptyKeys(ek *EmptyKeys) *CountersReport { cr.empty = ek; return cr }
+
+// New returns a report with filters of its own, as they are bound to the header of its inputs
+func (cr *CountersReport) New() Report[LogRecord] {
+	filters := make([]*ReportFilter, len(cr.filters))
+	for i, rf := range cr.filters {
+		filters[i] = rf.clone()
+	}
+	return NewCountersReport(cr.spec, cr.names, filters).Normalize(cr.norm).EmptyKeys(cr.empty)
+}
+
+func (cr *CountersReport) Name() string         { return "counters" }
+func (cr *CountersReport) Extension() string    { return ".csv" }
+func (cr *CountersReport) Clear()               { cr.counts = make(map[string][]int64) }
+func (cr *CountersReport) Len() int             { return len(cr.counts) }
+func (cr *CountersReport) LogValue() slog.Value { return keysLogValue(len(cr.counts), cr.empty) }
+
+func (cr *CountersReport) Merge(rpt Report[LogRecord]) {
+	for k, o := range rpt.(*CountersReport).counts {
+		counts, ok := cr.counts[k]
+		if !ok {
+			cr.counts[k] = o
+			continue
+		}
+		for i, v := range o {
+			counts[i] += v
+		}
+	}
+}
+
+func (cr *CountersReport) SetHeader(columns []string) error {
+	spec, err := cr.spec.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	for _, rf := range cr.filters {
+		if err := rf.SetHeader(columns); err != nil {
+			return err
+		}
+	}
+	cr.keys = newKeyCache(spec)
+	return nil
+}
+
+func (cr *CountersReport) Check(r LogRecord) error {
+	_, err := cr.keys.columns(len(r))
+	return err
+}
+
+// Add counts the record by its key, and by the counters whose condition it matches. Records rejected by
+// Check are skipped.
+func (cr *CountersReport) Add(r LogRecord) {
+	keys, err := cr.keys.columns(len(r))
+	if err != nil {
+		return
+	}
+	key, ok := joinKey(keys, r, cr.norm, cr.empty)
+	if !ok {
+		return
+	}
+	counts, ok := cr.counts[key]
+	if !ok {
+		counts = make([]int64, 1+len(cr.filters))
+		cr.counts[key] = counts
+	}
+	counts[0] += 1
+	for i, rf := range cr.filters {
+		if rf.Match(r) {
+			counts[i+1] += 1
+		}
+	}
+}
+
+// Output writes a key,records,COUNTER... header and a line per key, sorted by key
+// Load adds the counts of a result written by Output, which must have the same counters
+func (cr *CountersReport) Load(path string) error {
+	header := "key,records," + strings.Join(cr.names, ",")
+	first := true
+	return readResultLines(path, func(line string) error {
+		if first {
+			if first = false; line != header {
+				return fmt.Errorf("columns %s, want %s", line, header)
+			}
+			return nil
+		}
+		key, values, err := splitValues(line, len(cr.names)+1)
+		if err != nil {
+			return err
+		}
+		counts, ok := cr.counts[key]
+		if !ok {
+			counts = make([]int64, len(values))
+			cr.counts[key] = counts
+		}
+		for i, v := range values {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			counts[i] += n
+		}
+		return nil
+	})
+}
+
+func (cr *CountersReport) Output(path string) {
+	keys := make([]string, 0, len(cr.counts))
+	for k := range cr.counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	fmt.Fprintf(w, "key,records,%s\n", strings.Join(cr.names, ","))
+	for _, k := range keys {
+		w.WriteString(k)
+		for _, v := range cr.counts[k] {
+			fmt.Fprintf(w, ",%d", v)
+		}
+		w.WriteByte('\n')
+	}
+	w.Flush()
+}
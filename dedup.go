@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// Duplicate is an input skipped because it has the same content as an earlier one
+type Duplicate struct {
+	File     string
+	Original string
+	SHA256   string
+	Size     int64
+}
+
+// DedupFiles keeps the first of the inputs with identical content, in listing order. Only inputs of the
+// same size are hashed, so unique files are not read. Inputs that cannot be read are kept, for the run to
+// report them.
+func DedupFiles(files []string) ([]string, []Duplicate) {
+	sizes := make(map[int64][]string)
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			sizes[fi.Size()] = append(sizes[fi.Size()], file)
+		}
+	}
+
+	dups := make(map[string]Duplicate)
+	for size, same := range sizes {
+		if len(same) < 2 {
+			continue
+		}
+		originals := make(map[string]string)
+		for _, file := range same {
+			sum, err := fileSHA256(file)
+			if err != nil {
+				continue
+			}
+			if original, ok := originals[sum]; ok {
+				dups[file] = Duplicate{file, original, sum, size}
+			} else {
+				originals[sum] = file
+			}
+		}
+	}
+	if len(dups) == 0 {
+		return files, nil
+	}
+
+	unique := make([]string, 0, len(files)-len(dups))
+	skipped := make([]Duplicate, 0, len(dups))
+	for _, file := range files {
+		if d, ok := dups[file]; ok {
+			slog.Info("skipped duplicate input", "file", d.File, "same_as", d.Original)
+			skipped = append(skipped, d)
+		} else {
+			unique = append(unique, file)
+		}
+	}
+	return unique, skipped
+}
+
+func fileSHA256(path string) (string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DuplicatesResult writes result-duplicates.csv with the skipped inputs and the input each one duplicates
+type DuplicatesResult []Duplicate
+
+func (dr DuplicatesResult) Name() string      { return "duplicates" }
+func (dr DuplicatesResult) Extension() string { return ".csv" }
+
+func (dr DuplicatesResult) Output(path string) {
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := csv.NewWriter(fp)
+	w.Write([]string{"file", "same_as", "sha256", "bytes_compressed"})
+	for _, d := range dr {
+		w.Write([]string{d.File, d.Original, d.SHA256, strconv.FormatInt(d.Size, 10)})
+	}
+	w.Flush()
+}
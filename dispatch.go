@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	dispatchBatch   = 256 // records per batch
+	dispatchBatches = 8   // batches in flight, how far the parser may run ahead of the slowest report
+)
+
+// dispatcher adds the records of a ReportManager to every report in a goroutine of its own, so one slow
+// report does not hold up the others. The records that pass the checks are copied into batches, as parsers
+// reuse them, and every full batch goes to all the report goroutines. Batches come from a small ring and
+// return to it once every report added them, which bounds the memory and how far the worker runs ahead.
+type dispatcher[T any] struct {
+	reports []chan *recordBatch[T]
+	free    chan *recordBatch[T]
+	batch   *recordBatch[T]
+	pending sync.WaitGroup // batches not added by every report yet
+	done    sync.WaitGroup // report goroutines
+}
+
+type recordBatch[T any] struct {
+	records []T
+	left    int32 // reports still adding the batch, updated atomically
+}
+
+func newDispatcher[T any](reports []Report[T]) *dispatcher[T] {
+	d := &dispatcher[T]{reports: make([]chan *recordBatch[T], len(reports)), free: make(chan *recordBatch[T], dispatchBatches)}
+	for i := 0; i < dispatchBatches; i++ {
+		d.free <- &recordBatch[T]{records: make([]T, 0, dispatchBatch)}
+	}
+	for i, rpt := range reports {
+		d.reports[i] = make(chan *recordBatch[T], dispatchBatches)
+		d.done.Add(1)
+		go d.run(rpt, d.reports[i])
+	}
+	return d
+}
+
+func (d *dispatcher[T]) run(rpt Report[T], batches chan *recordBatch[T]) {
+	defer d.done.Done()
+	for b := range batches {
+		for _, rec := range b.records {
+			rpt.Add(rec)
+		}
+		if atomic.AddInt32(&b.left, -1) == 0 {
+			b.records = b.records[:0]
+			d.free <- b
+			d.pending.Done()
+		}
+	}
+}
+
+func (d *dispatcher[T]) add(rec T) {
+	if d.batch == nil {
+		d.batch = <-d.free
+	}
+	d.batch.records = append(d.batch.records, copyRecord(rec))
+	if len(d.batch.records) == cap(d.batch.records) {
+		d.flush()
+	}
+}
+
+// flush hands the current batch to the reports
+func (d *dispatcher[T]) flush() {
+	b := d.batch
+	if b == nil || len(b.records) == 0 {
+		return
+	}
+	d.batch = nil
+	b.left = int32(len(d.reports))
+	d.pending.Add(1)
+	for _, c := range d.reports {
+		c <- b
+	}
+}
+
+// sync waits until every report added every record handed to the dispatcher
+func (d *dispatcher[T]) sync() {
+	d.flush()
+	d.pending.Wait()
+}
+
+func (d *dispatcher[T]) stop() {
+	d.sync()
+	for _, c := range d.reports {
+		close(c)
+	}
+	d.done.Wait()
+}
+
+// copyRecord returns a copy of a LogRecord or ByteRecord that stays valid after the parser's next call
+func copyRecord[T any](rec T) T {
+	switch r := any(rec).(type) {
+	case LogRecord:
+		return any(CopyRecord(r)).(T)
+	case ByteRecord:
+		return any(CopyByteRecord(r)).(T)
+	}
+	return rec
+}
+
+// Dispatch makes ProcessRecord add the records to every report in a goroutine of its own, until Stop. The
+// reports must not be read, merged or handed a header before Sync.
+func (rm *ReportManager[T]) Dispatch() {
+	if rm.dispatch == nil && len(rm.reports) > 1 {
+		rm.dispatch = newDispatcher(rm.reports)
+	}
+}
+
+// Sync waits until the reports added every record processed, when they are dispatched
+func (rm *ReportManager[T]) Sync() {
+	if rm.dispatch != nil {
+		rm.dispatch.sync()
+	}
+}
+
+// Stop ends the dispatch of records to the report goroutines after they added every record
+func (rm *ReportManager[T]) Stop() {
+	if rm.dispatch != nil {
+		rm.dispatch.stop()
+		rm.dispatch = nil
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// TimeBucket truncates times to the start of their bucket: minute, hour, day, week (starting Monday) and
+// month in the time zone of the times, or a Go duration such as 15m, aligned to midnight UTC
+type TimeBucket func(t time.Time) time.Time
+
+func ParseTimeBucket(s string) (TimeBucket, error) {
+	switch s {
+	case "minute":
+		return func(t time.Time) time.Time { return t.Truncate(time.Minute) }, nil
+	case "hour":
+		return func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		}, nil
+	case "day":
+		return func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()) }, nil
+	case "week":
+		return func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+		}, nil
+	case "month":
+		return func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()) }, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("time bucket %s: want minute, hour, day, week, month or a duration", s)
+	}
+	return func(t time.Time) time.Time { return t.Truncate(d) }, nil
+}
+
+type distinctKey struct {
+	bucket int64 // unix seconds of the start of the bucket
+	key    string
+}
+
+// DistinctReport estimates the distinct values of the distinct columns, e.g. users or IPs, by the key
+// columns in every time bucket of the -time-column, such as the daily active users by site. Every pair of
+// bucket and key has an HLL sketch, so memory grows with the pairs, not with the distinct values, and
+// workers merge by their sketches.
+type DistinctReport struct {
+	sketches  map[distinctKey]*HLL
+	spec      *KeySpec
+	distinct  *KeySpec
+	keys      keyCache
+	distinctk keyCache
+	times     *TimeParser
+	bucket    TimeBucket
+	precision int
+	norm      *Normalizer
+	empty     *EmptyKeys
+}
+
+func NewDistinctReport(spec, distinct *KeySpec, times *TimeParser, bucket TimeBucket, precision int) (*DistinctReport, error) {
+	if _, err := NewHLL(precision); err != nil {
+		return nil, fmt.Errorf("distinct: %v", err)
+	}
+	return &DistinctReport{make(map[distinctKey]*HLL), spec, distinct, newKeyCache(spec), newKeyCache(distinct), times, bucket,
+		precision, nil, nil}, nil
+}
+
+// Normalize rewrites the key and distinct columns with n before they are counted
+func (dr *DistinctReport) Normalize(n *Normalizer) *DistinctReport { dr.norm = n; return dr }
+
+// EmptyKeys handles empty key columns with ek. Records with empty distinct columns are not counted.
+func (dr *DistinctReport) EmptyKeys(ek *EmptyKeys) *DistinctReport { dr.empty = ek; return dr }
+
+func (dr *DistinctReport) New() Report[LogRecord] {
+	ndr, _ := NewDistinctReport(dr.spec, dr.distinct, dr.times, dr.bucket, dr.precision)
+	return ndr.Normalize(dr.norm).EmptyKeys(dr.empty)
+}
+
+func (dr *DistinctReport) Name() string         { return "distinct" }
+func (dr *DistinctReport) Clear()               { dr.sketches = make(map[distinctKey]*HLL) }
+func (dr *DistinctReport) Len() int             { return len(dr.sketches) }
+func (dr *DistinctReport) LogValue() slog.Value { return keysLogValue(len(dr.sketches), dr.empty) }
+
+func (dr *DistinctReport) Merge(rpt Report[LogRecord]) {
+	for k, o := range rpt.(*DistinctReport).sketches {
+		if h, ok := dr.sketches[k]; ok {
+			h.Merge(o)
+		} else {
+			dr.sketches[k] = o
+		}
+	}
+}
+
+func (dr *DistinctReport) SetHeader(columns []string) error {
+	spec, err := dr.spec.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	distinct, err := dr.distinct.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	dr.keys, dr.distinctk = newKeyCache(spec), newKeyCache(distinct)
+	return nil
+}
+
+func (dr *DistinctReport) Check(r LogRecord) error {
+	if _, err := dr.keys.columns(len(r)); err != nil {
+		return err
+	}
+	if _, err := dr.distinctk.columns(len(r)); err != nil {
+		return fmt.Errorf("distinct: %v", err)
+	}
+	_, err := RecordTime(dr.times, r)
+	return err
+}
+
+// Add adds the record's distinct value to the sketch of its key in its bucket. Records rejected by Check
+// are skipped.
+func (dr *DistinctReport) Add(r LogRecord) {
+	keys, err := dr.keys.columns(len(r))
+	if err != nil {
+		return
+	}
+	distinct, err := dr.distinctk.columns(len(r))
+	if err != nil {
+		return
+	}
+	t, err := RecordTime(dr.times, r)
+	if err != nil {
+		return
+	}
+	key, ok := joinKey(keys, r, dr.norm, dr.empty)
+	if !ok {
+		return
+	}
+	v, _ := joinKey(distinct, r, dr.norm, nil)
+	if v == "" {
+		return
+	}
+	k := distinctKey{dr.bucket(t).Unix(), key}
+	h, ok := dr.sketches[k]
+	if !ok {
+		h, _ = NewHLL(dr.precision)
+		dr.sketches[k] = h
+	}
+	h.Add(v)
+}
+
+// Output writes bucket,key,estimate lines sorted by bucket and key, the buckets as RFC 3339 times in the
+// -tz
+func (dr *DistinctReport) Output(path string) {
+	keys := make([]distinctKey, 0, len(dr.sketches))
+	for k := range dr.sketches {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].bucket != keys[j].bucket {
+			return keys[i].bucket < keys[j].bucket
+		}
+		return keys[i].key < keys[j].key
+	})
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for _, k := range keys {
+		bucket := dr.times.In(time.Unix(k.bucket, 0)).Format(time.RFC3339)
+		fmt.Fprintf(w, "%s,%s,%d\n", bucket, k.key, dr.sketches[k].Estimate())
+	}
+	w.Flush()
+}
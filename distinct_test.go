@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseTimeBucket(t *testing.T) {
+	// a Wednesday
+	at := time.Date(2024, 5, 15, 13, 47, 12, 0, time.UTC)
+	for _, tc := range []struct {
+		bucket string
+		want   string
+	}{
+		{"minute", "2024-05-15T13:47:00Z"},
+		{"hour", "2024-05-15T13:00:00Z"},
+		{"day", "2024-05-15T00:00:00Z"},
+		{"week", "2024-05-13T00:00:00Z"},
+		{"month", "2024-05-01T00:00:00Z"},
+		{"15m", "2024-05-15T13:45:00Z"},
+	} {
+		bucket, err := ParseTimeBucket(tc.bucket)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.bucket, err)
+		}
+		if got := bucket(at).Format(time.RFC3339); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.bucket, got, tc.want)
+		}
+	}
+	for _, s := range []string{"fortnight", "-1h", "0s"} {
+		if _, err := ParseTimeBucket(s); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+}
+
+func TestHLLEstimate(t *testing.T) {
+	a, _ := NewHLL(12)
+	b, _ := NewHLL(12)
+	for i := 0; i < 20000; i++ {
+		a.Add(fmt.Sprint("user", i))
+		// half of b's values are in a as well
+		b.Add(fmt.Sprint("user", i+10000))
+		a.Add(fmt.Sprint("user", i)) // duplicates do not count
+	}
+	// 5% is three standard errors at p = 12
+	within := func(got uint64, want float64) bool { return float64(got) > want*0.95 && float64(got) < want*1.05 }
+	if got := a.Estimate(); !within(got, 20000) {
+		t.Errorf("estimate %d, want about 20000", got)
+	}
+	a.Merge(b)
+	if got := a.Estimate(); !within(got, 30000) {
+		t.Errorf("merged estimate %d, want about 30000", got)
+	}
+
+	if _, err := NewHLL(3); err == nil {
+		t.Errorf("precision 3: no error")
+	}
+}
+
+func TestDistinctReport(t *testing.T) {
+	times, err := NewTimeParser(0, "rfc3339", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day, _ := ParseTimeBucket("day")
+	dr, err := NewDistinctReport(KeyColumns(1), KeyColumns(2), times, day, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := []LogRecord{
+		{"2024-05-15T01:00:00Z", "a.com", "alice"},
+		{"2024-05-15T02:00:00Z", "a.com", "bob"},
+		{"2024-05-15T03:00:00Z", "a.com", "alice"},
+		{"2024-05-15T04:00:00Z", "b.com", "alice"},
+	}
+	b := []LogRecord{
+		{"2024-05-16T01:00:00Z", "a.com", "alice"},
+		{"2024-05-15T05:00:00Z", "a.com", "carol"},
+		{"2024-05-15T06:00:00Z", "a.com", ""}, // no distinct value
+		{"yesterday", "a.com", "dave"},        // no time
+		{"2024-05-15T07:00:00Z", "a.com"},     // too short
+	}
+	checkMerge[LogRecord](t, dr, a, b)
+	checkClear[LogRecord](t, dr.New(), a)
+	checkOutput(t, dr, append(a, b...), `2024-05-15T00:00:00Z,a.com,3
+2024-05-15T00:00:00Z,b.com,1
+2024-05-16T00:00:00Z,a.com,1
+`)
+
+	if _, err := NewDistinctReport(KeyColumns(1), KeyColumns(2), times, day, 20); err == nil {
+		t.Errorf("precision 20: no error")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// stateEvent is a state of a key at a time, in unix nanoseconds
+type stateEvent struct {
+	key, state string
+	t          int64
+}
+
+func (e stateEvent) size() int64 { return int64(len(e.key) + len(e.state) + 40) }
+
+func (e stateEvent) less(o stateEvent) bool {
+	if e.key != o.key {
+		return e.key < o.key
+	}
+	if e.t != o.t {
+		return e.t < o.t
+	}
+	return e.state < o.state
+}
+
+// eventSpool keeps the events of a report that need the timeline of every key, which may span inputs and
+// workers, until the result is written. It buffers the events and, past spillSize bytes unless it is 0,
+// spills them as a sorted run to the scratch workspace. each merges the runs and the buffer by key and
+// time, and events of a key at the same time by state.
+type eventSpool struct {
+	name      string // of the report, for the runs and errors
+	spillSize int64
+
+	ws     *Workspace
+	events []stateEvent
+	size   int64
+	runs   []string // sorted runs spilled, of this spool and the ones merged into it
+	count  int64
+	err    error
+}
+
+func (es *eventSpool) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("events", es.count), slog.Int("runs", len(es.runs)), slog.Int64("buffered_bytes", es.size))
+}
+
+func (es *eventSpool) add(e stateEvent) {
+	if es.err != nil {
+		return
+	}
+	es.events = append(es.events, e)
+	es.size += e.size()
+	es.count += 1
+	if es.spillSize > 0 && es.size > es.spillSize {
+		es.spill()
+	}
+}
+
+func (es *eventSpool) fail(err error) {
+	if es.err == nil {
+		es.err = err
+		slog.Error(es.name+": failed to spill the events", "error", err)
+	}
+}
+
+// spill writes the buffered events sorted to a run in the workspace
+func (es *eventSpool) spill() {
+	if es.ws == nil {
+		es.ws = NewWorkspace("", 0)
+	}
+	sort.Slice(es.events, func(i, j int) bool { return es.events[i].less(es.events[j]) })
+	run, err := es.ws.Create(es.name + "-*.csv")
+	if err != nil {
+		es.fail(err)
+		return
+	}
+	w := csv.NewWriter(run)
+	for _, e := range es.events {
+		w.Write([]string{e.key, strconv.FormatInt(e.t, 10), e.state})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		es.fail(err)
+	}
+	if err := run.Close(); err != nil {
+		es.fail(err)
+	}
+	es.runs = append(es.runs, run.Name())
+	es.events, es.size = nil, 0
+}
+
+func (es *eventSpool) merge(o *eventSpool) {
+	es.runs = append(es.runs, o.runs...)
+	es.events = append(es.events, o.events...)
+	es.size += o.size
+	es.count += o.count
+	if es.err == nil {
+		es.err = o.err
+	}
+	if es.spillSize > 0 && es.size > es.spillSize && es.err == nil {
+		es.spill()
+	}
+}
+
+// clear forgets the events and runs, which belong to the spool they were merged into
+func (es *eventSpool) clear() {
+	es.events, es.size, es.runs, es.count, es.err = nil, 0, nil, 0, nil
+}
+
+// eventCursor reads the events of a sorted run or of the sorted buffer
+type eventCursor struct {
+	e      stateEvent
+	events []stateEvent
+	r      *csv.Reader
+	fp     *os.File
+}
+
+func (c *eventCursor) next() (bool, error) {
+	if c.r == nil {
+		if len(c.events) == 0 {
+			return false, nil
+		}
+		c.e, c.events = c.events[0], c.events[1:]
+		return true, nil
+	}
+	rec, err := c.r.Read()
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	t, err := strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", c.fp.Name(), err)
+	}
+	c.e = stateEvent{rec[0], rec[2], t}
+	return true, nil
+}
+
+type eventHeap []*eventCursor
+
+func (h eventHeap) Len() int            { return len(h) }
+func (h eventHeap) Less(i, j int) bool  { return h[i].e.less(h[j].e) }
+func (h eventHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(*eventCursor)) }
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// each calls fn with all events in order, merging the runs and the buffer
+func (es *eventSpool) each(fn func(e stateEvent)) error {
+	if es.err != nil {
+		return es.err
+	}
+	sort.Slice(es.events, func(i, j int) bool { return es.events[i].less(es.events[j]) })
+	h := eventHeap{}
+	add := func(c *eventCursor) error {
+		ok, err := c.next()
+		if ok {
+			heap.Push(&h, c)
+		}
+		return err
+	}
+	if err := add(&eventCursor{events: es.events}); err != nil {
+		return err
+	}
+	for _, run := range es.runs {
+		fp, err := os.Open(run)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		r := csv.NewReader(fp)
+		r.FieldsPerRecord = 3
+		r.ReuseRecord = true
+		if err := add(&eventCursor{r: r, fp: fp}); err != nil {
+			return err
+		}
+	}
+
+	for h.Len() > 0 {
+		c := h[0]
+		fn(c.e)
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
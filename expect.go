@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Manifest holds the expected record counts and decompressed sizes of the inputs, to catch inputs that
+// end early, e.g. truncated gzip members, which otherwise read as a clean EOF. It is safe for concurrent
+// use by the workers.
+type Manifest struct {
+	expected map[string]Expected // by path and by base name
+	warn     bool                // log mismatches instead of failing the files
+
+	mu         sync.Mutex
+	seen       map[string]bool
+	verified   int64 // updated atomically
+	mismatched int64
+}
+
+// Expected is a line of a manifest, -1 for what is not checked
+type Expected struct {
+	File    string
+	Records int64
+	Bytes   int64
+}
+
+// MismatchError is the error of a file whose counts differ from the manifest
+type MismatchError struct {
+	File      string
+	What      string
+	Want, Got int64
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("expected %d %s, got %d", e.Want, e.What, e.Got)
+}
+
+// LoadManifest reads a CSV file of file,records[,bytes] lines, with an optional header line. Empty counts
+// are not checked, and files can be given by path or base name.
+func LoadManifest(path string, warn bool) (*Manifest, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	r := csv.NewReader(fp)
+	r.FieldsPerRecord = -1
+	lines, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("manifest %s: %v", path, err)
+	}
+	m := &Manifest{expected: make(map[string]Expected), warn: warn, seen: make(map[string]bool)}
+	for i, line := range lines {
+		if i == 0 && len(line) > 1 && line[0] == "file" {
+			continue
+		}
+		if len(line) < 2 || len(line) > 3 {
+			return nil, fmt.Errorf("manifest %s:%d: want file,records[,bytes]", path, i+1)
+		}
+		e := Expected{File: line[0], Records: -1, Bytes: -1}
+		if e.Records, err = manifestCount(line[1]); err == nil && len(line) == 3 {
+			e.Bytes, err = manifestCount(line[2])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s:%d: %v", path, i+1, err)
+		}
+		m.expected[e.File] = e
+		if base := filepath.Base(e.File); base != e.File {
+			m.expected[base] = e
+		}
+	}
+	return m, nil
+}
+
+func manifestCount(s string) (int64, error) {
+	if s == "" {
+		return -1, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func (m *Manifest) lookup(file string) (Expected, bool) {
+	if e, ok := m.expected[file]; ok {
+		return e, true
+	}
+	e, ok := m.expected[filepath.Base(file)]
+	return e, ok
+}
+
+// Verify compares the counts of a processed input to the manifest. It returns the mismatch, unless
+// mismatches are only logged.
+func (m *Manifest) Verify(stats FileStats) error {
+	e, ok := m.lookup(stats.File)
+	if !ok {
+		return nil
+	}
+	m.mu.Lock()
+	m.seen[e.File] = true
+	m.mu.Unlock()
+
+	var err *MismatchError
+	if e.Records >= 0 && stats.Records+stats.ParseErrors != e.Records {
+		err = &MismatchError{stats.File, "records", e.Records, stats.Records + stats.ParseErrors}
+	} else if e.Bytes >= 0 && stats.Bytes != e.Bytes {
+		err = &MismatchError{stats.File, "bytes", e.Bytes, stats.Bytes}
+	}
+	if err == nil {
+		atomic.AddInt64(&m.verified, 1)
+		return nil
+	}
+	atomic.AddInt64(&m.mismatched, 1)
+	if m.warn {
+		slog.Warn("input does not match the manifest", "file", stats.File, "error", err)
+		return nil
+	}
+	return err
+}
+
+// Missing returns the files of the manifest that were not processed
+func (m *Manifest) Missing() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var missing []string
+	for name, e := range m.expected {
+		if name == e.File && !m.seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func (m *Manifest) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("verified", atomic.LoadInt64(&m.verified)),
+		slog.Int64("mismatched", atomic.LoadInt64(&m.mismatched)), slog.Int("missing", len(m.Missing())))
+}
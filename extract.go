@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExtractReport writes the records themselves instead of aggregating them, which makes a run a parallel
+// ETL job: converting CSV to JSON, or extracting the records that pass the filters of the run, after its
+// transforms. Like the sql report, every worker spools its records, already encoded, to the scratch
+// workspace, and Output concatenates the spools. The records of a worker keep their order, so all records
+// do with -procs 1.
+//
+// With Split, the records are spooled by part, e.g. per service or per day, and written as a result of
+// their own per part by SplitSink.
+type ExtractReport struct {
+	format string   // csv, tsv or json
+	spec   *KeySpec // of the columns written, nil for all of them
+	split  *KeySpec // of the columns naming the part of a record, nil for none
+	times  *TimeParser
+	bucket TimeBucket // of the time naming the part of a record, nil for none
+	layout string     // of the time buckets in part names
+
+	keys    keyCache
+	splitk  keyCache
+	header  []string // of the input being read
+	names   []string // quoted JSON names of the columns, by index
+	first   []string // the header line of CSV and TSV, of the first input with a header
+	ws      *Workspace
+	spools  map[string]*extractSpool // open, by part
+	files   map[string][]string      // closed spools by part, of this report and the ones merged into it
+	records int64
+	err     error
+}
+
+type extractSpool struct {
+	file *ScratchFile
+	w    *bufio.Writer
+	enc  *csv.Writer // nil for JSON
+}
+
+func NewExtractReport(format string, spec *KeySpec) (*ExtractReport, error) {
+	switch format {
+	case "csv", "tsv", "json":
+	default:
+		return nil, fmt.Errorf("extract: unknown format %s, want csv, tsv or json", format)
+	}
+	er := &ExtractReport{format: format, spec: spec, spools: make(map[string]*extractSpool), files: make(map[string][]string)}
+	if spec != nil {
+		er.keys = newKeyCache(spec)
+	}
+	return er, nil
+}
+
+// Split writes the records in parts named by the values of the split columns, and by the bucket of their
+// time when bucket is set. Either may be nil.
+func (er *ExtractReport) Split(split *KeySpec, times *TimeParser, bucket TimeBucket, layout string) *ExtractReport {
+	er.split, er.times, er.bucket, er.layout = split, times, bucket, layout
+	if split != nil {
+		er.splitk = newKeyCache(split)
+	}
+	return er
+}
+
+func (er *ExtractReport) New() Report[LogRecord] {
+	ner, _ := NewExtractReport(er.format, er.spec)
+	ner.ws = er.ws
+	return ner.Split(er.split, er.times, er.bucket, er.layout)
+}
+
+func (er *ExtractReport) Name() string               { return "extract" }
+func (er *ExtractReport) SetWorkspace(ws *Workspace) { er.ws = ws }
+
+func (er *ExtractReport) Extension() string {
+	if er.format == "json" {
+		return ".jsonl"
+	}
+	return "." + er.format
+}
+
+func (er *ExtractReport) SetHeader(columns []string) error {
+	er.header, er.names = CopyRecord(columns), nil
+	columns = er.header
+	if er.split != nil {
+		split, err := er.split.WithHeader(columns)
+		if err != nil {
+			return err
+		}
+		er.splitk = newKeyCache(split)
+	}
+	if er.spec != nil {
+		spec, err := er.spec.WithHeader(columns)
+		if err != nil {
+			return err
+		}
+		er.keys = newKeyCache(spec)
+		if er.first == nil {
+			if cols, err := spec.Columns(len(columns)); err == nil {
+				columns = make([]string, len(cols))
+				for i, c := range cols {
+					columns[i] = er.header[c]
+				}
+			}
+		}
+	}
+	if er.first == nil {
+		er.first = columns
+	}
+	return nil
+}
+
+func (er *ExtractReport) Check(r LogRecord) error {
+	if er.spec != nil {
+		if _, err := er.keys.columns(len(r)); err != nil {
+			return err
+		}
+	}
+	_, err := er.part(r)
+	return err
+}
+
+// part returns the name of the part of the record, "" without Split. The values are joined by - with the
+// characters other than letters, digits, ., - and _ replaced by _, so they make file names.
+func (er *ExtractReport) part(r LogRecord) (string, error) {
+	var b strings.Builder
+	if er.bucket != nil {
+		t, err := RecordTime(er.times, r)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(er.bucket(t).Format(er.layout))
+	}
+	if er.split != nil {
+		cols, err := er.splitk.columns(len(r))
+		if err != nil {
+			return "", fmt.Errorf("split: %v", err)
+		}
+		for _, c := range cols {
+			if b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			if r[c] == "" {
+				b.WriteByte('_')
+			}
+			for _, ch := range r[c] {
+				if ch < utf8.RuneSelf && (ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '.' || ch == '-' || ch == '_') {
+					b.WriteRune(ch)
+				} else {
+					b.WriteByte('_')
+				}
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// Add spools the record, or its selected columns, to its part. Records rejected by Check are skipped.
+func (er *ExtractReport) Add(r LogRecord) {
+	if er.err != nil {
+		return
+	}
+	var cols []int
+	if er.spec != nil {
+		var err error
+		if cols, err = er.keys.columns(len(r)); err != nil {
+			return
+		}
+	}
+	part, err := er.part(r)
+	if err != nil {
+		return
+	}
+	sp := er.spools[part]
+	if sp == nil {
+		if er.ws == nil {
+			er.ws = NewWorkspace("", 0)
+		}
+		file, err := er.ws.Create("extract-*." + er.format)
+		if err != nil {
+			er.fail(err)
+			return
+		}
+		sp = &extractSpool{file: file, w: bufio.NewWriter(file)}
+		if er.format != "json" {
+			sp.enc = csv.NewWriter(sp.w)
+			if er.format == "tsv" {
+				sp.enc.Comma = '\t'
+			}
+		}
+		er.spools[part] = sp
+	}
+
+	if sp.enc != nil {
+		if cols != nil {
+			values := make([]string, len(cols))
+			for i, c := range cols {
+				values[i] = r[c]
+			}
+			r = values
+		}
+		if err := sp.enc.Write(r); err != nil {
+			er.fail(err)
+			return
+		}
+	} else {
+		er.writeJSON(sp.w, r, cols)
+	}
+	er.records += 1
+}
+
+// writeJSON writes the record as an object of the columns named by the header, c0, c1, ... for the ones
+// it does not name, in the order of the columns
+func (er *ExtractReport) writeJSON(w *bufio.Writer, r LogRecord, cols []int) {
+	n := len(r)
+	if cols != nil {
+		n = len(cols)
+	}
+	w.WriteByte('{')
+	for i := 0; i < n; i++ {
+		c := i
+		if cols != nil {
+			c = cols[i]
+		}
+		for len(er.names) <= c {
+			name := appendJSONString(nil, columnName(er.header, len(er.names)))
+			er.names = append(er.names, string(name)+":")
+		}
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(er.names[c])
+		w.Write(appendJSONString(nil, r[c]))
+	}
+	w.WriteString("}\n")
+}
+
+// appendJSONString appends s as a JSON string with only the escapes JSON requires, so URLs keep their &,
+// < and >. Invalid UTF-8 is replaced by U+FFFD.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c == '\n':
+			b = append(b, '\\', 'n')
+		case c == '\r':
+			b = append(b, '\\', 'r')
+		case c == '\t':
+			b = append(b, '\\', 't')
+		case c < 0x20:
+			b = fmt.Appendf(b, `\u%04x`, c)
+		case c < utf8.RuneSelf:
+			b = append(b, c)
+		default:
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				b = append(b, `�`...)
+			} else {
+				b = append(b, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		i++
+	}
+	return append(b, '"')
+}
+
+func (er *ExtractReport) fail(err error) {
+	if er.err == nil {
+		er.err = err
+		slog.Error("extract: failed to spool the records", "error", err)
+	}
+}
+
+// seal flushes and closes the spools, so they can be merged or written
+func (er *ExtractReport) seal() {
+	for part, sp := range er.spools {
+		if sp.enc != nil {
+			sp.enc.Flush()
+			if err := sp.enc.Error(); err != nil {
+				er.fail(err)
+			}
+		}
+		if err := sp.w.Flush(); err != nil {
+			er.fail(err)
+		}
+		if err := sp.file.Close(); err != nil {
+			er.fail(err)
+		}
+		er.files[part] = append(er.files[part], sp.file.Name())
+	}
+	clear(er.spools)
+}
+
+func (er *ExtractReport) Merge(rpt Report[LogRecord]) {
+	ner := rpt.(*ExtractReport)
+	ner.seal()
+	for part, files := range ner.files {
+		er.files[part] = append(er.files[part], files...)
+	}
+	er.records += ner.records
+	if er.first == nil {
+		er.first = ner.first
+	}
+	if er.err == nil {
+		er.err = ner.err
+	}
+}
+
+// Clear forgets the spools, which belong to the report they were merged into
+func (er *ExtractReport) Clear() {
+	er.seal()
+	er.files, er.records, er.err = make(map[string][]string), 0, nil
+}
+
+func (er *ExtractReport) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("records", er.records), slog.Int("parts", len(er.files)))
+}
+
+// Parts returns the parts sorted by name, nil without Split
+func (er *ExtractReport) Parts() []string {
+	if er.split == nil && er.bucket == nil {
+		return nil
+	}
+	er.seal()
+	parts := make([]string, 0, len(er.files))
+	for part := range er.files {
+		parts = append(parts, part)
+	}
+	sort.Strings(parts)
+	return parts
+}
+
+// Output writes all parts, one after the other
+func (er *ExtractReport) Output(path string) {
+	er.seal()
+	parts := make([]string, 0, len(er.files))
+	for part := range er.files {
+		parts = append(parts, part)
+	}
+	sort.Strings(parts)
+	er.output(path, parts)
+}
+
+func (er *ExtractReport) OutputPart(part, path string) { er.output(path, []string{part}) }
+
+// output writes the header line of the first input with -header, for CSV and TSV, and the spools of the
+// parts
+func (er *ExtractReport) output(path string, parts []string) {
+	er.seal()
+	if er.err != nil {
+		slog.Error("failed to write", "file", path, "error", er.err)
+		return
+	}
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	if er.first != nil && er.format != "json" {
+		enc := csv.NewWriter(w)
+		if er.format == "tsv" {
+			enc.Comma = '\t'
+		}
+		enc.Write(er.first)
+		enc.Flush()
+	}
+	for _, part := range parts {
+		for _, file := range er.files[part] {
+			if err := appendFile(w, file); err != nil {
+				slog.Error("failed to write", "file", path, "error", err)
+				return
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+	}
+}
+
+func appendFile(w io.Writer, path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = io.Copy(w, fp)
+	return err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvPrefix starts the environment variables that set flags, e.g. GOLOPRO_IN for -in or
+// GOLOPRO_LOG_LEVEL for -log-level
+const EnvPrefix = "GOLOPRO_"
+
+const maxArgsFileDepth = 8
+
+// parseFlags sets the flags of a command from the environment, then parses the arguments with the
+// @file arguments replaced by the arguments in the files, so the command line wins over the environment
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if cmd := findCommand(fs.Name()); cmd != nil && cmd.Flags != nil {
+		fs.Usage = func() { commandUsage(fs.Output(), cmd) }
+	}
+	if err := setFlagsFromEnv(fs); err != nil {
+		return err
+	}
+	args, err := expandArgsFiles(args, "", 0)
+	if err != nil {
+		return err
+	}
+	return fs.Parse(args)
+}
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func setFlagsFromEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && err == nil {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, envName(f.Name), serr)
+			}
+		}
+	})
+	return err
+}
+
+// expandArgsFiles replaces every @file argument by the arguments in the file, which can include other
+// files relative to their own directory. @@ starts an argument that is an @ followed by the rest.
+func expandArgsFiles(args []string, dir string, depth int) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "@@"):
+			expanded = append(expanded, arg[1:])
+		case strings.HasPrefix(arg, "@") && len(arg) > 1:
+			if depth == maxArgsFileDepth {
+				return nil, fmt.Errorf("%s: @file includes nested too deep", arg)
+			}
+			path := arg[1:]
+			if dir != "" && !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			fileArgs, err := splitArgs(string(data))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if fileArgs, err = expandArgsFiles(fileArgs, filepath.Dir(path), depth+1); err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, fileArgs...)
+		default:
+			expanded = append(expanded, arg)
+		}
+	}
+	return expanded, nil
+}
+
+// splitArgs splits the content of an args file into arguments separated by white space. 'single' quotes
+// keep everything, "double" quotes allow \" and \\, and # starts a comment up to the end of the line.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case c == '#' && !inArg:
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' quote")
+			}
+			arg.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					i++
+				}
+				arg.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated \" quote")
+			}
+			inArg = true
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
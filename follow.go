@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxFollowRead caps the bytes of a live file read in one poll, so a large backlog is caught up in steps
+const maxFollowRead = 64 << 20
+
+// tailSource serves the complete lines appended to a live file since the last poll. The first line of
+// the file is put before every later segment with a header, so each segment parses like the whole file.
+type tailSource struct {
+	file    string
+	header  bool
+	fi      os.FileInfo // of the file read so far, to notice it was replaced
+	offset  int64       // up to which the lines were processed
+	first   []byte      // the header line
+	segment []byte      // the lines of the current poll
+	behind  bool        // whether the poll was capped at maxFollowRead
+}
+
+// poll reads the complete lines appended since the last commit and tells whether there are any. A file
+// that was truncated or replaced, e.g. by log rotation, is read from its start.
+func (ts *tailSource) poll() (bool, error) {
+	fp, err := os.Open(ts.file)
+	if err != nil {
+		return false, err
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return false, err
+	}
+	if ts.fi != nil && (!os.SameFile(fi, ts.fi) || fi.Size() < ts.offset) {
+		slog.Info("live file replaced, reading it from the start", "file", ts.file)
+		ts.offset, ts.first = 0, nil
+	}
+	ts.fi = fi
+
+	n := fi.Size() - ts.offset
+	if ts.behind = n > maxFollowRead; ts.behind {
+		n = maxFollowRead
+	}
+	data := make([]byte, n)
+	read, err := fp.ReadAt(data, ts.offset)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	// a line being written is left for the next poll
+	ts.segment = data[:bytes.LastIndexByte(data[:read], '\n')+1]
+	if ts.header && ts.offset == 0 && len(ts.segment) > 0 {
+		ts.first = ts.segment[:bytes.IndexByte(ts.segment, '\n')+1]
+	}
+	return len(ts.segment) > 0, nil
+}
+
+// commit marks the lines of the poll as processed
+func (ts *tailSource) commit() {
+	ts.offset += int64(len(ts.segment))
+	ts.segment = nil
+}
+
+func (ts *tailSource) Open(name string) (io.ReadCloser, int64, error) {
+	if name != ts.file {
+		return nil, 0, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	data := ts.segment
+	if ts.offset > 0 && ts.first != nil {
+		data = append(append([]byte(nil), ts.first...), data...)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// Follow tails a live file after the run of the archive, e.g. the file the archive is rotated from: every
+// interval the lines appended since the last poll are processed into the same reports, which are then
+// written again, so the results continue the backfill without a gap. Lines are read once complete, and
+// up to the last one the run processed if it fails. Follow returns nil once the run is canceled.
+func (p *Pipeline[T]) Follow(file string, every time.Duration, header bool) error {
+	ts := &tailSource{file: file, header: header}
+	source := p.source
+	p.From(ts)
+	defer p.From(source)
+
+	slog.Info("following", "file", file, "every", every)
+	st := &followState{file: file, every: every, polled: time.Now()}
+	following.Store(st)
+	defer following.Store(nil)
+	for !p.control.Canceled() {
+		more, err := ts.poll()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if more {
+			if err := p.Run([]string{file}); err == ErrCanceled {
+				break
+			} else if err != nil {
+				return fmt.Errorf("follow %s: %v", file, err)
+			}
+			ts.commit()
+			foldFollowed(&p.stats, file)
+		}
+		st.update(ts)
+		if more && ts.behind {
+			continue
+		}
+		for deadline := time.Now().Add(every); time.Now().Before(deadline) && !p.control.Canceled(); {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	slog.Info("stopped following", "file", file, "offset", ts.offset)
+	return nil
+}
+
+// followState is the progress of -follow, served on /healthz and /readyz with -metrics
+type followState struct {
+	sync.Mutex
+	file         string
+	every        time.Duration
+	offset, size int64     // of the live file, processed up to offset
+	polled       time.Time // when the last poll was done
+}
+
+// following is the running -follow, nil until the backfill is done
+var following atomic.Pointer[followState]
+
+func (st *followState) update(ts *tailSource) {
+	st.Lock()
+	defer st.Unlock()
+	st.offset, st.polled = ts.offset, time.Now()
+	if ts.fi != nil {
+		st.size = ts.fi.Size()
+	}
+}
+
+// followStalled is how many intervals -follow may go without a poll, and at least a minute, before
+// /healthz fails
+const followStalled = 5
+
+// followHealthz reports the lag of -follow behind the live file, in bytes appended but not processed yet
+// and seconds since the last poll, and fails once it stalls
+func followHealthz(w http.ResponseWriter, r *http.Request) {
+	st := following.Load()
+	if st == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "backfilling"})
+		return
+	}
+	st.Lock()
+	defer st.Unlock()
+	since := time.Since(st.polled)
+	status, code := "ok", http.StatusOK
+	if since > max(followStalled*st.every, time.Minute) {
+		status, code = "stalled", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "file": st.file, "offset": st.offset,
+		"lag_bytes": max(st.size-st.offset, 0), "lag_seconds": since.Seconds()})
+}
+
+// followReadyz is ready once the backfill is done and the live file is followed
+func followReadyz(w http.ResponseWriter, r *http.Request) {
+	if following.Load() == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "backfilling"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "following"})
+}
+
+// foldFollowed totals the stats of the polls of the live file in its first entry, so the files result has
+// one line for it and does not grow with every poll
+func foldFollowed(stats *WorkerStats, file string) {
+	var first *FileStats
+	kept := stats.perFile[:0]
+	for _, fs := range stats.perFile {
+		if fs.File != file {
+			kept = append(kept, fs)
+			continue
+		}
+		if first == nil {
+			kept = append(kept, fs)
+			first = &kept[len(kept)-1]
+			continue
+		}
+		first.Worker, first.Err = fs.Worker, fs.Err
+		first.Bytes += fs.Bytes
+		first.BytesCompressed += fs.BytesCompressed
+		first.Records += fs.Records
+		first.ParseErrors += fs.ParseErrors
+		first.Duration += fs.Duration
+	}
+	stats.perFile = kept
+}
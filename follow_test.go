@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func pollTail(t *testing.T, ts *tailSource, want string) {
+	t.Helper()
+	more, err := ts.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if more != (want != "") {
+		t.Fatalf("poll = %v, want %q", more, want)
+	}
+	if more {
+		fp, _, err := ts.Open(ts.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(fp)
+		if string(data) != want {
+			t.Errorf("read %q, want %q", data, want)
+		}
+		ts.commit()
+	}
+}
+
+func TestTailSource(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "live.csv")
+	appendFile := func(s string) {
+		fp, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp.WriteString(s)
+		fp.Close()
+	}
+	ts := &tailSource{file: file, header: true}
+
+	appendFile("k,v\na,1\nb,")
+	pollTail(t, ts, "k,v\na,1\n")
+	pollTail(t, ts, "")
+	appendFile("2\nc,3\n")
+	pollTail(t, ts, "k,v\nb,2\nc,3\n")
+
+	// a rotated file is read from its start
+	os.Remove(file)
+	appendFile("k,v\nd,4\n")
+	pollTail(t, ts, "k,v\nd,4\n")
+	// and so is a truncated one
+	os.WriteFile(file, []byte("k,v\n"), 0o644)
+	pollTail(t, ts, "k,v\n")
+	appendFile("e,5\n")
+	pollTail(t, ts, "k,v\ne,5\n")
+}
+
+func TestFollow(t *testing.T) {
+	dir := t.TempDir()
+	old, live := filepath.Join(dir, "old.log"), filepath.Join(dir, "live.log")
+	os.WriteFile(old, []byte("a,1\nb,2\n"), 0o644)
+	os.WriteFile(live, []byte("a,3\n"), 0o644)
+
+	ctl := NewControl()
+	runs := 0
+	p := NewPipeline[LogRecord]().
+		From(NewFileSource(Retry{})).
+		Parse(NewCSVParser(',')).
+		Report(quickReport(t, "0")).
+		To(NewDirSink(t.TempDir())).
+		Procs(2).
+		Control(ctl).
+		Hook(Hooks{OnRunEnd: func(stats *WorkerStats, err error) {
+			// the backfill, then a poll appending a line for the next one
+			if runs += 1; runs == 2 {
+				fp, _ := os.OpenFile(live, os.O_APPEND|os.O_WRONLY, 0)
+				fp.WriteString("c,4\n")
+				fp.Close()
+			} else if runs == 3 {
+				ctl.Cancel()
+			}
+		}})
+	if err := p.Run([]string{old}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Follow(live, 10*time.Millisecond, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := reportOutput(t, p.Reports()[0]); out != "a,2\nb,1\nc,1\n" {
+		t.Errorf("output %q", out)
+	}
+	if n := len(p.reportMgr.references); n != 0 {
+		t.Errorf("%d clones kept after the runs", n)
+	}
+	var polls []FileStats
+	for _, fs := range p.Stats().PerFile() {
+		if fs.File == live {
+			polls = append(polls, fs)
+		}
+	}
+	if len(polls) != 1 || polls[0].Records != 2 {
+		t.Errorf("stats of the live file %+v", polls)
+	}
+}
+
+func TestFollowHealth(t *testing.T) {
+	get := func(handler func(w http.ResponseWriter, r *http.Request)) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/", nil))
+		var body map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	if code, body := get(followReadyz); code != 503 || body["status"] != "backfilling" {
+		t.Errorf("readyz while backfilling: %d %v", code, body)
+	}
+	st := &followState{file: "live.log", every: time.Second, offset: 100, size: 150, polled: time.Now()}
+	following.Store(st)
+	defer following.Store(nil)
+	if code, _ := get(followReadyz); code != 200 {
+		t.Errorf("readyz while following: %d", code)
+	}
+	if code, body := get(followHealthz); code != 200 || body["lag_bytes"] != 50.0 {
+		t.Errorf("healthz: %d %v", code, body)
+	}
+	st.polled = time.Now().Add(-2 * time.Minute)
+	if code, body := get(followHealthz); code != 503 || body["status"] != "stalled" {
+		t.Errorf("healthz when stalled: %d %v", code, body)
+	}
+}
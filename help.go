@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Capability describes a parser, report, transform or sink to people and scripts discovering them. Records
+// is the record type of parsers and reports, Flag the flag that selects a transform.
+type Capability struct {
+	Name    string   `json:"name"`
+	Records string   `json:"records,omitempty"`
+	Flag    string   `json:"flag,omitempty"`
+	Usage   string   `json:"usage"`
+	Options []string `json:"options,omitempty"`
+}
+
+// sinks describes where results go. Unlike parsers and reports they are not registered by name: the
+// command line writes to the dir sink, and the Pipeline API takes any Sink.
+var sinks = []Capability{
+	{Name: "dir", Usage: "writes every report to <-out>/result-<name><ext>, .txt unless the report has an Extension", Options: []string{"out"}},
+	{Name: "nop", Usage: "discards the results, used by -bench and replay"},
+}
+
+// transforms describes the rewrites of records before the reports see them, see NewNormalizer,
+// NewUnitConverter, NewClassifier, NewBucketer and NewRedactor
+var transforms = []Capability{
+	{Name: "lower", Flag: "normalize", Usage: "lower cases the key columns"},
+	{Name: "upper", Flag: "normalize", Usage: "upper cases the key columns"},
+	{Name: "trim", Flag: "normalize", Usage: "removes leading and trailing white space from the key columns"},
+	{Name: "strip-query", Flag: "normalize", Usage: "removes the ?query and #fragment of URLs in the key columns"},
+	{Name: "nfc", Flag: "normalize", Usage: "converts the key columns to Unicode normalization form C"},
+	{Name: "fold", Flag: "normalize", Usage: "case folds the key columns, for case insensitive keys in any script"},
+	{Name: "rewrite", Flag: "rewrite", Usage: "replaces the matches of a regular expression in the key columns, regexp=>replacement"},
+	{Name: "duration[:UNIT]", Flag: "units", Usage: "converts Go durations such as 1.5s or 250ms to a number of UNIT: s (default), ms, us or ns"},
+	{Name: "size", Flag: "units", Usage: "converts sizes such as 3.4MB, 512KiB or 2G to a number of bytes"},
+	{Name: "pow2", Flag: "buckets", Usage: "replaces numbers by the largest power of two not above them, 0 below 1"},
+	{Name: "pow10", Flag: "buckets", Usage: "replaces numbers by the largest power of ten not above them, 0 below 1"},
+	{Name: "width:N", Flag: "buckets", Usage: "replaces numbers by the lower bound of their bucket of width N"},
+	{Name: "FILE", Flag: "classify", Usage: "replaces values by the class of the first exact, prefix or regex rule of FILE they match, or its default"},
+	{Name: "hash", Flag: "redact", Usage: "HMAC-SHA256 of the column with the redaction key, equal values stay equal", Options: []string{"redact-key"}},
+	{Name: "mask", Flag: "redact", Usage: "replaces e-mail local parts, and all but the last 4 characters of other values, by *"},
+	{Name: "truncate:N", Flag: "redact", Usage: "keeps the first N characters of the column"},
+	{Name: "ip[:N[/M]]", Flag: "redact", Usage: "zeroes all but the first N bits of IPv4 (default 24) or M bits of IPv6 (default 48) addresses"},
+	{Name: "cidr:FILE", Flag: "redact", Usage: "replaces IP addresses by the name of the network of FILE they are in"},
+	{Name: "drop", Flag: "redact", Usage: "empties the column"},
+}
+
+// registryCapabilities describes the entries of a registry for records of one type
+func registryCapabilities[V any](r *Registry[V], records string) []Capability {
+	var caps []Capability
+	for _, name := range r.Names() {
+		caps = append(caps, Capability{Name: name, Records: records, Usage: r.Usage(name), Options: r.Options(name)})
+	}
+	return caps
+}
+
+func parserCapabilities() []Capability {
+	return append(registryCapabilities(parsers, "string"), registryCapabilities(byteParsers, "bytes")...)
+}
+
+func reportCapabilities() []Capability {
+	return append(registryCapabilities(reports, "string"), registryCapabilities(byteReports, "bytes")...)
+}
+
+// helpCommand describes the commands, parsers, reports, transforms and sinks, or the flags of one command
+func helpCommand(args []string) int {
+	w := os.Stdout
+	if len(args) > 0 {
+		cmd := findCommand(args[0])
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+			return 2
+		}
+		commandUsage(w, cmd)
+		return 0
+	}
+
+	usage(w)
+	fmt.Fprintf(w, "\nParsers (-parser), by record type (-records):\n")
+	writeCapabilities(w, parserCapabilities())
+	fmt.Fprintf(w, "\nReports (-reports), by record type (-records):\n")
+	writeCapabilities(w, reportCapabilities())
+	fmt.Fprintf(w, "The options of parsers and reports are set by the flags of the same name, e.g. -comma or -keys.\n")
+	fmt.Fprintf(w, "\nTransforms, by the flag that applies them:\n")
+	writeCapabilities(w, transforms)
+	fmt.Fprintf(w, "\nSinks:\n")
+	writeCapabilities(w, sinks)
+	return 0
+}
+
+func findCommand(name string) *Command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func writeCapabilities(w io.Writer, caps []Capability) {
+	width := 12
+	for _, c := range caps {
+		width = max(width, len(c.Name))
+	}
+	for _, c := range caps {
+		switch {
+		case c.Records != "":
+			fmt.Fprintf(w, "  %-*s %-10s %s\n", width, c.Name, c.Records, c.Usage)
+		case c.Flag != "":
+			fmt.Fprintf(w, "  %-*s %-10s %s\n", width, c.Name, "-"+c.Flag, c.Usage)
+		default:
+			fmt.Fprintf(w, "  %-*s %s\n", width, c.Name, c.Usage)
+		}
+	}
+}
+
+func listFlags(fs *flag.FlagSet) (asJSON *bool) {
+	return fs.Bool("json", false, "print a JSON array of objects with the name, record type or flag, usage and options")
+}
+
+// listCommand returns a command listing capabilities, for people or, with -json, for scripts
+func listCommand(name string, list func() []Capability) func(args []string) int {
+	return func(args []string) int {
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		asJSON := listFlags(fs)
+		if err := parseFlags(fs, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if !*asJSON {
+			writeCapabilities(os.Stdout, list())
+			return 0
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(list()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+}
+
+// commandUsage prints the usage and the flags of a command
+func commandUsage(w io.Writer, cmd *Command) {
+	fmt.Fprintf(w, "Usage: %s %s [flags]\n\n%s\n", filepath.Base(os.Args[0]), cmd.Name, cmd.Usage)
+	if cmd.Flags == nil {
+		return
+	}
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	cmd.Flags(fs)
+	fs.SetOutput(w)
+	fmt.Fprintf(w, "\nFlags, also set by %s<FLAG> environment variables:\n", EnvPrefix)
+	fs.PrintDefaults()
+}
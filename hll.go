@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// HLL is a HyperLogLog sketch estimating the number of distinct values added to it in 2^p bytes, with a
+// standard error of about 1.04/sqrt(2^p): 1.6% for p = 12. Sketches of the same precision merge by taking
+// the larger register, so they count the distinct values of all inputs together. Values are hashed with
+// FNV-1a and a finalizer, so the estimates are the same in every run.
+type HLL struct {
+	p    uint8
+	regs []uint8
+}
+
+func NewHLL(p int) (*HLL, error) {
+	if p < 4 || p > 18 {
+		return nil, fmt.Errorf("hll precision %d: want 4 to 18", p)
+	}
+	return &HLL{uint8(p), make([]uint8, 1<<p)}, nil
+}
+
+func hashValue(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	// fmix64 of MurmurHash3, as FNV alone leaves the high bits of similar values alike
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func (h *HLL) Add(s string) {
+	x := hashValue(s)
+	i := x >> (64 - h.p)
+	rank := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	if rank > h.regs[i] {
+		h.regs[i] = rank
+	}
+}
+
+func (h *HLL) Merge(o *HLL) {
+	for i, r := range o.regs {
+		if r > h.regs[i] {
+			h.regs[i] = r
+		}
+	}
+}
+
+// Estimate returns the estimated number of distinct values, by linear counting while registers are empty
+func (h *HLL) Estimate() uint64 {
+	m := float64(len(h.regs))
+	sum, zeros := 0.0, 0
+	for _, r := range h.regs {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	switch m {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(e))
+}
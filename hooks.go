@@ -0,0 +1,52 @@
+package main
+
+// Hooks are called by a running pipeline, e.g. for custom metrics or auditing. OnFileStart, OnFileEnd
+// and OnRecordError are called on the worker goroutines, so they must be safe for concurrent use, and
+// they slow the workers down by the time they take. Unset hooks are skipped.
+type Hooks struct {
+	OnRunStart    func(ninputs int) // -1 if the number of inputs is not known
+	OnFileStart   func(worker int, file string)
+	OnFileEnd     func(stats FileStats) // not called for files interrupted by a cancel
+	OnRecordError func(file string, err error)
+	OnRunEnd      func(stats *WorkerStats, err error)
+}
+
+func (p *Pipeline[T]) runStart(ninputs int) {
+	for _, h := range p.hooks {
+		if h.OnRunStart != nil {
+			h.OnRunStart(ninputs)
+		}
+	}
+}
+
+func (p *Pipeline[T]) fileStart(worker int, file string) {
+	for _, h := range p.hooks {
+		if h.OnFileStart != nil {
+			h.OnFileStart(worker, file)
+		}
+	}
+}
+
+func (p *Pipeline[T]) fileEnd(stats FileStats) {
+	for _, h := range p.hooks {
+		if h.OnFileEnd != nil {
+			h.OnFileEnd(stats)
+		}
+	}
+}
+
+func (p *Pipeline[T]) recordError(file string, err error) {
+	for _, h := range p.hooks {
+		if h.OnRecordError != nil {
+			h.OnRecordError(file, err)
+		}
+	}
+}
+
+func (p *Pipeline[T]) runEnd(err error) {
+	for _, h := range p.hooks {
+		if h.OnRunEnd != nil {
+			h.OnRunEnd(&p.stats, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+)
+
+// inferTypes are the schema types tried for a column, the first one all its values have wins
+var inferTypes = []string{"int", "uint", "float", "bool", "ip", "time"}
+
+// SchemaInference guesses the schema of records from a sample of them: the type every value of a column
+// has, with string when none fits, and whether the column is ever empty. Its schema can be written as a
+// -schema file and edited from there.
+type SchemaInference struct {
+	header  []string
+	times   *TimeParser // of the time type, in -time-layout
+	columns []*columnGuess
+	widths  map[int]int // records by number of columns
+	records int
+}
+
+type columnGuess struct {
+	values  int    // not empty
+	fits    []bool // by inferTypes
+	example string
+}
+
+func NewSchemaInference(times *TimeParser) *SchemaInference {
+	return &SchemaInference{times: times, widths: make(map[int]int)}
+}
+
+// SetHeader names the columns after the header of the first input that has one
+func (si *SchemaInference) SetHeader(header []string) {
+	if si.header == nil && header != nil {
+		si.header = CopyRecord(header)
+	}
+}
+
+func (si *SchemaInference) Add(r LogRecord) {
+	si.records += 1
+	si.widths[len(r)] += 1
+	for len(si.columns) < len(r) {
+		fits := make([]bool, len(inferTypes))
+		for i := range fits {
+			fits[i] = true
+		}
+		si.columns = append(si.columns, &columnGuess{fits: fits})
+	}
+	for i, v := range r {
+		if v == "" {
+			continue
+		}
+		c := si.columns[i]
+		if c.values == 0 {
+			c.example = v
+		}
+		c.values += 1
+		for t, name := range inferTypes {
+			if !c.fits[t] {
+				continue
+			}
+			if name == "time" {
+				_, err := si.times.Parse(v)
+				c.fits[t] = err == nil
+			} else {
+				c.fits[t] = schemaTypes[name](v)
+			}
+		}
+	}
+}
+
+// Schema returns the schema of the sample: as many columns as most records have, and a field for each of
+// them. Columns absent from records count as empty.
+func (si *SchemaInference) Schema() *Schema {
+	sc := &Schema{}
+	for width, n := range si.widths {
+		if n > si.widths[sc.Columns] || n == si.widths[sc.Columns] && width > sc.Columns {
+			sc.Columns = width
+		}
+	}
+	for i, c := range si.columns {
+		f := SchemaField{Type: "string", Example: c.example}
+		if i < len(si.header) {
+			f.Name = strings.TrimSpace(si.header[i])
+		}
+		if c.values > 0 {
+			for t, fits := range c.fits {
+				if fits {
+					f.Type = inferTypes[t]
+					break
+				}
+			}
+		}
+		if empty := si.records - c.values; empty > 0 {
+			f.Optional = true
+			f.NullRate = math.Round(float64(empty)/float64(si.records)*1e4) / 1e4
+		}
+		sc.Fields = append(sc.Fields, f)
+	}
+	if len(sc.Fields) > sc.Columns {
+		sc.Fields = sc.Fields[:sc.Columns]
+	}
+	return sc
+}
+
+// WriteYAML writes the schema in the YAML subset LoadSchema reads. Examples that subset cannot read back
+// are left out.
+func (sc *Schema) WriteYAML(w io.Writer) {
+	fmt.Fprintf(w, "columns: %d\nfields:\n", sc.Columns)
+	for _, f := range sc.Fields {
+		if f.Name != "" {
+			fmt.Fprintf(w, "  - name: \"%s\"\n    type: %s\n", f.Name, f.Type)
+		} else {
+			fmt.Fprintf(w, "  - type: %s\n", f.Type)
+		}
+		if f.Optional {
+			fmt.Fprintf(w, "    optional: true\n    null_rate: %g\n", f.NullRate)
+		}
+		if ex := f.Example; ex != "" && !strings.ContainsAny(ex, "\"'\r\n") && !strings.Contains(ex, " #") && ex == strings.TrimSpace(ex) {
+			fmt.Fprintf(w, "    example: \"%s\"\n", ex)
+		}
+	}
+}
+
+// InferSchema reads up to n records of the files, in order, before any filter or transform
+func InferSchema(cfg *Config, files []string, n int) (*SchemaInference, error) {
+	parser, err := parsers.New(cfg.parserName("csv"), cfg.Options())
+	if err != nil {
+		return nil, ConfigError{err}
+	}
+	times, err := NewTimeParser(-1, cfg.TimeLayout, cfg.TZ)
+	if err != nil {
+		return nil, ConfigError{err}
+	}
+	p := NewPipeline[LogRecord]().
+		From(NewFileSource(Retry{cfg.Retries, cfg.RetryBackoff})).
+		Parse(parser).
+		Lines(&LineFilter{cfg.SkipLines, cfg.SkipFooter, cfg.Comment})
+
+	si := NewSchemaInference(times)
+	for _, file := range files {
+		if si.records >= n {
+			break
+		}
+		header, err := p.Scan(file, n-si.records, si.Add)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		si.SetHeader(header)
+	}
+	return si, nil
+}
+
+// inferFlags registers the flags infer has on top of the run flags
+func inferFlags(fs *flag.FlagSet) (sample *int, format *string) {
+	return fs.Int("sample", 10000, "records read from the inputs, in order, to infer the schema from"),
+		fs.String("format", "yaml", "format of the schema: yaml or json")
+}
+
+func inferCommand(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("infer", flag.ExitOnError)
+	cfg.RegisterFlags(fs)
+	sample, format := inferFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if cfg.Records != "string" {
+		fmt.Fprintln(os.Stderr, "infer: only -records string is supported")
+		return 2
+	}
+	if *format != "yaml" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "infer: -format must be yaml or json")
+		return 2
+	}
+	if *sample <= 0 {
+		fmt.Fprintln(os.Stderr, "infer: -sample must be positive")
+		return 2
+	}
+	files, err := cfg.ListFiles()
+	if err != nil {
+		slog.Error("failed to list inputs", "error", err)
+		return 2
+	}
+	si, err := InferSchema(&cfg, files, *sample)
+	if err != nil {
+		slog.Error("infer failed", "error", err)
+		if _, ok := err.(ConfigError); ok {
+			return 2
+		}
+		return 1
+	}
+	if si.records == 0 {
+		fmt.Fprintln(os.Stderr, "infer: no records to infer a schema from")
+		return 1
+	}
+	sc := si.Schema()
+	if len(si.widths) > 1 {
+		slog.Warn("the records have different numbers of columns, the schema drops the ones without the most common",
+			"columns", sc.Columns)
+	}
+	slog.Info("inferred", "records", si.records, "columns", sc.Columns)
+
+	if *format == "json" {
+		data, _ := json.MarshalIndent(sc, "", "  ")
+		fmt.Printf("%s\n", data)
+	} else {
+		sc.WriteYAML(os.Stdout)
+	}
+	return 0
+}
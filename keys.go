@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// KeySpec selects the key columns of a record: indices and ranges ("0-3,7"), open ranges to the last
+// column ("4-"), all columns ("*"), exclusions ("*,!5", or just "!5") and, with a header, column names
+// ("method,status"). Columns past the end of a record are an error instead of an empty key part.
+type KeySpec struct {
+	terms  []keyTerm
+	static []int // the columns when they depend neither on the record width nor on a header
+	max    int   // the largest static column
+}
+
+type keyTerm struct {
+	from, to int    // inclusive, to is -1 for the last column
+	name     string // a column name instead of from and to
+	exclude  bool
+}
+
+// KeyColumns selects the given columns
+func KeyColumns(columns ...int) *KeySpec {
+	ks := &KeySpec{}
+	for _, c := range columns {
+		ks.terms = append(ks.terms, keyTerm{from: c, to: c})
+	}
+	ks.resolveStatic()
+	return ks
+}
+
+// ParseKeySpec parses a -keys value
+func ParseKeySpec(s string) (*KeySpec, error) {
+	ks := &KeySpec{}
+	include := false
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var t keyTerm
+		if strings.HasPrefix(part, "!") {
+			t.exclude, part = true, part[1:]
+		}
+		if err := t.parse(part); err != nil {
+			return nil, fmt.Errorf("keys %s: %v", s, err)
+		}
+		include = include || !t.exclude
+		ks.terms = append(ks.terms, t)
+	}
+	if len(ks.terms) == 0 {
+		return nil, fmt.Errorf("no keys")
+	}
+	if !include {
+		// only exclusions, of all columns
+		ks.terms = append([]keyTerm{{from: 0, to: -1}}, ks.terms...)
+	}
+	ks.resolveStatic()
+	return ks, nil
+}
+
+func (t *keyTerm) parse(s string) error {
+	if s == "*" {
+		t.from, t.to = 0, -1
+		return nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 {
+			return fmt.Errorf("negative column %d", n)
+		}
+		t.from, t.to = n, n
+		return nil
+	}
+	if i := strings.IndexByte(s, '-'); i > 0 {
+		from, err := strconv.Atoi(s[:i])
+		if err == nil {
+			to := -1
+			if s[i+1:] != "" {
+				if to, err = strconv.Atoi(s[i+1:]); err != nil || to < from {
+					return fmt.Errorf("bad range %s", s)
+				}
+			}
+			t.from, t.to = from, to
+			return nil
+		}
+	}
+	t.name = s
+	return nil
+}
+
+func (ks *KeySpec) resolveStatic() {
+	for _, t := range ks.terms {
+		if t.to == -1 || t.name != "" {
+			return
+		}
+	}
+	ks.static, _ = ks.resolve(math.MaxInt32)
+	for _, c := range ks.static {
+		ks.max = max(ks.max, c)
+	}
+}
+
+// HasNames tells whether the spec refers to columns by name, which needs a header
+func (ks *KeySpec) HasNames() bool {
+	for _, t := range ks.terms {
+		if t.name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// WithHeader returns the spec with its column names resolved by the header of an input
+func (ks *KeySpec) WithHeader(header []string) (*KeySpec, error) {
+	if !ks.HasNames() {
+		return ks, nil
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.TrimSpace(name)] = i
+	}
+	nks := &KeySpec{terms: make([]keyTerm, len(ks.terms))}
+	for i, t := range ks.terms {
+		if t.name != "" {
+			c, ok := index[t.name]
+			if !ok {
+				return nil, fmt.Errorf("no key column %q in the header", t.name)
+			}
+			t = keyTerm{from: c, to: c, exclude: t.exclude}
+		}
+		nks.terms[i] = t
+	}
+	nks.resolveStatic()
+	return nks, nil
+}
+
+// Columns returns the key columns of a record with width fields
+func (ks *KeySpec) Columns(width int) ([]int, error) {
+	if ks.static != nil {
+		if ks.max >= width {
+			return nil, fmt.Errorf("key column %d out of range, the record has %d columns", ks.max, width)
+		}
+		return ks.static, nil
+	}
+	return ks.resolve(width)
+}
+
+func (ks *KeySpec) resolve(width int) ([]int, error) {
+	var columns []int
+	var excluded map[int]bool
+	for _, t := range ks.terms {
+		if t.name != "" {
+			return nil, fmt.Errorf("key column %q needs a header", t.name)
+		}
+		to := t.to
+		if to == -1 {
+			to = width - 1
+		}
+		if t.exclude {
+			if excluded == nil {
+				excluded = make(map[int]bool)
+			}
+			for c := t.from; c <= to; c++ {
+				excluded[c] = true
+			}
+			continue
+		}
+		if to >= width {
+			return nil, fmt.Errorf("key column %d out of range, the record has %d columns", to, width)
+		}
+		for c := t.from; c <= to; c++ {
+			columns = append(columns, c)
+		}
+	}
+
+	keys := columns[:0]
+	for _, c := range columns {
+		if !excluded[c] {
+			keys = append(keys, c)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key columns left in a record of %d columns", width)
+	}
+	return keys, nil
+}
+
+// keyCache keeps the key columns of a spec for the width of the last record, as records of one input
+// usually have the same width
+type keyCache struct {
+	spec  *KeySpec
+	width int
+	keys  []int
+	err   error
+}
+
+func newKeyCache(spec *KeySpec) keyCache { return keyCache{spec: spec, width: -1} }
+
+func (kc *keyCache) columns(width int) ([]int, error) {
+	if width != kc.width {
+		kc.keys, kc.err = kc.spec.Columns(width)
+		kc.width = width
+	}
+	return kc.keys, kc.err
+}
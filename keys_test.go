@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestKeySpecColumns(t *testing.T) {
+	for _, tc := range []struct {
+		keys  string
+		width int
+		want  string // the columns, or the error
+	}{
+		{"0-3,7", 8, "[0 1 2 3 7]"},
+		{"*,!5", 7, "[0 1 2 3 4 6]"},
+		{"!5", 7, "[0 1 2 3 4 6]"},
+		{"!1-2", 4, "[0 3]"},
+		{"4-", 6, "[4 5]"},
+		{"2,0", 3, "[2 0]"},
+		{"7", 3, "key column 7 out of range, the record has 3 columns"},
+		{"1-4", 3, "key column 4 out of range, the record has 3 columns"},
+		{"*,!0", 1, "no key columns left in a record of 1 columns"},
+		{"status", 3, `key column "status" needs a header`},
+	} {
+		ks, err := ParseKeySpec(tc.keys)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.keys, err)
+		}
+		columns, err := ks.Columns(tc.width)
+		got := fmt.Sprint(columns)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tc.want {
+			t.Errorf("%s of %d columns: %s, want %s", tc.keys, tc.width, got, tc.want)
+		}
+	}
+}
+
+func TestParseKeySpecErrors(t *testing.T) {
+	for _, keys := range []string{"", " , ", "3-1", "-1"} {
+		if _, err := ParseKeySpec(keys); err == nil {
+			t.Errorf("%q parsed", keys)
+		}
+	}
+}
+
+func TestKeySpecHeader(t *testing.T) {
+	ks, err := ParseKeySpec("status,method,!method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []string{"method", " status ", "path"}
+	hks, err := ks.WithHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns, err := hks.Columns(3); err != nil || fmt.Sprint(columns) != "[1]" {
+		t.Errorf("columns %v, %v", columns, err)
+	}
+	if _, err := ks.WithHeader([]string{"path"}); err == nil || !strings.Contains(err.Error(), `"status"`) {
+		t.Errorf("missing column: %v", err)
+	}
+}
+
+func TestQuickReportKeyRange(t *testing.T) {
+	rpt := quickReport(t, "1-2")
+	if err := rpt.Check(LogRecord{"a", "b"}); err == nil {
+		t.Error("a record without its key columns passed")
+	}
+	checkOutput[LogRecord](t, rpt, []LogRecord{{"a", "b", "c"}, {"x", "b", "c"}, {"a", "b"}}, "b,c,2\n")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Limits stop a run early, e.g. an exploratory run over cloud storage that should not read everything:
+// no input is started once MaxFiles were, or when it would take the stored bytes of the started inputs
+// past MaxBytes. The results of a run that hit a limit are partial. Zero is no limit.
+type Limits struct {
+	MaxFiles int
+	MaxBytes int64
+}
+
+func (l Limits) Active() bool { return l.MaxFiles > 0 || l.MaxBytes > 0 }
+
+// SizedSource is implemented by sources that tell the stored size of an input without opening it, which
+// MaxBytes needs
+type SizedSource interface {
+	Size(name string) (int64, error)
+}
+
+func (fs *FileSource) Size(name string) (int64, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// limitedInputs hands out inputs until a limit is hit, and then remembers why and what was left
+type limitedInputs struct {
+	Inputs
+	limits Limits
+	source SizedSource
+	files  int
+	bytes  int64
+
+	reason string   // the limit hit, "" if none was
+	left   []string // the inputs not processed, as far as they are known
+}
+
+func (li *limitedInputs) Next() (string, error) {
+	if li.reason != "" {
+		return "", nil
+	}
+	if li.limits.MaxFiles > 0 && li.files >= li.limits.MaxFiles {
+		// no input is taken from a task queue only to be left out, so whether one is left is not known
+		if si, ok := li.Inputs.(*sliceInputs); !ok || si.next < len(si.names) {
+			li.stop(fmt.Sprintf("-max-files %d", li.limits.MaxFiles))
+		}
+		return "", nil
+	}
+	name, err := li.Inputs.Next()
+	if err != nil || name == "" {
+		return name, err
+	}
+	if li.limits.MaxBytes > 0 && li.source != nil {
+		// an input that cannot be sized fails when it is opened
+		size, _ := li.source.Size(name)
+		if li.bytes+size > li.limits.MaxBytes {
+			li.stop(fmt.Sprintf("-max-input-bytes %d", li.limits.MaxBytes), name)
+			return "", nil
+		}
+		li.bytes += size
+	}
+	li.files += 1
+	return name, nil
+}
+
+// stop records the limit and the inputs left: the input taken but not started, and the rest of a listing
+func (li *limitedInputs) stop(reason string, taken ...string) {
+	li.reason = reason
+	li.left = taken
+	if si, ok := li.Inputs.(*sliceInputs); ok {
+		li.left = append(li.left, si.names[si.next:]...)
+	}
+	slog.Warn("input limit reached, the results are partial", "limit", reason, "files", li.files, "bytes_compressed", li.bytes,
+		"inputs_left", len(li.left))
+}
+
+// PartialResult lists the inputs a run left out because of its Limits, as result-partial.txt: the limit,
+// then one input per line
+type PartialResult struct {
+	Reason string
+	Left   []string
+}
+
+func (pr PartialResult) Name() string { return "partial" }
+
+func (pr PartialResult) Output(path string) {
+	fp, err := os.Create(path)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+	w := bufio.NewWriter(fp)
+	fmt.Fprintf(w, "# stopped by %s, inputs not processed:\n", pr.Reason)
+	for _, name := range pr.Left {
+		fmt.Fprintln(w, name)
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+	}
+}
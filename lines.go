@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// LineFilter drops lines of every input before it is parsed: the first Skip lines, such as a banner, the
+// last Footer lines, such as a trailer summary, and the lines starting with Comment
+type LineFilter struct {
+	Skip    int
+	Footer  int
+	Comment string
+}
+
+// Active tells whether the filter drops anything
+func (lf *LineFilter) Active() bool {
+	return lf != nil && (lf.Skip > 0 || lf.Footer > 0 || lf.Comment != "")
+}
+
+// Reader filters the lines of r
+func (lf *LineFilter) Reader(r io.Reader) io.Reader {
+	return &lineFilterReader{lf: lf, in: bufio.NewReader(r), comment: []byte(lf.Comment), ring: make([][]byte, lf.Footer+1)}
+}
+
+type lineFilterReader struct {
+	lf      *LineFilter
+	in      *bufio.Reader
+	comment []byte
+	skipped int
+	ring    [][]byte // the lines held back until Footer more lines followed them
+	held    int
+	long    []byte // a line longer than the read buffer
+	out     []byte
+	err     error
+}
+
+func (r *lineFilterReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.skipped == r.lf.Skip && r.lf.Footer == 0 && len(r.comment) == 0 {
+			return r.in.Read(p)
+		}
+		r.out, r.err = r.next()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// next returns the next line to pass on, if any. It stays valid until the following call.
+func (r *lineFilterReader) next() ([]byte, error) {
+	line, err := r.in.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		r.long = append(r.long[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = r.in.ReadSlice('\n')
+			r.long = append(r.long, line...)
+		}
+		line = r.long
+	}
+	if len(line) == 0 {
+		return nil, err
+	}
+	if r.skipped < r.lf.Skip {
+		r.skipped += 1
+		return nil, err
+	}
+	if n := len(r.ring); n > 1 {
+		r.ring[r.held%n] = append(r.ring[r.held%n][:0], line...)
+		r.held += 1
+		if r.held < n {
+			return nil, err
+		}
+		line = r.ring[r.held%n]
+	}
+	if len(r.comment) > 0 && bytes.HasPrefix(line, r.comment) {
+		return nil, err
+	}
+	return line, err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// listing walks the input directory. Subdirectories are not inputs, but a link to a directory stands in for
+// its files when links are followed; every directory is listed once however it is reached, so links that
+// point back up do not loop.
+type listing struct {
+	cfg   *Config
+	seen  map[string]bool // real paths of the directories listed
+	files []string
+
+	dotfiles, links, empty, sized, marked, loops int // entries skipped
+}
+
+func (ls *listing) dir(dir string) error {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		ls.seen[real] = true
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(fis))
+	for _, fi := range fis {
+		names[fi.Name()] = true
+	}
+	markers := ls.cfg.AfterProcess == "touch-marker"
+	for _, fi := range fis {
+		path := dir + "/" + fi.Name()
+		if markers && (strings.HasSuffix(fi.Name(), markerSuffix) || names[fi.Name()+markerSuffix]) {
+			slog.Debug("skipped processed file or its marker", "file", path)
+			ls.marked += 1
+			continue
+		}
+		if ls.cfg.SkipDotfiles && strings.HasPrefix(fi.Name(), ".") {
+			slog.Debug("skipped dotfile", "file", path)
+			ls.dotfiles += 1
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if !ls.cfg.FollowSymlinks {
+				slog.Debug("skipped link", "file", path)
+				ls.links += 1
+				continue
+			}
+			target, err := os.Stat(path)
+			if err != nil {
+				// a broken link fails like any input that cannot be opened
+				ls.files = append(ls.files, path)
+				continue
+			}
+			if target.IsDir() {
+				if real, err := filepath.EvalSymlinks(path); err != nil || ls.seen[real] {
+					slog.Debug("skipped link to a directory already listed", "file", path)
+					ls.loops += 1
+				} else if err := ls.dir(path); err != nil {
+					slog.Warn("failed to list a linked directory", "file", path, "error", err)
+				}
+				continue
+			}
+			fi = target
+		}
+		if fi.IsDir() {
+			continue
+		}
+		if ls.cfg.SkipEmpty && fi.Size() == 0 {
+			slog.Debug("skipped empty file", "file", path)
+			ls.empty += 1
+			continue
+		}
+		if fi.Size() < ls.cfg.MinSize || ls.cfg.MaxSize > 0 && fi.Size() > ls.cfg.MaxSize {
+			slog.Debug("skipped file by size", "file", path, "size", fi.Size())
+			ls.sized += 1
+			continue
+		}
+		ls.files = append(ls.files, path)
+	}
+	return nil
+}
+
+func (ls *listing) logSkipped() {
+	if ls.dotfiles+ls.links+ls.empty+ls.sized+ls.marked+ls.loops > 0 {
+		slog.Info("skipped inputs", "dotfiles", ls.dotfiles, "links", ls.links, "empty", ls.empty, "size", ls.sized,
+			"marked", ls.marked, "link_loops", ls.loops)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// LevelTrace is below debug, for the details of every input that only -vv shows
+const LevelTrace = slog.LevelDebug - 4
+
+// LogFlags select the level and format of the logs on stderr
+type LogFlags struct {
+	LogLevel    string
+	LogFormat   string
+	Quiet       bool
+	Verbose     bool
+	VeryVerbose bool
+}
+
+func (lf *LogFlags) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&lf.LogLevel, "log-level", "info", "minimum level of the logs: trace, debug, info, warn or error")
+	fs.StringVar(&lf.LogFormat, "log-format", "text", "format of the logs: text (key=value) or json (one object per line)")
+	fs.BoolVar(&lf.Quiet, "quiet", false, "log warnings and errors only, without the progress and the warnings about single inputs, which the error summary counts")
+	fs.BoolVar(&lf.Verbose, "v", false, "log at debug level, with a line for every input processed")
+	fs.BoolVar(&lf.VeryVerbose, "vv", false, "log at trace level, with the parser, compression and open and decode times of every input")
+}
+
+// SetupLogging installs the logger selected by the flags as the default, for slog and log alike
+func (lf *LogFlags) SetupLogging() error {
+	var level slog.Level
+	if lf.LogLevel == "trace" {
+		level = LevelTrace
+	} else if err := level.UnmarshalText([]byte(lf.LogLevel)); err != nil {
+		return fmt.Errorf("-log-level: %v", err)
+	}
+	switch {
+	case lf.Quiet && (lf.Verbose || lf.VeryVerbose):
+		return fmt.Errorf("-quiet and -v or -vv exclude each other")
+	case lf.Quiet:
+		level = slog.LevelWarn
+	case lf.VeryVerbose:
+		level = LevelTrace
+	case lf.Verbose:
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.LevelKey && len(groups) == 0 && a.Value.Any() == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+		return a
+	}}
+
+	var handler slog.Handler
+	switch lf.LogFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("-log-format: unknown format %s", lf.LogFormat)
+	}
+	if lf.Quiet {
+		handler = quietHandler{handler}
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// quietHandler drops the warnings about single inputs, such as retried reads or manifest mismatches, which
+// a run over many files can have thousands of. Errors, and the summaries at the end of the run, still pass.
+type quietHandler struct {
+	slog.Handler
+}
+
+func (h quietHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelError {
+		perFile := false
+		r.Attrs(func(a slog.Attr) bool {
+			perFile = a.Key == "file"
+			return !perFile
+		})
+		if perFile {
+			return nil
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h quietHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return quietHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h quietHandler) WithGroup(name string) slog.Handler {
+	return quietHandler{h.Handler.WithGroup(name)}
+}
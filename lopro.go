@@ -2,77 +2,259 @@ package main
 
 import (
 	"bufio"
-	"compress/bzip2"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
-	"flag"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
+	"math"
 	"os"
-	"runtime"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-//TODO: or you can redefine LogRecord
-type LogRecord interface{}
+// LogRecord is the record type of the built-in parsers and reports used by the CLI
+type LogRecord = []string
 
-type Report interface {
-	New() Report
-	Merge(report Report)
+// Result is the part of a report a Sink needs, independent of the record type
+type Result interface {
+	Name() string
+	Output(path string)
+}
+
+type Report[T any] interface {
+	New() Report[T]
+	Merge(report Report[T])
 	Clear()
 
 	Name() string
-	Add(rec LogRecord)
+	Add(rec T)
 	Output(path string)
 }
 
-type ReportManager struct {
-	reports    []Report
-	references []*ReportManager
+// ReportManager holds the reports of a pipeline, or of one of its workers. The master of a pipeline is lent
+// to its first worker, which adds to it without locking, unless reports are folded into the master while
+// the workers run; the others get clones. Only reports implementing SharedReport are ever added to by
+// several workers, and they are not cloned.
+type ReportManager[T any] struct {
+	sync.Mutex
+	reports    []Report[T]
+	checkers   []RecordChecker[T]
+	references []*ReportManager[T]
+	lent       int32          // to a worker, updated atomically
+	dispatch   *dispatcher[T] // of the records to the reports, nil when they are added in turn
 }
 
-func NewReportManager() *ReportManager {
-	return &ReportManager{make([]Report, 0, 1), make([]*ReportManager, 0, 1)}
+func NewReportManager[T any]() *ReportManager[T] {
+	return &ReportManager[T]{reports: make([]Report[T], 0, 1), references: make([]*ReportManager[T], 0, 1)}
 }
 
-func (rm *ReportManager) Clone() *ReportManager {
-	nrm := &ReportManager{make([]Report, len(rm.reports), len(rm.reports)), nil}
-	for i, r := range rm.reports {
-		nrm.reports[i] = r.New()
+// SharedReport is implemented by reports that are safe for concurrent Add. Clones get the same instance
+// instead of a new one, and reducing skips them.
+type SharedReport interface {
+	Shared()
+}
+
+// SizedReport is implemented by reports that can tell their number of keys, which is exported as a metric
+type SizedReport interface {
+	Len() int
+}
+
+// RecordChecker is implemented by reports that reject some records, e.g. ones without their key columns.
+// A rejected record is a bad record of its input and is not added to any report.
+type RecordChecker[T any] interface {
+	Check(rec T) error
+}
+
+// HeaderReport is implemented by reports that refer to columns by name. SetHeader is called with the
+// header of every input of a parser that reads one.
+type HeaderReport interface {
+	SetHeader(columns []string) error
+}
+
+// HeaderParser is implemented by parsers that can read a header line at the start of every input. It
+// returns nil when the parser is not configured to read one.
+type HeaderParser interface {
+	ReadHeader() ([]string, error)
+}
+
+// WorkspaceReport is implemented by reports that write to the scratch workspace of the run, e.g. to spill
+// what does not fit in memory. SetWorkspace is called on the master reports before they are cloned.
+type WorkspaceReport interface {
+	SetWorkspace(ws *Workspace)
+}
+
+// Loader is implemented by reports that can read back their own output, so the results of separate runs
+// can be combined with Merge
+type Loader interface {
+	Load(path string) error
+}
+
+// canLoad tells whether the report is a Loader, looking through the reports wrapping another one, which
+// are Loaders whether the other one is or not
+func canLoad(rpt interface{}) bool {
+	if w, ok := rpt.(interface{ loads() bool }); ok {
+		return w.loads()
+	}
+	_, ok := rpt.(Loader)
+	return ok
+}
+
+func (rm *ReportManager[T]) Clone() *ReportManager[T] {
+	nrm := &ReportManager[T]{reports: make([]Report[T], 0, len(rm.reports))}
+	for _, r := range rm.reports {
+		if _, ok := r.(SharedReport); ok {
+			nrm.RegisterReport(r)
+		} else {
+			nrm.RegisterReport(r.New())
+		}
 	}
 	rm.references = append(rm.references, nrm)
 	return nrm
 }
 
-func (rm *ReportManager) Reduce() {
+// lend hands rm to a worker until giveBack is called once the workers are done. Folding into rm or reducing
+// it in between would race with the worker and panics instead.
+func (rm *ReportManager[T]) lend()     { atomic.StoreInt32(&rm.lent, 1) }
+func (rm *ReportManager[T]) giveBack() { atomic.StoreInt32(&rm.lent, 0) }
+
+func (rm *ReportManager[T]) mustOwn(op string) {
+	if atomic.LoadInt32(&rm.lent) != 0 {
+		panic(op + " into reports a worker is adding to")
+	}
+}
+
+// Reduce merges all clones into rm. With many clones it first merges groups of about sqrt(n) clones into
+// their first member in parallel, then merges the group leaders into rm with one goroutine per report.
+func (rm *ReportManager[T]) Reduce() {
+	rm.mustOwn("reduce")
+	refs := rm.references
+	if group := int(math.Sqrt(float64(len(refs)))); group > 1 {
+		var wg sync.WaitGroup
+		leaders := make([]*ReportManager[T], 0, len(refs)/group+1)
+		for i := 0; i < len(refs); i += group {
+			leaders = append(leaders, refs[i])
+			wg.Add(1)
+			go func(leader *ReportManager[T], members []*ReportManager[T]) {
+				defer wg.Done()
+				leader.merge(members)
+			}(refs[i], refs[i+1:min(i+group, len(refs))])
+		}
+		wg.Wait()
+		refs = leaders
+	}
+	rm.merge(refs)
+	// the next run clones again, e.g. every poll of -follow
+	rm.references = rm.references[:0]
+}
+
+// Fold merges a clone into rm while workers are still running and clears the clone
+func (rm *ReportManager[T]) Fold(nrm *ReportManager[T]) {
+	rm.mustOwn("fold")
+	rm.Lock()
+	defer rm.Unlock()
+
 	for i, r := range rm.reports {
-		for _, nrm := range rm.references {
+		if r != nrm.reports[i] {
 			r.Merge(nrm.reports[i])
 			nrm.reports[i].Clear()
 		}
 	}
 }
 
-func (rm *ReportManager) Output(dir string) {
-	for _, r := range rm.reports {
-		path := dir + "/result-" + r.Name() + ".txt"
-		r.Output(path)
+func (rm *ReportManager[T]) merge(nrms []*ReportManager[T]) {
+	var wg sync.WaitGroup
+	for i, r := range rm.reports {
+		wg.Add(1)
+		go func(i int, r Report[T]) {
+			defer wg.Done()
+			for _, nrm := range nrms {
+				if r != nrm.reports[i] {
+					r.Merge(nrm.reports[i])
+					nrm.reports[i].Clear()
+				}
+			}
+		}(i, r)
 	}
+	wg.Wait()
 }
 
-func (rm *ReportManager) RegisterReport(rpt Report) { rm.reports = append(rm.reports, rpt) }
+func (rm *ReportManager[T]) RegisterReport(rpt Report[T]) {
+	rm.reports = append(rm.reports, rpt)
+	if c, ok := rpt.(RecordChecker[T]); ok {
+		rm.checkers = append(rm.checkers, c)
+	}
+}
 
-func (rm *ReportManager) ProcessRecord(rec LogRecord) {
+// SetHeader hands the header of an input to the reports that use column names
+func (rm *ReportManager[T]) SetHeader(columns []string) error {
+	for _, report := range rm.reports {
+		if hr, ok := report.(HeaderReport); ok {
+			if err := hr.SetHeader(columns); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ProcessRecord adds the record to every report, unless a report rejects it
+func (rm *ReportManager[T]) ProcessRecord(rec T) error {
+	for _, c := range rm.checkers {
+		if err := c.Check(rec); err != nil {
+			return err
+		}
+	}
+	if rm.dispatch != nil {
+		rm.dispatch.add(rec)
+		return nil
+	}
 	for _, report := range rm.reports {
 		report.Add(rec)
 	}
+	return nil
 }
 
 type WorkerStats struct {
 	files, bytes, bytesCompressed, records int64
+	perFile                                []FileStats
+
+	// wall time by stage with -stage-times: reading the inputs, decompressing them, parsing the records and
+	// handing them to the reports, including the validators, filters and transforms
+	read, decompress, parse, report time.Duration
+}
+
+// FileStats is the outcome of one input. Bytes are after decompression.
+type FileStats struct {
+	File            string
+	Worker          int
+	Bytes           int64
+	BytesCompressed int64
+	Records         int64
+	ParseErrors     int64
+	Duration        time.Duration
+	Err             error
+	SHA256          string // hex checksum of the input with -audit
+}
+
+// Status is ok, failed, or partial for an input that was processed up to corrupt or truncated data
+func (s FileStats) Status() string {
+	var de *DecodeError
+	if s.Err == nil {
+		return "ok"
+	} else if errors.As(s.Err, &de) {
+		return "partial"
+	}
+	return "failed"
 }
 
 func (s *WorkerStats) Merge(ws *WorkerStats) {
@@ -80,47 +262,481 @@ func (s *WorkerStats) Merge(ws *WorkerStats) {
 	s.bytes += ws.bytes
 	s.bytesCompressed += ws.bytesCompressed
 	s.records += ws.records
+	s.perFile = append(s.perFile, ws.perFile...)
+	s.read += ws.read
+	s.decompress += ws.decompress
+	s.parse += ws.parse
+	s.report += ws.report
+}
+
+// PerFile returns the stats of every processed input
+func (s *WorkerStats) PerFile() []FileStats { return s.perFile }
+
+// FileStatsResult writes the per-file stats of a run as result-files.csv, sorted by file
+type FileStatsResult []FileStats
+
+func (fs FileStatsResult) Name() string      { return "files" }
+func (fs FileStatsResult) Extension() string { return ".csv" }
+
+// LogSkew logs the n slowest files and the load of each worker, whose imbalance shows scheduling and
+// data layout problems, e.g. a few huge files keeping one worker busy long after the others are done
+func (fs FileStatsResult) LogSkew(n int, nworkers int) {
+	if n > 0 && len(fs) > 0 {
+		slowest := make(FileStatsResult, len(fs))
+		copy(slowest, fs)
+		sort.Slice(slowest, func(i, j int) bool { return slowest[i].Duration > slowest[j].Duration })
+		if n > len(slowest) {
+			n = len(slowest)
+		}
+		for i, s := range slowest[:n] {
+			slog.Info("slow file", "rank", i+1, "file", s.File, "worker", s.Worker, "duration", s.Duration,
+				"bytes_compressed", s.BytesCompressed, "records", s.Records)
+		}
+	}
+
+	if nworkers < 2 {
+		return
+	}
+	busy := make([]time.Duration, nworkers)
+	bytes := make([]int64, nworkers)
+	for _, s := range fs {
+		busy[s.Worker] += s.Duration
+		bytes[s.Worker] += s.BytesCompressed
+	}
+	var maxBusy, sumBusy time.Duration
+	var maxBytes, sumBytes int64
+	for i := range busy {
+		slog.Info("worker load", "worker", i, "busy", busy[i], "bytes_compressed", bytes[i])
+		sumBusy += busy[i]
+		sumBytes += bytes[i]
+		if busy[i] > maxBusy {
+			maxBusy = busy[i]
+		}
+		if bytes[i] > maxBytes {
+			maxBytes = bytes[i]
+		}
+	}
+
+	// skew is the busiest worker over the average, 1 when the load is even
+	skew := func(max, sum float64) float64 {
+		if sum == 0 {
+			return 1
+		}
+		return math.Round(max/(sum/float64(nworkers))*100) / 100
+	}
+	slog.Info("worker skew", "time", skew(float64(maxBusy), float64(sumBusy)), "bytes", skew(float64(maxBytes), float64(sumBytes)))
+}
+
+func (fs FileStatsResult) Output(path string) {
+	sort.Slice(fs, func(i, j int) bool { return fs[i].File < fs[j].File })
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := csv.NewWriter(fp)
+	w.Write([]string{"file", "worker", "bytes", "bytes_compressed", "records", "parse_errors", "duration_ms", "error", "status"})
+	for _, s := range fs {
+		var errText string
+		if s.Err != nil {
+			errText = s.Err.Error()
+		}
+		w.Write([]string{s.File, strconv.Itoa(s.Worker), strconv.FormatInt(s.Bytes, 10), strconv.FormatInt(s.BytesCompressed, 10),
+			strconv.FormatInt(s.Records, 10), strconv.FormatInt(s.ParseErrors, 10), strconv.FormatInt(s.Duration.Milliseconds(), 10), errText, s.Status()})
+	}
+	w.Flush()
 }
 
 func (s *WorkerStats) ToString() string {
 	return fmt.Sprintf("files=%d, bytes=%d, bytesCompressed=%d, records=%d", s.files, s.bytes, s.bytesCompressed, s.records)
 }
 
-type Parser interface {
-	Clone() Parser
+func (s *WorkerStats) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.Int64("files", s.files), slog.Int64("bytes", s.bytes),
+		slog.Int64("bytes_compressed", s.bytesCompressed), slog.Int64("records", s.records)}
+	if s.parse > 0 || s.report > 0 {
+		attrs = append(attrs, slog.Duration("read", s.read), slog.Duration("decompress", s.decompress),
+			slog.Duration("parse", s.parse), slog.Duration("report", s.report))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+type ErrorPolicy int
+
+const (
+	ErrorSkip ErrorPolicy = iota
+	ErrorAbort
+	ErrorQuarantine
+)
+
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	switch s {
+	case "skip":
+		return ErrorSkip, nil
+	case "abort":
+		return ErrorAbort, nil
+	case "quarantine":
+		return ErrorQuarantine, nil
+	}
+	return ErrorSkip, fmt.Errorf("unknown error policy: %s", s)
+}
+
+type FileFailure struct {
+	file       string
+	err        error
+	badRecords int64
+}
+
+// Failures collects failed files from all workers and decides when the run should stop
+type Failures struct {
+	sync.Mutex
+	policy   ErrorPolicy
+	maxFiles int
+	files    []FileFailure
+	aborted  bool
+	causes   map[string]*ErrorCause
+}
+
+// ErrorCause groups the file or record errors of the same type whose messages only differ in numbers
+// and file names
+type ErrorCause struct {
+	Kind    string `json:"kind"` // file or record
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	Count   int64  `json:"count"`
+	Example string `json:"example"`
+	files   map[string]bool
+}
+
+func (c *ErrorCause) Files() int { return len(c.files) }
+
+func NewFailures(policy ErrorPolicy, maxFiles int) *Failures {
+	return &Failures{policy: policy, maxFiles: maxFiles, causes: make(map[string]*ErrorCause)}
+}
+
+var digitsRegexp = regexp.MustCompile(`[0-9]+`)
+
+// note adds an error to its cause, with f locked
+func (f *Failures) note(kind, file string, err error) {
+	pattern := digitsRegexp.ReplaceAllString(strings.ReplaceAll(err.Error(), file, "<file>"), "N")
+	typ := fmt.Sprintf("%T", err)
+	key := kind + "\x00" + typ + "\x00" + pattern
+	c, ok := f.causes[key]
+	if !ok {
+		c = &ErrorCause{Kind: kind, Type: typ, Pattern: pattern, Example: file + ": " + err.Error(), files: make(map[string]bool)}
+		f.causes[key] = c
+	}
+	c.Count += 1
+	c.files[file] = true
+}
+
+// RecordError registers a bad record, which does not fail the file by itself
+func (f *Failures) RecordError(file string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.note("record", file, err)
+}
+
+// Causes returns the error causes, most frequent first
+func (f *Failures) Causes() []*ErrorCause {
+	f.Lock()
+	defer f.Unlock()
+	causes := make([]*ErrorCause, 0, len(f.causes))
+	for _, c := range f.causes {
+		causes = append(causes, c)
+	}
+	sort.Slice(causes, func(i, j int) bool {
+		if causes[i].Count != causes[j].Count {
+			return causes[i].Count > causes[j].Count
+		}
+		return causes[i].Pattern < causes[j].Pattern
+	})
+	return causes
+}
+
+// Record registers a failed file and returns true if the run should be aborted
+func (f *Failures) Record(file string, err error, badRecords int64) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	f.files = append(f.files, FileFailure{file, err, badRecords})
+	f.note("file", file, err)
+	if f.policy == ErrorAbort || (f.maxFiles > 0 && len(f.files) > f.maxFiles) {
+		f.aborted = true
+	}
+	return f.aborted
+}
+
+func (f *Failures) Aborted() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.aborted
+}
+
+func (f *Failures) Count() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.files)
+}
+
+// Summary logs the errors of the run grouped by cause, and the failed files at debug level
+func (f *Failures) Summary() {
+	causes := f.Causes()
+	f.Lock()
+	defer f.Unlock()
+
+	for _, ff := range f.files {
+		slog.Debug("failed file", "file", ff.file, "bad_records", ff.badRecords, "error", ff.err)
+	}
+	if len(causes) == 0 {
+		return
+	}
+	slog.Warn("error summary", "failed_files", len(f.files), "causes", len(causes))
+	for _, c := range causes {
+		slog.Warn("error cause", "kind", c.Kind, "count", c.Count, "files", c.Files(), "type", c.Type, "pattern", c.Pattern, "example", c.Example)
+	}
+}
+
+// Quarantine writes the failed files to path, one per line, so they can be inspected or reprocessed
+func (f *Failures) Quarantine(path string) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.policy != ErrorQuarantine || len(f.files) == 0 {
+		return
+	}
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write quarantine list", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	for _, ff := range f.files {
+		fp.WriteString(ff.file + "\n")
+	}
+}
+
+// Retry retries transient I/O failures with exponential backoff
+type Retry struct {
+	attempts int
+	backoff  time.Duration
+}
+
+func (r Retry) Do(what string, fn func() error) error {
+	delay := r.backoff
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || i >= r.attempts {
+			return err
+		}
+		slog.Warn(what+" failed, retrying", "attempt", i, "attempts", r.attempts, "delay", delay, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// RetryFile reopens the file and seeks back to the last offset when a read fails, e.g. on a flaky NFS mount
+type RetryFile struct {
+	path   string
+	retry  Retry
+	fp     *os.File
+	offset int64
+}
+
+func OpenRetryFile(path string, retry Retry) (*RetryFile, error) {
+	f := &RetryFile{path: path, retry: retry}
+	err := retry.Do("open "+path, func() (err error) {
+		f.fp, err = os.Open(path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RetryFile) Read(p []byte) (int, error) {
+	n, err := f.fp.Read(p)
+	f.offset += int64(n)
+	if err == nil || err == io.EOF || n > 0 {
+		return n, err
+	}
+
+	slog.Warn("failed to read", "file", f.path, "offset", f.offset, "error", err)
+	err = f.retry.Do("reopen "+f.path, func() error {
+		f.fp.Close()
+		fp, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		f.fp = fp
+		if _, err = fp.Seek(f.offset, io.SeekStart); err != nil {
+			return err
+		}
+		n, err = fp.Read(p)
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	})
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *RetryFile) Close() error { return f.fp.Close() }
+
+// Parser turns a stream into records. Parsers may reuse the record's buffers, so a record returned by
+// NextRecord is only valid until the next call; reports that keep records must copy them (see CopyRecord).
+type Parser[T any] interface {
+	Clone() Parser[T]
 	Reset(r io.Reader)
-	NextRecord() (int, interface{}, error)
+	NextRecord() (int, T, error)
+}
+
+// readerPools recycle the read buffers between files, a pool per buffer size
+var readerPools sync.Map // int to *sync.Pool
+
+func getReader(size int) *bufio.Reader {
+	pool, ok := readerPools.Load(size)
+	if !ok {
+		pool, _ = readerPools.LoadOrStore(size, &sync.Pool{New: func() interface{} { return bufio.NewReaderSize(nil, size) }})
+	}
+	return pool.(*sync.Pool).Get().(*bufio.Reader)
+}
+
+func putReader(r *bufio.Reader) {
+	r.Reset(nil)
+	if pool, ok := readerPools.Load(r.Size()); ok {
+		pool.(*sync.Pool).Put(r)
+	}
 }
 
-type Worker struct {
+type Worker[T any] struct {
 	tasks chan string
 	exit  chan bool
 
 	id        int
 	stats     WorkerStats
-	reportMgr *ReportManager
-	parser    Parser
+	pipeline  *Pipeline[T]
+	reportMgr *ReportManager[T]
+	parser    Parser[T]
+	lastFold  time.Time
+
+	file        atomic.Value // input in progress, "" when idle
+	fileRecords int64        // records of the input in progress, updated atomically
+	reportKeys  []int64      // keys per report of sized reports, updated atomically
+	span        *Span        // of the input in progress
+	fileSize    int64        // compressed size of the input in progress
+	fileBytes   int64        // decompressed bytes of the input in progress, updated atomically
+	top         atomic.Value // [][]KeyCount per report for the dashboard
+	lastTop     time.Time
+	hash        hash.Hash // checksum of the input in progress with -audit
+	next        string    // input after the one in progress in its task, prefetched once that is open
+	pending     *string   // next input, taken ahead by prefetchNext
+	ahead       *prefetch // of the pending input with -prefetch
+	prefetched  *prefetch // of the input in progress until it is opened
+
+	// with -stage-times, nanoseconds in the reads of the inputs and of the decoders, which include the
+	// former, updated atomically as -async-decode reads in another goroutine; and in the parser and reports
+	readTime, decodeTime  int64
+	parseTime, reportTime time.Duration
 }
 
-func NewWorker(tasks chan string, exit chan bool, id int, reportMgr *ReportManager, parser Parser) *Worker {
-	return &Worker{tasks: tasks, exit: exit, id: id, reportMgr: reportMgr, parser: parser}
+func NewWorker[T any](tasks chan string, exit chan bool, id int, pipeline *Pipeline[T], reportMgr *ReportManager[T], parser Parser[T]) *Worker[T] {
+	return &Worker[T]{tasks: tasks, exit: exit, id: id, pipeline: pipeline, reportMgr: reportMgr, parser: parser,
+		reportKeys: make([]int64, len(reportMgr.reports))}
 }
 
-func (w *Worker) Run() {
+func (w *Worker[T]) Run() {
+	if w.pipeline.dispatch {
+		w.reportMgr.Dispatch()
+	}
 	for {
-		file := <-w.tasks
-		if file == "" {
+		start := time.Now()
+		task := w.nextTask()
+		atomic.AddInt64(&w.pipeline.queue.workerWait, int64(time.Since(start)))
+		if task == "" {
+			w.reportMgr.Stop()
+			w.stageTimes()
 			w.exit <- true
 			break
 		}
 
-		err := w.Process(file)
-		if err != nil {
-			log.Printf("failed to process %s: %v\n", file, err)
+		if as := w.pipeline.autoscaler; as != nil {
+			as.Acquire()
+		}
+		files := strings.Split(task, taskSep)
+		for i, file := range files {
+			next := ""
+			if i+1 < len(files) {
+				next = files[i+1]
+			}
+			w.processFile(file, next)
+		}
+		w.settle()
+		if as := w.pipeline.autoscaler; as != nil {
+			as.Release()
 		}
 	}
 }
 
+// processFile processes an input of a task and records its outcome. next is the input that follows it in
+// the task, "" for the last one.
+func (w *Worker[T]) processFile(file, next string) {
+	w.prefetched, w.ahead = w.ahead, nil
+	defer w.dropPrefetched()
+	failures := w.pipeline.failures
+	if failures.Aborted() || w.pipeline.control.Canceled() {
+		return
+	}
+
+	w.next = next
+	w.span = w.pipeline.span.Child("file")
+	w.span.Set("file", file)
+	w.span.Set("worker", w.id)
+	w.pipeline.fileStart(w.id, file)
+	records := w.stats.records
+	start := time.Now()
+	badRecords, err := w.Process(file)
+	w.span.Set("records", w.stats.records-records)
+	w.span.End(err)
+	if err == ErrCanceled {
+		return
+	}
+	stats := FileStats{file, w.id, atomic.LoadInt64(&w.fileBytes), w.fileSize, w.stats.records - records, badRecords, time.Since(start), err, ""}
+	if err == nil && w.pipeline.manifest != nil {
+		err = w.pipeline.manifest.Verify(stats)
+		stats.Err = err
+	}
+	if err == nil && w.hash != nil {
+		stats.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	}
+	w.stats.perFile = append(w.stats.perFile, stats)
+	atomic.AddInt64(&w.pipeline.control.attempted, 1)
+	slog.Debug("processed", "worker", w.id, "file", file, "records", stats.Records, "bad_records", badRecords,
+		"bytes", stats.Bytes, "duration", stats.Duration)
+	if err != nil {
+		slog.Debug("failed to process", "worker", w.id, "file", file, "error", err)
+		failures.Record(file, err, badRecords)
+	}
+	w.pipeline.fileEnd(stats)
+}
+
+// settle waits for the reports to add the records of a task, folds them if it is time and publishes their
+// keys, once per task rather than per input
+func (w *Worker[T]) settle() {
+	w.reportMgr.Sync()
+	w.maybeFold()
+	w.publishKeys()
+	w.publishTop()
+}
+
 type DefaultReport struct {
 	result map[string]int64
 }
@@ -131,204 +747,465 @@ func (r *DefaultReport) Merge(nr *DefaultReport) {
 	}
 }
 
-func (r *DefaultReport) Clear() { r.result = make(map[string]int64) }
+func (r *DefaultReport) Clear()                 { r.result = make(map[string]int64) }
+func (r *DefaultReport) Len() int               { return len(r.result) }
+func (r *DefaultReport) Load(path string) error { return readCounts(path, r.result) }
+func (r *DefaultReport) Top(n int) []KeyCount {
+	return topCounts(n, func(fn func(string, int64)) {
+		for k, v := range r.result {
+			fn(k, v)
+		}
+	})
+}
 func (r *DefaultReport) Output(path string) {
-	fp, _ := os.OpenFile(path, os.O_RDWR|os.O_CREATE, os.ModePerm)
+	WriteCounts(path, func(fn func(string, int64)) {
+		for k, v := range r.result {
+			fn(k, v)
+		}
+	})
+}
+
+// WriteCounts writes key,count lines sorted by key, so equal results always give identical files
+func WriteCounts(path string, each func(fn func(key string, count int64))) {
+	type entry struct {
+		key   string
+		count int64
+	}
+	entries := make([]entry, 0, 1024)
+	each(func(k string, v int64) { entries = append(entries, entry{k, v}) })
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
 	defer fp.Close()
 
-	for k, v := range r.result {
-		fp.WriteString(fmt.Sprintf("%s,%d\n", k, v))
+	w := bufio.NewWriter(fp)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s,%d\n", e.key, e.count)
 	}
+	w.Flush()
 }
 
-func (w *Worker) Process(file string) error {
-	log.Printf("[%d]processing %s...\n", w.id, file)
+// Process parses the file and feeds the records to the reports. It returns the number of bad records and
+// an error if the file should be counted as failed.
+func (w *Worker[T]) Process(file string) (int64, error) {
+	slog.Debug("processing", "worker", w.id, "file", file)
+	w.file.Store(file)
+	atomic.StoreInt64(&w.fileRecords, 0)
+	atomic.StoreInt64(&w.fileBytes, 0)
+	w.fileSize = 0
+	defer w.file.Store("")
 
-	fi, err := os.Stat(file)
+	// a cached input is read from its cache file instead of being opened, decoded and parsed
+	parser, size, cw, err := w.openCache(file)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if cp, ok := parser.(cacheParser[T]); ok {
+		defer cp.Close()
+		slog.Log(context.Background(), LevelTrace, "reading the cache", "worker", w.id, "file", file, "bytes", size)
+		w.prefetchNext()
+		w.fileSize = size
+		atomic.AddInt64(&w.pipeline.control.bytesRead, size)
+		return w.processRecords(file, parser, size, nil, nil)
+	}
+	if cw != nil {
+		defer cw.Abort()
 	}
 
-	fp, err := os.Open(file)
+	opened := time.Now()
+	span := w.span.Child("open")
+	fp, size, err := w.open(file)
+	span.End(err)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	w.span.Set("bytes_compressed", size)
+	w.fileSize = size
 	defer fp.Close()
+	if w.pipeline.audit {
+		if w.hash == nil {
+			w.hash = sha256.New()
+		}
+		w.hash.Reset()
+		if m, ok := fp.(*MappedReader); ok {
+			w.hash.Write(m.data)
+		} else {
+			fp = &hashingReader{fp, w.hash}
+		}
+	}
+	if _, ok := fp.(*MappedReader); ok {
+		// mapped files are not read through a reader, so count them as read up front
+		atomic.AddInt64(&w.pipeline.control.bytesRead, size)
+	} else {
+		if as := w.pipeline.autoscaler; as != nil {
+			fp = as.Count(fp)
+		}
+		fp = &countingReader{fp, &w.pipeline.control.bytesRead}
+		if w.pipeline.stageTimes {
+			fp = &timedReader{fp, &w.readTime}
+		}
+	}
+
+	decoded := time.Now()
+	span = w.span.Child("decode")
+	zfp, err := w.pipeline.decoder.Decode(file, fp)
+	span.End(err)
+	if err != nil {
+		return 0, err
+	}
+	defer zfp.Close()
+	if slog.Default().Enabled(context.Background(), LevelTrace) {
+		_, mapped := zfp.(*MappedReader)
+		slog.Log(context.Background(), LevelTrace, "opened", "worker", w.id, "file", file, "bytes_compressed", size,
+			"compression", compression(file), "mmap", mapped, "parser", fmt.Sprintf("%T", w.parser),
+			"open", decoded.Sub(opened), "decode", time.Since(decoded))
+	}
+
+	if m, ok := zfp.(*MappedReader); ok && !w.pipeline.lines.Active() {
+		atomic.StoreInt64(&w.fileBytes, size)
+		w.parser.Reset(m)
+	} else {
+		dec := zfp
+		if w.pipeline.stageTimes {
+			dec = &timedReader{zfp, &w.decodeTime}
+		}
+		var src io.Reader = &countingReader{dec, &w.fileBytes}
+		if w.pipeline.asyncDecode {
+			ring := NewRingReader(src, 4, 1024*1024)
+			defer ring.Close()
+			src = ring
+		}
+		if w.pipeline.lines.Active() {
+			src = w.pipeline.lines.Reader(src)
+		}
+
+		fin := getReader(w.pipeline.readBufferSize(size, isCompressed(file)))
+		fin.Reset(src)
+		defer putReader(fin)
+		w.parser.Reset(fin)
+	}
+	return w.processRecords(file, parser, size, fp, cw)
+}
+
+// openCache returns the parser of the cache file of a cached input and the size of the input, or the
+// worker's parser and, unless the cache is off, the writer of the cache file
+func (w *Worker[T]) openCache(file string) (Parser[T], int64, *CacheWriter, error) {
+	cc := w.pipeline.cache
+	if cc == nil || w.pipeline.audit {
+		return w.parser, 0, nil, nil
+	}
+	cached, size, err := cc.Open(file)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if cached != nil {
+		return cacheParser[T]{cached}, size, nil, nil
+	}
+	cw, err := cc.Create(file)
+	if err != nil {
+		slog.Warn("failed to create the cache file", "file", file, "error", err)
+	}
+	return w.parser, 0, cw, nil
+}
 
-	var zfp io.Reader
-	if strings.HasSuffix(file, ".gz") {
-		gzfp, err := gzip.NewReader(fp)
+// processRecords feeds the records of an input to the reports, and to the cache writer of a cache miss
+func (w *Worker[T]) processRecords(file string, parser Parser[T], size int64, fp io.Reader, cw *CacheWriter) (int64, error) {
+	if hp, ok := parser.(HeaderParser); ok {
+		header, err := hp.ReadHeader()
+		if err == nil && header != nil {
+			// the records of the previous inputs of the task are added before the header changes
+			w.reportMgr.Sync()
+			if err = w.pipeline.validateHeader(header); err == nil {
+				err = w.reportMgr.SetHeader(header)
+			}
+			if cw != nil {
+				cw.SetHeader(header)
+			}
+		}
 		if err != nil {
-			return err
+			return 0, err
 		}
-		zfp = gzfp
-		defer gzfp.Close()
-	} else if strings.HasSuffix(file, ".bz2") {
-		zfp = bzip2.NewReader(fp)
-	} else {
-		zfp = fp
 	}
 
-	fin := bufio.NewReaderSize(zfp, 8*1024*1024)
-	w.parser.Reset(fin)
+	// decompression, parsing and reporting are interleaved, so they share one span
+	span := w.span.Child("process")
+	defer span.End(nil)
+
+	ctl := w.pipeline.control
+	var badRecords int64
+	first, reported := w.stats.records, w.stats.records
+	defer func() { ctl.addRecords(w.stats.records - reported) }()
 
+	// bad counts a record that failed to parse or was rejected by a report, which does not fail the file,
+	// and tells whether to give up on the file and fail it, as -on-error abort does on the first one
+	bad := func(err error) bool {
+		slog.Debug("bad record", "worker", w.id, "file", file, "records", w.stats.records, "error", err)
+		w.pipeline.failures.RecordError(file, err)
+		w.pipeline.recordError(file, err)
+		badRecords += 1
+		atomic.AddInt64(&ctl.parseErrors, 1)
+		return w.pipeline.failures.policy == ErrorAbort
+	}
+	timed := w.pipeline.stageTimes
+	var now time.Time
+	if timed {
+		now = time.Now()
+	}
 	for {
-		bytes, rec, err := w.parser.NextRecord()
+		bytes, rec, err := parser.NextRecord()
+		if timed {
+			t := time.Now()
+			w.parseTime += t.Sub(now)
+			now = t
+		}
 		if err != nil {
-			if err != io.EOF {
-				log.Printf("failed to parse: file=%s, %v\n", file, err)
-			} else {
+			if err == io.EOF {
 				break
 			}
+			if _, ok := err.(*csv.ParseError); !ok {
+				// not recoverable: a read error from the underlying stream, or a corrupt compressed input
+				if isCorrupt(err) {
+					err = &DecodeError{atomic.LoadInt64(&w.fileBytes), w.stats.records - first, err}
+				}
+				return badRecords, err
+			}
+			if bad(err) {
+				return badRecords, err
+			}
+			continue
 		}
 
-		w.reportMgr.ProcessRecord(rec)
 		w.stats.bytes += int64(bytes)
 		w.stats.records += 1
+		if cw != nil {
+			cw.Add(rec, bytes)
+		}
+		if err := w.pipeline.validate(rec); err != nil {
+			if bad(err) {
+				return badRecords, err
+			}
+		} else if w.pipeline.Keep(rec) {
+			if err := w.reportMgr.ProcessRecord(w.pipeline.transform(rec)); err != nil && bad(err) {
+				return badRecords, err
+			}
+		}
+		if timed {
+			t := time.Now()
+			w.reportTime += t.Sub(now)
+			now = t
+		}
+		if w.stats.records&0xffff == 0 {
+			w.reportMgr.Sync()
+			w.maybeFold()
+			ctl.addRecords(w.stats.records - reported)
+			atomic.AddInt64(&w.fileRecords, w.stats.records-reported)
+			reported = w.stats.records
+			w.publishKeys()
+			w.publishTop()
+			if ctl.Canceled() {
+				return badRecords, ErrCanceled
+			}
+		}
+	}
+	if cp, ok := parser.(cacheParser[T]); ok {
+		atomic.StoreInt64(&w.fileBytes, cp.bytes)
+	} else if cw != nil && badRecords == 0 {
+		if err := cw.Commit(atomic.LoadInt64(&w.fileBytes)); err != nil {
+			slog.Warn("failed to write the cache file", "file", file, "error", err)
+		}
+	}
+	if w.hash != nil {
+		// the checksum covers the whole input, even what the decoder left unread
+		if _, err := io.Copy(io.Discard, fp); err != nil {
+			return badRecords, err
+		}
 	}
 
-	w.stats.bytesCompressed += fi.Size()
+	w.stats.bytesCompressed += size
 	w.stats.files += 1
-	return nil
+	ctl.addFile(size)
+	return badRecords, nil
+}
+
+// stageTimes sets the time by stage of the worker's stats. Parsing reads through the decoder, so its time
+// includes decompression unless that happens in another goroutine, and decompression includes reading.
+func (w *Worker[T]) stageTimes() {
+	if !w.pipeline.stageTimes {
+		return
+	}
+	read, decode := time.Duration(atomic.LoadInt64(&w.readTime)), time.Duration(atomic.LoadInt64(&w.decodeTime))
+	w.stats.read, w.stats.decompress, w.stats.parse, w.stats.report = read, decode-read, w.parseTime, w.reportTime
+	if !w.pipeline.asyncDecode {
+		w.stats.parse -= decode
+	}
+}
+
+// timedReader adds the time spent in Read to d, in nanoseconds
+type timedReader struct {
+	io.ReadCloser
+	d *int64
+}
+
+func (tr *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := tr.ReadCloser.Read(p)
+	atomic.AddInt64(tr.d, int64(time.Since(start)))
+	return n, err
+}
+
+// publishKeys stores the number of keys of the worker's sized reports for the metrics
+func (w *Worker[T]) publishKeys() {
+	for i, rpt := range w.reportMgr.reports {
+		if sized, ok := rpt.(SizedReport); ok {
+			atomic.StoreInt64(&w.reportKeys[i], int64(sized.Len()))
+		}
+	}
+}
+
+// maybeFold hands the worker's partial reports to the master when the reduce interval has passed
+func (w *Worker[T]) maybeFold() {
+	interval := w.pipeline.reduceEvery
+	if interval <= 0 || time.Since(w.lastFold) < interval {
+		return
+	}
+	w.pipeline.reportMgr.Fold(w.reportMgr)
+	w.lastFold = time.Now()
 }
 
 type CSVParser struct {
 	comma  byte
+	header bool
+	ragged bool
 	reader *csv.Reader
 }
 
 func NewCSVParser(comma byte) *CSVParser { return &CSVParser{comma: comma, reader: nil} }
 
+// Header makes the parser read the first line of every input as its header
+func (lp *CSVParser) Header(on bool) *CSVParser { lp.header = on; return lp }
+
+// Ragged lets records have fewer or more fields than the first one, so absent trailing fields are told
+// apart from empty ones instead of failing the record
+func (lp *CSVParser) Ragged(on bool) *CSVParser { lp.ragged = on; return lp }
+
+func (lp *CSVParser) ReadHeader() ([]string, error) {
+	if !lp.header {
+		return nil, nil
+	}
+	r, err := lp.reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return CopyRecord(r), err
+}
+
 func (lp *CSVParser) Reset(r io.Reader) {
 	lp.reader = csv.NewReader(r)
 	lp.reader.Comma = rune(lp.comma)
 	lp.reader.TrimLeadingSpace = true
+	lp.reader.ReuseRecord = true
+	if lp.ragged {
+		lp.reader.FieldsPerRecord = -1
+	}
 }
 
-func (lp *CSVParser) Clone() Parser { return NewCSVParser(lp.comma) }
-func (lp *CSVParser) NextRecord() (int, interface{}, error) {
+func (lp *CSVParser) Clone() Parser[LogRecord] {
+	return NewCSVParser(lp.comma).Header(lp.header).Ragged(lp.ragged)
+}
+func (lp *CSVParser) NextRecord() (int, LogRecord, error) {
 	r, err := lp.reader.Read()
 	return 0, r, err
 }
 
+// CopyRecord returns a copy of a record that stays valid after the parser's next call
+func CopyRecord(r LogRecord) LogRecord {
+	c := make(LogRecord, len(r))
+	copy(c, r)
+	return c
+}
+
 type QuickReport struct {
 	DefaultReport
-	keys []int
+	spec   *KeySpec
+	keys   keyCache // of spec with the names of the current header resolved
+	norm   *Normalizer
+	empty  *EmptyKeys
+	rollup *Rollup
 }
 
-func NewQuickReport(keys []int) *QuickReport {
-	return &QuickReport{DefaultReport{make(map[string]int64)}, keys}
+func NewQuickReport(spec *KeySpec) *QuickReport {
+	return &QuickReport{DefaultReport{make(map[string]int64)}, spec, newKeyCache(spec), nil, nil, nil}
 }
 
-func (qr *QuickReport) New() Report      { return NewQuickReport(qr.keys) }
-func (qr *QuickReport) Name() string     { return "quick" }
-func (qr *QuickReport) Merge(rpt Report) { qr.DefaultReport.Merge(&rpt.(*QuickReport).DefaultReport) }
+// Normalize rewrites the key columns with n before counting
+func (qr *QuickReport) Normalize(n *Normalizer) *QuickReport { qr.norm = n; return qr }
 
-func (qr *QuickReport) Add(rec LogRecord) {
-	r, ok := rec.([]string)
-	if !ok {
-		return
-	}
+// EmptyKeys handles empty key columns with ek
+func (qr *QuickReport) EmptyKeys(ek *EmptyKeys) *QuickReport { qr.empty = ek; return qr }
 
-	//TODO: implement report logic
-	var key string
-	for i, k := range qr.keys {
-		if i > 0 {
-			key += ","
-		}
-		if k >= len(r) {
-			continue
-		} else {
-			key += r[k]
-		}
-	}
+// Rollup counts the records under the ancestors of their key with ru too
+func (qr *QuickReport) Rollup(ru *Rollup) *QuickReport { qr.rollup = ru; return qr }
 
-	qr.result[key] += 1
+func (qr *QuickReport) New() Report[LogRecord] {
+	return NewQuickReport(qr.spec).Normalize(qr.norm).EmptyKeys(qr.empty).Rollup(qr.rollup)
 }
 
-func main() {
-	var in *string = flag.String("in", ".", "input directory")
-	var out *string = flag.String("out", ".", "output directory")
-	var nprocs *int = flag.Int("procs", 1, "number of processes")
-	var comma *string = flag.String("comma", ",", "separator")
-	var keys *string = flag.String("keys", "0", "keys")
-	flag.Parse()
-
-	fi, err := os.Stat(*in)
-	if err != nil {
-		return
-	}
+func (qr *QuickReport) Name() string         { return "quick" }
+func (qr *QuickReport) LogValue() slog.Value { return keysLogValue(qr.Len(), qr.empty) }
+func (qr *QuickReport) Merge(rpt Report[LogRecord]) {
+	qr.DefaultReport.Merge(&rpt.(*QuickReport).DefaultReport)
+}
 
-	files := make([]string, 0, 4096)
-	if fi.IsDir() {
-		fis, _ := ioutil.ReadDir(*in)
-		for _, fi := range fis {
-			if !fi.IsDir() {
-				files = append(files, *in+"/"+fi.Name())
-			}
-		}
-	} else {
-		files = append(files, *in)
+func (qr *QuickReport) SetHeader(columns []string) error {
+	spec, err := qr.spec.WithHeader(columns)
+	if err != nil {
+		return err
 	}
+	qr.keys = newKeyCache(spec)
+	return nil
+}
 
-	log.Printf("%d files to process\n", len(files))
+func (qr *QuickReport) Check(r LogRecord) error {
+	_, err := qr.keys.columns(len(r))
+	return err
+}
 
-	ks := make([]int, 0, 1)
-	for _, s := range strings.Split(*keys, ",") {
-		i, err := strconv.Atoi(s)
-		if err != nil {
-			continue
+// Add counts the record by its key. Records without the key columns are skipped, and rejected by Check.
+func (qr *QuickReport) Add(r LogRecord) {
+	if keys, err := qr.keys.columns(len(r)); err == nil {
+		if key, ok := joinKey(keys, r, qr.norm, qr.empty); ok {
+			qr.result[key] += 1
+			qr.rollup.Ancestors(key, len(keys), func(k string) { qr.result[k] += 1 })
 		}
-		ks = append(ks, i)
-	}
-	if len(ks) == 0 {
-		return
-	}
-
-	parser := NewCSVParser((*comma)[0])
-	reportMgr := NewReportManager()
-	//TODO: register reports
-	reportMgr.RegisterReport(NewQuickReport(ks))
-
-	nworkers := *nprocs
-	runtime.GOMAXPROCS(nworkers)
-
-	workers := make([]*Worker, nworkers)
-	tasks := make(chan string, nworkers)
-	exit := make(chan bool, nworkers)
-
-	workers[0] = NewWorker(tasks, exit, 0, reportMgr, parser)
-	for i := 1; i < nworkers; i++ {
-		workers[i] = NewWorker(tasks, exit, i, reportMgr.Clone(), parser.Clone())
-	}
-
-	for _, w := range workers {
-		go w.Run()
-	}
-
-	nfiles := len(files)
-	for i, file := range files {
-		log.Printf("%d/%d (%d%%): +%s\n", i, nfiles, int(i*100.0/nfiles), file)
-		tasks <- file
-	}
-
-	// wait for all workers to exit
-	for _, _ = range workers {
-		tasks <- ""
-		<-exit
 	}
+}
 
-	master := workers[0]
-	for _, w := range workers {
-		log.Printf("Worker[%d]: %s\n", w.id, w.stats.ToString())
-		if w == master {
-			continue
+// joinKey builds the comma separated key from the normalized key columns of a record. It returns false
+// if the record is dropped for an empty key column.
+func joinKey(keys []int, r LogRecord, norm *Normalizer, empty *EmptyKeys) (string, bool) {
+	var key string
+	hasEmpty := false
+	for i, k := range keys {
+		if i > 0 {
+			key += ","
+		}
+		v := norm.Normalize(r[k])
+		if v == "" && empty != nil {
+			hasEmpty = true
+			if empty.drop {
+				break
+			}
+			v = empty.value
 		}
-		master.stats.Merge(&w.stats)
+		key += v
 	}
+	return key, !hasEmpty || empty.record()
+}
 
-	reportMgr.Reduce()
-	log.Printf("Total: %s\n", master.stats.ToString())
-
-	reportMgr.Output(*out)
+func main() {
+	os.Exit(Main(os.Args[1:]))
 }
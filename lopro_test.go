@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCSVParser(t *testing.T) {
+	lp := NewCSVParser(',').Header(true)
+	lp.Reset(strings.NewReader("host,status\na, 200\n\"b,c\",404\n"))
+	header, err := lp.ReadHeader()
+	if err != nil || strings.Join(header, "|") != "host|status" {
+		t.Fatalf("header %q, %v", header, err)
+	}
+	for _, want := range []string{"a|200", "b,c|404"} {
+		_, rec, err := lp.NextRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(rec, "|"); got != want {
+			t.Errorf("record %q, want %q", got, want)
+		}
+	}
+	if _, _, err := lp.NextRecord(); err != io.EOF {
+		t.Errorf("after the last record: %v, want EOF", err)
+	}
+}
+
+func TestCSVParserRagged(t *testing.T) {
+	input := "a,1\nb\nc,3,x\n"
+	lp := NewCSVParser(',')
+	lp.Reset(strings.NewReader(input))
+	lp.NextRecord()
+	if _, _, err := lp.NextRecord(); err == nil {
+		t.Error("short record accepted without Ragged")
+	}
+
+	lp = lp.Clone().(*CSVParser).Ragged(true)
+	lp.Reset(strings.NewReader(input))
+	for _, want := range []int{2, 1, 3} {
+		_, rec, err := lp.NextRecord()
+		if err != nil || len(rec) != want {
+			t.Errorf("%d fields, %v, want %d", len(rec), err, want)
+		}
+	}
+}
+
+func quickReport(t *testing.T, keys string) *QuickReport {
+	spec, err := ParseKeySpec(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewQuickReport(spec)
+}
+
+func TestQuickReport(t *testing.T) {
+	recs := []LogRecord{{"a", "1"}, {"b", "2"}, {"a", "3"}, {"c"}}
+	checkOutput(t, quickReport(t, "0"), recs, "a,2\nb,1\nc,1\n")
+	checkMerge(t, quickReport(t, "0"), recs[:1], recs[1:])
+	checkClear(t, quickReport(t, "0"), recs)
+}
+
+// runRecords runs a pipeline of a quick report by the first column over the inputs of ms, each parsed
+// into the steps
+func runRecords(t *testing.T, ms memSource, steps ...step[LogRecord]) (*Pipeline[LogRecord], error) {
+	p := NewPipeline[LogRecord]().
+		From(ms).
+		Parse(&scriptedParser[LogRecord]{steps: steps}).
+		Report(quickReport(t, "0")).
+		To(NewDirSink(t.TempDir())).
+		Procs(2)
+	return p, p.Run(ms.names())
+}
+
+func TestPipelineBadRecords(t *testing.T) {
+	ms := memSource{"x.log": "", "y.log": ""}
+	bad := &csv.ParseError{Line: 2, Err: csv.ErrFieldCount}
+	p, err := runRecords(t, ms, step[LogRecord]{record: LogRecord{"a"}}, step[LogRecord]{err: bad},
+		step[LogRecord]{record: LogRecord{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Failures().Count(); n != 0 {
+		t.Errorf("%d files failed for bad records", n)
+	}
+	out := reportOutput(t, p.Reports()[0])
+	if out != "a,2\nb,2\n" {
+		t.Errorf("output %q", out)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	rec := step[LogRecord]{record: LogRecord{"a"}}
+	for _, tc := range []struct {
+		name   string
+		inputs []string
+		want   int
+	}{
+		{"ok", []string{"x.log", "y.log"}, ExitOK},
+		{"partial", []string{"x.log", "missing.log"}, ExitPartial},
+		{"failed", []string{"missing.log", "gone.log"}, ExitFailed},
+	} {
+		ms := memSource{"x.log": "", "y.log": ""}
+		ctl := NewControl()
+		p := NewPipeline[LogRecord]().From(ms).Parse(records(rec.record)).
+			Report(quickReport(t, "0")).To(NewDirSink(t.TempDir())).Control(ctl)
+		err := p.Run(tc.inputs)
+		if got := exitCode(ctl, p.Failures(), err); got != tc.want {
+			t.Errorf("%s: exit code %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	if got := exitCode(NewControl(), NewFailures(ErrorSkip, 0), ErrCanceled); got != ExitInterrupted {
+		t.Errorf("canceled: exit code %d", got)
+	}
+	if got := exitCode(NewControl(), NewFailures(ErrorSkip, 0), ConfigError{os.ErrInvalid}); got != ExitConfig {
+		t.Errorf("config error: exit code %d", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is one sample in the Prometheus text format
+type metric struct {
+	name   string
+	kind   string // counter or gauge
+	help   string
+	labels string
+	value  float64
+}
+
+type metricsSource interface {
+	metrics(id string) []metric
+}
+
+// running pipelines, exported on /metrics while they run
+var live = struct {
+	sync.Mutex
+	next    int
+	sources map[int]metricsSource
+}{sources: make(map[int]metricsSource)}
+
+func registerMetrics(src metricsSource) int {
+	live.Lock()
+	defer live.Unlock()
+	live.next += 1
+	live.sources[live.next] = src
+	return live.next
+}
+
+func unregisterMetrics(id int) {
+	live.Lock()
+	defer live.Unlock()
+	delete(live.sources, id)
+}
+
+func (p *Pipeline[T]) metrics(id string) []metric {
+	prog := p.control.Progress()
+	labels := `pipeline="` + id + `"`
+	ms := []metric{
+		{"golopro_files_total", "counter", "Input files processed.", labels, float64(prog.Files)},
+		{"golopro_files_failed_total", "counter", "Input files that failed.", labels, float64(p.failures.Count())},
+		{"golopro_bytes_read_total", "counter", "Input bytes read, before decompression.", labels, float64(prog.BytesRead)},
+		{"golopro_records_total", "counter", "Records parsed.", labels, float64(prog.Records)},
+		{"golopro_parse_errors_total", "counter", "Records that failed to parse.", labels, float64(prog.ParseErrors)},
+	}
+	if prog.TotalFiles >= 0 {
+		ms = append(ms, metric{"golopro_input_files", "gauge", "Input files of the run.", labels, float64(prog.TotalFiles)})
+	}
+
+	depth := 0
+	for i, q := range p.tasks {
+		if i == 0 || q != p.tasks[0] {
+			depth += len(q)
+		}
+	}
+	ms = append(ms,
+		metric{"golopro_queue_depth", "gauge", "Inputs waiting for a worker.", labels, float64(depth)},
+		metric{"golopro_workers_busy", "gauge", "Workers processing an input.", labels, float64(busyWorkers(p.workers))})
+
+	for _, w := range p.workers {
+		for i, rpt := range w.reportMgr.reports {
+			if _, ok := rpt.(SizedReport); ok {
+				rl := fmt.Sprintf(`%s,report="%s",worker="%d"`, labels, rpt.Name(), w.id)
+				ms = append(ms, metric{"golopro_report_keys", "gauge", "Keys in the report of a worker.", rl, float64(atomic.LoadInt64(&w.reportKeys[i]))})
+			}
+		}
+	}
+	return ms
+}
+
+// MetricsHandler serves the metrics of the running pipelines and the Go heap in the Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	ms := []metric{
+		{"go_memstats_heap_alloc_bytes", "gauge", "Heap bytes allocated and in use.", "", float64(mem.HeapAlloc)},
+		{"go_memstats_heap_sys_bytes", "gauge", "Heap bytes obtained from the system.", "", float64(mem.HeapSys)},
+		{"go_goroutines", "gauge", "Number of goroutines.", "", float64(runtime.NumGoroutine())},
+	}
+
+	live.Lock()
+	ids := make([]int, 0, len(live.sources))
+	for id := range live.sources {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		ms = append(ms, live.sources[id].metrics(strconv.Itoa(id))...)
+	}
+	live.Unlock()
+
+	// samples of one metric must be grouped under its HELP and TYPE lines
+	sort.SliceStable(ms, func(i, j int) bool { return ms[i].name < ms[j].name })
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for i, m := range ms {
+		if i == 0 || ms[i-1].name != m.name {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		}
+		if m.labels != "" {
+			fmt.Fprintf(w, "%s{%s} %g\n", m.name, m.labels, m.value)
+		} else {
+			fmt.Fprintf(w, "%s %g\n", m.name, m.value)
+		}
+	}
+}
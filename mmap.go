@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// MappedReader reads a memory-mapped file. Parsers that know about it can slice the mapping directly
+// instead of copying through a read buffer.
+type MappedReader struct {
+	*bytes.Reader
+	data []byte
+}
+
+// MapFile maps the whole file read-only
+func MapFile(path string) (*MappedReader, int64, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fp.Close()
+
+	fi, err := fp.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if fi.Size() == 0 {
+		return &MappedReader{bytes.NewReader(nil), nil}, 0, nil
+	}
+
+	data, err := mmap(fp, int(fi.Size()))
+	if err != nil {
+		return nil, 0, err
+	}
+	return &MappedReader{bytes.NewReader(data), data}, fi.Size(), nil
+}
+
+// Remaining returns the unread part of the mapping and marks it as read
+func (m *MappedReader) Remaining() []byte {
+	rest := m.data[len(m.data)-m.Len():]
+	m.Seek(0, io.SeekEnd)
+	return rest
+}
+
+// Close unmaps the file, it is safe to call more than once
+func (m *MappedReader) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	m.Reader = bytes.NewReader(nil)
+	return munmap(data)
+}
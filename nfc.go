@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NFC returns s in Unicode normalization form C, so canonically equivalent strings, such as é as one
+// character or as e and a combining accent, become equal
+func NFC(s string) string { return formNFC.String(s) }
+
+// caseFold maps every character to a single case with Unicode simple case folding, so keys that only
+// differ in case, including non-ASCII letters such as the Kelvin sign, merge
+func caseFold(s string) string { return strings.Map(simpleFold, s) }
+
+// simpleFold is the C+S mapping of CaseFolding.txt: the lower case of the upper case, except that Cherokee
+// folds to upper case, which it had first, and the Turkic dotted and dotless i do not fold at all
+func simpleFold(r rune) rune {
+	switch {
+	case r < 0x80:
+		return unicode.ToLower(r)
+	case r == 'İ' || r == 'ı':
+		return r
+	case unicode.Is(unicode.Cherokee, r):
+		return unicode.ToUpper(r)
+	}
+	return unicode.ToLower(unicode.ToUpper(r))
+}
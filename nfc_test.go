@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestNFC(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"plain ascii", "plain ascii"},
+		{"cafe\u0301", "caf\u00e9"},
+		{"caf\u00e9", "caf\u00e9"},
+		{"\u1100\u1161\u11a8", "\uac01"},       // Hangul jamo
+		{"a\u0323\u0302", "\u1ead"},            // marks in canonical order
+		{"a\u0302\u0323", "\u1ead"},            // marks reordered
+		{"\u212b", "\u00c5"},                   // Angstrom sign, a singleton
+		{"\u0344", "\u0308\u0301"},             // excluded from composition
+		{"\U0001d15e", "\U0001d157\U0001d165"}, // excluded from composition
+		{"\u0915\u093c", "\u0915\u093c"},       // Devanagari qa, excluded
+		{"e\u0301\u0301", "\u00e9\u0301"},      // blocked second accent
+		{"e\u0301\u0327", "\u0229\u0301"},      // the cedilla comes first
+	} {
+		if got := NFC(tc.in); got != tc.want {
+			t.Errorf("NFC(%+q) = %+q, want %+q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestCaseFold(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"GET /Foo", "get /foo"},
+		{"\u212a", "k"},                              // Kelvin sign
+		{"\u03a3\u03c3\u03c2", "\u03c3\u03c3\u03c3"}, // final sigma
+		{"\u017f", "s"},                              // long s
+		{"\u1e9e", "\u00df"},                         // capital sharp s, simple folding keeps ß
+		{"\u0130\u0131", "\u0130\u0131"},
+		{"\u01c5", "\u01c6"},                         // title case
+		{"\u13a0\uab70\u13f8", "\u13a0\u13a0\u13f0"}, // Cherokee folds to upper case
+		{"\u1f88", "\u1f80"},
+	} {
+		if got := caseFold(tc.in); got != tc.want {
+			t.Errorf("caseFold(%+q) = %+q, want %+q", tc.in, got, tc.want)
+		}
+	}
+}
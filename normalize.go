@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Normalizer rewrites key columns before they are counted, so variants of a key roll up together. A nil
+// Normalizer leaves them as they are.
+type Normalizer struct {
+	steps []func(string) string
+}
+
+var normalizeSteps = map[string]func(string) string{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	"nfc":   NFC,
+	"fold":  caseFold,
+	"strip-query": func(s string) string {
+		if i := strings.IndexAny(s, "?#"); i >= 0 {
+			return s[:i]
+		}
+		return s
+	},
+}
+
+// NewNormalizer applies the comma separated steps in order, then the rewrite, a regular expression and
+// its replacement separated by =>, e.g. "/users/[0-9]+=>/users/:id". It returns nil when there is
+// nothing to do.
+func NewNormalizer(steps, rewrite string) (*Normalizer, error) {
+	n := &Normalizer{}
+	for _, name := range strings.Split(steps, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		step, ok := normalizeSteps[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown normalize step: %s", name)
+		}
+		n.steps = append(n.steps, step)
+	}
+	if rewrite != "" {
+		pattern, repl, ok := strings.Cut(rewrite, "=>")
+		if !ok {
+			return nil, fmt.Errorf("rewrite %s: want regexp=>replacement", rewrite)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite: %v", err)
+		}
+		n.steps = append(n.steps, func(s string) string { return re.ReplaceAllString(s, repl) })
+	}
+	if len(n.steps) == 0 {
+		return nil, nil
+	}
+	return n, nil
+}
+
+func (n *Normalizer) Normalize(s string) string {
+	if n == nil {
+		return s
+	}
+	for _, step := range n.steps {
+		s = step(s)
+	}
+	return s
+}
+
+// EmptyKeys is the policy for key columns that are empty after normalization: drop the record, count
+// it under the (empty) bucket or under a default value. A nil EmptyKeys keeps them empty. The counts are
+// shared by the clones of a report.
+type EmptyKeys struct {
+	drop    bool
+	value   string
+	dropped int64 // records, updated atomically
+	filled  int64
+}
+
+// NewEmptyKeys parses a policy: keep, drop, bucket or default=VALUE
+func NewEmptyKeys(policy string) (*EmptyKeys, error) {
+	switch {
+	case policy == "" || policy == "keep":
+		return nil, nil
+	case policy == "drop":
+		return &EmptyKeys{drop: true}, nil
+	case policy == "bucket":
+		return &EmptyKeys{value: "(empty)"}, nil
+	case strings.HasPrefix(policy, "default="):
+		return &EmptyKeys{value: strings.TrimPrefix(policy, "default=")}, nil
+	}
+	return nil, fmt.Errorf("unknown empty key policy: %s", policy)
+}
+
+// record counts a record with empty key columns and tells whether to keep it
+func (ek *EmptyKeys) record() bool {
+	if ek.drop {
+		atomic.AddInt64(&ek.dropped, 1)
+		return false
+	}
+	atomic.AddInt64(&ek.filled, 1)
+	return true
+}
+
+// keysLogValue describes the keys of a report and its records with empty key columns at the end of a run
+func keysLogValue(keys int, empty *EmptyKeys) slog.Value {
+	if empty == nil {
+		return slog.GroupValue(slog.Int("keys", keys))
+	}
+	return slog.GroupValue(slog.Int("keys", keys), slog.Any("empty", empty))
+}
+
+func (ek *EmptyKeys) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("dropped", atomic.LoadInt64(&ek.dropped)), slog.Int64("filled", atomic.LoadInt64(&ek.filled)))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// RunSummary is posted to the -notify URL when a run ends
+type RunSummary struct {
+	Status      string        `json:"status"` // ok, partial (some files failed or a limit stopped the run), failed or canceled
+	Error       string        `json:"error,omitempty"`
+	Host        string        `json:"host"`
+	In          string        `json:"in"`
+	Out         string        `json:"out"`
+	Started     time.Time     `json:"started"`
+	Seconds     float64       `json:"seconds"`
+	Progress    Progress      `json:"progress"`
+	FailedFiles int           `json:"failed_files"`
+	Errors      []*ErrorCause `json:"errors,omitempty"`
+	Build       BuildInfo     `json:"build"`
+}
+
+// NewRunSummary describes the outcome of a run of cfg
+func NewRunSummary(cfg *Config, started time.Time, ctl *Control, failures *Failures, err error) RunSummary {
+	host, _ := os.Hostname()
+	s := RunSummary{Status: "ok", Host: host, In: cfg.In, Out: cfg.Out, Started: started,
+		Seconds: time.Since(started).Seconds(), Progress: ctl.Progress(), Build: GetBuildInfo()}
+	if failures != nil {
+		s.FailedFiles = failures.Count()
+		s.Errors = failures.Causes()
+		if s.FailedFiles > 0 && int64(s.FailedFiles) >= ctl.Attempted() {
+			s.Status = "failed"
+		} else if s.FailedFiles > 0 || s.Progress.Partial {
+			s.Status = "partial"
+		}
+	}
+	switch {
+	case err == ErrCanceled:
+		s.Status = "canceled"
+	case err != nil:
+		s.Status, s.Error = "failed", err.Error()
+	}
+	return s
+}
+
+// Text is a one-line description for chat notifications
+func (s RunSummary) Text() string {
+	text := fmt.Sprintf("golopro %s run %s on %s: in=%s, %d files, %d records, %d failed files, %.0fs",
+		s.Build.Version, s.Status, s.Host, s.In, s.Progress.Files, s.Progress.Records, s.FailedFiles, s.Seconds)
+	if s.Error != "" {
+		text += ": " + s.Error
+	}
+	return text
+}
+
+// Notify POSTs the summary as JSON to target, or as a Slack message to Slack incoming webhooks
+func Notify(target string, s RunSummary) error {
+	var body interface{} = s
+	if u, err := url.Parse(target); err == nil && u.Host == "hooks.slack.com" {
+		body = map[string]string{"text": s.Text()}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(target, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notify %s: %s", target, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// NumberLocale reads numbers written with other decimal and grouping separators than 1234.5, e.g. 1.234,5
+// in German logs. A grouping separator must separate groups of three digits before the decimal separator.
+type NumberLocale struct {
+	decimal rune
+	group   string // the grouping separators, any of them
+}
+
+// numberLocales are the -number-locale names, besides the custom decimal and grouping separators
+var numberLocales = map[string]NumberLocale{
+	"c":  {'.', ""},
+	"en": {'.', ","},
+	"de": {',', "."},
+	"fr": {',', " \u00a0\u202f"},
+	"ch": {'.', "'"},
+}
+
+// ParseNumberLocale parses a locale name or the decimal separator optionally followed by the grouping
+// separator, e.g. ",." for 1.234,5. It returns nil for plain numbers.
+func ParseNumberLocale(s string) (*NumberLocale, error) {
+	nl, ok := numberLocales[s]
+	if s == "" {
+		nl, ok = numberLocales["c"]
+	}
+	if !ok {
+		decimal, n := utf8.DecodeRuneInString(s)
+		group := s[n:]
+		if n := utf8.RuneCountInString(s); n < 1 || n > 2 || strings.ContainsAny(group, "0123456789-+") || strings.ContainsRune("0123456789-+", decimal) || group == string(decimal) {
+			return nil, fmt.Errorf("unknown number locale %q, want c, en, de, fr, ch or the decimal and grouping separators, e.g. \",.\"", s)
+		}
+		nl = NumberLocale{decimal, group}
+	}
+	if nl.decimal == '.' && nl.group == "" {
+		return nil, nil
+	}
+	return &nl, nil
+}
+
+// Canonical rewrites v in the locale as strconv parses it, e.g. 1.234,5 as 1234.5
+func (nl *NumberLocale) Canonical(v string) (string, error) {
+	if nl == nil {
+		return v, nil
+	}
+	b := make([]byte, 0, len(v))
+	digits := -1 // digits since the last grouping separator, -1 before any
+	decimal := false
+	for _, c := range v {
+		switch {
+		case c >= '0' && c <= '9':
+			if digits >= 0 {
+				digits++
+			}
+			b = append(b, byte(c))
+		case !decimal && nl.group != "" && strings.ContainsRune(nl.group, c):
+			if digits >= 0 && digits != 3 || len(b) == 0 || b[len(b)-1] < '0' || b[len(b)-1] > '9' {
+				return "", nl.invalid(v)
+			}
+			digits = 0
+		case c == nl.decimal && !decimal:
+			if digits >= 0 && digits != 3 {
+				return "", nl.invalid(v)
+			}
+			decimal, digits = true, -1
+			b = append(b, '.')
+		case c == '.' || c == ',':
+			// a separator of another locale
+			return "", nl.invalid(v)
+		default:
+			b = utf8.AppendRune(b, c)
+		}
+	}
+	if digits >= 0 && digits != 3 {
+		return "", nl.invalid(v)
+	}
+	return string(b), nil
+}
+
+func (nl *NumberLocale) invalid(v string) error {
+	return fmt.Errorf("misplaced separator in %q for decimal %q and grouping %q", v, nl.decimal, nl.group)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// pairSession holds the distinct values of both sides seen in a session
+type pairSession struct {
+	a, b map[string]bool
+}
+
+// PairsReport counts how often the values of the key columns occur together with the values of the
+// pair-with columns, e.g. which referrers lead to which landing pages, and writes the most frequent pairs.
+// Pairs are counted by record, or with session columns by session: a pair then counts once for every
+// session in which both values occur, in the same record or not. Sessions are kept with their distinct
+// values until the result is written, as their records may be read by different workers.
+type PairsReport struct {
+	counts   map[string]int64
+	sessions map[string]*pairSession
+	n        int
+	spec     *KeySpec
+	with     *KeySpec
+	session  *KeySpec // nil to count by record
+	keys     keyCache
+	withk    keyCache
+	sessionk keyCache
+	norm     *Normalizer
+	empty    *EmptyKeys
+}
+
+func NewPairsReport(spec, with, session *KeySpec, n int) (*PairsReport, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("pairs: -top %d must be at least 1", n)
+	}
+	pr := &PairsReport{counts: make(map[string]int64), sessions: make(map[string]*pairSession), n: n, spec: spec, with: with,
+		session: session, keys: newKeyCache(spec), withk: newKeyCache(with)}
+	if session != nil {
+		pr.sessionk = newKeyCache(session)
+	}
+	return pr, nil
+}
+
+// Normalize rewrites the key and pair-with columns with n before counting
+func (pr *PairsReport) Normalize(n *Normalizer) *PairsReport { pr.norm = n; return pr }
+
+// EmptyKeys handles empty key and pair-with columns with ek
+func (pr *PairsReport) EmptyKeys(ek *EmptyKeys) *PairsReport { pr.empty = ek; return pr }
+
+func (pr *PairsReport) New() Report[LogRecord] {
+	npr, _ := NewPairsReport(pr.spec, pr.with, pr.session, pr.n)
+	return npr.Normalize(pr.norm).EmptyKeys(pr.empty)
+}
+
+func (pr *PairsReport) Name() string { return "pairs" }
+
+func (pr *PairsReport) Clear() {
+	pr.counts = make(map[string]int64)
+	pr.sessions = make(map[string]*pairSession)
+}
+
+func (pr *PairsReport) Len() int {
+	if pr.session != nil {
+		return len(pr.sessions)
+	}
+	return len(pr.counts)
+}
+
+func (pr *PairsReport) LogValue() slog.Value { return keysLogValue(pr.Len(), pr.empty) }
+
+func (pr *PairsReport) Merge(rpt Report[LogRecord]) {
+	o := rpt.(*PairsReport)
+	for k, v := range o.counts {
+		pr.counts[k] += v
+	}
+	for id, other := range o.sessions {
+		s, ok := pr.sessions[id]
+		if !ok {
+			pr.sessions[id] = other
+			continue
+		}
+		for v := range other.a {
+			s.a[v] = true
+		}
+		for v := range other.b {
+			s.b[v] = true
+		}
+	}
+}
+
+func (pr *PairsReport) SetHeader(columns []string) error {
+	spec, err := pr.spec.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	with, err := pr.with.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	pr.keys, pr.withk = newKeyCache(spec), newKeyCache(with)
+	if pr.session != nil {
+		session, err := pr.session.WithHeader(columns)
+		if err != nil {
+			return err
+		}
+		pr.sessionk = newKeyCache(session)
+	}
+	return nil
+}
+
+func (pr *PairsReport) Check(r LogRecord) error {
+	if _, err := pr.keys.columns(len(r)); err != nil {
+		return err
+	}
+	if _, err := pr.withk.columns(len(r)); err != nil {
+		return fmt.Errorf("pair-with: %v", err)
+	}
+	if pr.session != nil {
+		if _, err := pr.sessionk.columns(len(r)); err != nil {
+			return fmt.Errorf("session: %v", err)
+		}
+	}
+	return nil
+}
+
+// Add counts the pair of the record, or adds its values to its session. Records rejected by Check are
+// skipped.
+func (pr *PairsReport) Add(r LogRecord) {
+	keys, err := pr.keys.columns(len(r))
+	if err != nil {
+		return
+	}
+	with, err := pr.withk.columns(len(r))
+	if err != nil {
+		return
+	}
+	a, ok := joinKey(keys, r, pr.norm, pr.empty)
+	if !ok {
+		return
+	}
+	b, ok := joinKey(with, r, pr.norm, pr.empty)
+	if !ok {
+		return
+	}
+	if pr.session == nil {
+		pr.counts[a+"\x00"+b] += 1
+		return
+	}
+	sessions, err := pr.sessionk.columns(len(r))
+	if err != nil {
+		return
+	}
+	id, _ := joinKey(sessions, r, nil, nil)
+	s, ok := pr.sessions[id]
+	if !ok {
+		s = &pairSession{make(map[string]bool), make(map[string]bool)}
+		pr.sessions[id] = s
+	}
+	s.a[a], s.b[b] = true, true
+}
+
+// pairCounts returns the counts of the pairs, adding up the sessions
+func (pr *PairsReport) pairCounts() map[string]int64 {
+	if pr.session == nil {
+		return pr.counts
+	}
+	counts := make(map[string]int64)
+	for _, s := range pr.sessions {
+		for a := range s.a {
+			for b := range s.b {
+				counts[a+"\x00"+b] += 1
+			}
+		}
+	}
+	return counts
+}
+
+// Top returns the n most frequent pairs as key,pair-with
+func (pr *PairsReport) Top(n int) []KeyCount {
+	top := heapTop(pr.pairCounts(), n)
+	for i := range top {
+		top[i].Key = strings.Replace(top[i].Key, "\x00", ",", 1)
+	}
+	return top
+}
+
+// Output writes key,pair-with,count lines of the -top pairs, the most frequent first
+func (pr *PairsReport) Output(path string) {
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for _, kc := range pr.Top(pr.n) {
+		fmt.Fprintf(w, "%s,%d\n", kc.Key, kc.Count)
+	}
+	w.Flush()
+}
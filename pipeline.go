@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Source opens an input by name and returns its stored (possibly compressed) size
+type Source interface {
+	Open(name string) (io.ReadCloser, int64, error)
+}
+
+// Decoder wraps the raw input, e.g. to decompress it
+type Decoder interface {
+	Decode(name string, r io.Reader) (io.ReadCloser, error)
+}
+
+// Filter decides whether a parsed record is handed to the reports
+type Filter[T any] interface {
+	Keep(rec T) bool
+}
+
+// Validator rejects a parsed record as a bad record of its input
+type Validator[T any] interface {
+	Validate(rec T) error
+}
+
+// HeaderValidator is implemented by validators that also check the header of an input
+type HeaderValidator interface {
+	ValidateHeader(header []string) error
+}
+
+type FilterFunc[T any] func(rec T) bool
+
+func (f FilterFunc[T]) Keep(rec T) bool { return f(rec) }
+
+// Sink stores the reduced reports
+type Sink interface {
+	Write(rpt Result) error
+}
+
+type FileSource struct {
+	retry Retry
+	mmap  bool
+	rate  *RateLimiter
+	slots chan struct{} // of the files open at the same time
+}
+
+func NewFileSource(retry Retry) *FileSource { return &FileSource{retry: retry} }
+
+// Mmap makes the source memory-map uncompressed files instead of reading them
+func (fs *FileSource) Mmap(enable bool) *FileSource { fs.mmap = enable; return fs }
+
+// Throttle limits how fast the inputs are read and how many are open at the same time, to spare shared
+// storage. Zero is no limit. Throttled inputs are read, not memory-mapped.
+func (fs *FileSource) Throttle(mbps float64, openFiles int) *FileSource {
+	fs.rate = NewRateLimiter(mbps)
+	if openFiles > 0 {
+		fs.slots = make(chan struct{}, openFiles)
+	}
+	return fs
+}
+
+func (fs *FileSource) Open(name string) (io.ReadCloser, int64, error) {
+	if fs.slots != nil {
+		fs.slots <- struct{}{}
+	}
+	r, size, err := fs.open(name)
+	if err != nil {
+		if fs.slots != nil {
+			<-fs.slots
+		}
+		return nil, 0, err
+	}
+	if fs.rate != nil {
+		r = &throttledReader{r, fs.rate}
+	}
+	if fs.slots != nil {
+		r = &openSlot{ReadCloser: r, slots: fs.slots}
+	}
+	return r, size, nil
+}
+
+func (fs *FileSource) open(name string) (io.ReadCloser, int64, error) {
+	if fs.mmap && fs.rate == nil && fs.slots == nil && !isCompressed(name) {
+		var m *MappedReader
+		var size int64
+		err := fs.retry.Do("map "+name, func() (err error) {
+			m, size, err = MapFile(name)
+			return err
+		})
+		if err == nil {
+			return m, size, nil
+		}
+		slog.Warn("failed to map, reading instead", "file", name, "error", err)
+	}
+
+	// the open file is sized, which saves looking the name up twice on inputs of a few KB
+	fp, err := OpenRetryFile(name, fs.retry)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := fp.fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, 0, err
+	}
+	return fp, fi.Size(), nil
+}
+
+const (
+	minReadBuffer = 64 << 10
+	maxReadBuffer = 8 << 20
+	readBuffers   = 256 << 20 // of all workers together, for the automatic sizes
+)
+
+// readBufferSize returns the size of the read buffer of an input of the stored size: -read-buffer, or the
+// smallest power of two holding a plain input, and the largest size for a compressed one, from 64K to 8M
+// and less with so many workers that their buffers would take more than 256M
+func (p *Pipeline[T]) readBufferSize(size int64, compressed bool) int {
+	if p.readBuffer > 0 {
+		return p.readBuffer
+	}
+	n := max(p.bufferMax, minReadBuffer)
+	if compressed {
+		return n
+	}
+	for n > minReadBuffer && int64(n/2) >= size {
+		n /= 2
+	}
+	return n
+}
+
+// compression returns the compression SuffixDecoder uses for a file, or "" for plain files
+func compression(name string) string {
+	if strings.HasSuffix(name, ".gz") {
+		return "gzip"
+	} else if strings.HasSuffix(name, ".bz2") {
+		return "bzip2"
+	}
+	return ""
+}
+
+func isCompressed(name string) bool { return compression(name) != "" }
+
+// SuffixDecoder picks the decompressor by file name suffix
+type SuffixDecoder struct{}
+
+func (SuffixDecoder) Decode(name string, r io.Reader) (io.ReadCloser, error) {
+	if strings.HasSuffix(name, ".gz") {
+		return gzip.NewReader(r)
+	} else if strings.HasSuffix(name, ".bz2") {
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	} else if m, ok := r.(*MappedReader); ok {
+		// keep the type so the worker can parse straight from the mapping
+		return m, nil
+	}
+	return ioutil.NopCloser(r), nil
+}
+
+// DecodeError is the error of an input that ended in corrupt or truncated compressed data, as opposed to a
+// clean end of file. The records before it were processed.
+type DecodeError struct {
+	Bytes   int64 // decompressed bytes read
+	Records int64
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("corrupt or truncated input after %d bytes and %d records: %v", e.Bytes, e.Records, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// isCorrupt tells whether a read error comes from corrupt or truncated compressed data
+func isCorrupt(err error) bool {
+	var flateErr flate.CorruptInputError
+	var bzip2Err bzip2.StructuralError
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) ||
+		errors.As(err, &flateErr) || errors.As(err, &bzip2Err)
+}
+
+// Extension is implemented by results that are not key,count text files, to pick their file extension
+type Extension interface {
+	Extension() string
+}
+
+// Discarder is implemented by results that write no file, e.g. the nop report, which sinks skip
+type Discarder interface {
+	Discards()
+}
+
+// extensionOf returns the file extension of a result, .txt unless it implements Extension
+func extensionOf(r interface{}) string {
+	if e, ok := r.(Extension); ok {
+		return e.Extension()
+	}
+	return ".txt"
+}
+
+// DirSink writes each report to dir/result-<name>.txt
+type DirSink struct {
+	dir     string
+	written []string
+}
+
+func NewDirSink(dir string) *DirSink { return &DirSink{dir: dir} }
+
+func (ds *DirSink) Write(rpt Result) error {
+	if _, ok := rpt.(Discarder); ok {
+		return nil
+	}
+	path := ds.dir + "/result-" + rpt.Name() + extensionOf(rpt)
+	if err := outputFile(rpt, path); err != nil {
+		return err
+	}
+	ds.written = append(ds.written, path)
+	return nil
+}
+
+// Written returns the paths of the results written so far
+func (ds *DirSink) Written() []string { return ds.written }
+
+var ErrCanceled = errors.New("canceled")
+
+// Control lets other goroutines follow the progress of a running pipeline and cancel it
+type Control struct {
+	totalFiles  int64
+	files       int64
+	attempted   int64 // files ended, failed or not
+	bytes       int64
+	bytesRead   int64 // including the files in progress
+	records     int64
+	parseErrors int64
+	canceled    int32
+	partial     int32 // stopped by the Limits
+}
+
+type Progress struct {
+	TotalFiles      int64 `json:"total_files"`
+	Files           int64 `json:"files"`
+	BytesCompressed int64 `json:"bytes_compressed"`
+	BytesRead       int64 `json:"bytes_read"`
+	Records         int64 `json:"records"`
+	ParseErrors     int64 `json:"parse_errors"`
+	Partial         bool  `json:"partial,omitempty"` // stopped by the Limits before all inputs were processed
+}
+
+func NewControl() *Control { return &Control{} }
+
+func (c *Control) Cancel()        { atomic.StoreInt32(&c.canceled, 1) }
+func (c *Control) Canceled() bool { return atomic.LoadInt32(&c.canceled) != 0 }
+
+func (c *Control) addRecords(n int64) { atomic.AddInt64(&c.records, n) }
+func (c *Control) addFile(size int64) {
+	atomic.AddInt64(&c.bytes, size)
+	atomic.AddInt64(&c.files, 1)
+}
+
+// Attempted returns the number of inputs that ended, failed or not, leaving out the ones interrupted by
+// a cancel
+func (c *Control) Attempted() int64 { return atomic.LoadInt64(&c.attempted) }
+
+func (c *Control) Progress() Progress {
+	return Progress{
+		TotalFiles:      atomic.LoadInt64(&c.totalFiles),
+		Files:           atomic.LoadInt64(&c.files),
+		BytesCompressed: atomic.LoadInt64(&c.bytes),
+		BytesRead:       atomic.LoadInt64(&c.bytesRead),
+		Records:         atomic.LoadInt64(&c.records),
+		ParseErrors:     atomic.LoadInt64(&c.parseErrors),
+		Partial:         atomic.LoadInt32(&c.partial) != 0,
+	}
+}
+
+// Pipeline wires Source -> Decoder -> LineFilter -> Parser -> Validators -> Filters -> Transformers -> Reports -> Sink and runs it over a
+// set of inputs with a pool of workers. Each worker gets its own clone of the parser and reports.
+type Pipeline[T any] struct {
+	source     Source
+	decoder    Decoder
+	parser     Parser[T]
+	validators []Validator[T]
+	filters    []Filter[T]
+	transforms []Transformer[T]
+	reportMgr  *ReportManager[T]
+	sink       Sink
+
+	nprocs        int
+	reduceEvery   time.Duration
+	asyncDecode   bool
+	autoscaler    *Autoscaler
+	deterministic bool
+	queueSize     int
+	queue         QueueStats
+	failures      *Failures
+	control       *Control
+	progressEvery time.Duration
+	slowest       int
+	tui           bool
+	audit         bool
+	dispatch      bool
+	stageTimes    bool
+	readBuffer    int // 0 to size the read buffers by input
+	prefetch      int // bytes of the next input of a worker read ahead, 0 for none
+	filesPerTask  int // inputs per task of a worker, 0 for 1
+	bufferMax     int // of the automatic read buffers, set by Run
+	results       []Result
+	manifest      *Manifest
+	lines         *LineFilter
+	limits        Limits
+	workspace     *Workspace
+	cache         *ColumnCache
+	hooks         []Hooks
+	tracer        *Tracer
+	stats         WorkerStats
+
+	// set while running, for the metrics
+	workers []*Worker[T]
+	tasks   []chan string
+	span    *Span
+}
+
+// QueueStats tell where a run waits: a dispatcher blocked on a full queue means the workers are the
+// bottleneck (I/O or CPU), workers blocked on an empty queue mean they are starved by the listing
+type QueueStats struct {
+	capacity       int
+	enqueued       int64
+	depthSum       int64
+	maxDepth       int
+	dispatcherWait time.Duration
+	workerWait     int64 // nanoseconds, summed over workers atomically
+}
+
+func (qs *QueueStats) avgDepth() float64 {
+	if qs.enqueued == 0 {
+		return 0
+	}
+	return float64(qs.depthSum) / float64(qs.enqueued)
+}
+
+func (qs *QueueStats) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("capacity", qs.capacity), slog.Float64("avg_depth", qs.avgDepth()), slog.Int("max_depth", qs.maxDepth),
+		slog.Duration("dispatcher_wait", qs.dispatcherWait), slog.Duration("worker_wait", time.Duration(atomic.LoadInt64(&qs.workerWait))))
+}
+
+func (qs *QueueStats) ToString() string {
+	return fmt.Sprintf("capacity=%d, avgDepth=%.1f, maxDepth=%d, dispatcherWait=%v, workerWait=%v",
+		qs.capacity, qs.avgDepth(), qs.maxDepth, qs.dispatcherWait, time.Duration(atomic.LoadInt64(&qs.workerWait)))
+}
+
+func NewPipeline[T any]() *Pipeline[T] {
+	return &Pipeline[T]{
+		source:    NewFileSource(Retry{1, 0}),
+		decoder:   SuffixDecoder{},
+		reportMgr: NewReportManager[T](),
+		sink:      NewDirSink("."),
+		nprocs:    1,
+		failures:  NewFailures(ErrorSkip, 0),
+		control:   NewControl(),
+	}
+}
+
+func (p *Pipeline[T]) From(source Source) *Pipeline[T]     { p.source = source; return p }
+func (p *Pipeline[T]) Decode(decoder Decoder) *Pipeline[T] { p.decoder = decoder; return p }
+func (p *Pipeline[T]) Parse(parser Parser[T]) *Pipeline[T] { p.parser = parser; return p }
+func (p *Pipeline[T]) Validate(v Validator[T]) *Pipeline[T] {
+	p.validators = append(p.validators, v)
+	return p
+}
+func (p *Pipeline[T]) Filter(filter Filter[T]) *Pipeline[T] {
+	p.filters = append(p.filters, filter)
+	return p
+}
+func (p *Pipeline[T]) Transform(t Transformer[T]) *Pipeline[T] {
+	p.transforms = append(p.transforms, t)
+	return p
+}
+func (p *Pipeline[T]) Report(rpt Report[T]) *Pipeline[T]          { p.reportMgr.RegisterReport(rpt); return p }
+func (p *Pipeline[T]) To(sink Sink) *Pipeline[T]                  { p.sink = sink; return p }
+func (p *Pipeline[T]) Procs(n int) *Pipeline[T]                   { p.nprocs = n; return p }
+func (p *Pipeline[T]) ReduceEvery(d time.Duration) *Pipeline[T]   { p.reduceEvery = d; return p }
+func (p *Pipeline[T]) AsyncDecode(on bool) *Pipeline[T]           { p.asyncDecode = on; return p }
+func (p *Pipeline[T]) QueueSize(n int) *Pipeline[T]               { p.queueSize = n; return p }
+func (p *Pipeline[T]) Deterministic(on bool) *Pipeline[T]         { p.deterministic = on; return p }
+func (p *Pipeline[T]) Control(c *Control) *Pipeline[T]            { p.control = c; return p }
+func (p *Pipeline[T]) Pool(as *Autoscaler) *Pipeline[T]           { p.autoscaler = as; return p }
+func (p *Pipeline[T]) ProgressEvery(d time.Duration) *Pipeline[T] { p.progressEvery = d; return p }
+func (p *Pipeline[T]) Trace(t *Tracer) *Pipeline[T]               { p.tracer = t; return p }
+func (p *Pipeline[T]) Slowest(n int) *Pipeline[T]                 { p.slowest = n; return p }
+func (p *Pipeline[T]) TUI(on bool) *Pipeline[T]                   { p.tui = on; return p }
+func (p *Pipeline[T]) Audit(on bool) *Pipeline[T]                 { p.audit = on; return p }
+func (p *Pipeline[T]) Dispatch(on bool) *Pipeline[T]              { p.dispatch = on; return p }
+func (p *Pipeline[T]) StageTimes(on bool) *Pipeline[T]            { p.stageTimes = on; return p }
+func (p *Pipeline[T]) ReadBuffer(n int) *Pipeline[T]              { p.readBuffer = n; return p }
+func (p *Pipeline[T]) Prefetch(n int) *Pipeline[T]                { p.prefetch = n; return p }
+func (p *Pipeline[T]) FilesPerTask(n int) *Pipeline[T]            { p.filesPerTask = n; return p }
+func (p *Pipeline[T]) Result(r Result) *Pipeline[T]               { p.results = append(p.results, r); return p }
+func (p *Pipeline[T]) Expect(m *Manifest) *Pipeline[T]            { p.manifest = m; return p }
+func (p *Pipeline[T]) Lines(lf *LineFilter) *Pipeline[T]          { p.lines = lf; return p }
+func (p *Pipeline[T]) Limit(l Limits) *Pipeline[T]                { p.limits = l; return p }
+func (p *Pipeline[T]) Workspace(ws *Workspace) *Pipeline[T]       { p.workspace = ws; return p }
+func (p *Pipeline[T]) Cache(cc *ColumnCache) *Pipeline[T]         { p.cache = cc; return p }
+func (p *Pipeline[T]) Hook(h Hooks) *Pipeline[T]                  { p.hooks = append(p.hooks, h); return p }
+func (p *Pipeline[T]) OnError(f *Failures) *Pipeline[T]           { p.failures = f; return p }
+
+func (p *Pipeline[T]) Stats() *WorkerStats  { return &p.stats }
+func (p *Pipeline[T]) Queue() *QueueStats   { return &p.queue }
+func (p *Pipeline[T]) Failures() *Failures  { return p.failures }
+func (p *Pipeline[T]) Reports() []Report[T] { return p.reportMgr.reports }
+
+// validate applies the validators to a parsed record
+func (p *Pipeline[T]) validate(rec T) error {
+	for _, v := range p.validators {
+		if err := v.Validate(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateHeader applies the header validators to the header of an input
+func (p *Pipeline[T]) validateHeader(header []string) error {
+	for _, v := range p.validators {
+		if hv, ok := v.(HeaderValidator); ok {
+			if err := hv.ValidateHeader(header); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// transform applies the transformers to a record that passed the filters
+func (p *Pipeline[T]) transform(rec T) T {
+	for _, t := range p.transforms {
+		rec = t.Transform(rec)
+	}
+	return rec
+}
+
+// Keep applies the filters to a record
+func (p *Pipeline[T]) Keep(rec T) bool {
+	for _, f := range p.filters {
+		if !f.Keep(rec) {
+			return false
+		}
+	}
+	return true
+}
+
+// Inputs yields the names of the inputs to process, "" at the end. Len is -1 if the number of inputs is
+// not known in advance, e.g. when they come from a shared queue.
+type Inputs interface {
+	Next() (string, error)
+	Len() int
+}
+
+type sliceInputs struct {
+	names []string
+	next  int
+}
+
+func (si *sliceInputs) Len() int { return len(si.names) }
+func (si *sliceInputs) Next() (string, error) {
+	if si.next >= len(si.names) {
+		return "", nil
+	}
+	si.next += 1
+	return si.names[si.next-1], nil
+}
+
+// Sample parses the first n records of an input the way a worker would and checks them against the
+// validators and the reports, without adding them to the reports. It returns the number of records read and
+// rejected, and the first rejection. A header the reports or validators refuse is an error.
+func (p *Pipeline[T]) Sample(file string, n int) (records, rejected int, first, err error) {
+	parser, header, closeFile, err := p.openParser(file)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer closeFile()
+	if header != nil {
+		if err = p.validateHeader(header); err == nil {
+			err = p.reportMgr.SetHeader(header)
+		}
+		if err != nil {
+			return 0, 0, nil, err
+		}
+	}
+
+	reject := func(err error) {
+		if rejected == 0 {
+			first = fmt.Errorf("record %d: %v", records, err)
+		}
+		rejected += 1
+	}
+	for records < n {
+		_, rec, err := parser.NextRecord()
+		if err == io.EOF {
+			break
+		} else if _, ok := err.(*csv.ParseError); err != nil && !ok {
+			return records, rejected, first, err
+		}
+		records += 1
+		if err != nil {
+			reject(err)
+		} else if err := p.validate(rec); err != nil {
+			reject(err)
+		} else if p.Keep(rec) {
+			rec = p.transform(rec)
+			for _, c := range p.reportMgr.checkers {
+				if err := c.Check(rec); err != nil {
+					reject(err)
+					break
+				}
+			}
+		}
+	}
+	return records, rejected, first, nil
+}
+
+// Scan calls fn with the first n records of an input as parsed, before any validator, filter or transform.
+// It returns the header, nil without one.
+func (p *Pipeline[T]) Scan(file string, n int, fn func(rec T)) (header []string, err error) {
+	parser, header, closeFile, err := p.openParser(file)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile()
+	for records := 0; records < n; records++ {
+		_, rec, err := parser.NextRecord()
+		if err == io.EOF {
+			break
+		} else if _, ok := err.(*csv.ParseError); ok {
+			continue
+		} else if err != nil {
+			return header, err
+		}
+		fn(rec)
+	}
+	return header, nil
+}
+
+// openParser opens an input the way a worker would and reads its header, if the parser has one
+func (p *Pipeline[T]) openParser(file string) (parser Parser[T], header []string, closeFile func(), err error) {
+	fp, _, err := p.source.Open(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	zfp, err := p.decoder.Decode(file, fp)
+	if err != nil {
+		fp.Close()
+		return nil, nil, nil, err
+	}
+	closeFile = func() { zfp.Close(); fp.Close() }
+	var src io.Reader = zfp
+	if p.lines.Active() {
+		src = p.lines.Reader(src)
+	}
+
+	parser = p.parser.Clone()
+	parser.Reset(bufio.NewReader(src))
+	if hp, ok := parser.(HeaderParser); ok {
+		if header, err = hp.ReadHeader(); err != nil {
+			closeFile()
+			return nil, nil, nil, err
+		}
+	}
+	return parser, header, closeFile, nil
+}
+
+// taskSep separates the inputs of a task, as it cannot be part of a file name
+const taskSep = "\x00"
+
+// nextTask returns the next -files-per-task inputs joined by taskSep, fewer at the end of the inputs and
+// "" after it. On an error it returns the inputs so far with the error.
+func (p *Pipeline[T]) nextTask(inputs Inputs) (string, error) {
+	var task strings.Builder
+	for n := 0; n < max(p.filesPerTask, 1); n++ {
+		input, err := inputs.Next()
+		if err != nil || input == "" {
+			return task.String(), err
+		}
+		if n > 0 {
+			task.WriteString(taskSep)
+		}
+		task.WriteString(input)
+	}
+	return task.String(), nil
+}
+
+// Run processes the inputs, reduces the reports and writes them to the sink.
+// Nothing is written if the run was aborted by the error policy.
+func (p *Pipeline[T]) Run(inputs []string) error { return p.RunInputs(&sliceInputs{names: inputs}) }
+
+// RunInputs is Run for inputs that are not listed up front. If getting the next input fails, the inputs
+// so far are still reduced and written, and the error is returned.
+func (p *Pipeline[T]) RunInputs(inputs Inputs) (err error) {
+	if p.parser == nil {
+		return fmt.Errorf("pipeline has no parser")
+	}
+	started := time.Now()
+	p.span = p.tracer.Start("run")
+	defer func() {
+		p.span.End(err)
+		p.runEnd(err)
+		p.closeWorkspace(err)
+	}()
+
+	// 0 procs sizes the pool by the CPUs and lets the autoscaler throttle it. A pool shared with other
+	// pipelines limits how many files all of them process at the same time.
+	nworkers := p.nprocs
+	if p.autoscaler != nil {
+		nworkers = p.autoscaler.max
+	} else if nworkers < 1 {
+		nworkers = runtime.NumCPU()
+		p.autoscaler = NewAutoscaler(nworkers, 5*time.Second)
+		go p.autoscaler.Run()
+		defer p.autoscaler.Stop()
+	}
+	p.bufferMax = maxReadBuffer
+	for p.bufferMax > minReadBuffer && p.bufferMax*nworkers > readBuffers {
+		p.bufferMax /= 2
+	}
+
+	workers := make([]*Worker[T], nworkers)
+	queueSize := p.queueSize
+	if queueSize < 1 {
+		queueSize = nworkers
+	}
+	p.queue.capacity = queueSize
+
+	// deterministic runs give every worker its own queue and assign tasks round-robin, so each worker
+	// sees the same files in the same order on every run
+	queues := make([]chan string, nworkers)
+	tasks := make(chan string, queueSize)
+	for i := range queues {
+		if p.deterministic {
+			queues[i] = make(chan string, queueSize)
+		} else {
+			queues[i] = tasks
+		}
+	}
+	exit := make(chan bool, nworkers)
+	for _, rpt := range p.reportMgr.reports {
+		if wr, ok := rpt.(WorkspaceReport); ok {
+			if p.workspace == nil {
+				// removed at the end of the run like one set with Workspace
+				p.workspace = NewWorkspace("", 0)
+			}
+			wr.SetWorkspace(p.workspace)
+		}
+	}
+
+	// the first worker shares the master reports unless they are folded into concurrently, see ReportManager
+	if p.reduceEvery > 0 {
+		workers[0] = NewWorker(queues[0], exit, 0, p, p.reportMgr.Clone(), p.parser)
+	} else {
+		p.reportMgr.lend()
+		workers[0] = NewWorker(queues[0], exit, 0, p, p.reportMgr, p.parser)
+	}
+	for i := 1; i < nworkers; i++ {
+		workers[i] = NewWorker(queues[i], exit, i, p, p.reportMgr.Clone(), p.parser.Clone())
+	}
+
+	for _, w := range workers {
+		go w.Run()
+	}
+	var stopProgress func()
+	if p.tui {
+		stopProgress = p.showDashboard(os.Stdout, workers)
+	} else {
+		stopProgress = p.showProgress(os.Stderr, workers)
+	}
+	p.workers, p.tasks = workers, queues
+	defer unregisterMetrics(registerMetrics(p))
+
+	var limited *limitedInputs
+	if p.limits.Active() {
+		sized, ok := p.source.(SizedSource)
+		if !ok && p.limits.MaxBytes > 0 {
+			slog.Warn("the source cannot size inputs, ignoring the byte limit")
+		}
+		limited = &limitedInputs{Inputs: inputs, limits: p.limits, source: sized}
+		inputs = limited
+	}
+	ninputs := inputs.Len()
+	if p.limits.MaxFiles > 0 && ninputs > p.limits.MaxFiles {
+		ninputs = p.limits.MaxFiles
+	}
+	atomic.StoreInt64(&p.control.totalFiles, int64(ninputs))
+	p.runStart(ninputs)
+	var inputErr error
+	for i := 0; ; i++ {
+		if p.failures.Aborted() || p.control.Canceled() {
+			break
+		}
+		task, err := p.nextTask(inputs)
+		if task != "" {
+			q := queues[i%nworkers]
+			depth := len(q)
+			p.queue.enqueued += 1
+			p.queue.depthSum += int64(depth)
+			if depth > p.queue.maxDepth {
+				p.queue.maxDepth = depth
+			}
+			start := time.Now()
+			q <- task
+			p.queue.dispatcherWait += time.Since(start)
+		}
+		if err != nil {
+			slog.Error("failed to get the next input", "error", err)
+			inputErr = err
+			break
+		}
+		if task == "" {
+			break
+		}
+	}
+
+	// wait for all workers to exit
+	for i := range workers {
+		queues[i] <- ""
+		<-exit
+	}
+	p.reportMgr.giveBack()
+	stopProgress()
+
+	for _, w := range workers {
+		slog.Info("worker finished", "worker", w.id, "stats", &w.stats)
+		p.stats.Merge(&w.stats)
+	}
+
+	if p.control.Canceled() {
+		return ErrCanceled
+	}
+	p.failures.Summary()
+	if p.failures.Aborted() {
+		return fmt.Errorf("aborted after %d failed files, no results written", p.failures.Count())
+	}
+
+	span := p.span.Child("reduce")
+	p.reportMgr.Reduce()
+	span.End(nil)
+	slog.Info("total", "stats", &p.stats)
+	slog.Info("queue", "stats", &p.queue)
+	for _, rpt := range p.reportMgr.reports {
+		if lv, ok := rpt.(slog.LogValuer); ok {
+			slog.Info("report", "name", rpt.Name(), "stats", lv)
+		}
+	}
+	for _, v := range p.validators {
+		if lv, ok := v.(slog.LogValuer); ok {
+			slog.Info("validator", "stats", lv)
+		}
+	}
+	for _, f := range p.filters {
+		if lv, ok := f.(slog.LogValuer); ok {
+			slog.Info("filter", "stats", lv)
+		}
+	}
+	if p.cache != nil {
+		slog.Info("cache", "stats", p.cache)
+	}
+	if p.manifest != nil {
+		slog.Info("manifest", "stats", p.manifest)
+		for _, file := range p.manifest.Missing() {
+			slog.Warn("input of the manifest not processed", "file", file)
+		}
+	}
+	FileStatsResult(p.stats.perFile).LogSkew(p.slowest, nworkers)
+	p.span.Set("files", p.stats.files)
+	p.span.Set("records", p.stats.records)
+
+	for _, rpt := range p.reportMgr.reports {
+		span := p.span.Child("write")
+		span.Set("report", rpt.Name())
+		err := p.sink.Write(rpt)
+		span.End(err)
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.sink.Write(FileStatsResult(p.stats.perFile)); err != nil {
+		return err
+	}
+	if p.audit {
+		if err := p.sink.Write(AuditResult{p.stats.perFile, started}); err != nil {
+			return err
+		}
+	}
+	for _, r := range p.results {
+		if err := p.sink.Write(r); err != nil {
+			return err
+		}
+	}
+	if limited != nil && limited.reason != "" {
+		atomic.StoreInt32(&p.control.partial, 1)
+		if err := p.sink.Write(PartialResult{limited.reason, limited.left}); err != nil {
+			return err
+		}
+	}
+	return inputErr
+}
+
+// closeWorkspace removes the scratch workspace after a successful run and keeps it otherwise
+func (p *Pipeline[T]) closeWorkspace(err error) {
+	if p.workspace == nil {
+		return
+	}
+	if p.workspace.dir != "" {
+		slog.Info("workspace", "stats", p.workspace)
+	}
+	if err := p.workspace.Close(err != nil || p.failures.Count() > 0); err != nil {
+		slog.Warn("failed to remove the scratch workspace", "error", err)
+	}
+}
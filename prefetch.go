@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// prefetch is the next input of a worker, opened in the background with its first bytes read while the
+// worker still parses the current one, so the first-byte latency of object storage or a cold NFS cache
+// is hidden behind the parsing
+type prefetch struct {
+	file string
+	done chan struct{}
+	r    io.ReadCloser
+	size int64
+	err  error
+}
+
+// prefetchBufs recycle the read ahead buffers, a pool per size
+var prefetchBufs sync.Map // int to *sync.Pool
+
+func startPrefetch(source Source, file string, n int) *prefetch {
+	pf := &prefetch{file: file, done: make(chan struct{})}
+	go func() {
+		defer close(pf.done)
+		pf.r, pf.size, pf.err = source.Open(file)
+		if pf.err != nil {
+			return
+		}
+		if _, ok := pf.r.(*MappedReader); ok {
+			return
+		}
+		pool, ok := prefetchBufs.Load(n)
+		if !ok {
+			pool, _ = prefetchBufs.LoadOrStore(n, &sync.Pool{New: func() interface{} { return make([]byte, n) }})
+		}
+		buf := pool.(*sync.Pool).Get().([]byte)
+		m, err := io.ReadFull(pf.r, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		pf.r = &prefetchedReader{ReadCloser: pf.r, pool: pool.(*sync.Pool), buf: buf, ahead: buf[:m], err: err}
+	}()
+	return pf
+}
+
+// open waits for the input to be opened and returns it like Source.Open
+func (pf *prefetch) open() (io.ReadCloser, int64, error) {
+	<-pf.done
+	return pf.r, pf.size, pf.err
+}
+
+// drop closes the input if it was not used
+func (pf *prefetch) drop() {
+	if r, _, err := pf.open(); err == nil {
+		r.Close()
+	}
+}
+
+// prefetchedReader returns the bytes read ahead and the error that ended them, if any, before reading on
+type prefetchedReader struct {
+	io.ReadCloser
+	pool  *sync.Pool
+	buf   []byte
+	ahead []byte
+	err   error
+}
+
+func (pr *prefetchedReader) Read(p []byte) (int, error) {
+	if len(pr.ahead) > 0 {
+		n := copy(p, pr.ahead)
+		pr.ahead = pr.ahead[n:]
+		return n, nil
+	}
+	if pr.err != nil {
+		return 0, pr.err
+	}
+	return pr.ReadCloser.Read(p)
+}
+
+func (pr *prefetchedReader) Close() error {
+	if pr.buf != nil {
+		pr.pool.Put(pr.buf)
+		pr.buf, pr.ahead = nil, nil
+	}
+	return pr.ReadCloser.Close()
+}
+
+// nextTask returns the task taken ahead by prefetchNext, if any, or waits for the next one
+func (w *Worker[T]) nextTask() string {
+	if w.pending != nil {
+		task := *w.pending
+		w.pending = nil
+		return task
+	}
+	return <-w.tasks
+}
+
+// prefetchNext starts opening w.next, the input after the current one in its task, or after the last one
+// the first input of the next task of the worker if one is queued already. Taking that task early keeps
+// it from idle workers, so the last inputs of a run may be spread less evenly. It is called once the
+// current input is open, which would wait for the prefetch otherwise when the open files are limited.
+func (w *Worker[T]) prefetchNext() {
+	next := w.next
+	w.next = ""
+	if w.pipeline.prefetch <= 0 {
+		return
+	}
+	if next == "" && w.pending == nil {
+		select {
+		case task := <-w.tasks:
+			w.pending = &task
+			next, _, _ = strings.Cut(task, taskSep)
+		default:
+		}
+	}
+	if next != "" && !w.pipeline.failures.Aborted() && !w.pipeline.control.Canceled() {
+		w.ahead = startPrefetch(w.pipeline.source, next, w.pipeline.prefetch)
+	}
+}
+
+// open opens the input, or takes it from the prefetch, and starts prefetching the next one
+func (w *Worker[T]) open(file string) (io.ReadCloser, int64, error) {
+	defer w.prefetchNext()
+	if pf := w.prefetched; pf != nil && pf.file == file {
+		w.prefetched = nil
+		w.span.Set("prefetched", true)
+		return pf.open()
+	}
+	return w.pipeline.source.Open(file)
+}
+
+// dropPrefetched closes the prefetched input in progress if it was not opened, e.g. as it was cached or
+// the run was canceled
+func (w *Worker[T]) dropPrefetched() {
+	if pf := w.prefetched; pf != nil {
+		w.prefetched = nil
+		pf.drop()
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrefetchMaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 5; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		if err := os.WriteFile(file, []byte("a,1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	for _, perTask := range []int{1, 4} {
+		p := NewPipeline[LogRecord]().
+			From(NewFileSource(Retry{}).Throttle(0, 1)).
+			Parse(NewCSVParser(',')).
+			Report(quickReport(t, "0")).
+			To(NewDirSink(t.TempDir())).
+			Procs(1).Prefetch(64 << 10).FilesPerTask(perTask)
+		done := make(chan error, 1)
+		go func() { done <- p.Run(files) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%d files per task: prefetching with one open file hangs", perTask)
+		}
+		if out := reportOutput(t, p.Reports()[0]); out != "a,5\n" {
+			t.Errorf("%d files per task: output %q", perTask, out)
+		}
+	}
+}
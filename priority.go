@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// I/O scheduling classes of ioprio_set
+const (
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+// ParseIONice parses an I/O priority: idle, or best-effort[:LEVEL] with a level from 0, the highest, to 7
+// and 4 by default
+func ParseIONice(s string) (class, level int, err error) {
+	name, lvl, hasLevel := strings.Cut(s, ":")
+	switch name {
+	case "idle":
+		if hasLevel {
+			return 0, 0, fmt.Errorf("ionice %q: idle has no level", s)
+		}
+		return ioClassIdle, 0, nil
+	case "best-effort":
+		level = 4
+		if hasLevel {
+			if level, err = strconv.Atoi(lvl); err != nil || level < 0 || level > 7 {
+				return 0, 0, fmt.Errorf("ionice %q: want a level from 0 to 7", s)
+			}
+		}
+		return ioClassBestEffort, level, nil
+	}
+	return 0, 0, fmt.Errorf("ionice %q: want idle or best-effort[:LEVEL]", s)
+}
+
+// SetMaxProcs sets GOMAXPROCS once for the process of a run: to -max-procs, or to -procs workers, so a
+// run on one CPU uses one. Pipelines leave it alone, as the jobs of serve and batch share the process.
+func (cfg *Config) SetMaxProcs() {
+	n := cfg.Procs
+	if cfg.MaxProcs > 0 && (n < 1 || n > cfg.MaxProcs) {
+		n = cfg.MaxProcs
+	}
+	if n > 0 {
+		runtime.GOMAXPROCS(n)
+	}
+}
+
+// SetPriority lowers the CPU and I/O priority of the process to -nice and -ionice, so a run can share its
+// hosts with production workloads. Raising them usually needs root.
+func (cfg *Config) SetPriority() error {
+	class, level := 0, 0
+	if cfg.IONice != "" {
+		var err error
+		if class, level, err = ParseIONice(cfg.IONice); err != nil {
+			return err
+		}
+	}
+	if cfg.Nice != 0 {
+		if cfg.Nice < -20 || cfg.Nice > 19 {
+			return fmt.Errorf("-nice %d: want -20 to 19", cfg.Nice)
+		}
+		if err := setNice(cfg.Nice); err != nil {
+			return fmt.Errorf("-nice %d: %v", cfg.Nice, err)
+		}
+	}
+	if cfg.IONice != "" {
+		if err := setIONice(class, level); err != nil {
+			return fmt.Errorf("-ionice %s: %v", cfg.IONice, err)
+		}
+	}
+	if cfg.Nice != 0 || cfg.IONice != "" {
+		slog.Info("priority", "nice", cfg.Nice, "ionice", cfg.IONice)
+	}
+	return nil
+}
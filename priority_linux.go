@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+func init() { features = append(features, "priority") }
+
+// setNice sets the nice value of every thread of the process, as Linux keeps one per thread. Threads
+// started later inherit it from the thread starting them.
+func setNice(n int) error {
+	return eachThread(func(tid int) error { return syscall.Setpriority(syscall.PRIO_PROCESS, tid, n) })
+}
+
+// setIONice sets the I/O priority of every thread of the process with ioprio_set
+func setIONice(class, level int) error {
+	const ioprioWhoProcess, ioprioClassShift = 1, 13
+	return eachThread(func(tid int) error {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(class<<ioprioClassShift|level))
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+func eachThread(fn func(tid int) error) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fn(0)
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if err := fn(tid); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func setNice(n int) error {
+	return errors.New("setting the priority is not supported on this platform")
+}
+
+func setIONice(class, level int) error {
+	return errors.New("setting the I/O priority is not supported on this platform")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// StartProfiling starts the profilers requested in cfg and returns a function that stops them and
+// writes the profiles
+func (cfg *Config) StartProfiling() func() {
+	if cfg.Pprof != "" {
+		go func() {
+			slog.Info("pprof listening", "addr", cfg.Pprof)
+			if err := http.ListenAndServe(cfg.Pprof, nil); err != nil {
+				slog.Error("pprof failed", "error", err)
+			}
+		}()
+	}
+
+	if cfg.Metrics != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/metrics", MetricsHandler)
+			if cfg.Follow != "" {
+				mux.HandleFunc("/healthz", followHealthz)
+				mux.HandleFunc("/readyz", followReadyz)
+			}
+			slog.Info("metrics listening", "addr", cfg.Metrics)
+			if err := http.ListenAndServe(cfg.Metrics, mux); err != nil {
+				slog.Error("metrics failed", "error", err)
+			}
+		}()
+	}
+
+	var stops []func()
+	if cfg.CPUProfile != "" {
+		fp, err := os.Create(cfg.CPUProfile)
+		if err != nil {
+			slog.Error("failed to create cpu profile", "error", err)
+		} else if err := pprof.StartCPUProfile(fp); err != nil {
+			slog.Error("failed to start cpu profile", "error", err)
+			fp.Close()
+		} else {
+			stops = append(stops, func() {
+				pprof.StopCPUProfile()
+				fp.Close()
+			})
+		}
+	}
+
+	if cfg.Trace != "" {
+		fp, err := os.Create(cfg.Trace)
+		if err != nil {
+			slog.Error("failed to create trace", "error", err)
+		} else if err := trace.Start(fp); err != nil {
+			slog.Error("failed to start trace", "error", err)
+			fp.Close()
+		} else {
+			stops = append(stops, func() {
+				trace.Stop()
+				fp.Close()
+			})
+		}
+	}
+
+	if cfg.MemProfile != "" {
+		stops = append(stops, func() {
+			fp, err := os.Create(cfg.MemProfile)
+			if err != nil {
+				slog.Error("failed to create heap profile", "error", err)
+				return
+			}
+			defer fp.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(fp); err != nil {
+				slog.Error("failed to write heap profile", "error", err)
+			}
+		})
+	}
+
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// isTerminal tells whether f is a terminal, so progress can be redrawn in place
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// showProgress reports the completed files, the bytes read, the current throughput, the ETA and what
+// the workers are doing until stop is called. The line is redrawn in place on a terminal and logged
+// otherwise, every progressEvery or by default every second on a terminal and every 10 seconds otherwise.
+// Lines keep coming while no file completes, so a run over huge files does not look hung. There is no
+// progress when the logs are quieter than info.
+func (p *Pipeline[T]) showProgress(f *os.File, workers []*Worker[T]) (stop func()) {
+	if !slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		return func() {}
+	}
+	tty := isTerminal(f)
+	interval := p.progressEvery
+	if interval <= 0 {
+		interval = 10 * time.Second
+		if tty {
+			interval = time.Second
+		}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		meter := progressMeter{start: time.Now(), last: time.Now()}
+		for {
+			select {
+			case <-done:
+				if tty {
+					fmt.Fprintf(f, "\r\033[K%s\n", meter.line(p.progress()))
+				}
+				return
+			case <-ticker.C:
+			}
+			if tty {
+				fmt.Fprintf(f, "\r\033[K%s, %d/%d workers busy", meter.line(p.progress()), busyWorkers(workers), len(workers))
+			} else {
+				slog.Info(meter.line(p.progress()), "workers", workerStates(workers))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// progressMeter computes the throughput since its previous line
+type progressMeter struct {
+	start     time.Time
+	last      time.Time
+	lastBytes int64
+	rate      float64 // bytes per second of the last line
+}
+
+func (pm *progressMeter) line(prog Progress, failed int) string {
+	files := prog.Files + int64(failed)
+	now := time.Now()
+	rate := float64(prog.BytesRead-pm.lastBytes) / now.Sub(pm.last).Seconds()
+	pm.last, pm.lastBytes, pm.rate = now, prog.BytesRead, rate
+
+	var s string
+	if prog.TotalFiles > 0 {
+		s = fmt.Sprintf("%d/%d files (%d%%)", files, prog.TotalFiles, files*100/prog.TotalFiles)
+	} else {
+		s = fmt.Sprintf("%d files", files)
+	}
+	s += fmt.Sprintf(", %s, %s/s, %d records", formatBytes(float64(prog.BytesRead)), formatBytes(rate), prog.Records)
+	if files > 0 && prog.TotalFiles > files {
+		eta := time.Duration(float64(now.Sub(pm.start)) / float64(files) * float64(prog.TotalFiles-files))
+		s += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return s
+}
+
+// progress returns the progress so far and the number of failed files, which count as completed
+func (p *Pipeline[T]) progress() (Progress, int) { return p.control.Progress(), p.failures.Count() }
+
+func busyWorkers[T any](workers []*Worker[T]) int {
+	n := 0
+	for _, w := range workers {
+		if file, _ := w.file.Load().(string); file != "" {
+			n += 1
+		}
+	}
+	return n
+}
+
+// workerStates lists the input and records so far of every worker
+func workerStates[T any](workers []*Worker[T]) string {
+	states := make([]string, len(workers))
+	for i, w := range workers {
+		if file, _ := w.file.Load().(string); file != "" {
+			states[i] = fmt.Sprintf("[%d] %s %d records", w.id, filepath.Base(file), atomic.LoadInt64(&w.fileRecords))
+		} else {
+			states[i] = fmt.Sprintf("[%d] idle", w.id)
+		}
+	}
+	return strings.Join(states, ", ")
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i += 1
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
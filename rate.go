@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// rateViolation is the busiest window of a key over the threshold
+type rateViolation struct {
+	key      string
+	peak     int64
+	from, to int64 // times of the first and last event of the window
+	events   int64
+}
+
+// RateReport finds the keys, e.g. client IPs, with more than threshold events in any rolling window of the
+// -time-column, for abuse forensics over archived logs. Any window means the windows are not aligned to
+// the clock: the events of every key are walked in time order with a sliding window, so they are kept in
+// an eventSpool until the result is written.
+type RateReport struct {
+	spec      *KeySpec
+	keys      keyCache
+	times     *TimeParser
+	window    time.Duration
+	threshold int64
+	norm      *Normalizer
+	empty     *EmptyKeys
+	spool     eventSpool
+}
+
+func NewRateReport(spec *KeySpec, times *TimeParser, window time.Duration, threshold int64, spillSize int64) (*RateReport, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("rate: -window %v must be positive", window)
+	}
+	if threshold < 1 {
+		return nil, fmt.Errorf("rate: -threshold %d must be at least 1", threshold)
+	}
+	return &RateReport{spec: spec, keys: newKeyCache(spec), times: times, window: window, threshold: threshold,
+		spool: eventSpool{name: "rate", spillSize: spillSize}}, nil
+}
+
+// Normalize rewrites the key columns with n before they are looked up
+func (rr *RateReport) Normalize(n *Normalizer) *RateReport { rr.norm = n; return rr }
+
+// EmptyKeys handles empty key columns with ek
+func (rr *RateReport) EmptyKeys(ek *EmptyKeys) *RateReport { rr.empty = ek; return rr }
+
+func (rr *RateReport) New() Report[LogRecord] {
+	nrr, _ := NewRateReport(rr.spec, rr.times, rr.window, rr.threshold, rr.spool.spillSize)
+	nrr.spool.ws = rr.spool.ws
+	return nrr.Normalize(rr.norm).EmptyKeys(rr.empty)
+}
+
+func (rr *RateReport) Name() string                { return "rate" }
+func (rr *RateReport) SetWorkspace(ws *Workspace)  { rr.spool.ws = ws }
+func (rr *RateReport) LogValue() slog.Value        { return rr.spool.LogValue() }
+func (rr *RateReport) Merge(rpt Report[LogRecord]) { rr.spool.merge(&rpt.(*RateReport).spool) }
+func (rr *RateReport) Clear()                      { rr.spool.clear() }
+
+func (rr *RateReport) SetHeader(columns []string) error {
+	spec, err := rr.spec.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	rr.keys = newKeyCache(spec)
+	return nil
+}
+
+func (rr *RateReport) Check(r LogRecord) error {
+	if _, err := rr.keys.columns(len(r)); err != nil {
+		return err
+	}
+	_, err := RecordTime(rr.times, r)
+	return err
+}
+
+// Add spools the record's key at its time. Records rejected by Check are skipped.
+func (rr *RateReport) Add(r LogRecord) {
+	keys, err := rr.keys.columns(len(r))
+	if err != nil {
+		return
+	}
+	t, err := RecordTime(rr.times, r)
+	if err != nil {
+		return
+	}
+	key, ok := joinKey(keys, r, rr.norm, rr.empty)
+	if !ok {
+		return
+	}
+	rr.spool.add(stateEvent{key: key, t: t.UnixNano()})
+}
+
+// violations slides the window over the events of every key and returns the keys over the threshold
+func (rr *RateReport) violations() ([]rateViolation, error) {
+	var found []rateViolation
+	var cur rateViolation
+	var window []int64 // times of the events in the window, a queue
+	flush := func() {
+		if cur.peak > rr.threshold {
+			found = append(found, cur)
+		}
+	}
+	err := rr.spool.each(func(e stateEvent) {
+		if e.key != cur.key || cur.events == 0 {
+			flush()
+			cur, window = rateViolation{key: e.key}, window[:0]
+		}
+		window = append(window, e.t)
+		for e.t-window[0] >= int64(rr.window) {
+			window = window[1:]
+		}
+		cur.events += 1
+		if n := int64(len(window)); n > cur.peak {
+			cur.peak, cur.from, cur.to = n, window[0], e.t
+		}
+	})
+	flush()
+	return found, err
+}
+
+// Output writes key,peak,from,to,events lines of the keys over the threshold, the busiest first: the most
+// events in a window, the times of the first and last of them in RFC 3339 in the -tz, and all events of
+// the key
+func (rr *RateReport) Output(path string) {
+	found, err := rr.violations()
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].peak != found[j].peak {
+			return found[i].peak > found[j].peak
+		}
+		return found[i].key < found[j].key
+	})
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for _, v := range found {
+		from := rr.times.In(time.Unix(0, v.from)).Format(time.RFC3339Nano)
+		to := rr.times.In(time.Unix(0, v.to)).Format(time.RFC3339Nano)
+		fmt.Fprintf(w, "%s,%d,%s,%s,%d\n", v.key, v.peak, from, to, v.events)
+	}
+	w.Flush()
+}
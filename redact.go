@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Transformer rewrites a parsed record before it is handed to the reports
+type Transformer[T any] interface {
+	Transform(rec T) T
+}
+
+// Redactor replaces personal data in the columns of records before any report sees them. Rules are
+// separated by ; and select columns like -keys, without names, e.g. 0=ip;3,4=hash;5=mask;6=truncate:8.
+// The actions are:
+//
+//	hash        HMAC-SHA256 with the redaction key, the first 32 hex digits, so equal values stay equal
+//	mask        e-mail local parts, and all but the last 4 characters of other values, become *
+//	truncate:N  the first N characters
+//	ip[:N[/M]]  IP addresses with all but the first N bits (IPv4, default 24) or M bits (IPv6, default 48)
+//	            zeroed, e.g. ip:24/64
+//	cidr:FILE   IP addresses replaced by the name of the network of FILE they are in, see LoadCIDRBuckets
+//	drop        the empty string
+//
+// It is safe for concurrent use by the workers.
+type Redactor struct {
+	rules []redactRule
+	hmacs sync.Pool
+}
+
+type redactRule struct {
+	columns *KeySpec
+	redact  func(s string) string
+}
+
+// redactFiles returns the network files of the cidr: rules of -redact, which the result cache fingerprints
+func redactFiles(rules string) []string {
+	var files []string
+	for _, rule := range strings.Split(rules, ";") {
+		if _, action, ok := strings.Cut(rule, "="); ok {
+			if path, ok := strings.CutPrefix(strings.TrimSpace(action), "cidr:"); ok {
+				files = append(files, path)
+			}
+		}
+	}
+	return files
+}
+
+// NewRedactor parses the rules. key is the HMAC key of the hash action.
+func NewRedactor(rules string, key string) (*Redactor, error) {
+	rd := &Redactor{}
+	rd.hmacs.New = func() interface{} { return hmac.New(sha256.New, []byte(key)) }
+	for _, rule := range strings.Split(rules, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		columns, action, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("redact %s: want columns=action", rule)
+		}
+		spec, err := ParseKeySpec(columns)
+		if err != nil {
+			return nil, fmt.Errorf("redact %s: %v", rule, err)
+		}
+		if spec.HasNames() {
+			return nil, fmt.Errorf("redact %s: columns must be numbers", rule)
+		}
+		r := redactRule{columns: spec}
+		name, arg, _ := strings.Cut(action, ":")
+		switch name {
+		case "hash":
+			if key == "" {
+				return nil, fmt.Errorf("redact %s: hash needs a key", rule)
+			}
+			r.redact = rd.hash
+		case "mask":
+			r.redact = mask
+		case "truncate":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("redact %s: want truncate:N", rule)
+			}
+			r.redact = func(s string) string { return truncate(s, n) }
+		case "ip":
+			bits4, bits6 := 24, 48
+			if arg != "" {
+				s4, s6, _ := strings.Cut(arg, "/")
+				if bits4, err = strconv.Atoi(s4); err != nil || bits4 < 0 || bits4 > 32 {
+					return nil, fmt.Errorf("redact %s: want ip:N[/M]", rule)
+				}
+				if s6 != "" {
+					if bits6, err = strconv.Atoi(s6); err != nil || bits6 < 0 || bits6 > 128 {
+						return nil, fmt.Errorf("redact %s: want ip:N[/M]", rule)
+					}
+				}
+			}
+			r.redact = func(s string) string { return maskIP(s, bits4, bits6) }
+		case "cidr":
+			buckets, err := LoadCIDRBuckets(arg)
+			if err != nil {
+				return nil, fmt.Errorf("redact %s: %v", rule, err)
+			}
+			r.redact = buckets.Name
+		case "drop":
+			r.redact = func(string) string { return "" }
+		default:
+			return nil, fmt.Errorf("redact %s: unknown action %s", rule, name)
+		}
+		rd.rules = append(rd.rules, r)
+	}
+	if len(rd.rules) == 0 {
+		return nil, fmt.Errorf("no redact rules")
+	}
+	return rd, nil
+}
+
+// Redact rewrites the configured columns of a LogRecord or ByteRecord in place. Columns a record does not
+// have are left alone.
+func (rd *Redactor) Redact(rec interface{}) {
+	switch r := rec.(type) {
+	case LogRecord:
+		for _, rule := range rd.rules {
+			for _, c := range specColumns(rule.columns, len(r)) {
+				r[c] = rule.redact(r[c])
+			}
+		}
+	case ByteRecord:
+		for _, rule := range rd.rules {
+			for _, c := range specColumns(rule.columns, len(r)) {
+				r[c] = []byte(rule.redact(string(r[c])))
+			}
+		}
+	}
+}
+
+// specColumns returns the columns of spec that a record of width columns has
+func specColumns(spec *KeySpec, width int) []int {
+	columns, err := spec.Columns(width)
+	if err != nil {
+		// only the columns the record has
+		all, _ := spec.Columns(1 << 20)
+		columns = columns[:0]
+		for _, c := range all {
+			if c < width {
+				columns = append(columns, c)
+			}
+		}
+	}
+	return columns
+}
+
+func (rd *Redactor) hash(s string) string {
+	h := rd.hmacs.Get().(hash.Hash)
+	h.Reset()
+	h.Write([]byte(s))
+	sum := h.Sum(nil)
+	rd.hmacs.Put(h)
+	return hex.EncodeToString(sum[:16])
+}
+
+func mask(s string) string {
+	if at := strings.LastIndexByte(s, '@'); at > 0 {
+		return strings.Repeat("*", at) + s[at:]
+	}
+	if n := len(s) - 4; n > 0 {
+		return strings.Repeat("*", n) + s[n:]
+	}
+	return strings.Repeat("*", len(s))
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// maskIP keeps the first bits4 bits of IPv4 and bits6 bits of IPv6 addresses. Other values are unchanged.
+func maskIP(s string, bits4, bits6 int) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(bits4, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(bits6, 128)).String()
+}
+
+// CIDRBuckets names IP addresses by the network they are in
+type CIDRBuckets struct {
+	buckets []cidrBucket // longest prefix first
+	other   string
+}
+
+type cidrBucket struct {
+	net  *net.IPNet
+	name string
+}
+
+// LoadCIDRBuckets reads lines of a network and its name, e.g.
+//
+//	# internal networks
+//	10.0.0.0/8       internal
+//	10.1.0.0/16      vpc-prod
+//	fd00::/8         internal
+//	default          external
+//
+// The most specific network wins. Addresses in none of them are named by the default line, "other"
+// without one; values that are not IP addresses are unchanged.
+func LoadCIDRBuckets(path string) (*CIDRBuckets, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cb := &CIDRBuckets{other: "other"}
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want network and name", path, i+1)
+		}
+		if fields[0] == "default" {
+			cb.other = fields[1]
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		cb.buckets = append(cb.buckets, cidrBucket{ipnet, fields[1]})
+	}
+	sort.SliceStable(cb.buckets, func(i, j int) bool {
+		a, _ := cb.buckets[i].net.Mask.Size()
+		b, _ := cb.buckets[j].net.Mask.Size()
+		return a > b
+	})
+	return cb, nil
+}
+
+// Name returns the name of the network of an IP address
+func (cb *CIDRBuckets) Name(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+	for _, b := range cb.buckets {
+		if b.net.Contains(ip) {
+			return b.name
+		}
+	}
+	return cb.other
+}
+
+// Redaction is the Transformer of a Redactor for records of type T
+type Redaction[T any] struct{ *Redactor }
+
+func (rd Redaction[T]) Transform(rec T) T {
+	rd.Redact(rec)
+	return rec
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options are the name=value settings handed to parser and report factories
+type Options map[string]string
+
+func (o Options) String(name, def string) string {
+	if v, ok := o[name]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func (o Options) Int(name string, def int) (int, error) {
+	v, ok := o[name]
+	if !ok || v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("option %s: %v", name, err)
+	}
+	return i, nil
+}
+
+func (o Options) Duration(name string, def time.Duration) (time.Duration, error) {
+	v, ok := o[name]
+	if !ok || v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, fmt.Errorf("option %s: %v", name, err)
+	}
+	return d, nil
+}
+
+// Keys parses a key column spec, see KeySpec
+func (o Options) Keys(name string) (*KeySpec, error) {
+	ks, err := ParseKeySpec(o[name])
+	if err != nil {
+		return nil, fmt.Errorf("option %s: %v", name, err)
+	}
+	return ks, nil
+}
+
+// Time returns the parser of the time column set by the time-column, time-layout and tz options, or nil
+// when there is no time column. Time-based parsers, reports and filters should all use it.
+func (o Options) Time() (*TimeParser, error) {
+	column, err := o.Int("time-column", -1)
+	if err != nil {
+		return nil, err
+	}
+	tp, err := NewTimeParser(column, o.String("time-layout", "rfc3339"), o.String("tz", "Local"))
+	if err != nil || column < 0 {
+		return nil, err
+	}
+	return tp, nil
+}
+
+// Rollup returns the rollup set by the rollup and rollup-depth options, nil without a separator
+func (o Options) Rollup() (*Rollup, error) {
+	depth, err := o.Int("rollup-depth", 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewRollup(o["rollup"], depth)
+}
+
+// Ints parses a comma separated list of integers
+func (o Options) Ints(name string) ([]int, error) {
+	is := make([]int, 0, 1)
+	for _, s := range strings.Split(o[name], ",") {
+		if s == "" {
+			continue
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("option %s: %v", name, err)
+		}
+		is = append(is, i)
+	}
+	return is, nil
+}
+
+// keyed returns the normalizer and the empty key handling set by the normalize, rewrite and empty-keys
+// options of a report, after checking that column names in its key specs come with a header
+func (o Options) keyed(report string, specs ...*KeySpec) (*Normalizer, *EmptyKeys, error) {
+	for _, spec := range specs {
+		if spec != nil && spec.HasNames() && o.String("header", "false") != "true" {
+			return nil, nil, fmt.Errorf("%s: column names need -header", report)
+		}
+	}
+	norm, err := NewNormalizer(o["normalize"], o["rewrite"])
+	if err != nil {
+		return nil, nil, err
+	}
+	empty, err := NewEmptyKeys(o["empty-keys"])
+	if err != nil {
+		return nil, nil, err
+	}
+	return norm, empty, nil
+}
+
+type Factory[V any] func(opts Options) (V, error)
+
+// Registry maps names to factories, e.g. of parsers or reports for one record type
+type Registry[V any] struct {
+	kind      string
+	factories map[string]Factory[V]
+	usage     map[string]string
+}
+
+func NewRegistry[V any](kind string) *Registry[V] {
+	return &Registry[V]{kind, make(map[string]Factory[V]), make(map[string]string)}
+}
+
+var (
+	parsers = NewRegistry[Parser[LogRecord]]("parser")
+	reports = NewRegistry[Report[LogRecord]]("report")
+
+	// parsers and reports for the zero-allocation ByteRecord mode
+	byteParsers = NewRegistry[Parser[ByteRecord]]("parser")
+	byteReports = NewRegistry[Report[ByteRecord]]("report")
+)
+
+func (r *Registry[V]) Register(name, usage string, factory Factory[V]) {
+	if _, ok := r.factories[name]; ok {
+		panic(r.kind + " registered twice: " + name)
+	}
+	r.factories[name] = factory
+	r.usage[name] = usage
+}
+
+func (r *Registry[V]) New(name string, opts Options) (V, error) {
+	factory, ok := r.factories[name]
+	if !ok {
+		var v V
+		return v, fmt.Errorf("unknown %s: %s", r.kind, name)
+	}
+	return factory(opts)
+}
+
+func (r *Registry[V]) Names() []string          { return sortedNames(r.usage) }
+func (r *Registry[V]) Usage(name string) string { return r.usage[name] }
+
+// Options returns the options listed in the usage of an entry after "options:", e.g. keys and shards of
+// "counts records..., options: keys, shards (default 16)"
+func (r *Registry[V]) Options(name string) []string {
+	_, list, ok := strings.Cut(r.usage[name], "options:")
+	if !ok {
+		return nil
+	}
+	var options []string
+	depth, start := 0, 0
+	for i := 0; i <= len(list); i++ {
+		switch {
+		case i < len(list) && list[i] == '(':
+			depth += 1
+		case i < len(list) && list[i] == ')':
+			depth -= 1
+		case i == len(list) || list[i] == ',' && depth == 0:
+			if option, _, _ := strings.Cut(strings.TrimSpace(list[start:i]), " "); option != "" {
+				options = append(options, option)
+			}
+			start = i + 1
+		}
+	}
+	return options
+}
+
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	parsers.Register("csv", "comma separated fields, options: comma, header, ragged", func(opts Options) (Parser[LogRecord], error) {
+		comma := opts.String("comma", ",")
+		return NewCSVParser(comma[0]).Header(opts.String("header", "false") == "true").Ragged(opts.String("ragged", "false") == "true"), nil
+	})
+
+	reports.Register("quick", "counts records by the key columns, options: keys, normalize, rewrite, empty-keys, rollup, rollup-depth, aggregate (clone or sharded), shards", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		rollup, err := opts.Rollup()
+		if err != nil {
+			return nil, err
+		}
+		norm, empty, err := opts.keyed("quick", keys)
+		if err != nil {
+			return nil, err
+		}
+		if opts.String("aggregate", "clone") == "sharded" {
+			if keys.HasNames() {
+				return nil, fmt.Errorf("quick: key column names are not supported with -aggregate sharded")
+			}
+			shards, err := opts.Int("shards", 64)
+			if err != nil {
+				return nil, err
+			}
+			return NewShardedQuickReport(keys, shards).Normalize(norm).EmptyKeys(empty).Rollup(rollup), nil
+		}
+		return NewQuickReport(keys).Normalize(norm).EmptyKeys(empty).Rollup(rollup), nil
+	})
+
+	reports.Register("sum", "sums a value column by the key columns, options: keys, sum-column, accumulate (int64, uint64, float64 or decimal), number-locale, normalize, rewrite, empty-keys, rollup, rollup-depth", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		rollup, err := opts.Rollup()
+		if err != nil {
+			return nil, err
+		}
+		column, err := opts.Int("sum-column", -1)
+		if err != nil {
+			return nil, err
+		}
+		norm, empty, err := opts.keyed("sum", keys)
+		if err != nil {
+			return nil, err
+		}
+		numbers, err := ParseNumberLocale(opts["number-locale"])
+		if err != nil {
+			return nil, err
+		}
+		sr, err := NewSumReport(keys, column, opts.String("accumulate", "int64"))
+		if err != nil {
+			return nil, err
+		}
+		return sr.Normalize(norm).EmptyKeys(empty).Numbers(numbers).Rollup(rollup), nil
+	})
+
+	reports.Register("topn", "the most frequent values of the key columns within each group of other columns, options: keys, group-by, top, normalize, rewrite, empty-keys", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		if opts["group-by"] == "" {
+			return nil, fmt.Errorf("topn: no group columns, set -group-by")
+		}
+		group, err := opts.Keys("group-by")
+		if err != nil {
+			return nil, err
+		}
+		n, err := opts.Int("top", 5)
+		if err != nil {
+			return nil, err
+		}
+		norm, empty, err := opts.keyed("topn", keys, group)
+		if err != nil {
+			return nil, err
+		}
+		tr, err := NewTopNReport(keys, group, n)
+		if err != nil {
+			return nil, err
+		}
+		return tr.Normalize(norm).EmptyKeys(empty), nil
+	})
+
+	reports.Register("pairs", "the most frequent pairs of values of the key columns and the pair-with columns in a record or session, options: keys, pair-with, session-by, top, normalize, rewrite, empty-keys", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		if opts["pair-with"] == "" {
+			return nil, fmt.Errorf("pairs: no columns to pair with, set -pair-with")
+		}
+		with, err := opts.Keys("pair-with")
+		if err != nil {
+			return nil, err
+		}
+		var session *KeySpec
+		if opts["session-by"] != "" {
+			if session, err = opts.Keys("session-by"); err != nil {
+				return nil, err
+			}
+		}
+		n, err := opts.Int("top", 5)
+		if err != nil {
+			return nil, err
+		}
+		norm, empty, err := opts.keyed("pairs", keys, with, session)
+		if err != nil {
+			return nil, err
+		}
+		pr, err := NewPairsReport(keys, with, session, n)
+		if err != nil {
+			return nil, err
+		}
+		return pr.Normalize(norm).EmptyKeys(empty), nil
+	})
+
+	reports.Register("transitions", "counts the transitions between the values of the state columns along the timeline of every key, options: keys, state-columns, time-column, time-layout, tz, spill-size, normalize, rewrite, empty-keys", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		if opts["state-columns"] == "" {
+			return nil, fmt.Errorf("transitions: no state columns, set -state-columns")
+		}
+		states, err := opts.Keys("state-columns")
+		if err != nil {
+			return nil, err
+		}
+		times, err := opts.Time()
+		if err != nil {
+			return nil, err
+		}
+		if times == nil {
+			return nil, fmt.Errorf("transitions: no time column, set -time-column")
+		}
+		spillSize, err := opts.Int("spill-size", 64<<20)
+		if err != nil {
+			return nil, err
+		}
+		norm, empty, err := opts.keyed("transitions", keys, states)
+		if err != nil {
+			return nil, err
+		}
+		return NewTransitionsReport(keys, states, times, int64(spillSize)).Normalize(norm).EmptyKeys(empty), nil
+	})
+
+	reports.Register("rate", "the keys with more than threshold records in any rolling window of the -time-column, options: keys, window, threshold, time-column, time-layout, tz, spill-size, normalize, rewrite, empty-keys", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		times, err := opts.Time()
+		if err != nil {
+			return nil, err
+		}
+		if times == nil {
+			return nil, fmt.Errorf("rate: no time column, set -time-column")
+		}
+		window, err := opts.Duration("window", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		threshold, err := opts.Int("threshold", 0)
+		if err != nil {
+			return nil, err
+		}
+		spillSize, err := opts.Int("spill-size", 64<<20)
+		if err != nil {
+			return nil, err
+		}
+		norm, empty, err := opts.keyed("rate", keys)
+		if err != nil {
+			return nil, err
+		}
+		rr, err := NewRateReport(keys, times, window, int64(threshold), int64(spillSize))
+		if err != nil {
+			return nil, err
+		}
+		return rr.Normalize(norm).EmptyKeys(empty), nil
+	})
+
+	reports.Register("counters", "counts the records by the key columns and, as more columns, the ones matching each named condition, options: keys, counters, normalize, rewrite, empty-keys", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		header := opts.String("header", "false") == "true"
+		if opts["counters"] == "" {
+			return nil, fmt.Errorf("counters: no counters, set -counters")
+		}
+		names, filters, err := ParseCounters(opts["counters"], header)
+		if err != nil {
+			return nil, err
+		}
+		norm, empty, err := opts.keyed("counters", keys)
+		if err != nil {
+			return nil, err
+		}
+		return NewCountersReport(keys, names, filters).Normalize(norm).EmptyKeys(empty), nil
+	})
+
+	reports.Register("distinct", "estimates the distinct values of the distinct columns by the key columns in every time bucket with HyperLogLog, options: keys, distinct-columns, time-bucket, hll-precision, time-column, time-layout, tz, normalize, rewrite, empty-keys", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		if opts["distinct-columns"] == "" {
+			return nil, fmt.Errorf("distinct: no columns to count, set -distinct-columns")
+		}
+		distinct, err := opts.Keys("distinct-columns")
+		if err != nil {
+			return nil, err
+		}
+		times, err := opts.Time()
+		if err != nil {
+			return nil, err
+		}
+		if times == nil {
+			return nil, fmt.Errorf("distinct: no time column, set -time-column")
+		}
+		bucket, err := ParseTimeBucket(opts.String("time-bucket", "day"))
+		if err != nil {
+			return nil, err
+		}
+		precision, err := opts.Int("hll-precision", 12)
+		if err != nil {
+			return nil, err
+		}
+		norm, empty, err := opts.keyed("distinct", keys, distinct)
+		if err != nil {
+			return nil, err
+		}
+		dr, err := NewDistinctReport(keys, distinct, times, bucket, precision)
+		if err != nil {
+			return nil, err
+		}
+		return dr.Normalize(norm).EmptyKeys(empty), nil
+	})
+
+	reports.Register("seen", "the first and last time of the -time-column per key, options: keys, time-column, time-layout, tz, normalize, rewrite, empty-keys", func(opts Options) (Report[LogRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		times, err := opts.Time()
+		if err != nil {
+			return nil, err
+		}
+		if times == nil {
+			return nil, fmt.Errorf("seen: no time column, set -time-column")
+		}
+		norm, empty, err := opts.keyed("seen", keys)
+		if err != nil {
+			return nil, err
+		}
+		return NewSeenReport(keys, times).Normalize(norm).EmptyKeys(empty), nil
+	})
+
+	reports.Register("columns", "counts the values, empty values and absent values of every column", func(opts Options) (Report[LogRecord], error) {
+		return NewColumnsReport[LogRecord](), nil
+	})
+
+	reports.Register("sql", "evaluates a SQL query over a table named records with DuckDB, options: sql, duckdb", func(opts Options) (Report[LogRecord], error) {
+		query := opts.String("sql", "")
+		if query == "" {
+			return nil, fmt.Errorf("sql: -sql is required")
+		}
+		// checked up front, as the binary only runs once the records are read
+		duckdb, err := exec.LookPath(opts.String("duckdb", "duckdb"))
+		if err != nil {
+			return nil, fmt.Errorf("sql: no DuckDB binary %s, install DuckDB or set -duckdb to its path: %v", opts.String("duckdb", "duckdb"), err)
+		}
+		return NewSQLReport(query, duckdb), nil
+	})
+
+	reports.Register("extract", "writes the records, or the extract-columns of them, as CSV, TSV or JSON lines instead of aggregating them, options: extract-format, extract-columns, split-by, split-time, time-column, time-layout, tz", func(opts Options) (Report[LogRecord], error) {
+		var spec *KeySpec
+		if columns := opts.String("extract-columns", ""); columns != "" {
+			var err error
+			if spec, err = ParseKeySpec(columns); err != nil {
+				return nil, fmt.Errorf("extract: %v", err)
+			}
+			if spec.HasNames() && opts.String("header", "false") != "true" {
+				return nil, fmt.Errorf("extract: column names need -header")
+			}
+		}
+		er, err := NewExtractReport(opts.String("extract-format", "csv"), spec)
+		if err != nil {
+			return nil, err
+		}
+		var split *KeySpec
+		if columns := opts.String("split-by", ""); columns != "" {
+			if split, err = ParseKeySpec(columns); err != nil {
+				return nil, fmt.Errorf("extract: -split-by: %v", err)
+			}
+			if split.HasNames() && opts.String("header", "false") != "true" {
+				return nil, fmt.Errorf("extract: column names need -header")
+			}
+		}
+		var times *TimeParser
+		var bucket TimeBucket
+		layout := ""
+		if s := opts.String("split-time", ""); s != "" {
+			if times, err = opts.Time(); err != nil {
+				return nil, err
+			}
+			if times == nil {
+				return nil, fmt.Errorf("extract: -split-time needs -time-column")
+			}
+			if bucket, err = ParseTimeBucket(s); err != nil {
+				return nil, fmt.Errorf("extract: %v", err)
+			}
+			layout = splitLayout(s)
+		}
+		return er.Split(split, times, bucket, layout), nil
+	})
+
+	reports.Register("nop", "discards all records", func(opts Options) (Report[LogRecord], error) {
+		return NopReport[LogRecord]{}, nil
+	})
+
+	byteParsers.Register("fields", "unquoted fields split by a separator, options: comma", func(opts Options) (Parser[ByteRecord], error) {
+		if opts.String("header", "false") == "true" {
+			return nil, fmt.Errorf("fields: -header is not supported, use -records string")
+		}
+		comma := opts.String("comma", ",")
+		return NewFieldsParser(comma[0]), nil
+	})
+
+	byteReports.Register("columns", "counts the values, empty values and absent values of every column", func(opts Options) (Report[ByteRecord], error) {
+		return NewColumnsReport[ByteRecord](), nil
+	})
+
+	byteReports.Register("quick", "counts records by the key columns, options: keys, normalize, rewrite, empty-keys", func(opts Options) (Report[ByteRecord], error) {
+		keys, err := opts.Keys("keys")
+		if err != nil {
+			return nil, err
+		}
+		norm, err := NewNormalizer(opts["normalize"], opts["rewrite"])
+		if err != nil {
+			return nil, err
+		}
+		empty, err := NewEmptyKeys(opts["empty-keys"])
+		if err != nil {
+			return nil, err
+		}
+		if keys.HasNames() {
+			return nil, fmt.Errorf("quick: key column names need a header-aware parser, use -records string")
+		}
+		if opts["rollup"] != "" {
+			return nil, fmt.Errorf("quick: -rollup needs -records string")
+		}
+		return NewBytesQuickReport(keys).Normalize(norm).EmptyKeys(empty), nil
+	})
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Table holds the parsed records of the inputs in memory, so the queries of the repl do not read and parse
+// them again
+type Table struct {
+	header  []string
+	records []LogRecord
+}
+
+// tableReport collects the records of a worker, merged into one table at the end of the load
+type tableReport struct {
+	t *Table
+}
+
+func (tr *tableReport) New() Report[LogRecord] { return &tableReport{&Table{}} }
+func (tr *tableReport) Name() string           { return "table" }
+func (tr *tableReport) Clear()                 { tr.t.records = nil }
+func (tr *tableReport) Output(path string)     {}
+func (tr *tableReport) Discards()              {}
+func (tr *tableReport) Add(r LogRecord)        { tr.t.records = append(tr.t.records, CopyRecord(r)) }
+func (tr *tableReport) Merge(rpt Report[LogRecord]) {
+	nt := rpt.(*tableReport).t
+	tr.t.records = append(tr.t.records, nt.records...)
+	if tr.t.header == nil {
+		tr.t.header = nt.header
+	}
+}
+
+func (tr *tableReport) SetHeader(columns []string) error {
+	if tr.t.header == nil {
+		tr.t.header = CopyRecord(columns)
+	}
+	return nil
+}
+
+// LoadTable parses the inputs of cfg into memory, with its parser, cache, procs, line and time filters and
+// redaction
+func LoadTable(cfg *Config, files []string) (*Table, error) {
+	parser, err := parsers.New(cfg.parserName("csv"), cfg.Options())
+	if err != nil {
+		return nil, ConfigError{err}
+	}
+	tr := &tableReport{&Table{}}
+	p := NewPipeline[LogRecord]().
+		From(NewFileSource(Retry{cfg.Retries, cfg.RetryBackoff})).
+		Parse(parser).
+		Report(tr).
+		To(NopSink{}).
+		Procs(cfg.Procs).
+		Deterministic(cfg.Deterministic).
+		Lines(&LineFilter{cfg.SkipLines, cfg.SkipFooter, cfg.Comment})
+	if cfg.Cache != "" {
+		cc, err := cfg.ColumnCache("csv")
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Cache(cc)
+	}
+	if cfg.From != "" || cfg.To != "" {
+		times, from, to, err := cfg.TimeRange()
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Filter(NewTimeRange[LogRecord](times, from, to))
+	}
+	if cfg.Units != "" {
+		uc, err := NewUnitConverter(cfg.Units)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Transform(Units[LogRecord]{uc})
+	}
+	if cfg.Classify != "" {
+		cl, err := NewClassifier(cfg.Classify)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Transform(Classification[LogRecord]{cl})
+	}
+	if cfg.Buckets != "" {
+		bk, err := NewBucketer(cfg.Buckets)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Transform(Buckets[LogRecord]{bk})
+	}
+	if cfg.Redact != "" {
+		rd, err := NewRedactor(cfg.Redact, cfg.RedactKey)
+		if err != nil {
+			return nil, ConfigError{err}
+		}
+		p.Transform(Redaction[LogRecord]{rd})
+	}
+	if err := p.Run(files); err != nil {
+		return nil, err
+	}
+	return tr.t, nil
+}
+
+// column returns the index of a column given by index or, with a header, by name
+func (t *Table) column(s string) (int, error) {
+	if i, err := strconv.Atoi(s); err == nil && i >= 0 {
+		return i, nil
+	}
+	for i, name := range t.header {
+		if strings.TrimSpace(name) == s {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown column: %s", s)
+}
+
+// Condition is a COLUMN OP VALUE test of a query. The operators are = and != , ~ and !~ for regexps, and
+// <, <=, > and >= which compare numbers when both sides are numbers and strings otherwise.
+type Condition struct {
+	column int
+	op     string
+	value  string
+	num    float64
+	isNum  bool
+	re     *regexp.Regexp
+}
+
+func (t *Table) condition(column, op, value string) (Condition, error) {
+	i, err := t.column(column)
+	if err != nil {
+		return Condition{}, err
+	}
+	return NewCondition(i, op, value)
+}
+
+func NewCondition(column int, op, value string) (Condition, error) {
+	c := Condition{column: column, op: op, value: value}
+	var err error
+	switch op {
+	case "~", "!~":
+		c.re, err = regexp.Compile(value)
+	case "<", "<=", ">", ">=":
+		c.num, err = strconv.ParseFloat(value, 64)
+		c.isNum, err = err == nil, nil
+	case "=", "!=":
+	default:
+		err = fmt.Errorf("unknown operator: %s", op)
+	}
+	return c, err
+}
+
+// Match tells whether the record passes the condition. A record without the column never does.
+func (c Condition) Match(r LogRecord) bool {
+	if c.column < 0 || c.column >= len(r) {
+		return false
+	}
+	return c.matchValue(r[c.column])
+}
+
+func (c Condition) matchValue(v string) bool {
+	switch c.op {
+	case "=":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	case "~":
+		return c.re.MatchString(v)
+	case "!~":
+		return !c.re.MatchString(v)
+	}
+	cmp := strings.Compare(v, c.value)
+	if n, err := strconv.ParseFloat(v, 64); err == nil && c.isNum {
+		cmp = 0
+		if n < c.num {
+			cmp = -1
+		} else if n > c.num {
+			cmp = 1
+		}
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func matchAll(conds []Condition, r LogRecord) bool {
+	for _, c := range conds {
+		if !c.Match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Repl is an interactive session on a table. Queries run on the current view, the records that passed the
+// filter commands so far.
+type Repl struct {
+	table *Table
+	view  []LogRecord
+	norm  *Normalizer
+	out   io.Writer
+}
+
+func NewRepl(t *Table, norm *Normalizer, out io.Writer) *Repl {
+	return &Repl{table: t, view: t.records, norm: norm, out: out}
+}
+
+const replHelp = `commands:
+  count [by COLUMNS] [where COND [and COND]...] [top N]   count the records, or the top N keys of COLUMNS
+  filter COND [and COND]...                               keep only the matching records for the next queries
+  reset                                                   undo the filters
+  show [N] [where COND [and COND]...]                     print the first N records
+  columns                                                 list the columns
+  help, quit
+COLUMNS use the -keys syntax, e.g. 0,2 or 3- or names with -header. COND is COLUMN OP VALUE with
+OP one of = != ~ !~ < <= > >=, e.g. status >= 500 or path ~ "^/api/"
+`
+
+// Exec runs one line of input. It returns false when the session ends.
+func (rl *Repl) Exec(line string) (bool, error) {
+	args, err := splitQuery(line)
+	if err != nil || len(args) == 0 {
+		return true, err
+	}
+	started := time.Now()
+	switch args[0] {
+	case "quit", "exit":
+		return false, nil
+	case "help":
+		fmt.Fprint(rl.out, replHelp)
+		return true, nil
+	case "columns":
+		rl.columns()
+		return true, nil
+	case "reset":
+		rl.view = rl.table.records
+		fmt.Fprintf(rl.out, "%d records\n", len(rl.view))
+		return true, nil
+	case "filter":
+		conds, rest, err := rl.conditions(args[1:])
+		if err == nil && (len(conds) == 0 || len(rest) > 0) {
+			err = fmt.Errorf("usage: filter COND [and COND]...")
+		}
+		if err != nil {
+			return true, err
+		}
+		var view []LogRecord
+		for _, r := range rl.view {
+			if matchAll(conds, r) {
+				view = append(view, r)
+			}
+		}
+		fmt.Fprintf(rl.out, "%d of %d records (%v)\n", len(view), len(rl.view), time.Since(started).Round(time.Microsecond))
+		rl.view = view
+		return true, nil
+	case "count":
+		return true, rl.count(args[1:], started)
+	case "show":
+		return true, rl.show(args[1:])
+	}
+	return true, fmt.Errorf("unknown command: %s, try help", args[0])
+}
+
+func (rl *Repl) columns() {
+	width := 0
+	for _, r := range rl.table.records {
+		if len(r) > width {
+			width = len(r)
+		}
+	}
+	for i := 0; i < width || i < len(rl.table.header); i++ {
+		var name string
+		if i < len(rl.table.header) {
+			name = rl.table.header[i]
+		}
+		fmt.Fprintf(rl.out, "%d\t%s\n", i, name)
+	}
+}
+
+// conditions parses the COND [and COND]... that start args and returns the args after them
+func (rl *Repl) conditions(args []string) ([]Condition, []string, error) {
+	var conds []Condition
+	for len(args) >= 3 {
+		c, err := rl.table.condition(args[0], args[1], args[2])
+		if err != nil {
+			return nil, nil, err
+		}
+		conds = append(conds, c)
+		args = args[3:]
+		if len(args) == 0 || args[0] != "and" {
+			break
+		}
+		args = args[1:]
+	}
+	return conds, args, nil
+}
+
+func (rl *Repl) count(args []string, started time.Time) error {
+	var by *KeySpec
+	var where []Condition
+	top := 10
+	var err error
+	for len(args) > 0 && err == nil {
+		switch {
+		case args[0] == "by" && len(args) > 1:
+			if by, err = ParseKeySpec(args[1]); err == nil {
+				by, err = by.WithHeader(rl.table.header)
+			}
+			args = args[2:]
+		case args[0] == "where":
+			where, args, err = rl.conditions(args[1:])
+		case args[0] == "top" && len(args) > 1:
+			top, err = strconv.Atoi(args[1])
+			args = args[2:]
+		default:
+			err = fmt.Errorf("usage: count [by COLUMNS] [where COND [and COND]...] [top N]")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if by == nil {
+		n := 0
+		for _, r := range rl.view {
+			if matchAll(where, r) {
+				n += 1
+			}
+		}
+		fmt.Fprintf(rl.out, "%d records (%v)\n", n, time.Since(started).Round(time.Microsecond))
+		return nil
+	}
+	counts := make(map[string]int64)
+	var skipped int
+	for _, r := range rl.view {
+		if !matchAll(where, r) {
+			continue
+		}
+		keys, err := by.Columns(len(r))
+		if err != nil {
+			skipped += 1
+			continue
+		}
+		key, _ := joinKey(keys, r, rl.norm, nil)
+		counts[key] += 1
+	}
+	for _, kc := range topCounts(top, func(fn func(string, int64)) {
+		for k, v := range counts {
+			fn(k, v)
+		}
+	}) {
+		fmt.Fprintf(rl.out, "%d\t%s\n", kc.Count, kc.Key)
+	}
+	fmt.Fprintf(rl.out, "%d keys", len(counts))
+	if skipped > 0 {
+		fmt.Fprintf(rl.out, ", %d records without the columns", skipped)
+	}
+	fmt.Fprintf(rl.out, " (%v)\n", time.Since(started).Round(time.Microsecond))
+	return nil
+}
+
+func (rl *Repl) show(args []string) error {
+	n := 10
+	if len(args) > 0 && args[0] != "where" {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("usage: show [N] [where COND [and COND]...]")
+		}
+		args = args[1:]
+	}
+	var where []Condition
+	if len(args) > 0 && args[0] == "where" {
+		var err error
+		if where, args, err = rl.conditions(args[1:]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("usage: show [N] [where COND [and COND]...]")
+	}
+	for _, r := range rl.view {
+		if n == 0 {
+			break
+		}
+		if matchAll(where, r) {
+			fmt.Fprintln(rl.out, strings.Join(r, "\t"))
+			n -= 1
+		}
+	}
+	return nil
+}
+
+// splitQuery splits a line into words, keeping "quoted strings" together
+func splitQuery(line string) ([]string, error) {
+	var words []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string: %s", line)
+			}
+			word, _ := strconv.Unquote(quoted)
+			words = append(words, word)
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		words = append(words, line[:end])
+		line = line[end:]
+	}
+	return words, nil
+}
+
+// Run reads queries from in until it ends or a quit, printing a prompt when in is a terminal
+func (rl *Repl) Run(in io.Reader, prompt bool) {
+	sc := bufio.NewScanner(in)
+	for {
+		if prompt {
+			fmt.Fprint(rl.out, "> ")
+		}
+		if !sc.Scan() {
+			return
+		}
+		more, err := rl.Exec(sc.Text())
+		if err != nil {
+			fmt.Fprintln(rl.out, "error:", err)
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+func replCommand(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	cfg.RegisterFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if cfg.Records != "string" {
+		fmt.Fprintln(os.Stderr, "repl: only -records string is supported")
+		return 2
+	}
+	norm, err := NewNormalizer(cfg.Normalize, cfg.Rewrite)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repl:", err)
+		return 2
+	}
+	files, err := cfg.ListFiles()
+	if err != nil {
+		slog.Error("failed to list inputs", "error", err)
+		return 2
+	}
+	started := time.Now()
+	t, err := LoadTable(&cfg, files)
+	if err != nil {
+		slog.Error("failed to load the inputs", "error", err)
+		if _, ok := err.(ConfigError); ok {
+			return 2
+		}
+		return 1
+	}
+	slog.Info("loaded", "files", len(files), "records", len(t.records), "elapsed", time.Since(started))
+
+	interactive := isTerminal(os.Stdin)
+	if interactive {
+		fmt.Printf("%d records loaded, type help for the commands\n", len(t.records))
+	}
+	NewRepl(t, norm, os.Stdout).Run(os.Stdin, interactive)
+	return 0
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Emitter receives the replayed records. Flush is called before every pause, so batching emitters send
+// what is due before waiting.
+type Emitter interface {
+	io.Writer
+	Flush() error
+}
+
+// OpenEmitter returns an emitter writing to stdout for "-" or POSTing to an http(s) URL
+func OpenEmitter(to string) (Emitter, error) {
+	switch {
+	case to == "-" || to == "stdout":
+		return bufio.NewWriter(os.Stdout), nil
+	case strings.HasPrefix(to, "http://") || strings.HasPrefix(to, "https://"):
+		return &HTTPEmitter{url: to, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown replay target: %s", to)
+}
+
+// HTTPEmitter POSTs the records due at the same time as one newline separated text/plain body
+type HTTPEmitter struct {
+	url    string
+	client *http.Client
+	buf    bytes.Buffer
+}
+
+func (he *HTTPEmitter) Write(p []byte) (int, error) {
+	n, _ := he.buf.Write(p)
+	if he.buf.Len() >= 1024*1024 {
+		return n, he.Flush()
+	}
+	return n, nil
+}
+
+func (he *HTTPEmitter) Flush() error {
+	if he.buf.Len() == 0 {
+		return nil
+	}
+	resp, err := he.client.Post(he.url, "text/plain", bytes.NewReader(he.buf.Bytes()))
+	he.buf.Reset()
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("replay to %s: %s", he.url, resp.Status)
+	}
+	return nil
+}
+
+// Replayer is a report that re-emits every record at the pace of its timestamps, divided by speed.
+// It only works on a single worker, so records keep their order.
+type Replayer struct {
+	out   Emitter
+	comma string
+	times *TimeParser
+	speed float64
+	ctl   *Control
+
+	first   time.Time // timestamp of the first record
+	started time.Time
+	emitted int64
+	err     error
+}
+
+func NewReplayer(out Emitter, comma string, times *TimeParser, speed float64, ctl *Control) *Replayer {
+	return &Replayer{out: out, comma: comma, times: times, speed: speed, ctl: ctl}
+}
+
+func (rp *Replayer) New() Report[LogRecord]      { return rp }
+func (rp *Replayer) Merge(rpt Report[LogRecord]) {}
+func (rp *Replayer) Clear()                      {}
+func (rp *Replayer) Name() string                { return "replay" }
+func (rp *Replayer) Output(path string)          {}
+func (rp *Replayer) Discards()                   {}
+
+// Add waits until the record is due and emits it. Records without a valid timestamp, or older than the
+// ones before them, are emitted right away.
+func (rp *Replayer) Add(r LogRecord) {
+	if rp.err != nil {
+		return
+	}
+	if ts, err := RecordTime(rp.times, r); err == nil {
+		if rp.started.IsZero() {
+			rp.first, rp.started = ts, time.Now()
+		}
+		due := rp.started.Add(time.Duration(float64(ts.Sub(rp.first)) / rp.speed))
+		if wait := time.Until(due); wait > 0 {
+			if rp.fail(rp.out.Flush()) {
+				return
+			}
+			time.Sleep(wait)
+		}
+	}
+
+	_, err := io.WriteString(rp.out, strings.Join(r, rp.comma)+"\n")
+	if !rp.fail(err) {
+		rp.emitted += 1
+	}
+}
+
+// fail stops the run on the first emit error
+func (rp *Replayer) fail(err error) bool {
+	if err != nil && rp.err == nil {
+		rp.err = err
+		rp.ctl.Cancel()
+	}
+	return rp.err != nil
+}
+
+// Replay runs the inputs of cfg through its parser on one worker and emits the records to out
+func Replay(cfg *Config, rp *Replayer, files []string) error {
+	parser, err := parsers.New(cfg.parserName("csv"), cfg.Options())
+	if err != nil {
+		return ConfigError{err}
+	}
+
+	p := NewPipeline[LogRecord]().
+		From(NewFileSource(Retry{cfg.Retries, cfg.RetryBackoff})).
+		Parse(parser).
+		Report(rp).
+		To(NopSink{}).
+		Procs(1).
+		Deterministic(true).
+		Control(rp.ctl)
+	if cfg.From != "" || cfg.To != "" {
+		times, from, to, err := cfg.TimeRange()
+		if err != nil {
+			return ConfigError{err}
+		}
+		p.Filter(NewTimeRange[LogRecord](times, from, to))
+	}
+	if cfg.Redact != "" {
+		rd, err := NewRedactor(cfg.Redact, cfg.RedactKey)
+		if err != nil {
+			return ConfigError{err}
+		}
+		p.Transform(Redaction[LogRecord]{rd})
+	}
+	err = p.Run(files)
+	if rp.err != nil {
+		return rp.err
+	}
+	if err != nil {
+		return err
+	}
+	return rp.out.Flush()
+}
+
+// replayFlags registers the flags replay has on top of the run flags
+func replayFlags(fs *flag.FlagSet) (target *string, speed *float64) {
+	return fs.String("target", "-", "where to send the records: - for stdout, or an http(s) URL to POST them to"),
+		fs.Float64("speed", 1, "replay speed multiplier, e.g. 10 for ten times faster than recorded")
+}
+
+func replayCommand(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	cfg.RegisterFlags(fs)
+	target, speed := replayFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *speed <= 0 {
+		fmt.Fprintln(os.Stderr, "replay: -speed must be positive")
+		return 2
+	}
+	files, err := cfg.ListFiles()
+	if err != nil {
+		slog.Error("failed to list inputs", "error", err)
+		return 2
+	}
+	if cfg.TimeColumn < 0 {
+		cfg.TimeColumn = 0
+	}
+	times, err := cfg.TimeParser()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		return 2
+	}
+	out, err := OpenEmitter(*target)
+	if err != nil {
+		slog.Error("failed to open replay target", "error", err)
+		return 2
+	}
+
+	rp := NewReplayer(out, cfg.Comma, times, *speed, NewControl())
+	if err := Replay(&cfg, rp, files); err != nil {
+		slog.Error("replay failed", "error", err)
+		if _, ok := err.(ConfigError); ok {
+			return 2
+		}
+		return 1
+	}
+	slog.Info("replayed", "records", rp.emitted)
+	return 0
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// ReportFilter selects the records one report sees, with the conditions of the repl: COLUMN OP VALUE
+// joined by and, e.g. status >= 500 and path ~ ^/api/. Columns are indices, or names resolved with the
+// header of every input.
+type ReportFilter struct {
+	conds   []Condition
+	columns []string // of the conditions, as given
+}
+
+func ParseReportFilter(expr string, header bool) (*ReportFilter, error) {
+	args, err := splitQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	rf := &ReportFilter{}
+	for len(args) >= 3 {
+		column, err := strconv.Atoi(args[0])
+		if err != nil || column < 0 {
+			if !header {
+				return nil, fmt.Errorf("column %s: column names need -header", args[0])
+			}
+			column = -1 // until the header is known
+		}
+		c, err := NewCondition(column, args[1], args[2])
+		if err != nil {
+			return nil, err
+		}
+		rf.conds = append(rf.conds, c)
+		rf.columns = append(rf.columns, args[0])
+		if args = args[3:]; len(args) == 0 || args[0] != "and" {
+			break
+		}
+		args = args[1:]
+	}
+	if len(rf.conds) == 0 || len(args) > 0 {
+		return nil, fmt.Errorf("%q: want COLUMN OP VALUE [and COLUMN OP VALUE]...", expr)
+	}
+	return rf, nil
+}
+
+// clone returns a filter to bind to other headers
+func (rf *ReportFilter) clone() *ReportFilter {
+	return &ReportFilter{append([]Condition(nil), rf.conds...), rf.columns}
+}
+
+// SetHeader resolves the column names of the conditions
+func (rf *ReportFilter) SetHeader(header []string) error {
+	for i, name := range rf.columns {
+		if _, err := strconv.Atoi(name); err == nil {
+			continue
+		}
+		rf.conds[i].column = -1
+		for j, column := range header {
+			if strings.TrimSpace(column) == name {
+				rf.conds[i].column = j
+				break
+			}
+		}
+		if rf.conds[i].column < 0 {
+			return fmt.Errorf("filter: no column named %s in the header", name)
+		}
+	}
+	return nil
+}
+
+// Match tells whether a LogRecord or ByteRecord passes every condition
+func (rf *ReportFilter) Match(rec interface{}) bool {
+	switch r := rec.(type) {
+	case LogRecord:
+		return matchAll(rf.conds, r)
+	case ByteRecord:
+		for _, c := range rf.conds {
+			if c.column < 0 || c.column >= len(r) || !c.matchValue(string(r[c.column])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FilteredReport adds only the records that pass its filter to a report, under a name of its own so a run
+// can have the same report for several subsets, e.g. -reports quick,errors=quick. It passes the optional
+// interfaces of the report through.
+type FilteredReport[T any] struct {
+	rpt    Report[T]
+	name   string
+	filter *ReportFilter // nil for all records
+	clone  bool          // of a shared report, which the clone does not own
+}
+
+func NewFilteredReport[T any](rpt Report[T], name string, filter *ReportFilter) *FilteredReport[T] {
+	return &FilteredReport[T]{rpt, name, filter, false}
+}
+
+// New clones the report, except a shared one, as the filter of every worker follows its own headers
+func (fr *FilteredReport[T]) New() Report[T] {
+	rpt := fr.rpt.New()
+	nfr := &FilteredReport[T]{rpt, fr.name, fr.filter, rpt == fr.rpt}
+	if fr.filter != nil {
+		nfr.filter = fr.filter.clone()
+	}
+	return nfr
+}
+
+func (fr *FilteredReport[T]) Name() string { return fr.name }
+
+func (fr *FilteredReport[T]) Clear() {
+	if !fr.clone {
+		fr.rpt.Clear()
+	}
+}
+
+func (fr *FilteredReport[T]) Add(rec T) {
+	if fr.filter == nil || fr.filter.Match(rec) {
+		fr.rpt.Add(rec)
+	}
+}
+
+func (fr *FilteredReport[T]) Merge(rpt Report[T]) {
+	if other := rpt.(*FilteredReport[T]).rpt; other != fr.rpt {
+		fr.rpt.Merge(other)
+	}
+}
+
+func (fr *FilteredReport[T]) Output(path string) { fr.rpt.Output(path) }
+
+// Check only rejects the records of the report's subset, the others are none of its business
+func (fr *FilteredReport[T]) Check(rec T) error {
+	c, ok := fr.rpt.(RecordChecker[T])
+	if !ok || fr.filter != nil && !fr.filter.Match(rec) {
+		return nil
+	}
+	return c.Check(rec)
+}
+
+func (fr *FilteredReport[T]) SetHeader(columns []string) error {
+	if fr.filter != nil {
+		if err := fr.filter.SetHeader(columns); err != nil {
+			return fmt.Errorf("%s: %v", fr.name, err)
+		}
+	}
+	if hr, ok := fr.rpt.(HeaderReport); ok {
+		return hr.SetHeader(columns)
+	}
+	return nil
+}
+
+func (fr *FilteredReport[T]) Extension() string { return extensionOf(fr.rpt) }
+
+func (fr *FilteredReport[T]) SetWorkspace(ws *Workspace) {
+	if wr, ok := fr.rpt.(WorkspaceReport); ok {
+		wr.SetWorkspace(ws)
+	}
+}
+
+func (fr *FilteredReport[T]) Len() int {
+	if sized, ok := fr.rpt.(SizedReport); ok {
+		return sized.Len()
+	}
+	return 0
+}
+
+func (fr *FilteredReport[T]) Top(n int) []KeyCount {
+	if top, ok := fr.rpt.(TopReport); ok {
+		return top.Top(n)
+	}
+	return nil
+}
+
+func (fr *FilteredReport[T]) loads() bool { return canLoad(fr.rpt) }
+
+func (fr *FilteredReport[T]) Load(path string) error {
+	if l, ok := fr.rpt.(Loader); ok {
+		return l.Load(path)
+	}
+	return fmt.Errorf("report %s cannot load its results", fr.name)
+}
+
+func (fr *FilteredReport[T]) LogValue() slog.Value {
+	if lv, ok := fr.rpt.(slog.LogValuer); ok {
+		return lv.LogValue()
+	}
+	return slog.GroupValue()
+}
+
+// ParseReportFilters parses ;-separated NAME:FILTER pairs, e.g. errors:status >= 500;api:path ~ ^/api/
+func ParseReportFilters(s string, header bool) (map[string]*ReportFilter, error) {
+	filters := make(map[string]*ReportFilter)
+	for _, part := range strings.Split(s, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, expr, ok := strings.Cut(part, ":")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, fmt.Errorf("report filter %s: want report:condition", part)
+		}
+		if _, ok := filters[name]; ok {
+			return nil, fmt.Errorf("report filter %s: %s filtered twice", part, name)
+		}
+		rf, err := ParseReportFilter(expr, header)
+		if err != nil {
+			return nil, fmt.Errorf("report filter %s: %v", name, err)
+		}
+		filters[name] = rf
+	}
+	return filters, nil
+}
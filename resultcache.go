@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// ResultCache keeps the results of runs by a fingerprint of their inputs and settings, so re-running a job
+// whose inputs did not change copies the results instead of computing them again. Only runs without
+// failed files are cached.
+type ResultCache struct {
+	dir string
+}
+
+func NewResultCache(dir string) *ResultCache { return &ResultCache{dir} }
+
+// Key fingerprints the settings that affect the results and the path, size and modification time of
+// every input. The content of the files the settings refer to, like -schema or the -redact networks, is
+// included.
+func (rc *ResultCache) Key(cfg *Config, files []string) (string, error) {
+	// only what changes the results, not how fast or where they are computed
+	c := *cfg
+	c.LogFlags = LogFlags{}
+	c.Out, c.Procs, c.QueueSize, c.Deterministic, c.Mmap, c.AsyncDecode, c.StageTimes, c.ReadBuffer, c.Prefetch, c.FilesPerTask, c.MaxProcs, c.ReduceEvery = "", 0, 0, false, false, false, false, 0, 0, 0, 0, 0
+	c.Nice, c.IONice, c.Retries, c.RetryBackoff, c.Pprof, c.Metrics, c.OTLP, c.CPUProfile, c.MemProfile, c.Trace = 0, "", 0, 0, "", "", "", "", "", ""
+	c.ProgressEvery, c.Slowest, c.Notify, c.TUI, c.TmpDir, c.TmpLimit, c.Cache, c.ResultCache = 0, 0, "", false, "", 0, "", ""
+	settings, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	// another build may compute them differently
+	bi := GetBuildInfo()
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", bi.Version, bi.Commit)
+	h.Write(settings)
+	paths := append([]string{cfg.Schema, cfg.Expect}, classifyFiles(cfg.Classify)...)
+	for _, path := range append(paths, redactFiles(cfg.Redact)...) {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "\x00%s\x00%d\x00", path, len(data))
+		h.Write(data)
+	}
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "\x00%s\x00%d\x00%d", abs, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// Restore copies the cached results of key to out. It returns false on a miss.
+func (rc *ResultCache) Restore(key, out string) (bool, error) {
+	entries, err := os.ReadDir(filepath.Join(rc.dir, key))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if err := copyFile(filepath.Join(rc.dir, key, e.Name()), filepath.Join(out, e.Name())); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Store copies the result files of a run to the cache under key. The entry only appears once complete.
+func (rc *ResultCache) Store(key string, results []string) error {
+	if err := os.MkdirAll(rc.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(rc.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	for _, path := range results {
+		if err := copyFile(path, filepath.Join(tmp, filepath.Base(path))); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, filepath.Join(rc.dir, key)); err != nil {
+		os.RemoveAll(tmp)
+		if os.IsExist(err) {
+			// stored by a concurrent run
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// cachedRun restores the results of a run from the -result-cache, or runs it and stores them. Only results
+// written to -out are cached, so runs routing any elsewhere with -output run without the cache.
+func cachedRun[T any](cfg *Config, p *Pipeline[T], files []string, failures *Failures) error {
+	sink := p.sink
+	if ss, ok := sink.(*SplitSink); ok {
+		sink = ss.next
+	}
+	if ss, ok := sink.(*ShareSink); ok {
+		sink = ss.next
+	}
+	ds, ok := sink.(*DirSink)
+	if !ok {
+		slog.Warn("not using the result cache, it only keeps results written to -out", "output", cfg.Output)
+		return p.Run(files)
+	}
+
+	rc := NewResultCache(cfg.ResultCache)
+	key, err := rc.Key(cfg, files)
+	if err != nil {
+		slog.Warn("not using the result cache", "error", err)
+		return p.Run(files)
+	}
+	if hit, err := rc.Restore(key, cfg.Out); err != nil {
+		slog.Warn("failed to restore results from the cache", "key", key, "error", err)
+	} else if hit {
+		slog.Info("results restored from the cache, nothing changed", "key", key, "files", len(files))
+		return nil
+	}
+
+	if err := p.Run(files); err != nil {
+		return err
+	}
+	if failures.Count() > 0 {
+		return nil
+	}
+	if err := rc.Store(key, ds.Written()); err != nil {
+		slog.Warn("failed to cache the results", "key", key, "error", err)
+	}
+	return nil
+}
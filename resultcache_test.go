@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResultCacheKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	nets, input := filepath.Join(dir, "nets.csv"), filepath.Join(dir, "x.log")
+	os.WriteFile(nets, []byte("10.0.0.0/8,office\n"), 0o644)
+	os.WriteFile(input, []byte("10.1.2.3\n"), 0o644)
+
+	cfg := &Config{Redact: "0=cidr:" + nets}
+	rc := NewResultCache(t.TempDir())
+	before, err := rc.Key(cfg, []string{input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(nets, []byte("10.0.0.0/8,vpn\n"), 0o644)
+	after, err := rc.Key(cfg, []string{input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("the key does not change with the -redact networks")
+	}
+}
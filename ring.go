@@ -0,0 +1,86 @@
+package main
+
+import "io"
+
+// RingReader reads ahead from r in a separate goroutine into a small ring of buffers, so that
+// decompression overlaps with parsing in the consuming goroutine
+type RingReader struct {
+	full chan []byte
+	free chan []byte
+	done chan struct{}
+	err  error
+
+	buf []byte
+	cur []byte
+}
+
+func NewRingReader(r io.Reader, nbufs, size int) *RingReader {
+	rr := &RingReader{
+		full: make(chan []byte, nbufs),
+		free: make(chan []byte, nbufs),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < nbufs; i++ {
+		rr.free <- make([]byte, size)
+	}
+	go rr.fill(r)
+	return rr
+}
+
+func (rr *RingReader) fill(r io.Reader) {
+	for {
+		var buf []byte
+		select {
+		case buf = <-rr.free:
+		case <-rr.done:
+			return
+		}
+
+		// fill the whole buffer unless the stream ends or fails
+		n := 0
+		var err error
+		for n < len(buf) && err == nil {
+			var m int
+			m, err = r.Read(buf[n:])
+			n += m
+		}
+
+		if n > 0 {
+			select {
+			case rr.full <- buf[:n]:
+			case <-rr.done:
+				return
+			}
+		}
+		if err != nil {
+			// published to the reader by closing full
+			rr.err = err
+			close(rr.full)
+			return
+		}
+	}
+}
+
+func (rr *RingReader) Read(p []byte) (int, error) {
+	if len(rr.cur) == 0 {
+		if rr.buf != nil {
+			rr.free <- rr.buf[:cap(rr.buf)]
+			rr.buf = nil
+		}
+		buf, ok := <-rr.full
+		if !ok {
+			return 0, rr.err
+		}
+		rr.buf, rr.cur = buf, buf
+	}
+
+	n := copy(p, rr.cur)
+	rr.cur = rr.cur[n:]
+	return n, nil
+}
+
+// Close stops the read-ahead goroutine. It does not close the underlying reader.
+func (rr *RingReader) Close() error {
+	close(rr.done)
+	return nil
+}
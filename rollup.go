@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rollup counts a key under the ancestors of its last key column too, e.g. /a/b/c also under /a/b and /a
+// with the separator /. A depth above 0 limits the ancestors to that many levels, e.g. /a and /a/b for 2.
+// A nil Rollup counts the key alone.
+type Rollup struct {
+	sep   string
+	depth int
+}
+
+func NewRollup(sep string, depth int) (*Rollup, error) {
+	if depth < 0 {
+		return nil, fmt.Errorf("rollup depth %d must not be negative", depth)
+	}
+	if sep == "" {
+		return nil, nil
+	}
+	return &Rollup{sep, depth}, nil
+}
+
+// Ancestors calls f with every ancestor of key, shallowest first. columns is the number of key columns
+// joined in key; the ancestors keep the other columns as they are.
+func (ru *Rollup) Ancestors(key string, columns int, f func(ancestor string)) {
+	if ru == nil {
+		return
+	}
+	start := 0
+	if columns > 1 {
+		start = strings.LastIndexByte(key, ',') + 1
+	}
+	level := 0
+	for i := start + 1; i < len(key); {
+		j := strings.Index(key[i:], ru.sep)
+		if j < 0 {
+			return
+		}
+		i += j
+		if level += 1; ru.depth > 0 && level > ru.depth {
+			return
+		}
+		f(key[:i])
+		i += len(ru.sep)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Schema declares the columns records are expected to have. It is read from a small subset of YAML, or
+// from JSON:
+//
+//	columns: 4           # number of columns, defaults to the number of fields
+//	fields:
+//	  - name: ip
+//	    type: ip
+//	  - name: time
+//	    type: time         # in -time-layout and -tz
+//	  - name: status
+//	    type: int
+//	    pattern: "[1-5][0-9][0-9]"
+//	  - name: referer
+//	    optional: true     # may be empty
+//
+// Types are string (the default), int, uint, float, bool, ip and time. Columns past the fields are not
+// checked. The null_rate and example keys the infer command writes are only informative. The schema counts
+// the nonconforming records and is safe for concurrent use by the workers.
+type Schema struct {
+	Columns int           `json:"columns"`
+	Fields  []SchemaField `json:"fields"`
+
+	times         *TimeParser
+	nonconforming int64 // updated atomically
+}
+
+type SchemaField struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Optional bool    `json:"optional"`
+	Pattern  string  `json:"pattern"`
+	NullRate float64 `json:"null_rate,omitempty"`
+	Example  string  `json:"example,omitempty"`
+
+	named   bool
+	check   func(s string) bool
+	pattern *regexp.Regexp
+}
+
+var schemaTypes = map[string]func(s string) bool{
+	"string": func(s string) bool { return true },
+	"int":    func(s string) bool { _, err := strconv.ParseInt(s, 10, 64); return err == nil },
+	"uint":   func(s string) bool { _, err := strconv.ParseUint(s, 10, 64); return err == nil },
+	"float":  func(s string) bool { _, err := strconv.ParseFloat(s, 64); return err == nil },
+	"bool":   func(s string) bool { _, err := strconv.ParseBool(s); return err == nil },
+	"ip":     func(s string) bool { return net.ParseIP(s) != nil },
+}
+
+// LoadSchema reads a schema file. times parses the columns of type time.
+func LoadSchema(path string, times *TimeParser) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc := &Schema{times: times}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		err = json.Unmarshal(data, sc)
+	} else {
+		err = sc.parseYAML(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %v", path, err)
+	}
+	if err := sc.compile(); err != nil {
+		return nil, fmt.Errorf("schema %s: %v", path, err)
+	}
+	return sc, nil
+}
+
+// parseYAML reads top level scalars and a list of fields of scalars, which is all a schema needs
+func (sc *Schema) parseYAML(data string) error {
+	top, fields, err := readYAMLList(data)
+	if err != nil {
+		return err
+	}
+	for key, value := range top {
+		switch key {
+		case "columns":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("columns: %v", err)
+			}
+			sc.Columns = n
+		case "fields":
+		default:
+			return fmt.Errorf("unknown key %s", key)
+		}
+	}
+	for _, f := range fields {
+		var sf SchemaField
+		for key, value := range f {
+			switch key {
+			case "name":
+				sf.Name = value
+			case "type":
+				sf.Type = value
+			case "pattern":
+				sf.Pattern = value
+			case "optional":
+				sf.Optional = value == "true"
+			case "null_rate":
+				sf.NullRate, _ = strconv.ParseFloat(value, 64)
+			case "example":
+				sf.Example = value
+			default:
+				return fmt.Errorf("unknown field key %s", key)
+			}
+		}
+		sc.Fields = append(sc.Fields, sf)
+	}
+	return nil
+}
+
+func (sc *Schema) compile() error {
+	if sc.Columns == 0 {
+		sc.Columns = len(sc.Fields)
+	}
+	if sc.Columns == 0 || len(sc.Fields) > sc.Columns {
+		return fmt.Errorf("%d columns and %d fields", sc.Columns, len(sc.Fields))
+	}
+	for i := range sc.Fields {
+		f := &sc.Fields[i]
+		if f.named = f.Name != ""; !f.named {
+			f.Name = strconv.Itoa(i)
+		}
+		if f.Type == "" {
+			f.Type = "string"
+		}
+		if f.Type == "time" {
+			if sc.times == nil {
+				return fmt.Errorf("field %s: no time layout", f.Name)
+			}
+			f.check = func(s string) bool { _, err := sc.times.Parse(s); return err == nil }
+		} else if f.check = schemaTypes[f.Type]; f.check == nil {
+			return fmt.Errorf("field %s: unknown type %s", f.Name, f.Type)
+		}
+		if f.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + f.Pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("field %s: %v", f.Name, err)
+			}
+			f.pattern = re
+		}
+	}
+	return nil
+}
+
+// Check tells why a LogRecord or ByteRecord does not conform to the schema, and counts it
+func (sc *Schema) Check(rec interface{}) error {
+	var width int
+	var column func(i int) string
+	switch r := rec.(type) {
+	case LogRecord:
+		width, column = len(r), func(i int) string { return r[i] }
+	case ByteRecord:
+		width, column = len(r), func(i int) string { return string(r[i]) }
+	default:
+		return fmt.Errorf("no schema for %T records", rec)
+	}
+
+	err := sc.check(width, column)
+	if err != nil {
+		atomic.AddInt64(&sc.nonconforming, 1)
+	}
+	return err
+}
+
+func (sc *Schema) check(width int, column func(i int) string) error {
+	if width != sc.Columns {
+		return fmt.Errorf("schema: the record has %d columns, want %d", width, sc.Columns)
+	}
+	for i, f := range sc.Fields {
+		v := column(i)
+		if v == "" {
+			if f.Optional {
+				continue
+			}
+			return fmt.Errorf("schema: column %d (%s) is empty", i, f.Name)
+		}
+		if !f.check(v) {
+			return fmt.Errorf("schema: column %d (%s) %q is not a valid %s", i, f.Name, v, f.Type)
+		}
+		if f.pattern != nil && !f.pattern.MatchString(v) {
+			return fmt.Errorf("schema: column %d (%s) %q does not match %s", i, f.Name, v, f.Pattern)
+		}
+	}
+	return nil
+}
+
+// ValidateHeader compares the header of an input to the field names
+func (sc *Schema) ValidateHeader(header []string) error {
+	if len(header) != sc.Columns {
+		return fmt.Errorf("schema: the header has %d columns, want %d", len(header), sc.Columns)
+	}
+	for i, f := range sc.Fields {
+		if name := strings.TrimSpace(header[i]); f.named && name != f.Name {
+			return fmt.Errorf("schema: header column %d is %q, want %q", i, name, f.Name)
+		}
+	}
+	return nil
+}
+
+func (sc *Schema) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("nonconforming", atomic.LoadInt64(&sc.nonconforming)))
+}
+
+// Conformance applies a Schema to records of type T: as a Validator nonconforming records are bad records,
+// as a Filter they are dropped.
+type Conformance[T any] struct{ *Schema }
+
+func (c Conformance[T]) Validate(rec T) error { return c.Check(rec) }
+func (c Conformance[T]) Keep(rec T) bool      { return c.Check(rec) == nil }
+
+// readYAMLList reads the small subset of YAML of the schema and other rule files: top level scalars and
+// one list of items of scalars, in order
+func readYAMLList(data string) (map[string]string, []map[string]string, error) {
+	var item map[string]string
+	var items []map[string]string
+	top := map[string]string{}
+	for i, line := range strings.Split(data, "\n") {
+		if c := strings.Index(line, " #"); c >= 0 {
+			line = line[:c]
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "- ") {
+			item = map[string]string{}
+			items = append(items, item)
+			line = strings.TrimSpace(line[2:])
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("line %d: want key: value", i+1)
+		}
+		key, value = strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"'`)
+		if indented && item != nil {
+			item[key] = value
+		} else {
+			top[key] = value
+			item = nil
+		}
+	}
+	return top, items, nil
+}
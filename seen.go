@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// seenSpan is the earliest and latest time of a key and the number of its records
+type seenSpan struct {
+	first, last time.Time
+	count       int64
+}
+
+func (s *seenSpan) add(t time.Time, count int64) {
+	if s.count == 0 || t.Before(s.first) {
+		s.first = t
+	}
+	if s.count == 0 || t.After(s.last) {
+		s.last = t
+	}
+	s.count += count
+}
+
+// SeenReport records when each key was first and last seen by the -time-column, e.g. per user or IP for
+// account age and churn. Merging takes the earliest first and the latest last time, so the result does
+// not depend on the order of inputs or records.
+type SeenReport struct {
+	spans map[string]*seenSpan
+	spec  *KeySpec
+	keys  keyCache
+	times *TimeParser
+	norm  *Normalizer
+	empty *EmptyKeys
+}
+
+func NewSeenReport(spec *KeySpec, times *TimeParser) *SeenReport {
+	return &SeenReport{make(map[string]*seenSpan), spec, newKeyCache(spec), times, nil, nil}
+}
+
+// Normalize rewrites the key columns with n before they are looked up
+func (sr *SeenReport) Normalize(n *Normalizer) *SeenReport { sr.norm = n; return sr }
+
+// EmptyKeys handles empty key columns with ek
+func (sr *SeenReport) EmptyKeys(ek *EmptyKeys) *SeenReport { sr.empty = ek; return sr }
+
+func (sr *SeenReport) New() Report[LogRecord] {
+	return NewSeenReport(sr.spec, sr.times).Normalize(sr.norm).EmptyKeys(sr.empty)
+}
+
+func (sr *SeenReport) Name() string { return "seen" }
+func (sr *SeenReport) Clear()       { sr.spans = make(map[string]*seenSpan) }
+func (sr *SeenReport) Len() int     { return len(sr.spans) }
+
+func (sr *SeenReport) LogValue() slog.Value { return keysLogValue(len(sr.spans), sr.empty) }
+
+func (sr *SeenReport) Merge(rpt Report[LogRecord]) {
+	for k, o := range rpt.(*SeenReport).spans {
+		s, ok := sr.spans[k]
+		if !ok {
+			sr.spans[k] = o
+			continue
+		}
+		s.add(o.first, o.count)
+		s.add(o.last, 0)
+	}
+}
+
+func (sr *SeenReport) SetHeader(columns []string) error {
+	spec, err := sr.spec.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	sr.keys = newKeyCache(spec)
+	return nil
+}
+
+func (sr *SeenReport) Check(r LogRecord) error {
+	if _, err := sr.keys.columns(len(r)); err != nil {
+		return err
+	}
+	_, err := RecordTime(sr.times, r)
+	return err
+}
+
+// Add extends the span of the record's key by its time. Records rejected by Check are skipped.
+func (sr *SeenReport) Add(r LogRecord) {
+	keys, err := sr.keys.columns(len(r))
+	if err != nil {
+		return
+	}
+	t, err := RecordTime(sr.times, r)
+	if err != nil {
+		return
+	}
+	key, ok := joinKey(keys, r, sr.norm, sr.empty)
+	if !ok {
+		return
+	}
+	s, ok := sr.spans[key]
+	if !ok {
+		s = &seenSpan{}
+		sr.spans[key] = s
+	}
+	s.add(t, 1)
+}
+
+// Load adds the spans of a result written by Output
+func (sr *SeenReport) Load(path string) error {
+	return readResultLines(path, func(line string) error {
+		key, values, err := splitValues(line, 3)
+		if err != nil {
+			return err
+		}
+		first, err := time.Parse(time.RFC3339Nano, values[0])
+		if err != nil {
+			return err
+		}
+		last, err := time.Parse(time.RFC3339Nano, values[1])
+		if err != nil {
+			return err
+		}
+		count, err := strconv.ParseInt(values[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		s, ok := sr.spans[key]
+		if !ok {
+			s = &seenSpan{}
+			sr.spans[key] = s
+		}
+		s.add(first, count)
+		s.add(last, 0)
+		return nil
+	})
+}
+
+// Output writes key,first,last,count lines sorted by key, the times in RFC 3339 in the -tz
+func (sr *SeenReport) Output(path string) {
+	keys := make([]string, 0, len(sr.spans))
+	for k := range sr.spans {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for _, k := range keys {
+		s := sr.spans[k]
+		fmt.Fprintf(w, "%s,%s,%s,%d\n", k, s.first.Format(time.RFC3339Nano), s.last.Format(time.RFC3339Nano), s.count)
+	}
+	w.Flush()
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job is a run submitted to the job server
+type Job struct {
+	ID       string     `json:"id"`
+	State    string     `json:"state"` // running, done, failed or canceled
+	Error    string     `json:"error,omitempty"`
+	Failed   int        `json:"failed_files"`
+	Progress Progress   `json:"progress"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	cfg     Config
+	control *Control
+	seen    Progress  // progress at the last health check
+	seenAt  time.Time // when the progress last changed
+}
+
+// JobServer runs jobs submitted over HTTP, each writing its results to its own directory
+type JobServer struct {
+	sync.Mutex
+	dir    string
+	jobs   map[string]*Job
+	nextID int
+
+	// StallTimeout fails /healthz when a running job makes no progress for this long, 0 to never fail
+	StallTimeout time.Duration
+	// Token is the bearer token of every request but the health checks
+	Token string
+	// Inputs is the directory the inputs, schemas, manifests and rule files of the jobs must be in, the
+	// jobs directory when empty
+	Inputs string
+}
+
+func NewJobServer(dir string) *JobServer {
+	return &JobServer{dir: dir, jobs: make(map[string]*Job), StallTimeout: 10 * time.Minute}
+}
+
+func (js *JobServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", js.auth(js.submit))
+	mux.HandleFunc("GET /jobs", js.auth(js.list))
+	mux.HandleFunc("GET /jobs/{id}", js.auth(js.get))
+	mux.HandleFunc("DELETE /jobs/{id}", js.auth(js.cancel))
+	mux.HandleFunc("GET /jobs/{id}/results", js.auth(js.results))
+	mux.HandleFunc("GET /jobs/{id}/results/{name}", js.auth(js.result))
+	mux.HandleFunc("GET /metrics", js.auth(MetricsHandler))
+	mux.HandleFunc("GET /healthz", js.healthz)
+	mux.HandleFunc("GET /readyz", js.readyz)
+	return mux
+}
+
+// auth rejects requests without the bearer token, and all of them when the server has none
+func (js *JobServer) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || js.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(js.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong bearer token"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// jobSettings are the run flags a job may set: what is computed and how fast, but nothing that writes
+// outside the job directory, deletes or moves inputs, listens, calls out, or runs other programs
+var jobSettings = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Fields(`in skip-dotfiles skip-empty min-size max-size shares procs max-procs
+		comma keys normalize rewrite empty-keys rollup rollup-depth group-by top pair-with state-columns
+		spill-size window threshold counters distinct-columns extract-format extract-columns split-by split-time
+		time-bucket hll-precision session-by sum-column number-locale accumulate buckets classify units redact
+		redact-key header skip-lines skip-footer comment ragged schema strict time-column time-layout from to tz
+		deterministic queue records parser reports report-filter on-error max-failed-files max-files
+		max-read-mbps max-open-files max-input-bytes retries retry-backoff aggregate slowest shards
+		dispatch-reports stage-times read-buffer files-per-task prefetch async-decode mmap reduce-every
+		progress-every expect expect-warn dedup cache-columns audit`) {
+		jobSettings[name] = true
+	}
+}
+
+// jobConfig reads the settings of a job, which must be jobSettings, and confines the files it reads to
+// the inputs directory
+func (js *JobServer) jobConfig(data []byte) (Config, error) {
+	var cfg Config
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return cfg, err
+	}
+	for name := range values {
+		if !jobSettings[name] {
+			return cfg, fmt.Errorf("setting %s is not allowed in jobs", name)
+		}
+	}
+	if err := cfg.setValues(values); err != nil {
+		return cfg, err
+	}
+
+	root := js.Inputs
+	if root == "" {
+		root = js.dir
+	}
+	var err error
+	for _, path := range []*string{&cfg.In, &cfg.Schema, &cfg.Expect} {
+		if *path != "" {
+			if *path, err = confinePath(root, *path); err != nil {
+				return cfg, err
+			}
+		}
+	}
+	if cfg.Classify, err = confineRules(root, cfg.Classify, ""); err != nil {
+		return cfg, err
+	}
+	if cfg.Redact, err = confineRules(root, cfg.Redact, "cidr:"); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// confineRules confines the files of the columns=action rules, separated by ;, whose action is prefix
+// followed by a file, e.g. the rule files of -classify or the cidr: buckets of -redact
+func confineRules(root, rules, prefix string) (string, error) {
+	if rules == "" {
+		return "", nil
+	}
+	list := strings.Split(rules, ";")
+	for i, rule := range list {
+		columns, action, ok := strings.Cut(rule, "=")
+		if !ok {
+			continue
+		}
+		if path, ok := strings.CutPrefix(strings.TrimSpace(action), prefix); ok {
+			path, err := confinePath(root, path)
+			if err != nil {
+				return "", err
+			}
+			list[i] = columns + "=" + prefix + path
+		}
+	}
+	return strings.Join(list, ";"), nil
+}
+
+// confinePath resolves a path relative to root, following symbolic links, and fails unless it is in root
+func confinePath(root, path string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	if realRoot, err = filepath.Abs(realRoot); err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(realRoot, path)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(realRoot, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in %s", path, root)
+	}
+	return real, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// submit starts a job from a JSON config with the jobSettings of the run flags. The output directory is
+// always the job's own directory.
+func (js *JobServer) submit(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job := &Job{State: "running", Started: time.Now(), control: NewControl()}
+	job.seenAt = job.Started
+	if job.cfg, err = js.jobConfig(data); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	js.Lock()
+	js.nextID += 1
+	job.ID = strconv.Itoa(js.nextID)
+	js.jobs[job.ID] = job
+	js.Unlock()
+
+	job.cfg.Out = filepath.Join(js.dir, job.ID)
+	if err := os.MkdirAll(job.cfg.Out, 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := job.cfg.Validate(); err != nil {
+		js.finish(job, nil, err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	go func() {
+		slog.Info("job started", "job", job.ID, "in", job.cfg.In)
+		failures, err := job.cfg.RunWith(job.control)
+		js.finish(job, failures, err)
+		slog.Info("job finished", "job", job.ID, "state", job.State)
+	}()
+	writeJSON(w, http.StatusCreated, js.snapshot(job))
+}
+
+func (js *JobServer) finish(job *Job, failures *Failures, err error) {
+	js.Lock()
+	defer js.Unlock()
+
+	now := time.Now()
+	job.Finished = &now
+	if failures != nil {
+		job.Failed = failures.Count()
+	}
+	switch {
+	case err == ErrCanceled:
+		job.State = "canceled"
+	case err != nil:
+		job.State = "failed"
+		job.Error = err.Error()
+	default:
+		job.State = "done"
+	}
+}
+
+func (js *JobServer) snapshot(job *Job) Job {
+	js.Lock()
+	defer js.Unlock()
+	j := *job
+	j.Progress = job.control.Progress()
+	return j
+}
+
+func (js *JobServer) lookup(w http.ResponseWriter, r *http.Request) *Job {
+	js.Lock()
+	job := js.jobs[r.PathValue("id")]
+	js.Unlock()
+	if job == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such job: %s", r.PathValue("id")))
+	}
+	return job
+}
+
+func (js *JobServer) list(w http.ResponseWriter, r *http.Request) {
+	js.Lock()
+	jobs := make([]*Job, 0, len(js.jobs))
+	for _, job := range js.jobs {
+		jobs = append(jobs, job)
+	}
+	js.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.Before(jobs[j].Started) })
+	snapshots := make([]Job, len(jobs))
+	for i, job := range jobs {
+		snapshots[i] = js.snapshot(job)
+	}
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+func (js *JobServer) get(w http.ResponseWriter, r *http.Request) {
+	if job := js.lookup(w, r); job != nil {
+		writeJSON(w, http.StatusOK, js.snapshot(job))
+	}
+}
+
+func (js *JobServer) cancel(w http.ResponseWriter, r *http.Request) {
+	if job := js.lookup(w, r); job != nil {
+		job.control.Cancel()
+		writeJSON(w, http.StatusAccepted, js.snapshot(job))
+	}
+}
+
+func (js *JobServer) results(w http.ResponseWriter, r *http.Request) {
+	job := js.lookup(w, r)
+	if job == nil {
+		return
+	}
+	if j := js.snapshot(job); j.State != "done" {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s is %s", j.ID, j.State))
+		return
+	}
+
+	fis, err := ioutil.ReadDir(job.cfg.Out)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (js *JobServer) result(w http.ResponseWriter, r *http.Request) {
+	job := js.lookup(w, r)
+	if job == nil {
+		return
+	}
+	name := filepath.Base(r.PathValue("name"))
+	http.ServeFile(w, r, filepath.Join(job.cfg.Out, name))
+}
+
+// healthz reports the lag of the running jobs, the time since their progress last changed, and fails
+// when one of them is stalled
+func (js *JobServer) healthz(w http.ResponseWriter, r *http.Request) {
+	js.Lock()
+	defer js.Unlock()
+
+	now := time.Now()
+	running, lag := 0, time.Duration(0)
+	stalled := []string{}
+	for _, job := range js.jobs {
+		if job.State != "running" {
+			continue
+		}
+		running += 1
+		if prog := job.control.Progress(); prog != job.seen {
+			job.seen, job.seenAt = prog, now
+		}
+		jobLag := now.Sub(job.seenAt)
+		lag = max(lag, jobLag)
+		if js.StallTimeout > 0 && jobLag > js.StallTimeout {
+			stalled = append(stalled, job.ID)
+		}
+	}
+	sort.Strings(stalled)
+
+	status, code := "ok", http.StatusOK
+	if len(stalled) > 0 {
+		status, code = "stalled", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "running_jobs": running,
+		"lag_seconds": lag.Seconds(), "stalled_jobs": stalled})
+}
+
+// readyz tells whether jobs can be accepted, which needs a writable jobs directory
+func (js *JobServer) readyz(w http.ResponseWriter, r *http.Request) {
+	err := os.MkdirAll(js.dir, 0755)
+	if err == nil {
+		var fp *os.File
+		if fp, err = ioutil.TempFile(js.dir, ".readyz"); err == nil {
+			fp.Close()
+			os.Remove(fp.Name())
+		}
+	}
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func serveFlags(fs *flag.FlagSet) (addr, dir *string, stallTimeout *time.Duration, token, inputs *string) {
+	return fs.String("addr", ":8080", "listen address"), fs.String("dir", "jobs", "directory for the results of the jobs"),
+		fs.Duration("stall-timeout", 10*time.Minute, "fail /healthz when a running job makes no progress for this long, 0 to never fail"),
+		fs.String("token", "", "bearer token every request but /healthz and /readyz must send, required; GOLOPRO_TOKEN keeps it off the command line"),
+		fs.String("inputs", "", "directory the inputs, schemas, manifests and classify rules of the jobs must be in, -dir when empty")
+}
+
+func serveCommand(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr, dir, stallTimeout, token, inputs := serveFlags(fs)
+	var lf LogFlags
+	lf.RegisterFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := lf.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "serve: -token is required")
+		return 2
+	}
+
+	js := NewJobServer(*dir)
+	js.StallTimeout, js.Token, js.Inputs = *stallTimeout, *token, *inputs
+	slog.Info("serving jobs", "addr", *addr, "dir", *dir)
+	if err := http.ListenAndServe(*addr, js.Handler()); err != nil {
+		slog.Error("serve failed", "error", err)
+		return 1
+	}
+	return 0
+}
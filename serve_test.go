@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJobConfigConfinesFiles(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	for _, dir := range []string{root, outside} {
+		os.WriteFile(filepath.Join(dir, "nets.csv"), []byte("10.0.0.0/8,office\n"), 0o644)
+	}
+	js := NewJobServer(t.TempDir())
+	js.Inputs = root
+
+	cfg, err := js.jobConfig([]byte(`{"redact": "0=cidr:nets.csv;1=mask"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	real, _ := filepath.EvalSymlinks(filepath.Join(root, "nets.csv"))
+	if cfg.Redact != "0=cidr:"+real+";1=mask" {
+		t.Errorf("redact %q", cfg.Redact)
+	}
+	for _, job := range []string{
+		`{"redact": "0=cidr:` + filepath.Join(outside, "nets.csv") + `"}`,
+		`{"redact": "0=cidr:../` + filepath.Base(outside) + `/nets.csv"}`,
+		`{"classify": "0=` + filepath.Join(outside, "nets.csv") + `"}`,
+	} {
+		if _, err := js.jobConfig([]byte(job)); err == nil || !strings.Contains(err.Error(), "is not in") {
+			t.Errorf("%s: error %v", job, err)
+		}
+	}
+}
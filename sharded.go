@@ -0,0 +1,140 @@
+package main
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"sync"
+)
+
+// ShardedCounts is a counter map split into independently locked shards, so all workers can count into
+// one map instead of cloning and merging it. It pays off for low-cardinality keys.
+type ShardedCounts struct {
+	shards []countShard
+}
+
+type countShard struct {
+	sync.Mutex
+	counts map[string]int64
+}
+
+func NewShardedCounts(nshards int) *ShardedCounts {
+	if nshards < 1 {
+		nshards = 1
+	}
+	sc := &ShardedCounts{make([]countShard, nshards)}
+	for i := range sc.shards {
+		sc.shards[i].counts = make(map[string]int64)
+	}
+	return sc
+}
+
+func (sc *ShardedCounts) shard(key string) *countShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &sc.shards[h.Sum32()%uint32(len(sc.shards))]
+}
+
+func (sc *ShardedCounts) Add(key string, n int64) {
+	s := sc.shard(key)
+	s.Lock()
+	s.counts[key] += n
+	s.Unlock()
+}
+
+// Range calls fn for every key, holding one shard lock at a time
+func (sc *ShardedCounts) Range(fn func(key string, count int64)) {
+	for i := range sc.shards {
+		s := &sc.shards[i]
+		s.Lock()
+		for k, v := range s.counts {
+			fn(k, v)
+		}
+		s.Unlock()
+	}
+}
+
+func (sc *ShardedCounts) Len() int {
+	n := 0
+	for i := range sc.shards {
+		s := &sc.shards[i]
+		s.Lock()
+		n += len(s.counts)
+		s.Unlock()
+	}
+	return n
+}
+
+func (sc *ShardedCounts) Clear() {
+	for i := range sc.shards {
+		s := &sc.shards[i]
+		s.Lock()
+		s.counts = make(map[string]int64)
+		s.Unlock()
+	}
+}
+
+// ShardedQuickReport counts like QuickReport, but every worker shares the same ShardedCounts
+type ShardedQuickReport struct {
+	counts *ShardedCounts
+	keys   *KeySpec
+	norm   *Normalizer
+	empty  *EmptyKeys
+	rollup *Rollup
+}
+
+func NewShardedQuickReport(keys *KeySpec, nshards int) *ShardedQuickReport {
+	return &ShardedQuickReport{NewShardedCounts(nshards), keys, nil, nil, nil}
+}
+
+// Normalize rewrites the key columns with n before counting
+func (sr *ShardedQuickReport) Normalize(n *Normalizer) *ShardedQuickReport { sr.norm = n; return sr }
+
+// EmptyKeys handles empty key columns with ek
+func (sr *ShardedQuickReport) EmptyKeys(ek *EmptyKeys) *ShardedQuickReport { sr.empty = ek; return sr }
+
+// Rollup counts the records under the ancestors of their key with ru too
+func (sr *ShardedQuickReport) Rollup(ru *Rollup) *ShardedQuickReport { sr.rollup = ru; return sr }
+
+func (sr *ShardedQuickReport) LogValue() slog.Value { return keysLogValue(sr.Len(), sr.empty) }
+
+func (sr *ShardedQuickReport) Shared()                {}
+func (sr *ShardedQuickReport) New() Report[LogRecord] { return sr }
+func (sr *ShardedQuickReport) Name() string           { return "quick" }
+func (sr *ShardedQuickReport) Clear()                 { sr.counts.Clear() }
+func (sr *ShardedQuickReport) Len() int               { return sr.counts.Len() }
+func (sr *ShardedQuickReport) Top(n int) []KeyCount   { return topCounts(n, sr.counts.Range) }
+
+func (sr *ShardedQuickReport) Check(r LogRecord) error {
+	_, err := sr.keys.Columns(len(r))
+	return err
+}
+
+func (sr *ShardedQuickReport) Add(r LogRecord) {
+	if keys, err := sr.keys.Columns(len(r)); err == nil {
+		if key, ok := joinKey(keys, r, sr.norm, sr.empty); ok {
+			sr.counts.Add(key, 1)
+			sr.rollup.Ancestors(key, len(keys), func(k string) { sr.counts.Add(k, 1) })
+		}
+	}
+}
+
+func (sr *ShardedQuickReport) Merge(rpt Report[LogRecord]) {
+	nr := rpt.(*ShardedQuickReport)
+	if nr == sr {
+		return
+	}
+	nr.counts.Range(func(k string, v int64) { sr.counts.Add(k, v) })
+}
+
+func (sr *ShardedQuickReport) Output(path string) { WriteCounts(path, sr.counts.Range) }
+
+func (sr *ShardedQuickReport) Load(path string) error {
+	counts := make(map[string]int64)
+	if err := readCounts(path, counts); err != nil {
+		return err
+	}
+	for k, v := range counts {
+		sr.counts.Add(k, v)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// LockedReport makes any report safe to share between all workers, for -aggregate shared, by serializing
+// its methods with a mutex. One report instead of one per worker saves memory and the final reduce, but
+// the workers wait on each other, so it only pays off for cheap reports with few keys.
+type LockedReport[T any] struct {
+	mu  sync.Mutex
+	rpt Report[T]
+}
+
+func NewLockedReport[T any](rpt Report[T]) *LockedReport[T] { return &LockedReport[T]{rpt: rpt} }
+
+func (lr *LockedReport[T]) Shared()        {}
+func (lr *LockedReport[T]) New() Report[T] { return lr }
+func (lr *LockedReport[T]) Name() string   { return lr.rpt.Name() }
+
+func (lr *LockedReport[T]) Add(rec T) {
+	lr.mu.Lock()
+	lr.rpt.Add(rec)
+	lr.mu.Unlock()
+}
+
+func (lr *LockedReport[T]) Merge(rpt Report[T]) {
+	if rpt == Report[T](lr) {
+		return
+	}
+	if nlr, ok := rpt.(*LockedReport[T]); ok {
+		nlr.mu.Lock()
+		defer nlr.mu.Unlock()
+		rpt = nlr.rpt
+	}
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.rpt.Merge(rpt)
+}
+
+func (lr *LockedReport[T]) Clear() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.rpt.Clear()
+}
+
+func (lr *LockedReport[T]) Output(path string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.rpt.Output(path)
+}
+
+func (lr *LockedReport[T]) Extension() string { return extensionOf(lr.rpt) }
+
+func (lr *LockedReport[T]) Check(rec T) error {
+	c, ok := lr.rpt.(RecordChecker[T])
+	if !ok {
+		return nil
+	}
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return c.Check(rec)
+}
+
+func (lr *LockedReport[T]) SetWorkspace(ws *Workspace) {
+	if wr, ok := lr.rpt.(WorkspaceReport); ok {
+		wr.SetWorkspace(ws)
+	}
+}
+
+func (lr *LockedReport[T]) Len() int {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if sized, ok := lr.rpt.(SizedReport); ok {
+		return sized.Len()
+	}
+	return 0
+}
+
+func (lr *LockedReport[T]) Top(n int) []KeyCount {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if top, ok := lr.rpt.(TopReport); ok {
+		return top.Top(n)
+	}
+	return nil
+}
+
+func (lr *LockedReport[T]) loads() bool { return canLoad(lr.rpt) }
+
+func (lr *LockedReport[T]) Load(path string) error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if l, ok := lr.rpt.(Loader); ok {
+		return l.Load(path)
+	}
+	return fmt.Errorf("report %s cannot load its results", lr.rpt.Name())
+}
+
+func (lr *LockedReport[T]) LogValue() slog.Value {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	attrs := []slog.Attr{slog.String("aggregate", "shared")}
+	if lv, ok := lr.rpt.(slog.LogValuer); ok {
+		if v := lv.LogValue(); v.Kind() == slog.KindGroup {
+			attrs = append(attrs, v.Group()...)
+		} else {
+			attrs = append(attrs, slog.Any("stats", v))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
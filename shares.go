@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/csv"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// CountReport is implemented by reports whose result is a count per key. EachCount calls fn for every key
+// and returns true, or returns false without calling fn when the report does not count, e.g. a wrapper of
+// another report.
+type CountReport interface {
+	EachCount(fn func(key string, count int64)) bool
+}
+
+func (r *DefaultReport) EachCount(fn func(string, int64)) bool {
+	for k, v := range r.result {
+		fn(k, v)
+	}
+	return true
+}
+
+func (sr *ShardedQuickReport) EachCount(fn func(string, int64)) bool {
+	sr.counts.Range(fn)
+	return true
+}
+
+func (br *BytesQuickReport) EachCount(fn func(string, int64)) bool {
+	for k, v := range br.result {
+		fn(k, *v)
+	}
+	return true
+}
+
+func (lr *LockedReport[T]) EachCount(fn func(string, int64)) bool {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if cr, ok := lr.rpt.(CountReport); ok {
+		return cr.EachCount(fn)
+	}
+	return false
+}
+
+func (fr *FilteredReport[T]) EachCount(fn func(string, int64)) bool {
+	if cr, ok := fr.rpt.(CountReport); ok {
+		return cr.EachCount(fn)
+	}
+	return false
+}
+
+// ShareSink writes, next to the result of every counting report, its shares as result-NAME-shares.csv
+// for Pareto analysis: the keys by count with their percentage of the total and the cumulative percentage
+// of the keys up to them. The result of the report itself stays as it is, so it can still be merged and
+// compared.
+type ShareSink struct {
+	next Sink
+}
+
+func NewShareSink(next Sink) *ShareSink { return &ShareSink{next: next} }
+
+func (ss *ShareSink) Write(rpt Result) error {
+	if err := ss.next.Write(rpt); err != nil {
+		return err
+	}
+	cr, ok := rpt.(CountReport)
+	if !ok {
+		return nil
+	}
+	var counts []KeyCount
+	if !cr.EachCount(func(k string, v int64) { counts = append(counts, KeyCount{k, v}) }) {
+		return nil
+	}
+	return ss.next.Write(SharesResult{rpt.Name() + "-shares", counts})
+}
+
+// SharesResult writes key,count,percent,cumulative lines with a header, the most frequent key first and
+// equal counts by key
+type SharesResult struct {
+	name   string
+	counts []KeyCount
+}
+
+func (sr SharesResult) Name() string      { return sr.name }
+func (sr SharesResult) Extension() string { return ".csv" }
+
+func (sr SharesResult) Output(path string) {
+	sort.Slice(sr.counts, func(i, j int) bool {
+		a, b := sr.counts[i], sr.counts[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Key < b.Key
+	})
+	var total int64
+	for _, kc := range sr.counts {
+		total += kc.Count
+	}
+
+	fp, err := os.Create(path)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+	// keys of several columns, or with quotes, are quoted as one field
+	w := csv.NewWriter(fp)
+	w.Write([]string{"key", "count", "percent", "cumulative"})
+	var cum int64
+	for _, kc := range sr.counts {
+		cum += kc.Count
+		w.Write([]string{kc.Key, strconv.FormatInt(kc.Count, 10), strconv.FormatFloat(percent(kc.Count, total), 'f', 2, 64),
+			strconv.FormatFloat(percent(cum, total), 'f', 2, 64)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+	}
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
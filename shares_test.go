@@ -0,0 +1,20 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestSharesOutput(t *testing.T) {
+	sr := SharesResult{"quick-shares", []KeyCount{{"a,b", 1}, {"c\"d", 3}}}
+	path := t.TempDir() + "/shares.csv"
+	sr.Output(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "key,count,percent,cumulative\n\"c\"\"d\",3,75.00,75.00\n\"a,b\",1,25.00,100.00\n"
+	if string(data) != want {
+		t.Errorf("output\n%s\nwant\n%s", data, want)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RouteSink writes the results named in its routes to their own destination and the other results to the
+// next sink, e.g. the top keys to stdout and the sums to S3 while the rest goes to -out. A destination is
+// "-" for standard output, s3://bucket/key for an S3 object uploaded with the credentials of -task-queue
+// sqs://, or else a file, whose directory is created.
+//
+// The format of a result is the report's own, so a file or object should end in its Extension. Results
+// for stdout and S3 are written to a temporary file in dir first, the -out directory.
+type RouteSink struct {
+	next   Sink
+	routes map[string]string
+	dir    string
+	stdout io.Writer
+}
+
+func NewRouteSink(next Sink, routes map[string]string, dir string) *RouteSink {
+	return &RouteSink{next: next, routes: routes, dir: dir, stdout: os.Stdout}
+}
+
+// ParseRoutes parses comma separated name=destination pairs, e.g. quick=-,sum=s3://bucket/sums.csv
+func ParseRoutes(s string) (map[string]string, error) {
+	routes := make(map[string]string)
+	for _, route := range strings.Split(s, ",") {
+		if route = strings.TrimSpace(route); route == "" {
+			continue
+		}
+		name, dest, ok := strings.Cut(route, "=")
+		if !ok || name == "" || dest == "" {
+			return nil, fmt.Errorf("output %s: want report=destination", route)
+		}
+		if _, ok := routes[name]; ok {
+			return nil, fmt.Errorf("output %s: %s routed twice", route, name)
+		}
+		if strings.HasPrefix(dest, "s3://") {
+			if _, _, err := s3Object(dest); err != nil {
+				return nil, fmt.Errorf("output %s: %v", route, err)
+			}
+		}
+		routes[name] = dest
+	}
+	return routes, nil
+}
+
+func (rs *RouteSink) Write(rpt Result) error {
+	dest, ok := rs.routes[rpt.Name()]
+	if !ok {
+		return rs.next.Write(rpt)
+	}
+	if _, ok := rpt.(Discarder); ok {
+		return nil
+	}
+	if dest != "-" && !strings.HasPrefix(dest, "s3://") {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		return outputFile(rpt, dest)
+	}
+
+	// results write to a path, so they go through a temporary file
+	tmp, err := outputTemp(rpt, rs.dir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	data, err := ioutil.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	if dest == "-" {
+		_, err = rs.stdout.Write(data)
+		return err
+	}
+	if err := PutS3(dest, data); err != nil {
+		return fmt.Errorf("result %s: %v", rpt.Name(), err)
+	}
+	return nil
+}
+
+// outputTemp writes a result to a new temporary file in dir and returns its path. Output only logs its
+// errors, so the file is created by Output alone, and a result that could not be written fails for the
+// missing file.
+func outputTemp(rpt Result, dir string) (string, error) {
+	tmp, err := ioutil.TempFile(dir, ".result-"+rpt.Name()+"-*")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	rpt.Output(tmp.Name())
+	if _, err := os.Stat(tmp.Name()); err != nil {
+		return "", fmt.Errorf("result %s was not written", rpt.Name())
+	}
+	return tmp.Name(), nil
+}
+
+// outputFile writes a result to path through a temporary file in its directory, so a result that could
+// not be written leaves the previous one in place and returns an error
+func outputFile(rpt Result, path string) error {
+	tmp, err := outputTemp(rpt, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// s3Object splits s3://bucket/key
+func s3Object(dest string) (bucket, key string, err error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return "", "", fmt.Errorf("want s3://bucket/key")
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// PutS3 uploads data to s3://bucket/key in the AWS_REGION, signed like SQSQueue requests. AWS_ENDPOINT_URL
+// replaces the AWS endpoint, e.g. for S3 compatible stores, with the bucket in the path.
+func PutS3(dest string, data []byte) error {
+	bucket, key, err := s3Object(dest)
+	if err != nil {
+		return err
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		return fmt.Errorf("s3: AWS_REGION is not set")
+	}
+	target := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, key)
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		target = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + key
+	}
+	req, err := http.NewRequest("PUT", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	if err := signV4(req, data, req.URL.Host, region, "s3", time.Now().UTC()); err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 put %s: %s: %s", dest, resp.Status, body)
+	}
+	return nil
+}
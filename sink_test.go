@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// textResult writes its text, or fails to write without creating the file, like a report logging the
+// error
+type textResult struct {
+	name, text string
+	fail       bool
+}
+
+func (tr textResult) Name() string { return tr.name }
+func (tr textResult) Output(path string) {
+	if !tr.fail {
+		ioutil.WriteFile(path, []byte(tr.text), 0644)
+	}
+}
+
+func TestRouteSink(t *testing.T) {
+	out, dir := t.TempDir(), t.TempDir()
+	var stdout strings.Builder
+	rs := NewRouteSink(NewDirSink(out), map[string]string{"a": "-", "b": dir + "/sub/b.txt"}, out)
+	rs.stdout = &stdout
+
+	for _, r := range []textResult{{name: "a", text: "a,1\n"}, {name: "b", text: "b,2\n"}, {name: "c", text: "c,3\n"}} {
+		if err := rs.Write(r); err != nil {
+			t.Fatalf("%s: %v", r.name, err)
+		}
+	}
+	if stdout.String() != "a,1\n" {
+		t.Errorf("stdout %q", stdout.String())
+	}
+	for path, want := range map[string]string{dir + "/sub/b.txt": "b,2\n", out + "/result-c.txt": "c,3\n"} {
+		if data, err := ioutil.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("%s: %q, %v", path, data, err)
+		}
+	}
+	if tmps, _ := filepath.Glob(out + "/.result-*"); len(tmps) > 0 {
+		t.Errorf("temporary files left: %v", tmps)
+	}
+}
+
+func TestRouteSinkFailed(t *testing.T) {
+	out := t.TempDir()
+	rs := NewRouteSink(NewDirSink(out), map[string]string{"a": "-", "b": out + "/b.txt"}, out)
+	rs.stdout = ioutil.Discard
+	ioutil.WriteFile(out+"/b.txt", []byte("old\n"), 0644)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := rs.Write(textResult{name: name, fail: true}); err == nil {
+			t.Errorf("%s: no error for a result that was not written", name)
+		}
+	}
+	if data, _ := ioutil.ReadFile(out + "/b.txt"); string(data) != "old\n" {
+		t.Errorf("previous result replaced with %q", data)
+	}
+	if _, err := os.Stat(out + "/result-c.txt"); err == nil {
+		t.Error("result-c.txt written")
+	}
+	if err := rs.Write(NopReport[LogRecord]{}); err != nil {
+		t.Errorf("nop: %v", err)
+	}
+}
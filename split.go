@@ -0,0 +1,97 @@
+package main
+
+import "time"
+
+// SplitReport is implemented by reports whose result may be written in parts, e.g. the extract report with
+// -split-by. Parts returns the names of the parts, or nil when the result is not split, e.g. a wrapper of
+// another report.
+type SplitReport interface {
+	Parts() []string
+	OutputPart(part, path string)
+}
+
+func (lr *LockedReport[T]) Parts() []string {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if sr, ok := lr.rpt.(SplitReport); ok {
+		return sr.Parts()
+	}
+	return nil
+}
+
+func (lr *LockedReport[T]) OutputPart(part, path string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.rpt.(SplitReport).OutputPart(part, path)
+}
+
+func (fr *FilteredReport[T]) Parts() []string {
+	if sr, ok := fr.rpt.(SplitReport); ok {
+		return sr.Parts()
+	}
+	return nil
+}
+
+func (fr *FilteredReport[T]) OutputPart(part, path string) {
+	fr.rpt.(SplitReport).OutputPart(part, path)
+}
+
+// SplitSink writes the parts of a split result as results of their own named NAME-PART, e.g.
+// result-extract-web.csv, so -output can route them one by one. Other results go to the next sink as they
+// are.
+type SplitSink struct {
+	next Sink
+}
+
+func NewSplitSink(next Sink) *SplitSink { return &SplitSink{next: next} }
+
+func (ss *SplitSink) Write(rpt Result) error {
+	sr, ok := rpt.(SplitReport)
+	if !ok {
+		return ss.next.Write(rpt)
+	}
+	parts := sr.Parts()
+	if parts == nil {
+		return ss.next.Write(rpt)
+	}
+	for _, part := range parts {
+		if err := ss.next.Write(partResult{rpt.Name() + "-" + part, extensionOf(rpt), part, sr}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type partResult struct {
+	name, ext, part string
+	rpt             SplitReport
+}
+
+func (pr partResult) Name() string       { return pr.name }
+func (pr partResult) Extension() string  { return pr.ext }
+func (pr partResult) Output(path string) { pr.rpt.OutputPart(pr.part, path) }
+
+// splitLayout returns the layout of the time buckets of -split-time in part names, as precise as the
+// bucket and without colons
+func splitLayout(bucket string) string {
+	switch bucket {
+	case "minute":
+		return "2006-01-02T15-04"
+	case "hour":
+		return "2006-01-02T15"
+	case "day", "week":
+		return "2006-01-02"
+	case "month":
+		return "2006-01"
+	}
+	d, _ := time.ParseDuration(bucket)
+	switch {
+	case d%(24*time.Hour) == 0:
+		return "2006-01-02"
+	case d%time.Hour == 0:
+		return "2006-01-02T15"
+	case d%time.Minute == 0:
+		return "2006-01-02T15-04"
+	}
+	return "2006-01-02T15-04-05"
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// SQLReport evaluates a SQL query over all records with DuckDB, for joins, window functions and other
+// queries the reports cannot express. Every worker spools its records as CSV to the scratch workspace,
+// and Output loads the spools of all workers into a DuckDB table named records and writes the result of
+// the query. DuckDB runs as its command line binary, which keeps cgo and a driver out of the build.
+type SQLReport struct {
+	query  string
+	duckdb string // path of the duckdb binary
+
+	ws      *Workspace
+	spool   *ScratchFile
+	w       *csv.Writer
+	files   []string // closed spools, of this report and the ones merged into it
+	header  []string
+	width   int
+	records int64
+	err     error
+}
+
+func NewSQLReport(query, duckdb string) *SQLReport {
+	return &SQLReport{query: strings.TrimRight(strings.TrimSpace(query), ";"), duckdb: duckdb}
+}
+
+func (sr *SQLReport) New() Report[LogRecord] {
+	return &SQLReport{query: sr.query, duckdb: sr.duckdb, ws: sr.ws}
+}
+
+func (sr *SQLReport) Name() string               { return "sql" }
+func (sr *SQLReport) Extension() string          { return ".csv" }
+func (sr *SQLReport) SetWorkspace(ws *Workspace) { sr.ws = ws }
+
+func (sr *SQLReport) SetHeader(columns []string) error {
+	if sr.header == nil {
+		sr.header = CopyRecord(columns)
+	}
+	return nil
+}
+
+func (sr *SQLReport) Add(r LogRecord) {
+	if sr.err != nil {
+		return
+	}
+	if sr.spool == nil {
+		if sr.ws == nil {
+			sr.fail(fmt.Errorf("no scratch workspace, which the pipeline sets"))
+			return
+		}
+		if sr.spool, sr.err = sr.ws.Create("sql-*.csv"); sr.err != nil {
+			slog.Error("sql: failed to spool the records", "error", sr.err)
+			return
+		}
+		sr.w = csv.NewWriter(sr.spool)
+	}
+	if err := sr.w.Write(r); err != nil {
+		sr.fail(err)
+		return
+	}
+	sr.width = max(sr.width, len(r))
+	sr.records += 1
+}
+
+func (sr *SQLReport) fail(err error) {
+	if sr.err == nil {
+		sr.err = err
+		slog.Error("sql: failed to spool the records", "error", err)
+	}
+}
+
+// seal flushes and closes the spool, so it can be merged or loaded
+func (sr *SQLReport) seal() {
+	if sr.spool == nil {
+		return
+	}
+	sr.w.Flush()
+	if err := sr.w.Error(); err != nil {
+		sr.fail(err)
+	}
+	if err := sr.spool.Close(); err != nil {
+		sr.fail(err)
+	}
+	sr.files = append(sr.files, sr.spool.Name())
+	sr.spool, sr.w = nil, nil
+}
+
+func (sr *SQLReport) Merge(rpt Report[LogRecord]) {
+	nsr := rpt.(*SQLReport)
+	nsr.seal()
+	sr.files = append(sr.files, nsr.files...)
+	sr.width = max(sr.width, nsr.width)
+	sr.records += nsr.records
+	if sr.header == nil {
+		sr.header = nsr.header
+	}
+	if sr.err == nil {
+		sr.err = nsr.err
+	}
+}
+
+// Clear forgets the spools, which belong to the report they were merged into
+func (sr *SQLReport) Clear() {
+	sr.seal()
+	sr.files, sr.width, sr.records, sr.err = nil, 0, 0, nil
+}
+
+func (sr *SQLReport) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("records", sr.records), slog.Int("columns", sr.width))
+}
+
+// Script returns the DuckDB statements that load the spools and write the result of the query to path
+func (sr *SQLReport) Script(path string) string {
+	var b strings.Builder
+	if len(sr.files) == 0 {
+		// no records, but the query still needs the table
+		b.WriteString("CREATE TABLE records (")
+		for i, name := range sr.columnNames(max(sr.width, len(sr.header), 1)) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(sqlIdent(name) + " VARCHAR")
+		}
+		b.WriteString(");\n")
+	} else {
+		b.WriteString("CREATE TABLE records AS SELECT * FROM read_csv([")
+		for i, file := range sr.files {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(sqlString(file))
+		}
+		b.WriteString("], header = false, null_padding = true, names = [")
+		for i, name := range sr.columnNames(sr.width) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(sqlString(name))
+		}
+		b.WriteString("]);\n")
+	}
+	fmt.Fprintf(&b, "COPY (%s) TO %s (HEADER, DELIMITER ',');\n", sr.query, sqlString(path))
+	return b.String()
+}
+
+// columnNames names the columns after the header, or c0, c1, ... for the ones without a name
+func (sr *SQLReport) columnNames(width int) []string {
+	names := make([]string, width)
+	for i := range names {
+		names[i] = columnName(sr.header, i)
+	}
+	return names
+}
+
+// columnName names column i after the header, or c<i> when the header does not name it
+func columnName(header []string, i int) string {
+	if i < len(header) && strings.TrimSpace(header[i]) != "" {
+		return strings.TrimSpace(header[i])
+	}
+	return "c" + strconv.Itoa(i)
+}
+
+func sqlString(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+func sqlIdent(s string) string  { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+
+// Output runs the query with DuckDB and writes its result as CSV with a header line
+func (sr *SQLReport) Output(path string) {
+	sr.seal()
+	if sr.err != nil {
+		slog.Error("failed to write", "file", path, "error", sr.err)
+		return
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(sr.duckdb, "-batch", "-bail", ":memory:")
+	cmd.Stdin = strings.NewReader(sr.Script(path))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		slog.Error("failed to write", "file", path, "error", fmt.Errorf("duckdb: %v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+}
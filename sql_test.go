@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLReportWorkspace(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	// stands in for DuckDB, writing the file the result is copied to
+	duckdb := filepath.Join(t.TempDir(), "duckdb")
+	script := "#!/bin/sh\nsed -n \"s/^COPY .* TO '\\(.*\\)' (HEADER.*/\\1/p\" | while read -r path; do echo c0 > \"$path\"; done\n"
+	if err := os.WriteFile(duckdb, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	ms := memSource{"x.log": "", "y.log": ""}
+	p := NewPipeline[LogRecord]().From(ms).Parse(records(LogRecord{"a"})).
+		Report(NewSQLReport("SELECT * FROM records", duckdb)).To(NewDirSink(t.TempDir())).Procs(2)
+	if err := p.Run(ms.names()); err != nil {
+		t.Fatal(err)
+	}
+	if p.workspace == nil || p.workspace.dir == "" {
+		t.Fatal("the records were not spooled to a workspace")
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("%d files left in the temporary directory", len(entries))
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Accumulator is the sum of one key. int64 and uint64 sums stop at their limit when they would overflow
+// instead of wrapping around, and stay there whatever is added or merged later, float64 sums at infinity,
+// and decimal sums are exact.
+type Accumulator interface {
+	Add(v string) error
+	Merge(a Accumulator)
+	Overflow() bool
+	String() string
+}
+
+// accumulators return a zero sum of each -accumulate type
+var accumulators = map[string]func() Accumulator{
+	"int64":   func() Accumulator { return &int64Sum{} },
+	"uint64":  func() Accumulator { return &uint64Sum{} },
+	"float64": func() Accumulator { return &float64Sum{} },
+	"decimal": func() Accumulator { return &decimalSum{} },
+}
+
+type int64Sum struct {
+	v    int64
+	over bool
+}
+
+func (s *int64Sum) Add(v string) error {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return err
+	}
+	s.add(n)
+	return nil
+}
+
+func (s *int64Sum) add(n int64) {
+	if s.over {
+		return
+	}
+	r := s.v + n
+	if (n > 0 && r < s.v) || (n < 0 && r > s.v) {
+		s.over = true
+		r = math.MaxInt64
+		if n < 0 {
+			r = math.MinInt64
+		}
+	}
+	s.v = r
+}
+
+func (s *int64Sum) Merge(a Accumulator) {
+	if o := a.(*int64Sum); o.over && !s.over {
+		*s = *o
+	} else {
+		s.add(o.v)
+	}
+}
+
+func (s *int64Sum) Overflow() bool { return s.over }
+func (s *int64Sum) String() string { return strconv.FormatInt(s.v, 10) }
+
+type uint64Sum struct {
+	v    uint64
+	over bool
+}
+
+func (s *uint64Sum) Add(v string) error {
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return err
+	}
+	s.add(n)
+	return nil
+}
+
+func (s *uint64Sum) add(n uint64) {
+	if s.over {
+		return
+	}
+	if s.v+n < s.v {
+		s.over, s.v = true, math.MaxUint64
+		return
+	}
+	s.v += n
+}
+
+func (s *uint64Sum) Merge(a Accumulator) {
+	if o := a.(*uint64Sum); o.over && !s.over {
+		*s = *o
+	} else {
+		s.add(o.v)
+	}
+}
+
+func (s *uint64Sum) Overflow() bool { return s.over }
+func (s *uint64Sum) String() string { return strconv.FormatUint(s.v, 10) }
+
+type float64Sum struct{ v float64 }
+
+func (s *float64Sum) Add(v string) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return err
+	}
+	s.v += f
+	return nil
+}
+
+func (s *float64Sum) Merge(a Accumulator) { s.v += a.(*float64Sum).v }
+func (s *float64Sum) Overflow() bool      { return math.IsInf(s.v, 0) || math.IsNaN(s.v) }
+func (s *float64Sum) String() string      { return strconv.FormatFloat(s.v, 'f', -1, 64) }
+
+// decimalSum is exact, and prints as many decimals as the most precise value had
+type decimalSum struct {
+	v     big.Rat
+	scale int
+}
+
+func (s *decimalSum) Add(v string) error {
+	var r big.Rat
+	if _, ok := r.SetString(v); !ok || strings.ContainsAny(v, "/eE") {
+		return fmt.Errorf("invalid decimal %q", v)
+	}
+	if dot := strings.IndexByte(v, '.'); dot >= 0 {
+		s.scale = max(s.scale, len(v)-dot-1)
+	}
+	s.v.Add(&s.v, &r)
+	return nil
+}
+
+func (s *decimalSum) Merge(a Accumulator) {
+	o := a.(*decimalSum)
+	s.v.Add(&s.v, &o.v)
+	s.scale = max(s.scale, o.scale)
+}
+
+func (s *decimalSum) Overflow() bool { return false }
+func (s *decimalSum) String() string { return s.v.FloatString(s.scale) }
+
+// SumReport sums a value column by the key columns, e.g. the bytes sent per URL. Records whose value is
+// not a number of the accumulator type are rejected.
+type SumReport struct {
+	result     map[string]Accumulator
+	spec       *KeySpec
+	keys       keyCache
+	column     int
+	accumulate string
+	norm       *Normalizer
+	empty      *EmptyKeys
+	numbers    *NumberLocale
+	rollup     *Rollup
+}
+
+func NewSumReport(spec *KeySpec, column int, accumulate string) (*SumReport, error) {
+	if _, ok := accumulators[accumulate]; !ok {
+		return nil, fmt.Errorf("unknown accumulator: %s", accumulate)
+	}
+	if column < 0 {
+		return nil, fmt.Errorf("sum: no value column, set -sum-column")
+	}
+	return &SumReport{make(map[string]Accumulator), spec, newKeyCache(spec), column, accumulate, nil, nil, nil, nil}, nil
+}
+
+// Normalize rewrites the key columns with n before summing
+func (sr *SumReport) Normalize(n *Normalizer) *SumReport { sr.norm = n; return sr }
+
+// EmptyKeys handles empty key columns with ek
+func (sr *SumReport) EmptyKeys(ek *EmptyKeys) *SumReport { sr.empty = ek; return sr }
+
+// Numbers reads the value column in the number locale nl, e.g. 1.234,5
+func (sr *SumReport) Numbers(nl *NumberLocale) *SumReport { sr.numbers = nl; return sr }
+
+// Rollup sums the values under the ancestors of their key with ru too
+func (sr *SumReport) Rollup(ru *Rollup) *SumReport { sr.rollup = ru; return sr }
+
+func (sr *SumReport) New() Report[LogRecord] {
+	nsr, _ := NewSumReport(sr.spec, sr.column, sr.accumulate)
+	return nsr.Normalize(sr.norm).EmptyKeys(sr.empty).Numbers(sr.numbers).Rollup(sr.rollup)
+}
+
+func (sr *SumReport) Name() string { return "sum" }
+func (sr *SumReport) Len() int     { return len(sr.result) }
+func (sr *SumReport) Clear()       { sr.result = make(map[string]Accumulator) }
+
+func (sr *SumReport) Merge(rpt Report[LogRecord]) {
+	for k, v := range rpt.(*SumReport).result {
+		if s, ok := sr.result[k]; ok {
+			s.Merge(v)
+		} else {
+			sr.result[k] = v
+		}
+	}
+}
+
+func (sr *SumReport) SetHeader(columns []string) error {
+	spec, err := sr.spec.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	sr.keys = newKeyCache(spec)
+	return nil
+}
+
+func (sr *SumReport) Check(r LogRecord) error {
+	if _, err := sr.keys.columns(len(r)); err != nil {
+		return err
+	}
+	if sr.column >= len(r) {
+		return fmt.Errorf("sum column %d out of range, the record has %d columns", sr.column, len(r))
+	}
+	v, err := sr.numbers.Canonical(r[sr.column])
+	if err == nil {
+		err = accumulators[sr.accumulate]().Add(v)
+	}
+	if err != nil {
+		return fmt.Errorf("sum column %d: %v", sr.column, err)
+	}
+	return nil
+}
+
+// Add adds the value column of the record to the sum of its key. Records rejected by Check are skipped.
+func (sr *SumReport) Add(r LogRecord) {
+	keys, err := sr.keys.columns(len(r))
+	if err != nil || sr.column >= len(r) {
+		return
+	}
+	v, err := sr.numbers.Canonical(r[sr.column])
+	if err != nil {
+		return
+	}
+	key, ok := joinKey(keys, r, sr.norm, sr.empty)
+	if !ok {
+		return
+	}
+	sr.add(key, v)
+	sr.rollup.Ancestors(key, len(keys), func(k string) { sr.add(k, v) })
+}
+
+func (sr *SumReport) add(key, v string) {
+	s, ok := sr.result[key]
+	if !ok {
+		s = accumulators[sr.accumulate]()
+	}
+	if s.Add(v) == nil && !ok {
+		sr.result[key] = s
+	}
+}
+
+// Load adds the sums of a result written by Output. A sum at the limit of its type is taken to have
+// overflowed, so merging keeps it there.
+func (sr *SumReport) Load(path string) error {
+	return readResultLines(path, func(line string) error {
+		key, values, err := splitValues(line, 1)
+		if err != nil {
+			return err
+		}
+		s := accumulators[sr.accumulate]()
+		if err := s.Add(values[0]); err != nil {
+			return err
+		}
+		switch s := s.(type) {
+		case *int64Sum:
+			s.over = s.v == math.MaxInt64 || s.v == math.MinInt64
+		case *uint64Sum:
+			s.over = s.v == math.MaxUint64
+		}
+		if o, ok := sr.result[key]; ok {
+			o.Merge(s)
+		} else {
+			sr.result[key] = s
+		}
+		return nil
+	})
+}
+
+// overflows returns the number of keys whose sum overflowed and the first few of them
+func (sr *SumReport) overflows() (int, []string) {
+	keys := []string{}
+	for k, s := range sr.result {
+		if s.Overflow() {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return len(keys), keys[:min(len(keys), 5)]
+}
+
+func (sr *SumReport) LogValue() slog.Value {
+	v := keysLogValue(sr.Len(), sr.empty)
+	if n, _ := sr.overflows(); n > 0 {
+		return slog.GroupValue(append(v.Group(), slog.Int("overflows", n))...)
+	}
+	return v
+}
+
+// Output writes key,sum lines sorted by key. Sums that overflowed are written at the limit of their type
+// and logged as a warning.
+func (sr *SumReport) Output(path string) {
+	keys := make([]string, 0, len(sr.result))
+	for k := range sr.result {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s,%s\n", k, sr.result[k])
+	}
+	w.Flush()
+	if n, keys := sr.overflows(); n > 0 {
+		slog.Warn("sums overflowed", "report", sr.Name(), "keys", n, "examples", keys, "accumulate", sr.accumulate, "hint", "use -accumulate decimal")
+	}
+}
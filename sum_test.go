@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func sumOf(t *testing.T, accumulate string, values ...string) Accumulator {
+	t.Helper()
+	s := accumulators[accumulate]()
+	for _, v := range values {
+		if err := s.Add(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestSumOverflowSticks(t *testing.T) {
+	max := strconv.FormatInt(math.MaxInt64, 10)
+	for _, tc := range []struct {
+		accumulate string
+		values     []string
+		want       string
+	}{
+		{"int64", []string{max, "1", "-5"}, max},
+		{"int64", []string{"-" + max, "-2", "7"}, strconv.FormatInt(math.MinInt64, 10)},
+		{"uint64", []string{"18446744073709551615", "1", "0"}, "18446744073709551615"},
+	} {
+		s := sumOf(t, tc.accumulate, tc.values...)
+		if !s.Overflow() || s.String() != tc.want {
+			t.Errorf("%s %v: %s, overflow %v", tc.accumulate, tc.values, s, s.Overflow())
+		}
+	}
+}
+
+func TestSumMergeOverflow(t *testing.T) {
+	max := strconv.FormatInt(math.MaxInt64, 10)
+	// an overflowed shard pins the merged sum, whichever side it is on
+	for _, shards := range [][2]Accumulator{
+		{sumOf(t, "int64", max, "1"), sumOf(t, "int64", "-10")},
+		{sumOf(t, "int64", "-10"), sumOf(t, "int64", max, "1")},
+		{sumOf(t, "int64", max), sumOf(t, "int64", "1")},
+	} {
+		a, b := shards[0], shards[1]
+		a.Merge(b)
+		if !a.Overflow() || a.String() != max {
+			t.Errorf("merged %s, overflow %v", a, a.Overflow())
+		}
+	}
+	a, b := sumOf(t, "uint64", "18446744073709551615", "1"), sumOf(t, "uint64", "3")
+	b.Merge(a)
+	if !b.Overflow() || b.String() != "18446744073709551615" {
+		t.Errorf("merged %s, overflow %v", b, b.Overflow())
+	}
+	a, b = sumOf(t, "int64", "-3"), sumOf(t, "int64", "5")
+	if a.Merge(b); a.Overflow() || a.String() != "2" {
+		t.Errorf("merged %s, overflow %v", a, a.Overflow())
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TaskQueue is a list of inputs shared by several golopro instances. Every instance pops inputs until
+// the queue is empty, acks the ones it processed once their results are written, and puts the others
+// back. Popped inputs are known by an id, as the same name may be queued twice.
+type TaskQueue interface {
+	Push(names []string) error
+	Pop() (id, name string, err error) // "" names when the queue is empty
+	Ack(id string) error
+	Nack(id string) error // puts the input back for another instance
+}
+
+// OpenTaskQueue opens redis://[:password@]host:port/key or sqs://sqs.<region>.amazonaws.com/<account>/<queue>
+func OpenTaskQueue(spec string) (TaskQueue, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		password, _ := u.User.Password()
+		return NewRedisQueue(u.Host, password, strings.TrimPrefix(u.Path, "/")), nil
+	case "sqs":
+		return NewSQSQueue("https://" + u.Host + u.Path)
+	}
+	return nil, fmt.Errorf("unknown task queue: %s", spec)
+}
+
+// queueInputs pulls the inputs of a run from a task queue and remembers them, and which were processed,
+// until the run settles them
+type queueInputs struct {
+	queue  TaskQueue
+	popped []poppedInput
+	err    error // of the last Pop
+
+	mu        sync.Mutex
+	processed map[string]int // files ended, by name
+}
+
+type poppedInput struct{ id, name string }
+
+func newQueueInputs(queue TaskQueue) *queueInputs {
+	return &queueInputs{queue: queue, processed: make(map[string]int)}
+}
+
+func (qi *queueInputs) Next() (string, error) {
+	id, name, err := qi.queue.Pop()
+	qi.err = err
+	if err != nil || name == "" {
+		return "", err
+	}
+	qi.popped = append(qi.popped, poppedInput{id, name})
+	return name, nil
+}
+
+func (qi *queueInputs) Len() int { return -1 }
+
+// ended records an input as processed, from the OnFileEnd hook
+func (qi *queueInputs) ended(stats FileStats) {
+	qi.mu.Lock()
+	qi.processed[stats.File] += 1
+	qi.mu.Unlock()
+}
+
+// settle acks the inputs processed once the results of the run are written, failed ones too as they are
+// left to the error policy, and puts back all others: the ones popped but not started, e.g. after an
+// abort or a limit, and all of them when no results were written
+func (qi *queueInputs) settle(written bool) {
+	acked, requeued := 0, 0
+	for _, in := range qi.popped {
+		var err error
+		if written && qi.processed[in.name] > 0 {
+			qi.processed[in.name] -= 1
+			err = qi.queue.Ack(in.id)
+			acked += 1
+		} else {
+			err = qi.queue.Nack(in.id)
+			requeued += 1
+		}
+		if err != nil {
+			slog.Warn("failed to settle the input with the task queue", "file", in.name, "error", err)
+		}
+	}
+	slog.Info("task queue", "acked", acked, "requeued", requeued)
+	qi.popped = nil
+}
+
+// RedisQueue keeps the inputs in a redis list. Popped inputs are moved to <key>:processing until they
+// are acked or put back, so inputs of a crashed instance can be found and requeued. Their ids are their
+// names.
+type RedisQueue struct {
+	sync.Mutex
+	addr     string
+	password string
+	key      string
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func NewRedisQueue(addr, password, key string) *RedisQueue {
+	if key == "" {
+		key = "golopro"
+	}
+	return &RedisQueue{addr: addr, password: password, key: key}
+}
+
+func (rq *RedisQueue) Push(names []string) error {
+	for len(names) > 0 {
+		n := len(names)
+		if n > 1000 {
+			n = 1000
+		}
+		args := append([]string{"LPUSH", rq.key}, names[:n]...)
+		if _, err := rq.do(args...); err != nil {
+			return err
+		}
+		names = names[n:]
+	}
+	return nil
+}
+
+func (rq *RedisQueue) Pop() (string, string, error) {
+	reply, err := rq.do("RPOPLPUSH", rq.key, rq.key+":processing")
+	if err != nil || reply == nil {
+		return "", "", err
+	}
+	return reply.(string), reply.(string), nil
+}
+
+func (rq *RedisQueue) Ack(id string) error {
+	_, err := rq.do("LREM", rq.key+":processing", "1", id)
+	return err
+}
+
+// Nack pushes the input back to the end that is popped first before removing it from <key>:processing,
+// so a failure in between queues it twice rather than losing it
+func (rq *RedisQueue) Nack(id string) error {
+	if _, err := rq.do("RPUSH", rq.key, id); err != nil {
+		return err
+	}
+	return rq.Ack(id)
+}
+
+// do sends a command and reads the reply, reconnecting once if the connection was lost
+func (rq *RedisQueue) do(args ...string) (interface{}, error) {
+	rq.Lock()
+	defer rq.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if rq.conn == nil {
+			if err = rq.connect(); err != nil {
+				return nil, err
+			}
+		}
+		var reply interface{}
+		reply, err = rq.command(args...)
+		if _, ok := err.(redisError); ok || err == nil {
+			return reply, err
+		}
+		rq.conn.Close()
+		rq.conn = nil
+	}
+	return nil, err
+}
+
+func (rq *RedisQueue) connect() error {
+	conn, err := net.DialTimeout("tcp", rq.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	rq.conn, rq.reader = conn, bufio.NewReader(conn)
+	if rq.password != "" {
+		if _, err := rq.command("AUTH", rq.password); err != nil {
+			conn.Close()
+			rq.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (rq *RedisQueue) command(args ...string) (interface{}, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := rq.conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return rq.readReply()
+}
+
+func (rq *RedisQueue) readReply() (interface{}, error) {
+	line, err := rq.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rq.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = rq.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// SQSQueue keeps the inputs in an SQS queue, using the JSON API signed with the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and optional AWS_SESSION_TOKEN environment variables. Inputs are acked when the
+// results of a run are written, so the queue's visibility timeout must be longer than a run. Their ids
+// are the message ids.
+type SQSQueue struct {
+	sync.Mutex
+	url      string
+	endpoint string
+	host     string
+	region   string
+	client   *http.Client
+
+	pending  []sqsMessage
+	receipts map[string]string // by message id
+}
+
+type sqsMessage struct{ MessageId, Body, ReceiptHandle string }
+
+func NewSQSQueue(queueURL string) (*SQSQueue, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return nil, err
+	}
+	region := os.Getenv("AWS_REGION")
+	if parts := strings.Split(u.Host, "."); len(parts) >= 4 && parts[0] == "sqs" {
+		region = parts[1]
+	}
+	if region == "" {
+		return nil, fmt.Errorf("sqs: no region in %s and AWS_REGION is not set", queueURL)
+	}
+	return &SQSQueue{
+		url:      queueURL,
+		endpoint: u.Scheme + "://" + u.Host + "/",
+		host:     u.Host,
+		region:   region,
+		client:   &http.Client{Timeout: 60 * time.Second},
+		receipts: make(map[string]string),
+	}, nil
+}
+
+func (sq *SQSQueue) Push(names []string) error {
+	for len(names) > 0 {
+		n := len(names)
+		if n > 10 {
+			n = 10
+		}
+		entries := make([]map[string]string, n)
+		for i, name := range names[:n] {
+			entries[i] = map[string]string{"Id": strconv.Itoa(i), "MessageBody": name}
+		}
+		var resp struct {
+			Failed []struct{ Id, Message string }
+		}
+		if err := sq.call("SendMessageBatch", map[string]interface{}{"QueueUrl": sq.url, "Entries": entries}, &resp); err != nil {
+			return err
+		}
+		if len(resp.Failed) > 0 {
+			return fmt.Errorf("sqs: failed to send %d messages: %s", len(resp.Failed), resp.Failed[0].Message)
+		}
+		names = names[n:]
+	}
+	return nil
+}
+
+func (sq *SQSQueue) Pop() (string, string, error) {
+	sq.Lock()
+	defer sq.Unlock()
+
+	if len(sq.pending) == 0 {
+		var resp struct{ Messages []sqsMessage }
+		req := map[string]interface{}{"QueueUrl": sq.url, "MaxNumberOfMessages": 10, "WaitTimeSeconds": 5}
+		if err := sq.call("ReceiveMessage", req, &resp); err != nil {
+			return "", "", err
+		}
+		for _, m := range resp.Messages {
+			sq.pending = append(sq.pending, m)
+			sq.receipts[m.MessageId] = m.ReceiptHandle
+		}
+		if len(sq.pending) == 0 {
+			return "", "", nil
+		}
+	}
+
+	m := sq.pending[0]
+	sq.pending = sq.pending[1:]
+	return m.MessageId, m.Body, nil
+}
+
+// receipt returns the receipt handle of a received message and forgets it
+func (sq *SQSQueue) receipt(id string) (string, error) {
+	sq.Lock()
+	defer sq.Unlock()
+	receipt, ok := sq.receipts[id]
+	delete(sq.receipts, id)
+	if !ok {
+		return "", fmt.Errorf("sqs: message %s was not received", id)
+	}
+	return receipt, nil
+}
+
+func (sq *SQSQueue) Ack(id string) error {
+	receipt, err := sq.receipt(id)
+	if err != nil {
+		return err
+	}
+	return sq.call("DeleteMessage", map[string]interface{}{"QueueUrl": sq.url, "ReceiptHandle": receipt}, nil)
+}
+
+// Nack makes the message visible again right away
+func (sq *SQSQueue) Nack(id string) error {
+	receipt, err := sq.receipt(id)
+	if err != nil {
+		return err
+	}
+	req := map[string]interface{}{"QueueUrl": sq.url, "ReceiptHandle": receipt, "VisibilityTimeout": 0}
+	return sq.call("ChangeMessageVisibility", req, nil)
+}
+
+func (sq *SQSQueue) call(action string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hreq, err := http.NewRequest("POST", sq.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	hreq.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	if err := signV4(hreq, body, sq.host, sq.region, "sqs", time.Now().UTC()); err != nil {
+		return err
+	}
+
+	hresp, err := sq.client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+	data, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
+		return err
+	}
+	if hresp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sqs %s: %s: %s", action, hresp.Status, data)
+	}
+	if resp != nil {
+		return json.Unmarshal(data, resp)
+	}
+	return nil
+}
+
+// signV4 adds an AWS signature version 4 to a request with credentials from the environment
+func signV4(req *http.Request, body []byte, host, region, service string, now time.Time) error {
+	key, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if key == "" || secret == "" {
+		return fmt.Errorf("%s: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set", service)
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("Host", host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalPath := req.URL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method, canonicalPath, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	signingKey := mac(mac(mac(mac([]byte("AWS4"+secret), date), region), service), "aws4_request")
+	signature := hex.EncodeToString(mac(signingKey, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		key, scope, signedHeaders, signature))
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// memQueue is a TaskQueue in memory
+type memQueue struct {
+	queued []string
+	popped map[string]string // by id
+	acked  []string
+	nextID int
+}
+
+func newMemQueue(names ...string) *memQueue {
+	return &memQueue{queued: names, popped: make(map[string]string)}
+}
+
+func (mq *memQueue) Push(names []string) error { mq.queued = append(mq.queued, names...); return nil }
+
+func (mq *memQueue) Pop() (string, string, error) {
+	if len(mq.queued) == 0 {
+		return "", "", nil
+	}
+	name := mq.queued[0]
+	mq.queued = mq.queued[1:]
+	mq.nextID += 1
+	id := fmt.Sprint(mq.nextID)
+	mq.popped[id] = name
+	return id, name, nil
+}
+
+func (mq *memQueue) Ack(id string) error {
+	mq.acked = append(mq.acked, mq.popped[id])
+	delete(mq.popped, id)
+	return nil
+}
+
+func (mq *memQueue) Nack(id string) error {
+	mq.queued = append(mq.queued, mq.popped[id])
+	delete(mq.popped, id)
+	return nil
+}
+
+func TestQueueInputs(t *testing.T) {
+	ms := memSource{"x.log": "", "y.log": ""}
+	queue := newMemQueue("x.log", "missing.log", "y.log", "x.log")
+	qi := newQueueInputs(queue)
+	p := NewPipeline[LogRecord]().From(ms).Parse(records(LogRecord{"a"})).
+		Report(quickReport(t, "0")).To(NewDirSink(t.TempDir())).Hook(Hooks{OnFileEnd: qi.ended})
+	if err := p.RunInputs(qi); err != nil {
+		t.Fatal(err)
+	}
+	out := reportOutput(t, p.Reports()[0])
+	if out != "a,3\n" {
+		t.Errorf("output %q", out)
+	}
+
+	qi.settle(true)
+	sort.Strings(queue.acked)
+	if got := fmt.Sprint(queue.acked); got != "[missing.log x.log x.log y.log]" {
+		t.Errorf("acked %s", got)
+	}
+	if len(queue.queued) > 0 || len(queue.popped) > 0 {
+		t.Errorf("left %v, unsettled %v", queue.queued, queue.popped)
+	}
+}
+
+func TestQueueInputsRequeue(t *testing.T) {
+	queue := newMemQueue("x.log", "y.log", "z.log")
+	qi := newQueueInputs(queue)
+	qi.Next()
+	qi.Next()
+	qi.ended(FileStats{File: "x.log"})
+	qi.settle(true)
+	if fmt.Sprint(queue.acked) != "[x.log]" || fmt.Sprint(queue.queued) != "[z.log y.log]" {
+		t.Errorf("acked %v, queued %v", queue.acked, queue.queued)
+	}
+
+	qi.Next()
+	qi.ended(FileStats{File: "z.log"})
+	qi.settle(false)
+	if fmt.Sprint(queue.acked) != "[x.log]" || fmt.Sprint(queue.queued) != "[y.log z.log]" {
+		t.Errorf("results not written: acked %v, queued %v", queue.acked, queue.queued)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memSource is a Source serving inputs from memory, keyed by name
+type memSource map[string]string
+
+func (ms memSource) Open(name string) (io.ReadCloser, int64, error) {
+	data, ok := ms[name]
+	if !ok {
+		return nil, 0, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), int64(len(data)), nil
+}
+
+func (ms memSource) Size(name string) (int64, error) { return int64(len(ms[name])), nil }
+
+// names returns the inputs of the source, to pass to Pipeline.Run
+func (ms memSource) names() []string {
+	names := make([]string, 0, len(ms))
+	for name := range ms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// step is one scripted NextRecord result
+type step[T any] struct {
+	bytes  int
+	record T
+	err    error
+}
+
+// scriptedParser replays the same steps for every input, then returns io.EOF
+type scriptedParser[T any] struct {
+	steps []step[T]
+	next  int
+}
+
+// records scripts a parser returning the records without errors
+func records[T any](recs ...T) *scriptedParser[T] {
+	steps := make([]step[T], len(recs))
+	for i, rec := range recs {
+		steps[i].record = rec
+	}
+	return &scriptedParser[T]{steps: steps}
+}
+
+func (sp *scriptedParser[T]) Clone() Parser[T]  { return &scriptedParser[T]{steps: sp.steps} }
+func (sp *scriptedParser[T]) Reset(r io.Reader) { sp.next = 0 }
+
+func (sp *scriptedParser[T]) NextRecord() (int, T, error) {
+	if sp.next >= len(sp.steps) {
+		var rec T
+		return 0, rec, io.EOF
+	}
+	step := sp.steps[sp.next]
+	sp.next += 1
+	return step.bytes, step.record, step.err
+}
+
+// reportOutput returns what the report writes with Output
+func reportOutput[T any](tb testing.TB, rpt Report[T]) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "result-"+rpt.Name())
+	rpt.Output(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("%s: output: %v", rpt.Name(), err)
+	}
+	return string(data)
+}
+
+// checkOutput adds the records to the report and compares its output
+func checkOutput[T any](tb testing.TB, rpt Report[T], recs []T, want string) {
+	tb.Helper()
+	for _, rec := range recs {
+		rpt.Add(rec)
+	}
+	if got := reportOutput(tb, rpt); got != want {
+		tb.Errorf("%s: output\n%s\nwant\n%s", rpt.Name(), got, want)
+	}
+}
+
+// checkMerge checks that merging reports of a and b gives the same output as one report of a and b,
+// which is what the workers rely on
+func checkMerge[T any](tb testing.TB, rpt Report[T], a, b []T) {
+	tb.Helper()
+	all := rpt.New()
+	left, right := rpt.New(), rpt.New()
+	for _, rec := range a {
+		all.Add(rec)
+		left.Add(rec)
+	}
+	for _, rec := range b {
+		all.Add(rec)
+		right.Add(rec)
+	}
+
+	left.Merge(right)
+	if got, want := reportOutput(tb, left), reportOutput(tb, all); got != want {
+		tb.Errorf("%s: merged output\n%s\nwant\n%s", rpt.Name(), got, want)
+	}
+}
+
+// checkClear checks that a cleared report has the output of a new one
+func checkClear[T any](tb testing.TB, rpt Report[T], recs []T) {
+	tb.Helper()
+	for _, rec := range recs {
+		rpt.Add(rec)
+	}
+	rpt.Clear()
+	if got, want := reportOutput(tb, rpt), reportOutput(tb, rpt.New()); got != want {
+		tb.Errorf("%s: output after Clear\n%s\nwant\n%s", rpt.Name(), got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket of bytes per second shared by the readers of a run, so the run reads no
+// faster than the rate however many workers it has. It allows bursts of a tenth of a second of reads.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter limits reads to mbps megabytes (of 2^20 bytes) per second, nil for no limit
+func NewRateLimiter(mbps float64) *RateLimiter {
+	if mbps <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: mbps * 1024 * 1024, last: time.Now()}
+}
+
+// chunk is the most a single read should ask for, so no reader sleeps for long in one go
+func (rl *RateLimiter) chunk() int { return max(int(rl.rate/10), 4096) }
+
+// Wait takes n bytes from the bucket and sleeps until they are covered. Readers that take more than there
+// is wait in turn, as each one reserves its bytes before sleeping.
+func (rl *RateLimiter) Wait(n int) {
+	rl.mu.Lock()
+	now := time.Now()
+	rl.tokens = min(rl.tokens+now.Sub(rl.last).Seconds()*rl.rate, rl.rate/10)
+	rl.last = now
+	rl.tokens -= float64(n)
+	debt := rl.tokens
+	rl.mu.Unlock()
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / rl.rate * float64(time.Second)))
+	}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	rl *RateLimiter
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > tr.rl.chunk() {
+		p = p[:tr.rl.chunk()]
+	}
+	n, err := tr.ReadCloser.Read(p)
+	tr.rl.Wait(n)
+	return n, err
+}
+
+// openSlot holds one of the -max-open-files slots of a run until the input is closed
+type openSlot struct {
+	io.ReadCloser
+	slots chan struct{}
+	once  sync.Once
+}
+
+func (s *openSlot) Close() error {
+	err := s.ReadCloser.Close()
+	s.once.Do(func() { <-s.slots })
+	return err
+}
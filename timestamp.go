@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TimeParser parses the time column of records with a list of candidate layouts, tried in order starting
+// with the one that matched last. A layout is a Go layout, a strftime layout (with %), a name such as
+// rfc3339, or epoch, epoch_ms, epoch_us or epoch_ns for numeric timestamps. Times without a zone are in
+// the parser's location, and all times are returned in it.
+type TimeParser struct {
+	Column  int
+	layouts []string
+	loc     *time.Location
+	last    int32 // updated atomically, as filters are shared by the workers
+}
+
+var timeLayoutNames = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"rfc1123z":    time.RFC1123Z,
+	"datetime":    time.DateTime,
+	"date":        time.DateOnly,
+	"clf":         "02/Jan/2006:15:04:05 -0700", // common log format
+}
+
+// epochUnits are the numeric layouts and their unit in nanoseconds
+var epochUnits = map[string]int64{"epoch": 1e9, "epoch_ms": 1e6, "epoch_us": 1e3, "epoch_ns": 1}
+
+// NewTimeParser parses the |-separated layouts in the time zone tz, a name of the IANA database, UTC or
+// Local
+func NewTimeParser(column int, layouts, tz string) (*TimeParser, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("tz: %v", err)
+	}
+	tp := &TimeParser{Column: column, loc: loc}
+	for _, layout := range strings.Split(layouts, "|") {
+		if layout = strings.TrimSpace(layout); layout == "" {
+			continue
+		}
+		if named, ok := timeLayoutNames[strings.ToLower(layout)]; ok {
+			layout = named
+		} else if strings.Contains(layout, "%") {
+			if layout, err = strftimeLayout(layout); err != nil {
+				return nil, err
+			}
+		}
+		tp.layouts = append(tp.layouts, layout)
+	}
+	if len(tp.layouts) == 0 {
+		return nil, fmt.Errorf("no time layouts")
+	}
+	return tp, nil
+}
+
+// Parse parses a timestamp with the first layout that matches
+func (tp *TimeParser) Parse(s string) (time.Time, error) {
+	last := int(atomic.LoadInt32(&tp.last))
+	for i := range tp.layouts {
+		n := (last + i) % len(tp.layouts)
+		if t, err := tp.parse(tp.layouts[n], s); err == nil {
+			if n != last {
+				atomic.StoreInt32(&tp.last, int32(n))
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("time %q matches none of the layouts", s)
+}
+
+// In returns t in the parser's location
+func (tp *TimeParser) In(t time.Time) time.Time { return t.In(tp.loc) }
+
+// RecordTime parses the time column of a LogRecord or ByteRecord. The switch is on a pointer to the
+// record, which does not box it.
+func RecordTime[T any](tp *TimeParser, rec T) (time.Time, error) {
+	var s string
+	switch r := interface{}(&rec).(type) {
+	case *LogRecord:
+		if tp.Column >= len(*r) {
+			return time.Time{}, fmt.Errorf("time column %d out of range, the record has %d columns", tp.Column, len(*r))
+		}
+		s = (*r)[tp.Column]
+	case *ByteRecord:
+		if tp.Column >= len(*r) {
+			return time.Time{}, fmt.Errorf("time column %d out of range, the record has %d columns", tp.Column, len(*r))
+		}
+		s = string((*r)[tp.Column])
+	default:
+		return time.Time{}, fmt.Errorf("no time column in %T records", rec)
+	}
+	return tp.Parse(s)
+}
+
+func (tp *TimeParser) parse(layout, s string) (time.Time, error) {
+	if unit, ok := epochUnits[layout]; ok {
+		// integers exactly, as nanoseconds since the epoch do not fit the mantissa of a float64
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			perSec := int64(1e9) / unit
+			return time.Unix(v/perSec, v%perSec*unit).In(tp.loc), nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		sec, frac := math.Modf(v * float64(unit) / 1e9)
+		return time.Unix(int64(sec), int64(frac*1e9)).In(tp.loc), nil
+	}
+	t, err := time.ParseInLocation(layout, s, tp.loc)
+	return t.In(tp.loc), err
+}
+
+var strftimeDirectives = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'j': "002",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'f': "000000", 'p': "PM",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST", 'T': "15:04:05", 'F': "2006-01-02", 'D': "01/02/06", '%': "%",
+}
+
+// strftimeLayout converts a strftime layout such as %Y-%m-%d %H:%M:%S to a Go layout
+func strftimeLayout(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("time layout %s: trailing %%", s)
+		}
+		i += 1
+		d, ok := strftimeDirectives[s[i]]
+		if !ok {
+			return "", fmt.Errorf("time layout %s: unsupported directive %%%c", s, s[i])
+		}
+		b.WriteString(d)
+	}
+	return b.String(), nil
+}
+
+// TimeRange keeps the records whose time is in [From, To), a zero bound being open. Records without a
+// valid time are dropped.
+type TimeRange[T any] struct {
+	times    *TimeParser
+	From, To time.Time
+}
+
+func NewTimeRange[T any](times *TimeParser, from, to time.Time) *TimeRange[T] {
+	return &TimeRange[T]{times, from, to}
+}
+
+func (tr *TimeRange[T]) Keep(rec T) bool {
+	t, err := RecordTime(tr.times, rec)
+	return err == nil && (tr.From.IsZero() || !t.Before(tr.From)) && (tr.To.IsZero() || t.Before(tr.To))
+}
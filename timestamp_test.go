@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeParserEpoch(t *testing.T) {
+	for _, tc := range []struct {
+		layout, in string
+		want       time.Time
+	}{
+		{"epoch", "1700000000", time.Unix(1700000000, 0)},
+		{"epoch", "1700000000.5", time.Unix(1700000000, 5e8)},
+		{"epoch_ms", "-1500", time.Unix(-2, 5e8)},
+		{"epoch_us", "1700000000123456", time.Unix(1700000000, 123456000)},
+		{"epoch_ns", "1700000000123456789", time.Unix(1700000000, 123456789)},
+	} {
+		tp, err := NewTimeParser(0, tc.layout, "UTC")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tp.Parse(tc.in)
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("%s %s: %v, %v, want %v", tc.layout, tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestTimeParserLayouts(t *testing.T) {
+	tp, err := NewTimeParser(1, "%d/%m/%Y %H:%M|rfc3339", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	for _, in := range []string{"01/03/2024 12:30", "2024-03-01T13:30:00+01:00", "01/03/2024 12:30"} {
+		if got, err := tp.Parse(in); err != nil || !got.Equal(want) {
+			t.Errorf("%s: %v, %v", in, got, err)
+		}
+	}
+	if _, err := tp.Parse("yesterday"); err == nil {
+		t.Error("parsed yesterday")
+	}
+
+	if got, err := RecordTime(tp, ByteRecord{[]byte("x"), []byte("01/03/2024 12:30")}); err != nil || !got.Equal(want) {
+		t.Errorf("byte record: %v, %v", got, err)
+	}
+	if _, err := RecordTime(tp, LogRecord{"x"}); err == nil {
+		t.Error("parsed a record without a time column")
+	}
+}
+
+func TestRecordTimeAllocs(t *testing.T) {
+	tp, err := NewTimeParser(0, "epoch_ms", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := LogRecord{"1700000000123"}
+	if n := testing.AllocsPerRun(100, func() { RecordTime(tp, rec) }); n > 0 {
+		t.Errorf("%v allocations per record", n)
+	}
+}
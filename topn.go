@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+)
+
+// TopNReport counts the records by the key columns within each group of the group columns, e.g. the URLs
+// by country, and writes the n most frequent keys of every group. The counts are exact: workers count
+// every key of every group and the merged counts are cut to the top n with a bounded heap per group when
+// the result is written, so memory grows with the distinct pairs of group and key.
+type TopNReport struct {
+	groups map[string]map[string]int64
+	n      int
+	spec   *KeySpec
+	group  *KeySpec
+	keys   keyCache
+	groupk keyCache
+	norm   *Normalizer
+	empty  *EmptyKeys
+}
+
+func NewTopNReport(spec, group *KeySpec, n int) (*TopNReport, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("topn: -top %d must be at least 1", n)
+	}
+	return &TopNReport{make(map[string]map[string]int64), n, spec, group, newKeyCache(spec), newKeyCache(group), nil, nil}, nil
+}
+
+// Normalize rewrites the key columns with n before counting, the group columns are taken as they are
+func (tr *TopNReport) Normalize(n *Normalizer) *TopNReport { tr.norm = n; return tr }
+
+// EmptyKeys handles empty key columns with ek
+func (tr *TopNReport) EmptyKeys(ek *EmptyKeys) *TopNReport { tr.empty = ek; return tr }
+
+func (tr *TopNReport) New() Report[LogRecord] {
+	ntr, _ := NewTopNReport(tr.spec, tr.group, tr.n)
+	return ntr.Normalize(tr.norm).EmptyKeys(tr.empty)
+}
+
+func (tr *TopNReport) Name() string { return "topn" }
+func (tr *TopNReport) Clear()       { tr.groups = make(map[string]map[string]int64) }
+
+func (tr *TopNReport) Len() int {
+	n := 0
+	for _, keys := range tr.groups {
+		n += len(keys)
+	}
+	return n
+}
+
+func (tr *TopNReport) LogValue() slog.Value {
+	return slog.GroupValue(append(keysLogValue(tr.Len(), tr.empty).Group(), slog.Int("groups", len(tr.groups)))...)
+}
+
+func (tr *TopNReport) Merge(rpt Report[LogRecord]) {
+	for group, keys := range rpt.(*TopNReport).groups {
+		counts, ok := tr.groups[group]
+		if !ok {
+			tr.groups[group] = keys
+			continue
+		}
+		for k, v := range keys {
+			counts[k] += v
+		}
+	}
+}
+
+func (tr *TopNReport) SetHeader(columns []string) error {
+	spec, err := tr.spec.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	group, err := tr.group.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	tr.keys, tr.groupk = newKeyCache(spec), newKeyCache(group)
+	return nil
+}
+
+func (tr *TopNReport) Check(r LogRecord) error {
+	if _, err := tr.keys.columns(len(r)); err != nil {
+		return err
+	}
+	if _, err := tr.groupk.columns(len(r)); err != nil {
+		return fmt.Errorf("group: %v", err)
+	}
+	return nil
+}
+
+// Add counts the record by its key in its group. Records rejected by Check are skipped.
+func (tr *TopNReport) Add(r LogRecord) {
+	keys, err := tr.keys.columns(len(r))
+	if err != nil {
+		return
+	}
+	groups, err := tr.groupk.columns(len(r))
+	if err != nil {
+		return
+	}
+	key, ok := joinKey(keys, r, tr.norm, tr.empty)
+	if !ok {
+		return
+	}
+	group, _ := joinKey(groups, r, nil, nil)
+	counts, ok := tr.groups[group]
+	if !ok {
+		counts = make(map[string]int64)
+		tr.groups[group] = counts
+	}
+	counts[key] += 1
+}
+
+// top returns the n most frequent keys of a group, the most frequent first and equal counts by key
+func (tr *TopNReport) top(group string) []KeyCount { return heapTop(tr.groups[group], tr.n) }
+
+// heapTop returns the n largest counts, the largest first and equal counts by key
+func heapTop(counts map[string]int64, n int) []KeyCount {
+	h := make(keyCountHeap, 0, n+1)
+	for k, v := range counts {
+		if len(h) == n && !h.less(h[0], KeyCount{k, v}) {
+			continue
+		}
+		heap.Push(&h, KeyCount{k, v})
+		if len(h) > n {
+			heap.Pop(&h)
+		}
+	}
+	top := make([]KeyCount, len(h))
+	for i := len(top) - 1; i >= 0; i-- {
+		top[i] = heap.Pop(&h).(KeyCount)
+	}
+	return top
+}
+
+// Output writes group,key,count lines, the groups sorted and their keys by count
+func (tr *TopNReport) Output(path string) {
+	groups := make([]string, 0, len(tr.groups))
+	for group := range tr.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for _, group := range groups {
+		for _, kc := range tr.top(group) {
+			fmt.Fprintf(w, "%s,%s,%d\n", group, kc.Key, kc.Count)
+		}
+	}
+	w.Flush()
+}
+
+// keyCountHeap is a min-heap of the least frequent key on top, of the larger key for equal counts
+type keyCountHeap []KeyCount
+
+func (h keyCountHeap) less(a, b KeyCount) bool {
+	return a.Count < b.Count || a.Count == b.Count && a.Key > b.Key
+}
+
+func (h keyCountHeap) Len() int            { return len(h) }
+func (h keyCountHeap) Less(i, j int) bool  { return h.less(h[i], h[j]) }
+func (h keyCountHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyCountHeap) Push(x interface{}) { *h = append(*h, x.(KeyCount)) }
+func (h *keyCountHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tracer exports spans to an OpenTelemetry collector with OTLP/HTTP in the JSON encoding. Spans are sent
+// in batches in the background. A nil *Tracer and its nil spans do nothing, so tracing costs nothing
+// when it is off.
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+
+	spans chan *Span
+	done  chan struct{}
+}
+
+// Span is one timed operation of a trace
+type Span struct {
+	tracer   *Tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      error
+}
+
+// NewTracer exports to endpoint, e.g. http://localhost:4318. The service name and extra headers are
+// taken from OTEL_SERVICE_NAME and OTEL_EXPORTER_OTLP_HEADERS (k1=v1,k2=v2).
+func NewTracer(endpoint string) *Tracer {
+	t := &Tracer{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:  make(map[string]string),
+		service:  os.Getenv("OTEL_SERVICE_NAME"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		spans:    make(chan *Span, 4096),
+		done:     make(chan struct{}),
+	}
+	if t.service == "" {
+		t.service = "golopro"
+	}
+	for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			t.headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	go t.export()
+	return t
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start begins the root span of a new trace
+func (t *Tracer) Start(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	return &Span{tracer: t, traceID: randomID(16), spanID: randomID(8), name: name, start: time.Now(), attrs: make(map[string]interface{})}
+}
+
+// Shutdown sends the spans that are still buffered
+func (t *Tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	close(t.spans)
+	<-t.done
+}
+
+// Child begins a span inside s
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{tracer: s.tracer, traceID: s.traceID, spanID: randomID(8), parentID: s.spanID, name: name, start: time.Now(), attrs: make(map[string]interface{})}
+}
+
+func (s *Span) Set(key string, value interface{}) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// End finishes the span, marking it as failed if err is not nil
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	select {
+	case s.tracer.spans <- s:
+	default:
+		// never block the pipeline on a slow collector
+	}
+}
+
+func (t *Tracer) export() {
+	defer close(t.done)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, 512)
+	for {
+		select {
+		case s, ok := <-t.spans:
+			if !ok {
+				t.send(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) < cap(batch) {
+				continue
+			}
+		case <-ticker.C:
+		}
+		t.send(batch)
+		batch = batch[:0]
+	}
+}
+
+func (t *Tracer) send(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	attribute := func(k string, v interface{}) map[string]interface{} {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		return map[string]interface{}{"key": k, "value": value}
+	}
+
+	spans := make([]map[string]interface{}, len(batch))
+	for i, s := range batch {
+		attrs := make([]map[string]interface{}, 0, len(s.attrs))
+		for _, k := range sortedKeys(s.attrs) {
+			attrs = append(attrs, attribute(k, s.attrs[k]))
+		}
+		span := map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1, // internal
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attrs,
+		}
+		if s.parentID != "" {
+			span["parentSpanId"] = s.parentID
+		}
+		if s.err != nil {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.err.Error()}
+		}
+		spans[i] = span
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   map[string]interface{}{"attributes": []interface{}{attribute("service.name", t.service)}},
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "golopro"}, "spans": spans}},
+		}},
+	})
+	req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("otlp failed", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		slog.Error("otlp failed to export", "spans", len(batch), "error", err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Error("otlp failed to export", "spans", len(batch), "status", resp.Status)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make(map[string]string, len(m))
+	for k := range m {
+		keys[k] = ""
+	}
+	return sortedNames(keys)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+)
+
+// TransitionsReport counts the transitions between the states of every key, e.g. from page to page of a
+// user, along the key's timeline ordered by the -time-column. The events are kept in an eventSpool until
+// the result is written.
+type TransitionsReport struct {
+	spec   *KeySpec
+	states *KeySpec
+	keys   keyCache
+	statek keyCache
+	times  *TimeParser
+	norm   *Normalizer
+	empty  *EmptyKeys
+	spool  eventSpool
+}
+
+func NewTransitionsReport(spec, states *KeySpec, times *TimeParser, spillSize int64) *TransitionsReport {
+	return &TransitionsReport{spec: spec, states: states, keys: newKeyCache(spec), statek: newKeyCache(states), times: times,
+		spool: eventSpool{name: "transitions", spillSize: spillSize}}
+}
+
+// Normalize rewrites the state columns with n, the key columns are taken as they are
+func (tr *TransitionsReport) Normalize(n *Normalizer) *TransitionsReport { tr.norm = n; return tr }
+
+// EmptyKeys handles empty key and state columns with ek
+func (tr *TransitionsReport) EmptyKeys(ek *EmptyKeys) *TransitionsReport { tr.empty = ek; return tr }
+
+func (tr *TransitionsReport) New() Report[LogRecord] {
+	ntr := NewTransitionsReport(tr.spec, tr.states, tr.times, tr.spool.spillSize).Normalize(tr.norm).EmptyKeys(tr.empty)
+	ntr.spool.ws = tr.spool.ws
+	return ntr
+}
+
+func (tr *TransitionsReport) Name() string               { return "transitions" }
+func (tr *TransitionsReport) SetWorkspace(ws *Workspace) { tr.spool.ws = ws }
+func (tr *TransitionsReport) LogValue() slog.Value       { return tr.spool.LogValue() }
+func (tr *TransitionsReport) Merge(rpt Report[LogRecord]) {
+	tr.spool.merge(&rpt.(*TransitionsReport).spool)
+}
+func (tr *TransitionsReport) Clear() { tr.spool.clear() }
+
+func (tr *TransitionsReport) SetHeader(columns []string) error {
+	spec, err := tr.spec.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	states, err := tr.states.WithHeader(columns)
+	if err != nil {
+		return err
+	}
+	tr.keys, tr.statek = newKeyCache(spec), newKeyCache(states)
+	return nil
+}
+
+func (tr *TransitionsReport) Check(r LogRecord) error {
+	if _, err := tr.keys.columns(len(r)); err != nil {
+		return err
+	}
+	if _, err := tr.statek.columns(len(r)); err != nil {
+		return fmt.Errorf("state: %v", err)
+	}
+	_, err := RecordTime(tr.times, r)
+	return err
+}
+
+// Add spools the state of the record's key at its time. Records rejected by Check are skipped.
+func (tr *TransitionsReport) Add(r LogRecord) {
+	keys, err := tr.keys.columns(len(r))
+	if err != nil {
+		return
+	}
+	states, err := tr.statek.columns(len(r))
+	if err != nil {
+		return
+	}
+	t, err := RecordTime(tr.times, r)
+	if err != nil {
+		return
+	}
+	key, ok := joinKey(keys, r, nil, tr.empty)
+	if !ok {
+		return
+	}
+	state, ok := joinKey(states, r, tr.norm, tr.empty)
+	if !ok {
+		return
+	}
+	tr.spool.add(stateEvent{key, state, t.UnixNano()})
+}
+
+// Output writes from,to,count,probability lines sorted by the from state and then by count, the
+// probability being the share of the transitions out of the from state
+func (tr *TransitionsReport) Output(path string) {
+	counts := make(map[[2]string]int64)
+	var prev stateEvent
+	first := true
+	err := tr.spool.each(func(e stateEvent) {
+		if !first && e.key == prev.key {
+			counts[[2]string{prev.state, e.state}] += 1
+		}
+		prev, first = e, false
+	})
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	pairs := make([][2]string, 0, len(counts))
+	out := make(map[string]int64)
+	for p, n := range counts {
+		pairs = append(pairs, p)
+		out[p[0]] += n
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		return a[1] < b[1]
+	})
+
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		slog.Error("failed to write", "file", path, "error", err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for _, p := range pairs {
+		fmt.Fprintf(w, "%s,%s,%d,%.4f\n", p[0], p[1], counts[p], float64(counts[p])/float64(out[p[0]]))
+	}
+	w.Flush()
+}
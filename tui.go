@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// KeyCount is one entry of a report's top keys
+type KeyCount struct {
+	Key   string
+	Count int64
+}
+
+// TopReport is implemented by reports that can list their most frequent keys, shown live by -tui
+type TopReport interface {
+	Top(n int) []KeyCount
+}
+
+// topCounts returns the n largest counts of each
+func topCounts(n int, each func(fn func(key string, count int64))) []KeyCount {
+	top := make([]KeyCount, 0, n+1)
+	each(func(k string, v int64) {
+		if len(top) == n && v <= top[n-1].Count {
+			return
+		}
+		i := sort.Search(len(top), func(i int) bool { return top[i].Count < v })
+		top = append(top, KeyCount{})
+		copy(top[i+1:], top[i:])
+		top[i] = KeyCount{k, v}
+		if len(top) > n {
+			top = top[:n]
+		}
+	})
+	return top
+}
+
+const dashboardTopKeys = 5
+
+// publishTop snapshots the top keys of the worker's reports for the dashboard, at most once a second
+func (w *Worker[T]) publishTop() {
+	if !w.pipeline.tui || time.Since(w.lastTop) < time.Second {
+		return
+	}
+	w.lastTop = time.Now()
+	top := make([][]KeyCount, len(w.reportMgr.reports))
+	for i, rpt := range w.reportMgr.reports {
+		if tr, ok := rpt.(TopReport); ok {
+			top[i] = tr.Top(dashboardTopKeys)
+		}
+	}
+	w.top.Store(top)
+}
+
+// showDashboard redraws a full screen dashboard on f every second until stop is called: the progress,
+// a throughput sparkline, error counts, what every worker is doing and the top keys of each report.
+// The top keys are summed over the workers' own reports, so they are approximate until the reduce.
+func (p *Pipeline[T]) showDashboard(f *os.File, workers []*Worker[T]) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	fmt.Fprint(f, "\033[?1049h\033[?25l") // alternate screen, hide cursor
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		meter := progressMeter{start: time.Now(), last: time.Now()}
+		rates := make([]float64, 0, 60)
+		for {
+			select {
+			case <-done:
+				fmt.Fprint(f, "\033[?25h\033[?1049l")
+				return
+			case <-ticker.C:
+			}
+			line := meter.line(p.progress())
+			if len(rates) == cap(rates) {
+				rates = append(rates[:0], rates[1:]...)
+			}
+			rates = append(rates, meter.rate)
+			fmt.Fprint(f, "\033[H\033[2J"+p.dashboard(line, rates, workers))
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (p *Pipeline[T]) dashboard(line string, rates []float64, workers []*Worker[T]) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "golopro  %s\n\n", line)
+	fmt.Fprintf(&b, "throughput  %s %s/s\n", sparkline(rates), formatBytes(rates[len(rates)-1]))
+	prog := p.control.Progress()
+	fmt.Fprintf(&b, "errors      %d failed files, %d bad records\n\n", p.failures.Count(), prog.ParseErrors)
+
+	b.WriteString("workers\n")
+	for _, w := range workers {
+		if file, _ := w.file.Load().(string); file != "" {
+			fmt.Fprintf(&b, "  [%d] %-40s %12d records\n", w.id, filepath.Base(file), atomic.LoadInt64(&w.fileRecords))
+		} else {
+			fmt.Fprintf(&b, "  [%d] idle\n", w.id)
+		}
+	}
+
+	for i, rpt := range p.reportMgr.reports {
+		if _, ok := rpt.(TopReport); !ok {
+			continue
+		}
+		// shared reports are the same instance on every worker, so their latest snapshot is the total
+		_, shared := rpt.(SharedReport)
+		sums := make(map[string]int64)
+		for _, w := range workers {
+			if top, _ := w.top.Load().([][]KeyCount); top != nil {
+				for _, kc := range top[i] {
+					if shared {
+						sums[kc.Key] = max(sums[kc.Key], kc.Count)
+					} else {
+						sums[kc.Key] += kc.Count
+					}
+				}
+			}
+		}
+		fmt.Fprintf(&b, "\ntop keys: %s\n", rpt.Name())
+		for _, kc := range topCounts(dashboardTopKeys, func(fn func(string, int64)) {
+			for k, v := range sums {
+				fn(k, v)
+			}
+		}) {
+			fmt.Fprintf(&b, "  %-40s %12d\n", kc.Key, kc.Count)
+		}
+	}
+	return b.String()
+}
+
+// sparkline draws values relative to their maximum with block characters
+func sparkline(values []float64) string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	top := 0.0
+	for _, v := range values {
+		top = max(top, v)
+	}
+	s := make([]rune, len(values))
+	for i, v := range values {
+		s[i] = blocks[0]
+		if top > 0 {
+			s[i] = blocks[int(v/top*float64(len(blocks)-1))]
+		}
+	}
+	return string(s)
+}
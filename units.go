@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnitConverter rewrites humanized durations and sizes in columns of records as plain numbers, so the sum
+// report and SQL queries aggregate them. Rules are separated by ; and select columns like -redact, e.g.
+// 3=duration;4=size;5=duration:ms. The conversions are:
+//
+//	duration[:UNIT]  Go durations such as 1h2m, 1.5s or 250ms as a number of UNIT: s (the default), ms,
+//	                 us or ns, e.g. 0.25 for 250ms in seconds
+//	size             sizes such as 3.4MB, 512 KiB or 2G as a number of bytes: kB, MB, GB, TB and PB are
+//	                 powers of 1000, KiB to PiB and K to P powers of 1024
+//
+// Plain numbers are taken to be in the unit already, and values that cannot be converted are left as they
+// are, so the reports reject them like any other malformed number. It is safe for concurrent use.
+type UnitConverter struct {
+	rules []unitRule
+}
+
+type unitRule struct {
+	columns *KeySpec
+	convert func(s string) (string, bool)
+}
+
+var durationUnits = map[string]time.Duration{"s": time.Second, "ms": time.Millisecond, "us": time.Microsecond, "ns": time.Nanosecond}
+
+// NewUnitConverter parses the rules
+func NewUnitConverter(rules string) (*UnitConverter, error) {
+	uc := &UnitConverter{}
+	for _, rule := range strings.Split(rules, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		columns, conversion, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("units %s: want columns=duration[:UNIT] or columns=size", rule)
+		}
+		spec, err := ParseKeySpec(columns)
+		if err != nil {
+			return nil, fmt.Errorf("units %s: %v", rule, err)
+		}
+		if spec.HasNames() {
+			return nil, fmt.Errorf("units %s: columns must be numbers", rule)
+		}
+		r := unitRule{columns: spec}
+		name, arg, _ := strings.Cut(conversion, ":")
+		switch {
+		case name == "duration":
+			unit, ok := durationUnits[arg]
+			if arg == "" {
+				unit, ok = time.Second, true
+			}
+			if !ok {
+				return nil, fmt.Errorf("units %s: unknown duration unit %s, want s, ms, us or ns", rule, arg)
+			}
+			r.convert = func(s string) (string, bool) { return convertDuration(s, unit) }
+		case name == "size" && arg == "":
+			r.convert = convertSize
+		default:
+			return nil, fmt.Errorf("units %s: unknown conversion %s", rule, conversion)
+		}
+		uc.rules = append(uc.rules, r)
+	}
+	if len(uc.rules) == 0 {
+		return nil, fmt.Errorf("no unit rules")
+	}
+	return uc, nil
+}
+
+// Convert rewrites the configured columns of a LogRecord or ByteRecord in place. Columns a record does not
+// have are left alone.
+func (uc *UnitConverter) Convert(rec interface{}) {
+	switch r := rec.(type) {
+	case LogRecord:
+		for _, rule := range uc.rules {
+			for _, c := range specColumns(rule.columns, len(r)) {
+				if v, ok := rule.convert(r[c]); ok {
+					r[c] = v
+				}
+			}
+		}
+	case ByteRecord:
+		for _, rule := range uc.rules {
+			for _, c := range specColumns(rule.columns, len(r)) {
+				if v, ok := rule.convert(string(r[c])); ok {
+					r[c] = []byte(v)
+				}
+			}
+		}
+	}
+}
+
+func convertDuration(s string, unit time.Duration) (string, bool) {
+	s = strings.TrimSpace(s)
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s, true
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return s, false
+	}
+	if d%unit == 0 {
+		return strconv.FormatInt(int64(d/unit), 10), true
+	}
+	return strconv.FormatFloat(float64(d)/float64(unit), 'f', -1, 64), true
+}
+
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12, "pb": 1e15,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40, "pib": 1 << 50,
+	"k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40, "p": 1 << 50,
+}
+
+// convertSize returns a size in whole bytes
+func convertSize(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(c rune) bool { return (c < '0' || c > '9') && c != '.' && c != '-' && c != '+' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	mult, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if err != nil || !ok || math.IsInf(n*mult, 0) {
+		return s, false
+	}
+	return strconv.FormatFloat(math.Round(n*mult), 'f', -1, 64), true
+}
+
+// Units is the Transformer of a UnitConverter for records of type T
+type Units[T any] struct{ *UnitConverter }
+
+func (u Units[T]) Transform(rec T) T {
+	u.Convert(rec)
+	return rec
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Version, Commit and BuildDate are set when building a release, e.g.
+//
+//	go build -ldflags "-X main.Version=1.4.0 -X main.Commit=$(git rev-parse HEAD) -X main.BuildDate=$(date -u +%FT%TZ)"
+//
+// Without them the commit and date come from the version control stamp of go build, if any.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// features lists the optional features compiled into the binary. Files behind build tags add theirs in init.
+var features []string
+
+// BuildInfo identifies the binary that produced a result
+type BuildInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	BuildDate string   `json:"build_date,omitempty"`
+	Go        string   `json:"go"`
+	Platform  string   `json:"platform"`
+	Features  []string `json:"features"`
+}
+
+func GetBuildInfo() BuildInfo {
+	bi := BuildInfo{Version: Version, Commit: Commit, BuildDate: BuildDate, Go: runtime.Version(),
+		Platform: runtime.GOOS + "/" + runtime.GOARCH, Features: features}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		modified := false
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && bi.Commit == "":
+				bi.Commit = s.Value
+			case s.Key == "vcs.time" && bi.BuildDate == "":
+				bi.BuildDate = s.Value
+			case s.Key == "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && Commit == "" && bi.Commit != "" {
+			bi.Commit += "-dirty"
+		}
+	}
+	if bi.Features == nil {
+		bi.Features = []string{}
+	}
+	return bi
+}
+
+func (bi BuildInfo) String() string {
+	s := fmt.Sprintf("lopro %s", bi.Version)
+	if bi.Commit != "" {
+		s += " commit " + bi.Commit
+	}
+	if bi.BuildDate != "" {
+		s += " built " + bi.BuildDate
+	}
+	s += fmt.Sprintf(" %s %s", bi.Go, bi.Platform)
+	if len(bi.Features) > 0 {
+		s += " features " + strings.Join(bi.Features, ",")
+	}
+	return s
+}
+
+func (bi BuildInfo) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("version", bi.Version)}
+	if bi.Commit != "" {
+		attrs = append(attrs, slog.String("commit", bi.Commit))
+	}
+	if bi.BuildDate != "" {
+		attrs = append(attrs, slog.String("build_date", bi.BuildDate))
+	}
+	return slog.GroupValue(append(attrs, slog.String("features", strings.Join(bi.Features, ",")))...)
+}
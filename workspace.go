@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrWorkspaceFull is returned by writes that would take the workspace over its limit
+var ErrWorkspaceFull = errors.New("scratch workspace is full")
+
+// Workspace is the scratch directory of a run, for data that does not fit in memory such as spills, dead
+// letters or checkpoints. It is created on first use in the parent directory, counts the bytes written to
+// it against a limit, and is removed at the end of a successful run. It is safe for concurrent use.
+type Workspace struct {
+	parent string
+	limit  int64 // 0 for no limit
+
+	once sync.Once
+	dir  string
+	err  error
+	used int64 // updated atomically
+	peak int64
+}
+
+// NewWorkspace returns a workspace in parent, os.TempDir() when empty, of at most limit bytes
+func NewWorkspace(parent string, limit int64) *Workspace {
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	return &Workspace{parent: parent, limit: limit}
+}
+
+// Dir creates the workspace directory if needed and returns it
+func (ws *Workspace) Dir() (string, error) {
+	ws.once.Do(func() {
+		if ws.err = os.MkdirAll(ws.parent, 0755); ws.err == nil {
+			ws.dir, ws.err = os.MkdirTemp(ws.parent, "lopro-")
+		}
+		if ws.err == nil {
+			slog.Debug("created scratch workspace", "dir", ws.dir)
+		}
+	})
+	return ws.dir, ws.err
+}
+
+// Create creates a new file in the workspace, named after pattern like os.CreateTemp
+func (ws *Workspace) Create(pattern string) (*ScratchFile, error) {
+	dir, err := ws.Dir()
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &ScratchFile{File: fp, ws: ws}, nil
+}
+
+func (ws *Workspace) reserve(n int64) error {
+	used := atomic.AddInt64(&ws.used, n)
+	if ws.limit > 0 && used > ws.limit {
+		atomic.AddInt64(&ws.used, -n)
+		return ErrWorkspaceFull
+	}
+	for peak := atomic.LoadInt64(&ws.peak); used > peak && !atomic.CompareAndSwapInt64(&ws.peak, peak, used); {
+		peak = atomic.LoadInt64(&ws.peak)
+	}
+	return nil
+}
+
+func (ws *Workspace) release(n int64) { atomic.AddInt64(&ws.used, -n) }
+
+// Close removes the workspace, unless keep is set, e.g. after a failed run so it can be inspected
+func (ws *Workspace) Close(keep bool) error {
+	if ws.dir == "" {
+		return nil
+	}
+	if keep {
+		slog.Warn("kept scratch workspace", "dir", ws.dir)
+		return nil
+	}
+	return os.RemoveAll(ws.dir)
+}
+
+func (ws *Workspace) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("dir", ws.dir), slog.Int64("used", atomic.LoadInt64(&ws.used)),
+		slog.Int64("peak", atomic.LoadInt64(&ws.peak)), slog.Int64("limit", ws.limit))
+}
+
+// ScratchFile is a file of a workspace whose writes count against its limit. Remove deletes it and gives
+// its bytes back.
+type ScratchFile struct {
+	*os.File
+	ws      *Workspace
+	written int64
+}
+
+func (sf *ScratchFile) Write(p []byte) (int, error) {
+	if err := sf.ws.reserve(int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := sf.File.Write(p)
+	sf.ws.release(int64(len(p) - n))
+	sf.written += int64(n)
+	return n, err
+}
+
+// Remove closes and deletes the file
+func (sf *ScratchFile) Remove() error {
+	sf.File.Close()
+	sf.ws.release(sf.written)
+	sf.written = 0
+	return os.Remove(sf.Name())
+}
+
+// SizeFlag is a byte size flag that takes a K, M, G or T suffix, e.g. 512M
+type SizeFlag struct {
+	n *int64
+}
+
+func (sf SizeFlag) String() string {
+	if sf.n == nil {
+		return "0"
+	}
+	return strconv.FormatInt(*sf.n, 10)
+}
+
+func (sf SizeFlag) Set(s string) error {
+	n, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	*sf.n = n
+	return nil
+}
+
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	if i := strings.IndexAny(s, "KMGTkmgt"); i > 0 && (i == len(s)-1 || strings.EqualFold(s[i+1:], "b")) {
+		mult = int64(1) << (10 * (1 + strings.IndexByte("KMGT", s[i]&^0x20)))
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * mult, nil
+}